@@ -3,9 +3,11 @@
 package goredis
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
 )
 
@@ -154,6 +156,41 @@ func ParseCommand(buf *bytes.Buffer) (*Command, error) {
 	return cmd, nil
 }
 
+// 从文件等数据流中连续读取指令，比如导入AOF
+func ReadCommand(r *bufio.Reader) (*Command, error) {
+	line, err := r.ReadBytes(LF)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[0] != '*' {
+		return nil, errors.New("bad command: " + string(line))
+	}
+	argCount, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, argCount)
+	for i := 0; i < argCount; i++ {
+		if line, err = r.ReadBytes(LF); err != nil {
+			return nil, err
+		}
+		if len(line) < 3 || line[0] != '$' {
+			return nil, errors.New("bad argument: " + string(line))
+		}
+		argSize, err := strconv.Atoi(string(line[1 : len(line)-2]))
+		if err != nil {
+			return nil, err
+		}
+		// <argument data> CR LF
+		buf := make([]byte, argSize+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = buf[:argSize]
+	}
+	return NewCommand(args...), nil
+}
+
 func (cmd *Command) String() string {
 	buf := bytes.Buffer{}
 	for i, count := 0, cmd.Len(); i < count; i++ {
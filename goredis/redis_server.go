@@ -49,7 +49,12 @@ func (server *RedisServer) Listen(host string) error {
 	if err != nil {
 		return err
 	}
+	return server.Serve(listener, nil)
+}
 
+// 在已创建的listener上处理连接，比如TLS listener
+// attrs在SessionOpened之前设置到每个session上，用于区分连接来自哪个listener
+func (server *RedisServer) Serve(listener net.Listener, attrs map[string]interface{}) error {
 	if server.handler == nil {
 		return errors.New("handler undefined")
 	}
@@ -61,8 +66,12 @@ func (server *RedisServer) Listen(host string) error {
 			go server.handler.ExceptionCaught(err)
 			continue
 		}
+		session := NewSession(conn)
+		for name, v := range attrs {
+			session.SetAttribute(name, v)
+		}
 		// go
-		go server.handleConnection(NewSession(conn))
+		go server.handleConnection(session)
 	}
 	return nil
 }
@@ -5,13 +5,18 @@ package goredis
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 )
 
 // 封装一个返回给客户端的Response
 // 对于每种Redis响应，都有一个对应的构造函数
+// Double/Boolean/BigNumber/Verbatim/Map是RESP3的类型，连接为RESP2时按redis的方式降级
 type Reply struct {
 	Type  ReplyType
 	Value interface{}
+	Attrs []interface{} // RESP3的attribute，key/value交替，RESP2时忽略
 }
 
 type ReplyType int
@@ -25,6 +30,11 @@ const (
 	ReplyTypeInteger
 	ReplyTypeBulk
 	ReplyTypeMultiBulks
+	ReplyTypeDouble
+	ReplyTypeBoolean
+	ReplyTypeBigNumber
+	ReplyTypeVerbatim
+	ReplyTypeMap
 )
 
 var replyTypeDesc = map[ReplyType]string{
@@ -33,6 +43,11 @@ var replyTypeDesc = map[ReplyType]string{
 	ReplyTypeInteger:    "IntegerReply",
 	ReplyTypeBulk:       "BulkReply",
 	ReplyTypeMultiBulks: "MultiBulksReply",
+	ReplyTypeDouble:     "DoubleReply",
+	ReplyTypeBoolean:    "BooleanReply",
+	ReplyTypeBigNumber:  "BigNumberReply",
+	ReplyTypeVerbatim:   "VerbatimReply",
+	ReplyTypeMap:        "MapReply",
 }
 
 // status 绝大部分情况下status="OK"
@@ -66,7 +81,7 @@ func BulkReply(bulk interface{}) (r *Reply) {
 	return
 }
 
-// bulks 数组元素可以是string, []byte, int, nil
+// bulks 数组元素可以是string, []byte, int, nil，嵌套的[]interface{}，以及*Reply
 func MultiBulksReply(bulks []interface{}) (r *Reply) {
 	r = &Reply{}
 	r.Type = ReplyTypeMultiBulks
@@ -74,6 +89,68 @@ func MultiBulksReply(bulks []interface{}) (r *Reply) {
 	return
 }
 
+// RESP2时为bulk，格式见FormatDouble
+func DoubleReply(f float64) (r *Reply) {
+	r = &Reply{}
+	r.Type = ReplyTypeDouble
+	r.Value = f
+	return
+}
+
+// RESP2时为整数1/0
+func BooleanReply(b bool) (r *Reply) {
+	r = &Reply{}
+	r.Type = ReplyTypeBoolean
+	r.Value = b
+	return
+}
+
+// 超出int64的整数，RESP2时为bulk
+func BigNumberReply(n *big.Int) (r *Reply) {
+	r = &Reply{}
+	r.Type = ReplyTypeBigNumber
+	r.Value = n
+	return
+}
+
+// format为3个字符，比如txt/mkd，RESP2时为只包含text的bulk
+func VerbatimReply(format string, text string) (r *Reply) {
+	r = &Reply{}
+	r.Type = ReplyTypeVerbatim
+	r.Value = [2]string{format, text}
+	return
+}
+
+// pairs为key/value交替，元素类型与MultiBulksReply相同，RESP2时为数组
+func MapReply(pairs []interface{}) (r *Reply) {
+	r = &Reply{}
+	r.Type = ReplyTypeMap
+	r.Value = pairs
+	return
+}
+
+// 附加RESP3的attribute，key/value交替
+func (r *Reply) WithAttrs(pairs ...interface{}) *Reply {
+	r.Attrs = append(r.Attrs, pairs...)
+	return r
+}
+
+// 与redis一致，整数不带小数点，输出inf/-inf/nan，很大或很小的数值使用科学计数法
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs >= 1e21 || abs < 1e-6) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 func (r *Reply) String() string {
 	buf := bytes.Buffer{}
 	buf.WriteString("<")
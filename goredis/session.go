@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"strconv"
+	"sync"
 )
 
 // Session继承了net.Conn，代表一个客户端会话
@@ -21,17 +23,46 @@ type Session struct {
 	net.Conn
 	rw    *bufio.Reader
 	attrs map[string]interface{}
+	proto int // HELLO协商的协议版本，默认为RESP2
+	// Close时关闭，阻塞中的指令据此放弃等待
+	closed    chan bool
+	closeOnce sync.Once
 }
 
 func NewSession(conn net.Conn) (s *Session) {
 	s = &Session{
-		Conn:  conn,
-		attrs: make(map[string]interface{}),
+		Conn:   conn,
+		attrs:  make(map[string]interface{}),
+		closed: make(chan bool),
 	}
 	s.rw = bufio.NewReader(s.Conn)
 	return
 }
 
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return s.Conn.Close()
+}
+
+// 连接关闭后可读
+func (s *Session) Closed() <-chan bool {
+	return s.closed
+}
+
+// 2或3，3时WriteReply使用RESP3的类型
+func (s *Session) SetProtocol(proto int) {
+	s.proto = proto
+}
+
+func (s *Session) Protocol() int {
+	if s.proto == 0 {
+		return 2
+	}
+	return s.proto
+}
+
 func (s *Session) SetAttribute(name string, v interface{}) {
 	s.attrs[name] = v
 }
@@ -42,20 +73,11 @@ func (s *Session) GetAttribute(name string) interface{} {
 
 // 返回数据到客户端
 func (s *Session) WriteReply(reply *Reply) (err error) {
-	switch reply.Type {
-	case ReplyTypeStatus:
-		err = s.replyStatus(reply.Value.(string))
-	case ReplyTypeError:
-		err = s.replyError(reply.Value.(string))
-	case ReplyTypeInteger:
-		err = s.replyInteger(reply.Value.(int))
-	case ReplyTypeBulk:
-		err = s.replyBulk(reply.Value)
-	case ReplyTypeMultiBulks:
-		err = s.replyMultiBulks(reply.Value.([]interface{}))
-	default:
-		err = errors.New("Illegal ReplyType: " + itoa(int(reply.Type)))
+	buf := bytes.Buffer{}
+	if err = writeReply(&buf, reply, s.proto); err != nil {
+		return
 	}
+	_, err = buf.WriteTo(s)
 	return
 }
 
@@ -197,38 +219,94 @@ func (s *Session) ReadCommand() (cmd *Command, err error) {
 	return
 }
 
-// Status reply
-func (s *Session) replyStatus(status string) (err error) {
-	buf := bytes.Buffer{}
-	buf.WriteString("+")
-	buf.WriteString(status)
-	buf.WriteString(CRLF)
-	_, err = buf.WriteTo(s)
-	return
-}
-
-// Error reply
-func (s *Session) replyError(errmsg string) (err error) {
-	buf := bytes.Buffer{}
-	buf.WriteString("-")
-	buf.WriteString(errmsg)
-	buf.WriteString(CRLF)
-	_, err = buf.WriteTo(s)
-	return
-}
-
-// Integer reply
-func (s *Session) replyInteger(i int) (err error) {
-	buf := bytes.Buffer{}
-	buf.WriteString(":")
-	buf.WriteString(itoa(i))
-	buf.WriteString(CRLF)
-	_, err = buf.WriteTo(s)
+// 按连接的协议版本编码，RESP2时新类型降级为redis对应的RESP2类型
+func writeReply(buf *bytes.Buffer, reply *Reply, proto int) (err error) {
+	resp3 := proto >= 3
+	if resp3 && len(reply.Attrs) > 0 {
+		buf.WriteString("|")
+		buf.WriteString(itoa(len(reply.Attrs) / 2))
+		buf.WriteString(CRLF)
+		writeElems(buf, reply.Attrs, proto)
+	}
+	switch reply.Type {
+	case ReplyTypeStatus:
+		buf.WriteString("+")
+		buf.WriteString(reply.Value.(string))
+		buf.WriteString(CRLF)
+	case ReplyTypeError:
+		buf.WriteString("-")
+		buf.WriteString(reply.Value.(string))
+		buf.WriteString(CRLF)
+	case ReplyTypeInteger:
+		buf.WriteString(":")
+		buf.WriteString(itoa(reply.Value.(int)))
+		buf.WriteString(CRLF)
+	case ReplyTypeBulk:
+		writeBulk(buf, reply.Value)
+	case ReplyTypeMultiBulks:
+		writeMultiBulks(buf, reply.Value.([]interface{}), proto)
+	case ReplyTypeDouble:
+		f := FormatDouble(reply.Value.(float64))
+		if resp3 {
+			buf.WriteString(",")
+			buf.WriteString(f)
+			buf.WriteString(CRLF)
+		} else {
+			writeBulk(buf, f)
+		}
+	case ReplyTypeBoolean:
+		b := reply.Value.(bool)
+		switch {
+		case resp3 && b:
+			buf.WriteString("#t")
+		case resp3:
+			buf.WriteString("#f")
+		case b:
+			buf.WriteString(":1")
+		default:
+			buf.WriteString(":0")
+		}
+		buf.WriteString(CRLF)
+	case ReplyTypeBigNumber:
+		n := reply.Value.(*big.Int).String()
+		if resp3 {
+			buf.WriteString("(")
+			buf.WriteString(n)
+			buf.WriteString(CRLF)
+		} else {
+			writeBulk(buf, n)
+		}
+	case ReplyTypeVerbatim:
+		v := reply.Value.([2]string)
+		if resp3 {
+			buf.WriteString("=")
+			buf.WriteString(itoa(len(v[0]) + 1 + len(v[1])))
+			buf.WriteString(CRLF)
+			buf.WriteString(v[0])
+			buf.WriteString(":")
+			buf.WriteString(v[1])
+			buf.WriteString(CRLF)
+		} else {
+			writeBulk(buf, v[1])
+		}
+	case ReplyTypeMap:
+		pairs := reply.Value.([]interface{})
+		if resp3 {
+			buf.WriteString("%")
+			buf.WriteString(itoa(len(pairs) / 2))
+			buf.WriteString(CRLF)
+			writeElems(buf, pairs, proto)
+		} else {
+			writeMultiBulks(buf, pairs, proto)
+		}
+	default:
+		err = errors.New("Illegal ReplyType: " + itoa(int(reply.Type)))
+	}
 	return
 }
 
-// Bulk Reply
-func (s *Session) replyBulk(bulk interface{}) (err error) {
+// Bulk Reply，可以是string或[]byte
+func writeBulk(buf *bytes.Buffer, bulk interface{}) {
 	// NULL Bulk Reply
 	isnil := bulk == nil
 	if !isnil {
@@ -237,10 +315,10 @@ func (s *Session) replyBulk(bulk interface{}) (err error) {
 		isnil = ok && b == nil
 	}
 	if isnil {
-		_, err = s.Write([]byte("$-1\r\n"))
+		buf.WriteString("$-1")
+		buf.WriteString(CRLF)
 		return
 	}
-	buf := bytes.Buffer{}
 	buf.WriteString("$")
 	switch bulk.(type) {
 	case []byte:
@@ -255,62 +333,43 @@ func (s *Session) replyBulk(bulk interface{}) (err error) {
 		buf.Write(b)
 	}
 	buf.WriteString(CRLF)
-	_, err = buf.WriteTo(s)
-	return
 }
 
 // Multi-bulk replies
-func (s *Session) replyMultiBulks(bulks []interface{}) (err error) {
+func writeMultiBulks(buf *bytes.Buffer, bulks []interface{}, proto int) {
 	// Null Multi Bulk Reply
 	if bulks == nil {
-		_, err = s.Write([]byte("*-1\r\n"))
-		return
-	}
-	bulkCount := len(bulks)
-	// Empty Multi Bulk Reply
-	if bulkCount == 0 {
-		_, err = s.Write([]byte("*0\r\n"))
+		buf.WriteString("*-1")
+		buf.WriteString(CRLF)
 		return
 	}
-	buf := bytes.Buffer{}
 	buf.WriteString("*")
-	buf.WriteString(itoa(bulkCount))
+	buf.WriteString(itoa(len(bulks)))
 	buf.WriteString(CRLF)
-	for i := 0; i < bulkCount; i++ {
-		bulk := bulks[i]
+	writeElems(buf, bulks, proto)
+}
+
+func writeElems(buf *bytes.Buffer, bulks []interface{}, proto int) {
+	for _, bulk := range bulks {
 		switch bulk.(type) {
-		case string:
-			buf.WriteString("$")
-			b := []byte(bulk.(string))
-			buf.WriteString(itoa(len(b)))
-			buf.WriteString(CRLF)
-			buf.Write(b)
-			buf.WriteString(CRLF)
-		case []byte:
-			b := bulk.([]byte)
-			if b == nil {
-				buf.WriteString("$-1")
-				buf.WriteString(CRLF)
-			} else {
-				buf.WriteString("$")
-				buf.WriteString(itoa(len(b)))
-				buf.WriteString(CRLF)
-				buf.Write(b)
-				buf.WriteString(CRLF)
-			}
+		case string, []byte:
+			writeBulk(buf, bulk)
 		case int:
 			buf.WriteString(":")
 			buf.WriteString(itoa(bulk.(int)))
 			buf.WriteString(CRLF)
+		case []interface{}:
+			// 嵌套的Multi-bulk，如SCAN的返回值
+			writeMultiBulks(buf, bulk.([]interface{}), proto)
+		case *Reply:
+			// 嵌套的其它类型，如数组里的double
+			writeReply(buf, bulk.(*Reply), proto)
 		default:
 			// nil element
 			buf.WriteString("$-1")
 			buf.WriteString(CRLF)
 		}
 	}
-	// flush
-	_, err = buf.WriteTo(s)
-	return
 }
 
 // ====================================
@@ -399,7 +458,8 @@ func (s *Session) ReadByte() (c byte, err error) {
 
 // 获取字节而不移动游标
 func (s *Session) PeekByte() (c byte, err error) {
-	if b, e := s.rw.Peek(1); e == nil {
+	b, err := s.rw.Peek(1)
+	if err == nil {
 		c = b[0]
 	}
 	return
@@ -0,0 +1,101 @@
+package goredis_client
+
+// GoRedis扩展指令的Go客户端，基于redigo的连接池
+// 为DOC_*、KEYNEXT/KEYSEARCH、BLOB.*、CRON.*、INCRLIMIT、BULK.WRITE提供类型化的封装，
+// 其它指令可以直接使用Do，批量指令使用Pipeline
+//
+//	client := goredis_client.NewClient("localhost:1602", 100)
+//	defer client.Close()
+//	client.DocSet("user:1", map[string]interface{}{"name": "latermoon"})
+//	doc, err := client.DocGet("user:1", "name")
+import (
+	"GoRedis/libs/redigo/redis"
+	"time"
+)
+
+type Client struct {
+	pool *redis.Pool
+}
+
+// maxIdle为连接池保留的空闲连接数
+func NewClient(host string, maxIdle int) (c *Client) {
+	return NewClientWithPool(&redis.Pool{
+		MaxIdle:     maxIdle,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", host)
+		},
+	})
+}
+
+// 使用已有的连接池，可以自定义超时和MaxActive
+func NewClientWithPool(pool *redis.Pool) (c *Client) {
+	c = &Client{pool: pool}
+	return
+}
+
+func (c *Client) Pool() *redis.Pool {
+	return c.pool
+}
+
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
+
+// 执行任意指令
+func (c *Client) Do(cmd string, args ...interface{}) (reply interface{}, err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// 在同一个连接上批量发送指令，Exec一次读取全部结果
+//
+//	p := client.Pipeline()
+//	defer p.Close()
+//	p.Send("SET", "a", "1")
+//	p.Send("INCR", "a")
+//	replies, err := p.Exec()
+type Pipeline struct {
+	conn  redis.Conn
+	count int
+}
+
+func (c *Client) Pipeline() (p *Pipeline) {
+	p = &Pipeline{conn: c.pool.Get()}
+	return
+}
+
+func (p *Pipeline) Send(cmd string, args ...interface{}) error {
+	if err := p.conn.Send(cmd, args...); err != nil {
+		return err
+	}
+	p.count++
+	return nil
+}
+
+// 按发送顺序返回结果，单条指令的错误以redis.Error出现在结果中，
+// 返回的err表示连接错误，这时连接不能再使用
+func (p *Pipeline) Exec() (replies []interface{}, err error) {
+	if err = p.conn.Flush(); err != nil {
+		return
+	}
+	replies = make([]interface{}, 0, p.count)
+	for ; p.count > 0; p.count-- {
+		var reply interface{}
+		reply, err = p.conn.Receive()
+		if e, ok := err.(redis.Error); ok {
+			reply, err = e, nil
+		}
+		if err != nil {
+			return
+		}
+		replies = append(replies, reply)
+	}
+	return
+}
+
+// 归还连接
+func (p *Pipeline) Close() error {
+	return p.conn.Close()
+}
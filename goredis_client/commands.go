@@ -0,0 +1,376 @@
+package goredis_client
+
+import (
+	"GoRedis/libs/redigo/redis"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// BLOB.APPEND每次上传的大小，与服务端的分块大小一致
+const BlobChunkSize = 64 * 1024
+
+var BadReplyError = errors.New("bad reply")
+
+// DOC_SET key json，doc可以是任何能序列化为json对象的值，包括$inc/$del等操作符
+func (c *Client) DocSet(key string, doc interface{}) (err error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	_, err = c.Do("DOC_SET", key, data)
+	return
+}
+
+// DOC_GET key [fields]，不指定fields时返回整个doc，key不存在时返回nil
+func (c *Client) DocGet(key string, fields ...string) (doc map[string]interface{}, err error) {
+	data, err := redis.Bytes(c.Do("DOC_GET", key, strings.Join(fields, ",")))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &doc); err == nil && len(doc) == 0 {
+		doc = nil // 服务端对不存在的key返回{}
+	}
+	return
+}
+
+type DocVersion struct {
+	Time time.Time
+	Doc  map[string]interface{}
+}
+
+// DOC_HISTORY key count，从新到旧
+func (c *Client) DocHistory(key string, count int) (versions []DocVersion, err error) {
+	values, err := redis.Values(c.Do("DOC_HISTORY", key, count))
+	if err != nil {
+		return
+	}
+	if len(values)%2 != 0 {
+		return nil, BadReplyError
+	}
+	versions = make([]DocVersion, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		ms, err := redis.Int64(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := redis.Bytes(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+		v := DocVersion{Time: time.Unix(0, ms*int64(time.Millisecond))}
+		if err = json.Unmarshal(data, &v.Doc); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return
+}
+
+// DOC_REVERT key n，回滚到第n个历史版本
+func (c *Client) DocRevert(key string, n int) (err error) {
+	_, err = c.Do("DOC_REVERT", key, n)
+	return
+}
+
+// KEYSEARCH prefix count
+func (c *Client) KeySearch(prefix string, count int) (keys []string, err error) {
+	return redis.Strings(c.Do("KEYSEARCH", prefix, count))
+}
+
+type KeyInfo struct {
+	Key  string
+	Type string
+}
+
+// KEYNEXT seek count withtype，按key顺序扫描，结果包含seek本身
+func (c *Client) KeyNext(seek string, count int) (keys []KeyInfo, err error) {
+	return c.keyEnumerate("KEYNEXT", seek, count)
+}
+
+// KEYPREV seek count withtype
+func (c *Client) KeyPrev(seek string, count int) (keys []KeyInfo, err error) {
+	return c.keyEnumerate("KEYPREV", seek, count)
+}
+
+func (c *Client) keyEnumerate(cmd string, seek string, count int) (keys []KeyInfo, err error) {
+	values, err := redis.Strings(c.Do(cmd, seek, count, "withtype"))
+	if err != nil {
+		return
+	}
+	if len(values)%2 != 0 {
+		return nil, BadReplyError
+	}
+	keys = make([]KeyInfo, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		keys = append(keys, KeyInfo{Key: values[i], Type: values[i+1]})
+	}
+	return
+}
+
+// INCRLIMIT key increment limit，ok为false时没有增加，value为当前值
+func (c *Client) IncrLimit(key string, increment, limit int64) (value int64, ok bool, err error) {
+	values, err := redis.Values(c.Do("INCRLIMIT", key, increment, limit))
+	if err != nil {
+		return
+	}
+	if len(values) != 2 {
+		return 0, false, BadReplyError
+	}
+	if value, err = redis.Int64(values[0], nil); err != nil {
+		return
+	}
+	rejected, err := redis.Int(values[1], nil)
+	return value, rejected == 0, err
+}
+
+// BLOB.PUT key value，返回sha256
+func (c *Client) BlobPut(key string, value []byte) (sum string, err error) {
+	return redis.String(c.Do("BLOB.PUT", key, value))
+}
+
+// 先尝试BLOB.LINK引用已有的内容，不存在时分块上传r
+// 适合客户端已知sha256的大文件
+func (c *Client) BlobPutWithSum(key string, sum string, r io.Reader) (err error) {
+	linked, err := redis.Int(c.Do("BLOB.LINK", key, sum))
+	if err != nil || linked == 1 {
+		return
+	}
+	uploaded, err := c.BlobUpload(key, r)
+	if err == nil && uploaded != sum {
+		err = errors.New("sha256 mismatch: " + uploaded)
+	}
+	return
+}
+
+// 在同一个连接上分块BLOB.APPEND并提交，返回sha256，失败时放弃上传
+func (c *Client) BlobUpload(key string, r io.Reader) (sum string, err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("BLOB.ABORT", key); err != nil {
+		return
+	}
+	buf := make([]byte, BlobChunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err = conn.Do("BLOB.APPEND", key, buf[:n]); err != nil {
+				conn.Do("BLOB.ABORT", key)
+				return
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			conn.Do("BLOB.ABORT", key)
+			return "", rerr
+		}
+	}
+	return redis.String(conn.Do("BLOB.COMMIT", key))
+}
+
+// BLOB.GET key，key不存在时返回nil
+func (c *Client) BlobGet(key string) (value []byte, err error) {
+	value, err = redis.Bytes(c.Do("BLOB.GET", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return
+}
+
+// 分块BLOB.GET写入w，返回写入的长度
+func (c *Client) BlobDownload(key string, w io.Writer) (n int64, err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	for {
+		chunk, err := redis.Bytes(conn.Do("BLOB.GET", key, n, BlobChunkSize))
+		if err == redis.ErrNil {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if _, err = io.Copy(w, bytes.NewReader(chunk)); err != nil {
+			return n, err
+		}
+		n += int64(len(chunk))
+		if len(chunk) < BlobChunkSize {
+			return n, nil
+		}
+	}
+}
+
+type BlobStat struct {
+	Sum    string // 未提交时为空
+	Size   int64
+	Refs   int64
+	Staged int64 // 未提交的上传长度
+}
+
+// BLOB.STAT key，key和上传都不存在时返回nil
+func (c *Client) BlobStat(key string) (stat *BlobStat, err error) {
+	values, err := redis.Values(c.Do("BLOB.STAT", key))
+	if err != nil || len(values) == 0 {
+		return
+	}
+	if len(values) != 4 {
+		return nil, BadReplyError
+	}
+	stat = &BlobStat{}
+	if values[0] != nil {
+		stat.Sum, _ = redis.String(values[0], nil)
+	}
+	stat.Size, _ = redis.Int64(values[1], nil)
+	stat.Refs, _ = redis.Int64(values[2], nil)
+	stat.Staged, _ = redis.Int64(values[3], nil)
+	return
+}
+
+type CronJob struct {
+	Name      string
+	Spec      string
+	Command   string
+	Runs      int64
+	LastRun   time.Time // 没有执行过时为零值
+	LastError string
+}
+
+// CRON.ADD name spec command [arg ...]
+func (c *Client) CronAdd(name, spec string, command string, args ...interface{}) (err error) {
+	_, err = c.Do("CRON.ADD", append([]interface{}{name, spec, command}, args...)...)
+	return
+}
+
+// CRON.DEL name，返回任务是否存在
+func (c *Client) CronDel(name string) (ok bool, err error) {
+	n, err := redis.Int(c.Do("CRON.DEL", name))
+	return n == 1, err
+}
+
+func (c *Client) CronList() (jobs []CronJob, err error) {
+	values, err := redis.Values(c.Do("CRON.LIST"))
+	if err != nil {
+		return
+	}
+	jobs = make([]CronJob, 0, len(values))
+	for _, v := range values {
+		fields, err := redis.Values(v, nil)
+		if err != nil || len(fields) != 6 {
+			return nil, BadReplyError
+		}
+		job := CronJob{}
+		job.Name, _ = redis.String(fields[0], nil)
+		job.Spec, _ = redis.String(fields[1], nil)
+		job.Command, _ = redis.String(fields[2], nil)
+		job.Runs, _ = redis.Int64(fields[3], nil)
+		if sec, _ := redis.Int64(fields[4], nil); sec > 0 {
+			job.LastRun = time.Unix(sec, 0)
+		}
+		job.LastError, _ = redis.String(fields[5], nil)
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// CRON.RUN name，返回指令的结果
+func (c *Client) CronRun(name string) (reply interface{}, err error) {
+	return c.Do("CRON.RUN", name)
+}
+
+// BULK.WRITE的批量导入，每batch条记录作为一批发送，不等待确认，
+// 最多window批未确认，Flush发送剩余的记录并等待全部确认
+//
+//	w := client.BulkWriter(1000, 10)
+//	defer w.Close()
+//	w.HSet("user:1", "name", "latermoon")
+//	w.ZAdd("rank", 100, "user:1")
+//	err := w.Flush()
+type BulkWriter struct {
+	conn    redis.Conn
+	batch   int
+	window  int
+	args    []interface{}
+	records int
+	pending []int // 未确认的每批记录数
+	Acked   int64 // 已确认写入的记录数，出错后可以从这里继续导入
+}
+
+func (c *Client) BulkWriter(batch, window int) (w *BulkWriter) {
+	w = &BulkWriter{conn: c.pool.Get(), batch: batch, window: window}
+	return
+}
+
+func (w *BulkWriter) Set(key string, value interface{}) error {
+	return w.add("SET", key, value)
+}
+
+func (w *BulkWriter) HSet(key, field string, value interface{}) error {
+	return w.add("HSET", key, field, value)
+}
+
+func (w *BulkWriter) SAdd(key string, member interface{}) error {
+	return w.add("SADD", key, member)
+}
+
+func (w *BulkWriter) ZAdd(key string, score float64, member interface{}) error {
+	return w.add("ZADD", key, score, member)
+}
+
+func (w *BulkWriter) add(args ...interface{}) error {
+	w.args = append(w.args, args...)
+	w.records++
+	if w.records < w.batch {
+		return nil
+	}
+	return w.send()
+}
+
+func (w *BulkWriter) send() (err error) {
+	if w.records == 0 {
+		return
+	}
+	if err = w.conn.Send("BULK.WRITE", w.args...); err != nil {
+		return
+	}
+	w.pending = append(w.pending, w.records)
+	w.args, w.records = nil, 0
+	if len(w.pending) < w.window {
+		return
+	}
+	if err = w.conn.Flush(); err != nil {
+		return
+	}
+	return w.receive()
+}
+
+// 读取最早一批的确认
+func (w *BulkWriter) receive() (err error) {
+	n, err := redis.Int(w.conn.Receive())
+	w.pending = w.pending[1:]
+	w.Acked += int64(n)
+	return
+}
+
+func (w *BulkWriter) Flush() (err error) {
+	if err = w.send(); err != nil {
+		return
+	}
+	if err = w.conn.Flush(); err != nil {
+		return
+	}
+	for len(w.pending) > 0 {
+		if e := w.receive(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// 归还连接，未Flush的记录被丢弃
+func (w *BulkWriter) Close() error {
+	return w.conn.Close()
+}
@@ -10,7 +10,7 @@ const (
 )
 
 // 跳过指令
-var ignoreCmdList = "DUMP,KEYS,MIGRATE,MOVE,OBJECT,RESTORE,SCAN,EVAL,EVALSHA,SCRIPT,DISCARD,EXEC,MULTI,UNWATCH,WATCH,PSUBSCRIBE,PUBSUB,PUBLISH,PUNSUBSCRIBE,SUBSCRIBE,UNSUBSCRIBE,BGREWRITEAOF,BGSAVE,CLIENT,DBSIZE,DEBUG,FLUSHALL,FLUSHDB,LASTSAVE,SAVE,SHUTDOWM,SLAVEOF,SLOWLOG,SYNC,TIME"
+var ignoreCmdList = "DUMP,KEYS,MIGRATE,MOVE,OBJECT,RESTORE,SCAN,EVAL,EVALSHA,SCRIPT,DISCARD,EXEC,MULTI,UNWATCH,WATCH,PSUBSCRIBE,PUBSUB,PUBLISH,PUNSUBSCRIBE,SUBSCRIBE,UNSUBSCRIBE,BGREWRITEAOF,BGSAVE,CLIENT,DBSIZE,DEBUG,FLUSHALL,FLUSHDB,LASTSAVE,SAVE,SHUTDOWM,SLAVEOF,REPLICAOF,SLOWLOG,SYNC,TIME"
 
 var ignoreSync = map[string]bool{}
 
@@ -4,9 +4,19 @@ import (
 	. "GoRedis/goredis"
 	"GoRedis/libs/levelredis"
 	"bufio"
+	"fmt"
 	"io"
-	"strconv"
+	"math"
+	"os"
 	"sync"
+	"time"
+)
+
+// appendfsync
+const (
+	aofFsyncAlways   = "always"   // 每次Flush都fsync
+	aofFsyncEverysec = "everysec" // 距离上一次fsync超过1秒时fsync
+	aofFsyncNo       = "no"       // 由操作系统决定
 )
 
 type AOFWriter struct {
@@ -14,6 +24,10 @@ type AOFWriter struct {
 	fd     *bufio.Writer
 	mu     sync.Mutex
 	closed bool
+	file   *os.File // 为nil时不fsync
+	fsync  string
+	dirty  bool // 上一次fsync之后有写入
+	synced time.Time
 }
 
 func NewAOFWriter(fd *bufio.Writer) (a *AOFWriter) {
@@ -23,16 +37,64 @@ func NewAOFWriter(fd *bufio.Writer) (a *AOFWriter) {
 	return
 }
 
+// 写入文件，按appendfsync的策略fsync
+func NewAOFFileWriter(f *os.File, fsync string) (a *AOFWriter) {
+	a = NewAOFWriter(bufio.NewWriter(f))
+	a.file, a.fsync = f, fsync
+	return
+}
+
+func (a *AOFWriter) SetFsync(fsync string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsync = fsync
+}
+
 func (a *AOFWriter) Write(p []byte) (n int, err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	a.dirty = true
 	return a.fd.Write(p)
 }
 
+// 注释行#name:value，与redis的#TS:相同的格式，回放时跳过
+func (a *AOFWriter) Annotate(name string, value int64) {
+	a.Write([]byte(fmt.Sprintf("#%s:%d\r\n", name, value)))
+}
+
 func (a *AOFWriter) Flush() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return a.fd.Flush()
+	if err := a.fd.Flush(); err != nil {
+		return err
+	}
+	switch a.fsync {
+	case aofFsyncAlways:
+		return a.sync()
+	case aofFsyncEverysec:
+		if time.Since(a.synced) >= time.Second {
+			return a.sync()
+		}
+	}
+	return nil
+}
+
+// 不论appendfsync的策略，立即写入磁盘
+func (a *AOFWriter) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.fd.Flush(); err != nil {
+		return err
+	}
+	return a.sync()
+}
+
+func (a *AOFWriter) sync() error {
+	if a.file == nil || !a.dirty {
+		return nil
+	}
+	a.dirty, a.synced = false, time.Now()
+	return a.file.Sync()
 }
 
 func (a *AOFWriter) AppendString(key, value []byte) {
@@ -56,7 +118,7 @@ func (a *AOFWriter) AppendHash(h *levelredis.LevelHash) {
 			buf = nil
 		}
 	})
-	if len(buf) > bufsize {
+	if len(buf) > 0 {
 		cmd := NewCommand(buf...)
 		a.Write(cmd.Bytes())
 	}
@@ -64,22 +126,22 @@ func (a *AOFWriter) AppendHash(h *levelredis.LevelHash) {
 	return
 }
 
-func (a *AOFWriter) AppendSet(h *levelredis.LevelHash) {
+func (a *AOFWriter) AppendSet(s *levelredis.LevelSet) {
 	var buf [][]byte
 	bufsize := 200
-	h.Enumerate(func(i int, field, value []byte, quit *bool) {
+	s.Enumerate(func(i int, member []byte, quit *bool) {
 		if buf == nil {
 			buf = make([][]byte, 0, bufsize+4)
-			buf = append(buf, []byte("SADD"), []byte(h.Key()))
+			buf = append(buf, []byte("SADD"), []byte(s.Key()))
 		}
-		buf = append(buf, field)
+		buf = append(buf, member)
 		if len(buf) > bufsize {
 			cmd := NewCommand(buf...)
 			a.Write(cmd.Bytes())
 			buf = nil
 		}
 	})
-	if len(buf) > bufsize {
+	if len(buf) > 0 {
 		cmd := NewCommand(buf...)
 		a.Write(cmd.Bytes())
 	}
@@ -102,7 +164,7 @@ func (a *AOFWriter) AppendList(h *levelredis.LevelList) {
 			buf = nil
 		}
 	})
-	if len(buf) > bufsize {
+	if len(buf) > 0 {
 		cmd := NewCommand(buf...)
 		a.Write(cmd.Bytes())
 	}
@@ -118,7 +180,7 @@ func (a *AOFWriter) AppendZSet(z *levelredis.LevelZSet) {
 			buf = make([][]byte, 0, bufsize+4)
 			buf = append(buf, []byte("ZADD"), []byte(z.Key()))
 		}
-		buf = append(buf, []byte(strconv.FormatInt(BytesToInt64(score), 10)), member)
+		buf = append(buf, formatScore(score), member)
 		if len(buf) > bufsize {
 			cmd := NewCommand(buf...)
 			a.Write(cmd.Bytes())
@@ -133,6 +195,18 @@ func (a *AOFWriter) AppendZSet(z *levelredis.LevelZSet) {
 	return
 }
 
+// 按块写入BLOB.APPEND，最后BLOB.COMMIT，不在内存中拼接整个value
+func (a *AOFWriter) AppendBlob(b *levelredis.LevelBlob) {
+	key := []byte(b.Key())
+	a.Write(NewCommand([]byte("BLOB.APPEND"), key, []byte{}).Bytes())
+	b.Range(0, math.MaxInt64, func(chunk []byte) bool {
+		a.Write(NewCommand([]byte("BLOB.APPEND"), key, chunk).Bytes())
+		return true
+	})
+	a.Write(NewCommand([]byte("BLOB.COMMIT"), key).Bytes())
+	a.Flush()
+}
+
 func (a *AOFWriter) AppendDoc(d *levelredis.LevelDoc) {
 	a.Flush()
 	return
@@ -27,21 +27,21 @@ var CommandCategoryList = []CCate{CCateKey, CCateString, CCateHash, CCateList, C
 
 // 指令集命令列表
 var ccatemaplist = map[CCate]string{
-	CCateKey:         "DEL,DUMP,EXISTS,EXPIRE,EXPIREAT,KEYS,MIGRATE,MOVE,OBJECT,PERSIST,PEXPIRE,PEXPIREAT,PTTL,RANDOMKEY,RENAME,RENAMENX,RESTORE,SORT,TTL,TYPE",
-	CCateString:      "APPEND,BITCOUNT,BITOP,DECR,DECRBY,GET,GETBIT,GETRANGE,GETSET,INCR,INCRBY,INCRBYFLOAT,MGET,MSET,MSETNX,PSETEX,SET,SETBIT,SETEX,SETNX,SETRANGE,STRLEN",
-	CCateHash:        "HDEL,HEXISTS,HGET,HGETALL,HINCRBY,HINCRBYFLOAT,HKEYS,HLEN,HMGET,HMSET,HSET,HSETNX,HVALS",
-	CCateList:        "BLPOP,BRPOP,BRPOPLPUSH,LINDEX,LINSERT,LLEN,LPOP,LPUSH,LPUSHX,LRANGE,LREM,LSET,LTRIM,RPOP,RPOPLRUSH,RPUSH,RPUSHX",
-	CCateSet:         "SADD,SCARD,SDIFF,SDIFFSTORE,SINTER,SINTERSTORE,SISMEMBER,SMEMBERS,SMOVE,SPOP,SRANDMEMBER,SREM,SUNION,SUNIONSTORE",
-	CCateSortedSet:   "ZADD,ZCARD,ZCOUNT,ZINCRBY,ZINTERSTORE,ZRANGE,ZRANGEBYSCORE,ZRANK,ZREM,ZREMRANGEBYRANK,ZREMRANGEBYSCORE,ZREVRANGE,ZREVRANGEBYSCORE,ZREVRANK,ZSCORE,ZUNIONSTORE",
+	CCateKey:         "COPY,DEL,DUMP,EXISTS,EXPIRE,EXPIREAT,KEYS,MIGRATE,MOVE,OBJECT,PERSIST,PEXPIRE,PEXPIREAT,PTTL,RANDOMKEY,RENAME,RENAMENX,RESTORE,SCAN,SORT,TTL,TYPE,UNLINK",
+	CCateString:      "APPEND,BITCOUNT,BITOP,BITPOS,BLOB.ABORT,BLOB.APPEND,BLOB.COMMIT,BLOB.GET,BLOB.LINK,BLOB.PUT,BLOB.STAT,DECR,DECRBY,GET,GETBIT,GETRANGE,GETSET,INCR,INCRBY,INCRBYFLOAT,INCRLIMIT,MGET,MSET,MSETNX,PSETEX,SET,SETBIT,SETEX,SETNX,SETRANGE,STRLEN",
+	CCateHash:        "HDEL,HEXISTS,HGET,HGETALL,HINCRBY,HINCRBYFLOAT,HKEYS,HLEN,HMGET,HMSET,HSCAN,HSET,HSETNX,HVALS",
+	CCateList:        "BLPOP,BRPOP,BRPOPLPUSH,BLMOVE,BLMPOP,LINDEX,LINSERT,LLEN,LMPOP,LPOP,LPUSH,LPUSHX,LRANGE,LREM,LSET,LTRIM,RPOP,RPOPLPUSH,LMOVE,RPUSH,RPUSHX",
+	CCateSet:         "SADD,SCARD,SDIFF,SDIFFSTORE,SINTER,SINTERSTORE,SISMEMBER,SMEMBERS,SMOVE,SPOP,SRANDMEMBER,SREM,SSCAN,SUNION,SUNIONSTORE",
+	CCateSortedSet:   "BZPOPMAX,BZPOPMIN,ZADD,ZCARD,ZCOUNT,ZINCRBY,ZINTERSTORE,ZPOPMAX,ZPOPMIN,ZRANDMEMBER,ZRANGE,ZRANGEBYSCORE,ZRANK,ZREM,ZREMRANGEBYRANK,ZREMRANGEBYSCORE,ZREVRANGE,ZREVRANGEBYSCORE,ZREVRANK,ZSCAN,ZSCORE,ZUNIONSTORE",
 	CCatePubSub:      "PSUBSCRIBE,PUBSUB,PUBLISH,PUNSUBSCRIBE,SUBSCRIBE,UNSUBSCRIBE",
 	CCateTransaction: "DISCARD,EXEC,MULTI,UNWATCH,WATCH",
 	CCateScript:      "EVAL,EVALSHA,SCRIPT",
-	CCateConnection:  "AUTH,ECHO,PING,QUIT,SELECT",
-	CCateServer:      "BGREWRITEAOF,BGSAVE,CLIENT,CONFIG,DBSIZE,DEBUG,FLUSHALL,FLUSHDB,INFO,LASTSAVE,MONITOR,SAVE,SHUTDOWM,SLAVEOF,SLOWLOG,SYNC,TIME",
+	CCateConnection:  "AUTH,ECHO,HELLO,PING,QUIT,SELECT,WAIT",
+	CCateServer:      "BACKUP,BGREWRITEAOF,BGSAVE,BULK.WRITE,CLIENT,COMPACT,CONFIG,CRON.ADD,CRON.DEL,CRON.LIST,CRON.RUN,DBINFO,DBSIZE,DEBUG,EXPIRYSTATS,EXPORT,EXPORT.JSON,FLUSHALL,FLUSHDB,IMPORT,IMPORT.JSON,INFO,LASTSAVE,MONITOR,REPLCONF,REPLICAOF,SAVE,SHUTDOWM,SLAVEOF,SLOWLOG,SWAPDB,SYNC,TIME",
 }
 
 // 需要同步到从库的命令 RAW_SET,RAW_SET_NOREPLY
-var syncCmdlist = "DEL,EXPIRE,PERSIST,PEXPIRE,PEXPIREAT,RENAME,RENAMENX,SORT,APPEND,DECR,DECRBY,INCR,INCRBY,INCRBYFLOAT,MSET,MSETNX,PSETEX,SET,SETBIT,SETEX,SETNX,SETRANGE,HDEL,HINCRBY,HINCRBYFLOAT,HMSET,HSET,HSETNX,BLPOP,BRPOP,BRPOPLPUSH,LINSERT,LPOP,LPUSH,LPUSHX,LREM,LSET,LTRIM,RPOP,RPOPLRUSH,RPUSH,RPUSHX,SADD,SDIFFSTORE,SINTERSTORE,SMOVE,SPOP,SREM,SUNIONSTORE,ZADD,ZINCRBY,ZINTERSTORE,ZREM,ZREMRANGEBYRANK,ZREMRANGEBYSCORE,ZREVRANK,ZUNIONSTORE"
+var syncCmdlist = "BULK.WRITE,CRON.ADD,CRON.DEL,FLUSHALL,FLUSHDB,SWAPDB,DEL,MOVE,UNLINK,EXPIRE,EXPIREAT,PERSIST,PEXPIRE,PEXPIREAT,RENAME,RENAMENX,COPY,RESTORE,SORT,APPEND,BITOP,BLOB.ABORT,BLOB.APPEND,BLOB.COMMIT,BLOB.LINK,BLOB.PUT,DECR,DECRBY,INCR,INCRBY,INCRBYFLOAT,INCRLIMIT,MSET,MSETNX,PSETEX,SET,SETBIT,SETEX,SETNX,SETRANGE,HDEL,HINCRBY,HINCRBYFLOAT,HMSET,HSET,HSETNX,LINSERT,LMPOP,LPOP,LPUSH,LPUSHX,LREM,LSET,LTRIM,RPOP,RPOPLPUSH,LMOVE,RPUSH,RPUSHX,SADD,SDIFFSTORE,SINTERSTORE,SMOVE,SPOP,SREM,SUNIONSTORE,ZADD,ZINCRBY,ZINTERSTORE,ZPOPMAX,ZPOPMIN,ZREM,ZREMRANGEBYRANK,ZREMRANGEBYSCORE,ZREVRANK,ZUNIONSTORE"
 
 // 存放指令类别
 var ccatemap map[string]CCate
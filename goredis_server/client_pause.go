@@ -0,0 +1,110 @@
+package goredis_server
+
+// CLIENT PAUSE timeout [WRITE|ALL] / CLIENT UNPAUSE
+// 暂停期间客户端的指令在On()入口等待，直到超时或UNPAUSE，用于切换主从时冻结写入
+// WRITE只暂停写指令，ALL暂停全部指令；CLIENT、SLAVEOF/REPLICAOF指令和主从同步不受影响
+// 切换完成(SLAVEOF执行成功)后自动解除暂停
+import (
+	"sync"
+	"time"
+)
+
+// 暂停WRITE时需要等待的非同步指令，阻塞指令以改写后的形式同步
+var pauseWriteCmds = map[string]bool{
+	"BLPOP":       true,
+	"BRPOP":       true,
+	"BRPOPLPUSH":  true,
+	"BLMOVE":      true,
+	"BLMPOP":      true,
+	"BZPOPMIN":    true,
+	"BZPOPMAX":    true,
+	"IMPORT":      true,
+	"IMPORT.JSON": true,
+	"MIGRATE":     true,
+	"FLUSHALL":    true,
+	"FLUSHDB":     true,
+}
+
+type ClientPause struct {
+	all      bool
+	deadline time.Time
+	done     chan bool // 暂停期间不为nil，结束时close
+	mu       sync.Mutex
+}
+
+func NewClientPause() (p *ClientPause) {
+	p = &ClientPause{}
+	return
+}
+
+// 与redis一致，暂停期间再次PAUSE时取更晚的结束时间和更严格的类型
+// 上一次暂停已经到期时(没有指令等待，done还没有清除)重新开始，不沿用原来的类型
+func (p *ClientPause) Pause(timeout time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	deadline := now.Add(timeout)
+	if p.done != nil && !now.Before(p.deadline) {
+		p.unpause()
+	}
+	if p.done == nil {
+		p.done = make(chan bool)
+		p.all = all
+		p.deadline = deadline
+		return
+	}
+	p.all = p.all || all
+	if deadline.After(p.deadline) {
+		p.deadline = deadline
+	}
+}
+
+func (p *ClientPause) Unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unpause()
+}
+
+func (p *ClientPause) unpause() {
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+}
+
+// 是否处于暂停中，返回暂停类型
+func (p *ClientPause) Paused() (paused bool, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil && time.Now().Before(p.deadline) {
+		return true, p.all
+	}
+	return false, false
+}
+
+// 暂停期间阻塞，write表示当前为写指令
+func (p *ClientPause) Wait(write bool) {
+	for {
+		p.mu.Lock()
+		if p.done == nil || (!p.all && !write) {
+			p.mu.Unlock()
+			return
+		}
+		remain := p.deadline.Sub(time.Now())
+		if remain <= 0 {
+			p.unpause()
+			p.mu.Unlock()
+			return
+		}
+		done := p.done
+		p.mu.Unlock()
+
+		// 等待期间可能被延长或改为ALL，醒来后重新判断
+		timer := time.NewTimer(remain)
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
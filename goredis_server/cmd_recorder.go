@@ -0,0 +1,70 @@
+package goredis_server
+
+// 录制全部传入的指令，配合main/tool/replay回放，用真实流量做压力测试
+// 每条记录格式: "<开始后的微秒数> <执行耗时微秒> <客户端地址> <指令长度>\n" + 指令的RESP编码
+// 回放时每个客户端使用单独的连接，SELECT和阻塞指令只影响自己的连接
+import (
+	. "GoRedis/goredis"
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type CmdRecorder struct {
+	path  string
+	file  *os.File
+	w     *bufio.Writer
+	begin time.Time
+	count int64
+	mu    sync.Mutex
+}
+
+func NewCmdRecorder(path string) (r *CmdRecorder, err error) {
+	r = &CmdRecorder{path: path}
+	if r.file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm); err != nil {
+		return
+	}
+	r.w = bufio.NewWriterSize(r.file, 1024*1024)
+	r.begin = time.Now()
+	return
+}
+
+func (r *CmdRecorder) Path() string {
+	return r.path
+}
+
+func (r *CmdRecorder) Count() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+func (r *CmdRecorder) Write(client string, cmd *Command, elapsed time.Duration) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	b := cmd.Bytes()
+	offset := time.Since(r.begin) / time.Microsecond
+	if _, err = fmt.Fprintf(r.w, "%d %d %s %d\n", offset, elapsed/time.Microsecond, client, len(b)); err != nil {
+		return
+	}
+	_, err = r.w.Write(b)
+	r.count++
+	return
+}
+
+func (r *CmdRecorder) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	r.w.Flush()
+	err = r.file.Close()
+	r.file = nil
+	return
+}
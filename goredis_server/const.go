@@ -6,6 +6,18 @@ const (
 	S_SLAVE_PORT   = "slaveport"
 	S_HOST         = "host"
 	S_LAST_COMMAND = "lastcmd"
+	S_LIB_NAME     = "lib-name" // CLIENT SETINFO
+	S_LIB_VER      = "lib-ver"
+	S_UNWATCH      = "unwatch"     // WATCHPREFIX
+	S_SYNC_SEQ     = "syncseq"     // master, 已发送给从库的seq
+	S_LAST_RECV    = "lastrecv"    // slave, 最后一次收到主库数据的时间
+	S_SYNCED_AT    = "syncedat"    // slave, 最后一次确认已经执行到主库最新seq的时间
+	S_POLICY       = "policy"      // 连接所属listener的访问策略
+	S_SHUTDOWN_ACK = "shutdownack" // master, 从库确认收到的最后一条seq
+	S_ACK_SEQ      = "ackseq"      // master, REPLCONF ACK确认执行完的seq
+	S_ACK_TIME     = "acktime"     // master, 最后一次REPLCONF ACK的时间
+	S_LAST_WRITE   = "lastwrite"   // 最后一次写入的*writeMark，见WAIT
+	S_DB           = "db"          // SELECT选择的db
 	REPL_WAIT      = "wait"
 	REPL_SEND_BULK = "send_bulk" // master
 	REPL_RECV_BULK = "recv_bulk" // slave
@@ -14,6 +26,12 @@ const (
 
 // Command属性
 const (
-	C_SESSION = "session"
-	C_ELAPSED = "elapsed"
+	C_SESSION    = "session"
+	C_ELAPSED    = "elapsed"
+	C_SYNC_AS    = "syncas"    // 改写后同步到从库，比如BLPOP改为LPOP，SPOP改为SREM
+	C_DB         = "db"        // 执行时连接所在的db
+	C_WRITE_MARK = "writemark" // 写入同步日志后记录seq，见WAIT
+	C_FAILED     = "failed"    // 返回了错误，不通知前缀订阅者
+	C_INFLIGHT   = "inflight"  // 阻塞指令已经写入，进入队列之后才结束inflight，见tracked
+	C_QUEUE_SEQ  = "queueseq"  // 进入指令队列的序号，见enqueue
 )
@@ -0,0 +1,254 @@
+package goredis_server
+
+import (
+	"GoRedis/libs/levelredis"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crontab格式的执行时间，精确到分钟
+// 分 时 日 月 周，支持 * 、数字、a-b、*/n、a-b/n 和逗号分隔的列表，周日可以写0或7
+// 另外支持 @hourly @daily @weekly @monthly
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool // 日和周同时指定时满足其一即可，与cron一致
+}
+
+var BadCronSpecError = errors.New("bad cron spec")
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func ParseCronSpec(spec string) (c *CronSpec, err error) {
+	if s, ok := cronAliases[strings.ToLower(spec)]; ok {
+		spec = s
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, BadCronSpecError
+	}
+	c = &CronSpec{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	dest := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		if *dest[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, err
+		}
+	}
+	// 7也表示周日
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return
+}
+
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, BadCronSpecError
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			if i := strings.Index(part, "-"); i != -1 {
+				lo, err = strconv.Atoi(part[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(part[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(part)
+				hi = lo
+				if err == nil && step > 1 {
+					hi = max // 5/15 等同于 5-max/15
+				}
+			}
+			if err != nil || lo < min || hi > max || lo > hi {
+				return 0, BadCronSpecError
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+// t所在的分钟是否需要执行
+func (c *CronSpec) Match(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domOk := c.dom&(1<<uint(t.Day())) != 0
+	dowOk := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domOk && dowOk
+	}
+	return domOk || dowOk
+}
+
+// 定时任务，按crontab执行，或者在匹配前缀的key过期后执行
+// cron:[name] = {"name":..., "spec":..., "args":[...], ...}
+type CronJob struct {
+	Name      string   `json:"name"`
+	Spec      string   `json:"spec"` // crontab，或者 @expire:前缀
+	Args      []string `json:"args"` // 执行的指令，过期触发时$KEY替换为过期的key
+	Runs      int64    `json:"runs"`
+	LastRun   int64    `json:"lastrun"` // unix秒
+	LastError string   `json:"lasterror,omitempty"`
+	cron      *CronSpec
+	prefix    string
+}
+
+const cronExpirePrefix = "@expire:"
+
+// 解析spec，返回的job没有执行记录
+func NewCronJob(name, spec string, args []string) (job *CronJob, err error) {
+	job = &CronJob{Name: name, Spec: spec, Args: args}
+	if err = job.parse(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (job *CronJob) parse() (err error) {
+	if strings.HasPrefix(job.Spec, cronExpirePrefix) {
+		job.prefix = job.Spec[len(cronExpirePrefix):]
+		return
+	}
+	job.cron, err = ParseCronSpec(job.Spec)
+	return
+}
+
+func (job *CronJob) OnExpire() bool {
+	return job.cron == nil
+}
+
+// key过期触发时返回替换了$KEY的指令参数，$KEY可以出现在参数中间，比如session_data:$KEY
+func (job *CronJob) CommandArgs(key []byte) (args [][]byte) {
+	args = make([][]byte, len(job.Args))
+	for i, arg := range job.Args {
+		args[i] = []byte(arg)
+		if key != nil {
+			args[i] = bytes.Replace(args[i], []byte("$KEY"), key, -1)
+		}
+	}
+	return
+}
+
+// 持久化的定时任务表，保存在系统前缀下
+type CronTable struct {
+	db     *levelredis.LevelRedis
+	prefix string
+	jobs   map[string]*CronJob
+	mu     sync.Mutex
+}
+
+func NewCronTable(db *levelredis.LevelRedis, prefix string) (t *CronTable) {
+	t = &CronTable{
+		db:     db,
+		prefix: prefix,
+	}
+	t.Reload()
+	return
+}
+
+// 从存储重新读取全部任务，从库全量同步之后调用
+func (t *CronTable) Reload() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs = make(map[string]*CronJob)
+	t.db.PrefixEnumerate([]byte(t.prefix), levelredis.IterForward, func(i int, key, value []byte, quit *bool) {
+		job := &CronJob{}
+		if err := json.Unmarshal(value, job); err != nil || job.parse() != nil {
+			return
+		}
+		t.jobs[job.Name] = job
+	})
+}
+
+func (t *CronTable) save(job *CronJob) error {
+	val, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return t.db.RawSet([]byte(t.prefix+job.Name), val)
+}
+
+// 添加或替换同名任务
+func (t *CronTable) Add(job *CronJob) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.save(job); err != nil {
+		return err
+	}
+	t.jobs[job.Name] = job
+	return nil
+}
+
+func (t *CronTable) Remove(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.jobs[name]; !ok {
+		return false
+	}
+	t.db.RawDel([]byte(t.prefix + name))
+	delete(t.jobs, name)
+	return true
+}
+
+func (t *CronTable) Get(name string) (job CronJob, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j, exists := t.jobs[name]; exists {
+		return *j, true
+	}
+	return
+}
+
+// 按名称排序的任务副本，match为nil时返回全部
+func (t *CronTable) Jobs(match func(job *CronJob) bool) (jobs []CronJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.jobs))
+	for name, job := range t.jobs {
+		if match == nil || match(job) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	jobs = make([]CronJob, 0, len(names))
+	for _, name := range names {
+		jobs = append(jobs, *t.jobs[name])
+	}
+	return
+}
+
+// 记录一次执行，任务已被删除或替换时忽略
+func (t *CronTable) Done(name string, spec string, errmsg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[name]
+	if !ok || job.Spec != spec {
+		return
+	}
+	job.Runs++
+	job.LastRun = time.Now().Unix()
+	job.LastError = errmsg
+	t.save(job)
+}
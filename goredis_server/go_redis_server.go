@@ -10,10 +10,13 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"reflect"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +27,8 @@ const PREFIX = "__goredis:"
 var (
 	WrongKindError = errors.New("Wrong kind opration")
 	WrongKindReply = ErrorReply(WrongKindError)
+	// 主库正常关闭，从库收到最后的seq后断开，之后重连增量同步
+	MasterShutdownError = errors.New("master shutdown")
 )
 
 var (
@@ -39,6 +44,11 @@ type GoRedisServer struct {
 	opt        *Options // 选项
 	levelRedis *levelredis.LevelRedis
 	config     *Config
+	// 多db，前缀编号0即levelRedis，配置等系统数据只在levelRedis里
+	dbs     []*levelredis.LevelRedis       // SELECT的index对应的db，通过dbAt/databases读取
+	dbmap   []int                          // SELECT的index对应的前缀编号，SWAPDB交换
+	dbsMu   sync.RWMutex                   // 保护dbs和dbmap，不在Suspend里的指令也可能读取
+	physdbs map[int]*levelredis.LevelRedis // 按前缀编号
 	// counters
 	counters        *counter.Counters
 	cmdCounters     *counter.Counters
@@ -47,18 +57,62 @@ type GoRedisServer struct {
 	// info
 	info *Info
 	// 从库
-	uid       string          // 实例id
-	syncmgr   *SessionManager // as master
-	slavemgr  *SessionManager // as slave
-	synclog   *SyncLog
-	aofwriter *AOFWriter
+	uid              string          // 实例id
+	syncmgr          *SessionManager // as master
+	slavemgr         *SessionManager // as slave
+	slaveofGen       int             // 每次SLAVEOF NO ONE增加，用于取消重连
+	reconnecting     string          // 等待重连的主库host:port
+	linkDownSince    time.Time       // 与主库断开的时间
+	reconnectBackoff time.Duration   // 上一次重连主库的等待时间，进入在线同步后清零
+	replMu           sync.Mutex      // 保护slaveofGen、reconnecting、linkDownSince、reconnectBackoff
+	synclog          *SyncLog
+	aofwriter        *AOFWriter // 正在追加的AOF
+	aofpending       *AOFWriter // 正在生成快照的AOF，见BGREWRITEAOF
+	aofMu            sync.Mutex
+	rdbSave          rdbSaveState // SAVE/BGSAVE
+	// 同步日志里上一条指令的db，以及之后写入的指令数，见writeSyncLog
+	synclogDB    int
+	synclogSince int
+	// 从库读延迟上限，秒
+	slaveMaxLag int64
+	// 从库拒绝普通连接的写入
+	replicaReadOnly bool
+	// 大集合保护
+	largeThreshold int64
+	largeWarnOnly  bool
+	// 持久化慢查询
+	slowlogStore *SlowLog
+	statsStop    chan bool // 停止定时保存指令计数
+	statsDone    chan bool
+	// DEBUG FAULT，丢弃同步日志的概率
+	replDropRate float64
+	// DEBUG RECORD，processCommandChan和DEBUG RECORD在不同的goroutine访问
+	recorder   *CmdRecorder
+	recorderMu sync.Mutex
+	// DOC_SET保留的历史版本数
+	docHistoryLen int
+	// 过期清理产生的DEL使用的内部会话
+	expireSession *Session
+	// 定时任务
+	cronTable   *CronTable
+	cronSession *Session
+	cronExpired chan []byte
+	// DBSIZE的缓存，按db
+	dbsizes  map[*levelredis.LevelRedis]*dbsizeCache
+	dbsizeMu sync.Mutex
 	// monitor
 	sessmgr     *SessionManager // all sessions
 	monmgr      *SessionManager
 	methodCache map[string]reflect.Value // 缓存处理函数，减少relect次数
 	cmdChan     chan *Command            // 指令队列，异步处理统计、从库、monitor输出
+	keySampler  *KeySampler              // 热点key采样
+	listWaiters *ListWaiters             // BLPOP等阻塞指令的等待队列
+	clientPause *ClientPause             // CLIENT PAUSE
 	rwlock      sync.RWMutex
 	rwwait      sync.WaitGroup
+	queueSeq    int64          // 进入指令队列的序号，见enqueue
+	notifySeq   int64          // 正在通知前缀订阅者的指令序号，只在processCommandChan中读写
+	inflight    sync.WaitGroup // 已经通过入口、正在执行的指令
 	// exit
 	sigs        chan os.Signal
 	closing     bool       // 准备退出
@@ -76,6 +130,9 @@ func NewGoRedisServer(opt *Options) (server *GoRedisServer) {
 	server.methodCache = make(map[string]reflect.Value)
 	server.cmdChan = make(chan *Command, 1000)
 	server.closingFunc = list.New()
+	server.keySampler = NewKeySampler()
+	server.listWaiters = NewListWaiters()
+	server.clientPause = NewClientPause()
 	go server.processCommandChan()
 	server.monmgr = NewSessionManager()
 	server.syncmgr = NewSessionManager()
@@ -90,10 +147,39 @@ func NewGoRedisServer(opt *Options) (server *GoRedisServer) {
 	return
 }
 
+// 先创建全部listener，任何一个失败都返回错误，再开始处理连接
+// 地址与-h/-p相同的listen配置作为主端口的策略
 func (server *GoRedisServer) Listen() error {
 	addr := fmt.Sprintf("%s:%d", server.opt.Host(), server.opt.Port())
-	stdlog.Printf("listen %s\n", addr)
-	return server.RedisServer.Listen(addr)
+	policies := []*ListenerPolicy{&ListenerPolicy{Addr: addr}}
+	for _, p := range server.opt.Listeners() {
+		if p.Addr == addr {
+			policies[0] = p
+		} else {
+			policies = append(policies, p)
+		}
+	}
+	listeners := make([]net.Listener, len(policies))
+	for i, p := range policies {
+		l, err := p.Listen()
+		if err != nil {
+			for _, opened := range listeners[:i] {
+				opened.Close()
+			}
+			return err
+		}
+		listeners[i] = l
+		stdlog.Printf("listen %s\n", p)
+	}
+	for i := 1; i < len(listeners); i++ {
+		go server.RedisServer.Serve(listeners[i], map[string]interface{}{S_POLICY: policies[i]})
+	}
+	return server.RedisServer.Serve(listeners[0], map[string]interface{}{S_POLICY: policies[0]})
+}
+
+// 供嵌入的应用在一致性读视图中执行多步读取，见levelredis.LevelRedis.View
+func (server *GoRedisServer) View(fn func(tx levelredis.ReadTx) error) error {
+	return server.levelRedis.View(fn)
 }
 
 func (server *GoRedisServer) UID() (uid string) {
@@ -119,6 +205,9 @@ func (server *GoRedisServer) SessionOpened(session *Session) {
 func (server *GoRedisServer) SessionClosed(session *Session, err error) {
 	server.counters.Get("connection").Incr(-1)
 	server.sessmgr.Remove(session.RemoteAddr().String())
+	if unwatch, ok := session.GetAttribute(S_UNWATCH).(func()); ok {
+		unwatch()
+	}
 	stdlog.Println("end connection", session.RemoteAddr(), err)
 }
 
@@ -130,14 +219,36 @@ func (server *GoRedisServer) ExceptionCaught(err error) {
 // ServerHandler.On()
 // 由GoRedis协议层触发，通过反射调用OnGET/OnSET等方法
 func (server *GoRedisServer) On(session *Session, cmd *Command) (reply *Reply) {
+	// CLIENT PAUSE，等待时间不计入耗时
+	if cmdName := cmd.Name(); cmdName != "CLIENT" && cmdName != "SLAVEOF" && cmdName != "REPLICAOF" && session.GetAttribute(S_STATUS) == nil {
+		server.clientPause.Wait(needSync(cmdName) || pauseWriteCmds[cmdName])
+	}
+
 	// invoke & time
 	begin := time.Now()
 
-	// suspend & resume
-	server.rwlock.Lock()
-	server.rwlock.Unlock()
+	// 关闭期间仍需处理从库的确认，不能等待Suspend
+	// REPLCONF ACK与SYNC_SHUTDOWN_ACK在同一个连接上，阻塞在Suspend时之后的SYNC_SHUTDOWN_ACK无法读取
+	if session.GetAttribute(S_STATUS) != nil {
+		switch cmd.Name() {
+		case "SYNC_SHUTDOWN_ACK":
+			return server.onSyncShutdownAck(session, cmd)
+		case "REPLCONF":
+			return server.OnREPLCONF(session, cmd)
+		}
+	}
+
+	// suspend & resume，Suspend等待已经进入的指令执行完并进入队列
+	if untrackedCmds[cmd.Name()] {
+		server.rwlock.RLock()
+		server.rwlock.RUnlock()
+	} else {
+		server.enter()
+		defer server.leave()
+	}
 
 	cmd.SetAttribute(C_SESSION, session)
+	cmd.SetAttribute(C_DB, sessionDB(session))
 
 	// varify command
 	if err := verifyCommand(cmd); err != nil {
@@ -145,23 +256,149 @@ func (server *GoRedisServer) On(session *Session, cmd *Command) (reply *Reply) {
 		return ErrorReply(err)
 	}
 
+	// 按连接所属listener的策略限制指令
+	if policy, ok := session.GetAttribute(S_POLICY).(*ListenerPolicy); ok {
+		if err := policy.Check(cmd); err != nil {
+			return ErrorReply(err)
+		}
+	}
+
+	// 从库延迟过大时拒绝读
+	if reply = server.checkSlaveMaxLag(cmd.Name()); reply != nil {
+		return
+	}
+
+	// 只读从库拒绝写入
+	if reply = server.checkReplicaReadOnly(session, cmd.Name()); reply != nil {
+		return
+	}
+
+	// 删除已过期的key
+	server.expireKeysOf(cmd)
+
+	// 写指令不能在其它类型的key上执行
+	if err := server.checkKeyTypes(cmd); err != nil {
+		return ErrorReply(err)
+	}
+	// codec只作用于string
+	if err := server.checkCodecKeys(session, cmd); err != nil {
+		return ErrorReply(err)
+	}
+
 	// invoke
 	reply = server.invokeCommandHandler(session, cmd)
+	if reply != nil && reply.Type == ReplyTypeError {
+		cmd.SetAttribute(C_FAILED, true)
+	}
 
 	elapsed := time.Now().Sub(begin)
 	cmd.SetAttribute(C_ELAPSED, elapsed)
 
+	// WAIT需要连接最后一次写入的seq
+	if server.synclog.IsEnabled() && needSync(cmd.Name()) {
+		markWrite(session, cmd)
+	}
+
 	// async: counter/sync/monitor
+	server.enqueue(cmd)
+	if cmd.GetAttribute(C_INFLIGHT) != nil {
+		server.leave()
+	}
+
+	return
+}
+
+// IMPORT/IMPORT.JSON逐条执行导入的指令，直接调用处理函数，不重新进入On()
+// 调用者在enter()之内，Suspend等待整个导入结束；写指令与On()一样进入队列，写同步日志、计数和前缀订阅
+// 只允许SELECT和需要同步的指令，会调用Suspend或者阻塞的指令在这里会死锁
+func (server *GoRedisServer) execImported(session *Session, cmd *Command) (reply *Reply) {
+	begin := time.Now()
+	cmd.SetAttribute(C_SESSION, session)
+	cmd.SetAttribute(C_DB, sessionDB(session))
+	if err := verifyCommand(cmd); err != nil {
+		return ErrorReply(err)
+	}
+	name := cmd.Name()
+	if (name != "SELECT" && !needSync(name)) || untrackedCmds[name] {
+		return ErrorReply(name + " is not allowed in an import file")
+	}
+	if err := server.checkKeyTypes(cmd); err != nil {
+		return ErrorReply(err)
+	}
+	reply = server.invokeCommandHandler(session, cmd)
+	if reply != nil && reply.Type == ReplyTypeError {
+		cmd.SetAttribute(C_FAILED, true)
+	}
+	cmd.SetAttribute(C_ELAPSED, time.Since(begin))
+	if server.synclog.IsEnabled() && needSync(name) {
+		markWrite(session, cmd)
+	}
+	server.enqueue(cmd)
+	return
+}
+
+// 指令进入队列，按进入的顺序编号，WATCHPREFIX据此跳过订阅之前进入队列的指令
+func (server *GoRedisServer) enqueue(cmd *Command) {
+	cmd.SetAttribute(C_QUEUE_SEQ, atomic.AddInt64(&server.queueSeq, 1))
 	server.rwwait.Add(1)
 	server.cmdChan <- cmd
+}
 
-	return
+// 执行期间调用Suspend或者长时间阻塞的指令，计入inflight会使Suspend死锁或者一直等待
+// 阻塞指令的写入由tracked计入
+var untrackedCmds = map[string]bool{
+	"BLPOP":       true,
+	"BRPOP":       true,
+	"BRPOPLPUSH":  true,
+	"BLMOVE":      true,
+	"BLMPOP":      true,
+	"BZPOPMIN":    true,
+	"BZPOPMAX":    true,
+	"WAIT":        true,
+	"MONITOR":     true,
+	"SYNC":        true,
+	"SLAVEOF":     true,
+	"REPLICAOF":   true,
+	"FLUSHALL":    true,
+	"FLUSHDB":     true,
+	"SWAPDB":      true,
+	"SAVE":        true,
+	"BACKUP":      true,
+	"MIGRATE":     true,
+	"EXPORT.JSON": true,
+	"DEBUG":       true,
+}
+
+// 进入指令处理，Suspend挂起期间等待
+func (server *GoRedisServer) enter() {
+	server.rwlock.RLock()
+	server.inflight.Add(1)
+	server.rwlock.RUnlock()
+}
+
+func (server *GoRedisServer) leave() {
+	server.inflight.Done()
+}
+
+// 阻塞指令每次尝试时计入inflight，成功写入时保持到指令进入队列，Suspend之后的快照不会缺少同步日志
+func (server *GoRedisServer) tracked(cmd *Command, try func() *Reply) func() *Reply {
+	return func() *Reply {
+		server.enter()
+		reply := try()
+		if reply == nil {
+			server.leave()
+		} else {
+			cmd.SetAttribute(C_INFLIGHT, true)
+		}
+		return reply
+	}
 }
 
 // 挂起指令处理
 func (server *GoRedisServer) Suspend() {
-	server.rwlock.Lock() // 锁定On(...)入口
-	server.rwwait.Wait() // 等待队列清空
+	server.rwlock.Lock()   // 锁定On(...)入口
+	server.inflight.Wait() // 等待正在执行的指令
+	server.rwwait.Wait()   // 等待队列清空
 }
 
 // 唤醒指令处理
@@ -181,9 +418,23 @@ func (server *GoRedisServer) processCommandChan() {
 
 		server.incrCommandCounter(cmdName)
 
-		// 从库
-		if server.synclog.IsEnabled() && needSync(cmdName) {
-			server.synclog.Write(cmd.Bytes())
+		// 热点key采样
+		server.sampleKey(cmd)
+
+		// 从库，改写过的指令优先，比如SPOP改为SREM
+		if c, ok := cmd.GetAttribute(C_SYNC_AS).(*Command); ok && server.synclog.IsEnabled() {
+			server.writeSyncLog(server.dbIndex(cmd), c.Bytes())
+		} else if server.synclog.IsEnabled() && needSync(cmdName) {
+			server.writeSyncLog(server.dbIndex(cmd), cmd.Bytes())
+		}
+		if mark, ok := cmd.GetAttribute(C_WRITE_MARK).(*writeMark); ok {
+			atomic.StoreInt64(&mark.seq, server.synclog.MaxSeq())
+		}
+
+		// 前缀订阅
+		if needSync(cmdName) && cmd.GetAttribute(C_FAILED) == nil {
+			server.notifySeq = cmd.GetAttribute(C_QUEUE_SEQ).(int64)
+			server.notifyWatchers(cmd)
 		}
 
 		// monitor
@@ -194,6 +445,11 @@ func (server *GoRedisServer) processCommandChan() {
 		// slowlog
 		server.calcExecTime(cmd)
 
+		// 指令录制
+		if r := server.currentRecorder(); r != nil {
+			r.Write(session.RemoteAddr().String(), cmd, cmd.GetAttribute(C_ELAPSED).(time.Duration))
+		}
+
 		server.rwwait.Done()
 	}
 }
@@ -233,17 +489,19 @@ func (server *GoRedisServer) calcExecTime(cmd *Command) {
 	if msec > slowexec {
 		session := cmd.GetAttribute(C_SESSION).(*Session)
 		slowlog.Printf("[%s] exec %0.2f ms [%s]\n", session.RemoteAddr(), msec, cmd)
+		server.writeSlowLog(cmd, elapsed)
 	}
 }
 
 // 首先搜索"On+大写NAME"格式的函数，存在则调用，不存在则调用OnUndefined
+// 指令名中的"."替换为"_"，如BLOB.PUT对应OnBLOB_PUT
 // OnGET(cmd *Command) (reply *Reply)
 // OnGET(session *Session, cmd *Command) (reply *Reply)
 func (server *GoRedisServer) invokeCommandHandler(session *Session, cmd *Command) (reply *Reply) {
 	cmdName := cmd.Name()
 	method, exists := server.methodCache[cmdName]
 	if !exists {
-		method = reflect.ValueOf(server).MethodByName("On" + cmdName)
+		method = reflect.ValueOf(server).MethodByName("On" + strings.Replace(cmdName, ".", "_", -1))
 		server.methodCache[cmdName] = method
 	}
 
@@ -1,17 +1,37 @@
 package goredis_server
 
+// AOF YES/NO、BGREWRITEAOF
+// appendonly.aof由快照和之后的同步日志组成：快照先写入appendonly.aof.tmp，完成后改名，再从快照时的seq开始追加同步日志
+// BGREWRITEAOF重新生成文件，改名之后替换正在追加的文件，重写期间原来的文件继续追加
+// config set appendfsync always/everysec/no，默认everysec，生成快照期间不fsync
+// 配置appendonly yes时启动后开启AOF，keyspace为空且文件存在时先回放，用于数据目录丢失后恢复
+// 追加部分每秒写入一次#TS:unixtime和#SEQ:下一条指令的seq，用于按时间点恢复，见go_redis_server_restore.go
 import (
 	. "GoRedis/goredis"
 	"GoRedis/libs/levelredis"
 	"GoRedis/libs/stdlog"
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	aofFilename    = "appendonly.aof"
+	appendFsyncKey = "appendfsync"
+)
+
+var (
+	AOFInitedError            = errors.New("aof already inited")
+	AOFRewriteInProgressError = errors.New("Background append only file rewriting already in progress")
+)
+
 func (server *GoRedisServer) OnAOF(session *Session, cmd *Command) (reply *Reply) {
 	defer func() {
 		if v := recover(); v != nil {
@@ -22,19 +42,9 @@ func (server *GoRedisServer) OnAOF(session *Session, cmd *Command) (reply *Reply
 
 	onoff := strings.ToUpper(cmd.StringAtIndex(1))
 	if onoff == "YES" {
-		if server.aofwriter != nil {
-			return ErrorReply("aof already inited")
+		if err := server.beginAOF(false); err != nil {
+			return ErrorReply(err)
 		}
-		if !server.synclog.IsEnabled() {
-			stdlog.Println("synclog enable")
-			server.synclog.Enable()
-		}
-		go func() {
-			err := server.aofStart()
-			if err != nil {
-				stdlog.Println("aof", err)
-			}
-		}()
 	} else if onoff == "NO" {
 		return server.onAOF_NO()
 	} else {
@@ -43,69 +53,112 @@ func (server *GoRedisServer) OnAOF(session *Session, cmd *Command) (reply *Reply
 	return StatusReply("OK")
 }
 
-func (server *GoRedisServer) aofStart() (err error) {
-	if server.aofwriter == nil {
-		filename := server.opt.LogPath() + "/appendonly.aof"
-		f, e := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
-		if e != nil {
-			return e
-		}
-		server.aofwriter = NewAOFWriter(bufio.NewWriter(f))
-		defer func() {
-			f.Close()
-			server.aofwriter.Close()
-			server.aofwriter = nil
-		}()
-		stdlog.Println("aof inited")
+// 没有开启AOF时与AOF YES相同
+func (server *GoRedisServer) OnBGREWRITEAOF(cmd *Command) (reply *Reply) {
+	if err := server.beginAOF(true); err != nil {
+		return ErrorReply(err)
 	}
-	server.Suspend()
-	snap := server.levelRedis.Snapshot()
-	snapclosed := false // 避免关闭两次
+	return StatusReply("Background append only file rewriting started")
+}
+
+// 创建appendonly.aof.tmp，在后台生成新的AOF文件
+// rewrite为false时已经开启则返回错误
+func (server *GoRedisServer) beginAOF(rewrite bool) (err error) {
+	server.aofMu.Lock()
+	defer server.aofMu.Unlock()
+	if server.aofpending != nil {
+		if rewrite {
+			return AOFRewriteInProgressError
+		}
+		return AOFInitedError
+	}
+	if !rewrite && server.aofwriter != nil {
+		return AOFInitedError
+	}
+	f, err := os.OpenFile(server.opt.LogPath()+"/"+aofFilename+".tmp", os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		return
+	}
+	if !server.synclog.IsEnabled() {
+		stdlog.Println("synclog enable")
+		server.synclog.Enable()
+	}
+	w := NewAOFFileWriter(f, aofFsyncNo)
+	server.aofpending = w
+	go func() {
+		if err := server.aofRun(w, f); err != nil {
+			stdlog.Println("aof", err)
+		}
+	}()
+	return
+}
+
+func (server *GoRedisServer) aofRun(w *AOFWriter, f *os.File) (err error) {
+	filename := server.opt.LogPath() + "/" + aofFilename
+	tmpname := filename + ".tmp"
+	renamed := false
 	defer func() {
-		if !snapclosed {
-			snap.Close()
+		w.Close()
+		f.Close()
+		server.aofMu.Lock()
+		if server.aofpending == w {
+			server.aofpending = nil
+		}
+		if server.aofwriter == w {
+			server.aofwriter = nil
+		}
+		server.aofMu.Unlock()
+		if !renamed {
+			os.Remove(tmpname)
 		}
 	}()
+
+	server.Suspend()
+	snap := server.levelRedis.Snapshot()
 	lastseq := server.synclog.MaxSeq()
+	dbmap := append([]int{}, server.dbmap[:server.dbCount()]...)
 	server.Resume()
 
-	snap.KeyEnumerate([]byte(""), levelredis.IterForward, func(i int, key, keytype, value []byte, quit *bool) {
-		// stdlog.Println(i, string(key), string(keytype))
-		if server.aofwriter.IsClosed() {
-			*quit = true
-			return
+	// 按逻辑db依次写入，每个db之前写入SELECT
+	for i, index := range dbmap {
+		w.Write(NewCommand(formatByteSlice("SELECT", i)...).Bytes())
+		db := snap.Database(index)
+		server.aofAppendKeys(w, db)
+		if db != snap {
+			db.Close()
 		}
-		switch string(keytype) {
-		case "zset":
-			server.aofwriter.AppendZSet(snap.GetSortedSet(string(key)))
-		case "hash":
-			server.aofwriter.AppendHash(snap.GetHash(string(key)))
-		case "set":
-			server.aofwriter.AppendSet(snap.GetSet(string(key)))
-		case "list":
-			server.aofwriter.AppendList(snap.GetList(string(key)))
-		case "string":
-			server.aofwriter.AppendString(key, value)
-		case "doc":
-			server.aofwriter.AppendDoc(snap.GetDoc(string(key)))
-		case "none":
-			stdlog.Println("bad key type", string(key), string(value))
-		default:
-			stdlog.Println("bad key type", string(key), string(keytype), string(value))
-		}
-	})
-
+	}
 	snap.Close()
-	snapclosed = true
 
-	if server.aofwriter.IsClosed() {
+	if w.IsClosed() {
 		return errors.New("aof closed")
 	}
 
 	seq := lastseq + 1
+	w.Write(NewCommand(formatByteSlice("SELECT", server.syncLogDBAt(seq))...).Bytes())
+	lastts := time.Now().Unix()
+	w.Annotate("TS", lastts)
+	w.Annotate("SEQ", seq)
+	if err = w.Sync(); err != nil {
+		return
+	}
+	if err = os.Rename(tmpname, filename); err != nil {
+		return
+	}
+	renamed = true
+	w.SetFsync(server.appendFsync())
+	server.aofMu.Lock()
+	old := server.aofwriter
+	server.aofwriter, server.aofpending = w, nil
+	server.aofMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	stdlog.Println("aof inited", filename)
+
 	deplymsec := 10
 	for {
-		if server.aofwriter.IsClosed() {
+		if w.IsClosed() {
 			return errors.New("aof closed")
 		}
 		var val []byte
@@ -115,6 +168,8 @@ func (server *GoRedisServer) aofStart() (err error) {
 			break
 		}
 		if val == nil {
+			// 空闲时补上everysec的fsync
+			w.Flush()
 			time.Sleep(time.Millisecond * time.Duration(deplymsec))
 			deplymsec += 10
 			if deplymsec >= 10000 {
@@ -125,20 +180,198 @@ func (server *GoRedisServer) aofStart() (err error) {
 			deplymsec = 10
 		}
 
-		server.aofwriter.Write(val)
-		server.aofwriter.Flush()
+		if now := time.Now().Unix(); now != lastts {
+			w.Annotate("TS", now)
+			w.Annotate("SEQ", seq)
+			lastts = now
+		}
+		w.Write(val)
+		w.Flush()
 
 		seq++
 	}
+	return
+}
 
-	return nil
+func (server *GoRedisServer) aofAppendKeys(w *AOFWriter, snap *levelredis.LevelRedis) {
+	snap.KeyEnumerate([]byte(""), levelredis.IterForward, func(i int, key, keytype, value []byte, quit *bool) {
+		// stdlog.Println(i, string(key), string(keytype))
+		if w.IsClosed() {
+			*quit = true
+			return
+		}
+		switch string(keytype) {
+		case "zset":
+			w.AppendZSet(snap.GetSortedSet(string(key)))
+		case "hash":
+			w.AppendHash(snap.GetHash(string(key)))
+		case "set":
+			w.AppendSet(snap.GetSet(string(key)))
+		case "list":
+			w.AppendList(snap.GetList(string(key)))
+		case "string":
+			if value, err := snap.Strings().Decode(key, value); err == nil {
+				w.AppendString(key, value)
+			} else {
+				stdlog.Println("decode string", string(key), err)
+			}
+		case "bitmap":
+			w.AppendString(key, snap.GetBitmap(string(key)).Bytes())
+		case "blob":
+			w.AppendBlob(snap.GetBlob(string(key)))
+		case "doc":
+			w.AppendDoc(snap.GetDoc(string(key)))
+		case "none":
+			stdlog.Println("bad key type", string(key), string(value))
+		default:
+			stdlog.Println("bad key type", string(key), string(keytype), string(value))
+		}
+	})
 }
 
 func (server *GoRedisServer) onAOF_NO() (reply *Reply) {
-	if server.aofwriter == nil {
+	server.aofMu.Lock()
+	w, pending := server.aofwriter, server.aofpending
+	server.aofMu.Unlock()
+	if w == nil && pending == nil {
 		return ErrorReply("aof not inited")
 	}
-	server.aofwriter.Close()
+	for _, a := range []*AOFWriter{w, pending} {
+		if a != nil {
+			a.Close()
+		}
+	}
 	stdlog.Println("aof closed")
 	return StatusReply("OK")
 }
+
+func (server *GoRedisServer) appendFsync() string {
+	switch s := server.config.StringForKey(appendFsyncKey); s {
+	case aofFsyncAlways, aofFsyncNo:
+		return s
+	}
+	return aofFsyncEverysec
+}
+
+// CONFIG SET appendfsync之后调用，正在生成快照的文件改名之后才使用新的策略
+func (server *GoRedisServer) initAppendFsync() {
+	server.aofMu.Lock()
+	defer server.aofMu.Unlock()
+	if server.aofwriter != nil {
+		server.aofwriter.SetFsync(server.appendFsync())
+	}
+}
+
+// INFO persistence里与redis相同的aof_*字段
+func (server *GoRedisServer) aofInfo() string {
+	server.aofMu.Lock()
+	defer server.aofMu.Unlock()
+	enabled, rewriting := 0, 0
+	if server.aofwriter != nil {
+		enabled = 1
+	}
+	if server.aofpending != nil {
+		rewriting = 1
+	}
+	return fmt.Sprintf("aof_enabled:%d\naof_rewrite_in_progress:%d\n", enabled, rewriting)
+}
+
+// 启动时开启AOF，需要在initSyncLog和initDatabases之后调用
+func (server *GoRedisServer) initAppendOnly() error {
+	if !server.opt.AppendOnly() {
+		return nil
+	}
+	filename := server.opt.LogPath() + "/" + aofFilename
+	if _, err := os.Stat(filename); err == nil && server.keyspaceEmpty() {
+		if err = server.replayAOF(filename, -1, 0); err != nil {
+			return err
+		}
+	}
+	return server.beginAOF(false)
+}
+
+func (server *GoRedisServer) keyspaceEmpty() bool {
+	for _, db := range server.databases() {
+		if key, _ := db.RandomKey(); key != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// 经过On()回放AOF文件，末尾不完整的指令(写入时崩溃)忽略
+// after小于0时回放全部指令，包括快照部分；否则只回放seq大于after的指令，AOF需要从after之前开始追加
+// until大于0时回放到#TS超过until为止
+func (server *GoRedisServer) replayAOF(filename string, after, until int64) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conn, _ := net.Pipe()
+	session := NewSession(conn)
+	// 与主库同步连接一样带上S_STATUS，从库上不被READONLY拒绝，也不受CLIENT PAUSE影响
+	session.SetAttribute(S_STATUS, REPL_ONLINE)
+	begin := time.Now()
+	count, failed := 0, 0
+	seq := int64(-1) // 下一条指令的seq，快照部分为-1
+	r := bufio.NewReaderSize(f, 1024*1024)
+	for {
+		c, name, value, err := readAOFEntry(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			stdlog.Printf("aof replay %s, stop at command %d: %s\n", filename, count, err)
+			break
+		}
+		if c == nil {
+			if name == "TS" && until > 0 && value > until {
+				break
+			}
+			if name == "SEQ" {
+				if seq == -1 && after >= 0 && value > after+1 {
+					return fmt.Errorf("aof starts at seq %d, missing %d-%d", value, after+1, value-1)
+				}
+				seq = value
+			}
+			continue
+		}
+		cur := seq
+		if seq != -1 {
+			seq++
+		}
+		// 跳过的部分也要执行SELECT，之后的指令在正确的db
+		if after >= 0 && cur <= after && c.Name() != "SELECT" {
+			continue
+		}
+		if reply := server.On(session, c); reply != nil && reply.Type == ReplyTypeError {
+			failed++
+		}
+		count++
+	}
+	stdlog.Printf("aof replay %s, %d commands, %d failed, %s\n", filename, count, failed, time.Since(begin))
+	return nil
+}
+
+// 读取一条指令，或者一行#name:value注释(c为nil)
+func readAOFEntry(r *bufio.Reader) (c *Command, name string, value int64, err error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if b[0] != '#' {
+		c, err = ReadCommand(r)
+		return
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	line = strings.TrimSpace(line[1:])
+	if i := strings.Index(line, ":"); i > 0 {
+		name = line[:i]
+		value, _ = strconv.ParseInt(line[i+1:], 10, 64)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package goredis_server
+
+// BACKUP [dir]
+// 用rocksdb的checkpoint在dir/db0生成数据目录某一时刻的副本，返回dir
+// dir可以直接作为-dbpath启动；默认在dbpath下的backup_20060102_150405，同一个文件系统上sst文件使用硬链接
+// 没有开启同步日志时不停止写入；开启时挂起指令处理直到checkpoint完成，dir/backup.info记录对应的seq，用于按时间点恢复
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/gorocks"
+	"GoRedis/libs/stdlog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (server *GoRedisServer) OnBACKUP(cmd *Command) (reply *Reply) {
+	if !gorocks.CheckpointSupported() {
+		return ErrorReply("BACKUP requires rocksdb 5.14 or later")
+	}
+	dir := filepath.Join(server.opt.DBPath(), time.Now().Format("backup_20060102_150405"))
+	if cmd.Len() > 1 {
+		dir = cmd.StringAtIndex(1)
+	}
+	dbhome := filepath.Join(dir, "db0")
+	if _, err := os.Stat(dbhome); err == nil {
+		return ErrorReply(dbhome + " already exists")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return ErrorReply(err)
+	}
+	begin := time.Now()
+	seq, suspended := int64(-1), server.synclog.IsEnabled()
+	if suspended {
+		server.Suspend()
+		seq = server.synclog.MaxSeq()
+	}
+	err := server.levelRedis.DB().Checkpoint(dbhome)
+	if suspended {
+		server.Resume()
+	}
+	if err == nil {
+		err = writeBackupInfo(dir, seq)
+	}
+	if err != nil {
+		stdlog.Println("backup", dbhome, err)
+		return ErrorReply(err)
+	}
+	stdlog.Printf("backup %s, %s\n", dbhome, time.Since(begin))
+	return BulkReply(dir)
+}
@@ -0,0 +1,344 @@
+package goredis_server
+
+// SETBIT/GETBIT/BITCOUNT/BITPOS/BITOP
+// SETBIT创建的key以分块的bitmap保存(见levelredis.LevelBitmap)，对已有的string执行SETBIT时先转换为bitmap；
+// 对外仍然表现为string，GET/STRLEN/GETRANGE/APPEND/SETRANGE都可以操作bitmap
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// 与redis一致，offset不超过2^32-1
+const maxBitOffset = 1<<32 - 1
+
+var (
+	BitOffsetError = errors.New("bit offset is not an integer or out of range")
+	BitRangeError  = errors.New("value is not an integer or out of range")
+)
+
+// 每个字节中1的个数
+var popcountTable [256]byte
+
+func init() {
+	for i := range popcountTable {
+		popcountTable[i] = popcountTable[i/2] + byte(i&1)
+	}
+}
+
+// bit指令的输入，string或分块保存的bitmap
+type bitSource interface {
+	Len() int64
+	Range(start, end int64, fn func(b []byte) bool)
+}
+
+type stringBits []byte
+
+func (s stringBits) Len() int64 {
+	return int64(len(s))
+}
+
+func (s stringBits) Range(start, end int64, fn func(b []byte) bool) {
+	if end >= int64(len(s)) {
+		end = int64(len(s)) - 1
+	}
+	if start <= end {
+		fn(s[start : end+1])
+	}
+}
+
+// key不存在时视为空字符串
+func bitSourceOf(redis *levelredis.LevelRedis, key []byte) (bitSource, error) {
+	if value, err := redis.Strings().Get(key); err != nil || value != nil {
+		return stringBits(value), err
+	}
+	if bm := redis.ExistingBitmap(string(key)); bm != nil {
+		return bm, nil
+	}
+	return stringBits(nil), nil
+}
+
+func parseBitOffset(s string) (offset int64, err error) {
+	offset, err = strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, BitOffsetError
+	}
+	return
+}
+
+// 与GETRANGE相同的规则处理负数和越界，返回的范围可能为空(start > end)
+func normalizeRange(start, end, length int64) (int64, int64) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end
+}
+
+// 解析 start end [BYTE|BIT]，返回bit为单位的范围
+func parseBitRange(cmd *Command, idx int, length int64) (bstart, bend int64, err error) {
+	start, e1 := strconv.ParseInt(cmd.StringAtIndex(idx), 10, 64)
+	end, e2 := strconv.ParseInt(cmd.StringAtIndex(idx+1), 10, 64)
+	if e1 != nil || e2 != nil {
+		return 0, 0, BitRangeError
+	}
+	unit := "BYTE"
+	if cmd.Len() > idx+2 {
+		unit = strings.ToUpper(cmd.StringAtIndex(idx + 2))
+	}
+	switch unit {
+	case "BYTE":
+		start, end = normalizeRange(start, end, length)
+		return start * 8, end*8 + 7, nil
+	case "BIT":
+		bstart, bend = normalizeRange(start, end, length*8)
+		return
+	}
+	return 0, 0, errors.New("syntax error")
+}
+
+// SETBIT key offset value
+func (server *GoRedisServer) OnSETBIT(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	offset, err := parseBitOffset(cmd.StringAtIndex(2))
+	if err != nil {
+		return ErrorReply(err)
+	}
+	on := cmd.StringAtIndex(3)
+	if on != "0" && on != "1" {
+		return ErrorReply("bit is not an integer or out of range")
+	}
+	mu := stringKeyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	bm := db.GetBitmap(string(key))
+	value, err := db.Strings().Get(key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if value != nil {
+		if err = bm.SetRange(0, value); err != nil {
+			return ErrorReply(err)
+		}
+		db.Strings().Delete(key)
+	}
+	old, err := bm.SetBit(offset, on == "1")
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(old)
+}
+
+// GETBIT key offset
+func (server *GoRedisServer) OnGETBIT(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	offset, err := parseBitOffset(cmd.StringAtIndex(2))
+	if err != nil {
+		return ErrorReply(err)
+	}
+	src, err := bitSourceOf(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	bit := 0
+	src.Range(offset/8, offset/8, func(b []byte) bool {
+		bit = int(b[0]>>uint(7-offset%8)) & 1
+		return false
+	})
+	return IntegerReply(bit)
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func (server *GoRedisServer) OnBITCOUNT(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	src, err := bitSourceOf(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	bstart, bend := int64(0), src.Len()*8-1
+	if cmd.Len() > 2 {
+		if bstart, bend, err = parseBitRange(cmd, 2, src.Len()); err != nil {
+			return ErrorReply(err)
+		}
+	}
+	if bstart > bend {
+		return IntegerReply(0)
+	}
+	count := 0
+	pos := bstart / 8
+	src.Range(bstart/8, bend/8, func(b []byte) bool {
+		for _, c := range b {
+			// 首尾字节去掉范围以外的bit
+			if pos == bstart/8 {
+				c &= 0xff >> uint(bstart%8)
+			}
+			if pos == bend/8 {
+				c &= 0xff << uint(7-bend%8)
+			}
+			count += int(popcountTable[c])
+			pos++
+		}
+		return true
+	})
+	return IntegerReply(count)
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+// 查找0且没有指定end时，值的右侧视为无限个0
+func (server *GoRedisServer) OnBITPOS(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	target := cmd.StringAtIndex(2)
+	if target != "0" && target != "1" {
+		return ErrorReply("The bit argument must be 1 or 0.")
+	}
+	src, err := bitSourceOf(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	length := src.Len()
+	bstart, bend := int64(0), length*8-1
+	switch {
+	case cmd.Len() == 4:
+		start, err := strconv.ParseInt(cmd.StringAtIndex(3), 10, 64)
+		if err != nil {
+			return ErrorReply(BitRangeError)
+		}
+		start, _ = normalizeRange(start, -1, length)
+		bstart = start * 8
+	case cmd.Len() > 4:
+		if bstart, bend, err = parseBitRange(cmd, 3, length); err != nil {
+			return ErrorReply(err)
+		}
+	}
+	// key不存在时查找0返回0，查找1返回-1
+	if length == 0 {
+		if target == "0" {
+			return IntegerReply(0)
+		}
+		return IntegerReply(-1)
+	}
+	if bstart > bend {
+		return IntegerReply(-1)
+	}
+	endGiven := cmd.Len() > 4
+
+	found := int64(-1)
+	skip := byte(0xff) // 查找0时跳过全1的字节
+	if target == "1" {
+		skip = 0
+	}
+	pos := bstart / 8
+	src.Range(bstart/8, bend/8, func(b []byte) bool {
+		for _, c := range b {
+			if c != skip {
+				lo, hi := pos*8, pos*8+7
+				if lo < bstart {
+					lo = bstart
+				}
+				if hi > bend {
+					hi = bend
+				}
+				for i := lo; i <= hi; i++ {
+					if int(c>>uint(7-i%8))&1 == int(target[0]-'0') {
+						found = i
+						return false
+					}
+				}
+			}
+			pos++
+		}
+		return true
+	})
+	if found == -1 && target == "0" && !endGiven {
+		found = bend + 1
+	}
+	return IntegerReply(int(found))
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+// 从快照读取输入，按块计算并写入destkey，结果以bitmap保存，长度为最长的输入
+func (server *GoRedisServer) OnBITOP(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	op := strings.ToUpper(cmd.StringAtIndex(1))
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if cmd.Len() != 4 {
+			return ErrorReply("BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return ErrorReply("syntax error")
+	}
+	snap := db.Snapshot()
+	defer snap.Close()
+	srcs := make([]bitSource, 0, cmd.Len()-3)
+	maxlen := int64(0)
+	for _, key := range cmd.Args()[3:] {
+		src, err := bitSourceOf(snap, key)
+		if err != nil {
+			return ErrorReply(err)
+		}
+		if src.Len() > maxlen {
+			maxlen = src.Len()
+		}
+		srcs = append(srcs, src)
+	}
+
+	destkey, _ := cmd.ArgAtIndex(2)
+	db.Delete(destkey)
+	if maxlen == 0 {
+		return IntegerReply(0)
+	}
+	dest := db.GetBitmap(string(destkey))
+	for w := int64(0); w < maxlen; w += levelredis.BitmapChunkSize {
+		n := maxlen - w
+		if n > levelredis.BitmapChunkSize {
+			n = levelredis.BitmapChunkSize
+		}
+		out := make([]byte, n)
+		buf := make([]byte, n)
+		for i, src := range srcs {
+			for j := range buf {
+				buf[j] = 0
+			}
+			copied := 0
+			src.Range(w, w+n-1, func(b []byte) bool {
+				copied += copy(buf[copied:], b)
+				return true
+			})
+			for j := range out {
+				switch {
+				case op == "NOT":
+					out[j] = ^buf[j]
+				case i == 0:
+					out[j] = buf[j]
+				case op == "AND":
+					out[j] &= buf[j]
+				case op == "OR":
+					out[j] |= buf[j]
+				case op == "XOR":
+					out[j] ^= buf[j]
+				}
+			}
+		}
+		if err := dest.SetRange(w, out); err != nil {
+			return ErrorReply(err)
+		}
+	}
+	return IntegerReply(int(maxlen))
+}
@@ -0,0 +1,127 @@
+package goredis_server
+
+// BLOB.PUT/BLOB.GET等按内容寻址的blob指令，内容按sha256去重保存(见levelredis.LevelBlob)
+// 大的value可以分段上传和下载，避免单条指令占用过多内存：
+// BLOB.APPEND key data 多次追加后 BLOB.COMMIT key 提交，BLOB.GET key offset count 分段读取
+import (
+	. "GoRedis/goredis"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// BLOB.PUT key value，返回sha256
+func (server *GoRedisServer) OnBLOB_PUT(cmd *Command) (reply *Reply) {
+	value, _ := cmd.ArgAtIndex(2)
+	if len(value) > maxStringLength {
+		return ErrorReply("blob exceeds maximum allowed size (512MB), use BLOB.APPEND")
+	}
+	sum, err := server.db(cmd).GetBlob(cmd.StringAtIndex(1)).Put(value)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply([]byte(sum))
+}
+
+// BLOB.LINK key sha256，内容已存在时直接引用并返回1，否则返回0，客户端需要重新上传
+func (server *GoRedisServer) OnBLOB_LINK(cmd *Command) (reply *Reply) {
+	sum := strings.ToLower(cmd.StringAtIndex(2))
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return ErrorReply("invalid sha256")
+	}
+	ok, err := server.db(cmd).GetBlob(cmd.StringAtIndex(1)).Link(sum)
+	if err != nil {
+		return ErrorReply(err)
+	} else if !ok {
+		return IntegerReply(0)
+	}
+	return IntegerReply(1)
+}
+
+// BLOB.APPEND key data，返回已上传的长度
+func (server *GoRedisServer) OnBLOB_APPEND(cmd *Command) (reply *Reply) {
+	data, _ := cmd.ArgAtIndex(2)
+	staged, err := server.db(cmd).GetBlob(cmd.StringAtIndex(1)).Append(data)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(int(staged))
+}
+
+// BLOB.COMMIT key，返回sha256
+func (server *GoRedisServer) OnBLOB_COMMIT(cmd *Command) (reply *Reply) {
+	sum, err := server.db(cmd).GetBlob(cmd.StringAtIndex(1)).Commit()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply([]byte(sum))
+}
+
+// BLOB.ABORT key，放弃未提交的上传
+func (server *GoRedisServer) OnBLOB_ABORT(cmd *Command) (reply *Reply) {
+	ok, err := server.db(cmd).GetBlob(cmd.StringAtIndex(1)).Abort()
+	if err != nil {
+		return ErrorReply(err)
+	} else if !ok {
+		return IntegerReply(0)
+	}
+	return IntegerReply(1)
+}
+
+// BLOB.GET key [offset count]，key不存在时返回nil
+func (server *GoRedisServer) OnBLOB_GET(cmd *Command) (reply *Reply) {
+	blob := server.db(cmd).GetBlob(cmd.StringAtIndex(1))
+	sum, size, _, err := blob.Stat()
+	if err != nil {
+		return ErrorReply(err)
+	} else if len(sum) == 0 {
+		return BulkReply(nil)
+	}
+	start, end := int64(0), size-1
+	if cmd.Len() == 4 {
+		offset, e1 := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
+		count, e2 := strconv.ParseInt(cmd.StringAtIndex(3), 10, 64)
+		if e1 != nil || e2 != nil || offset < 0 || count < 0 {
+			return ErrorReply(NotIntegerError)
+		}
+		start, end = offset, offset+count-1
+	} else if cmd.Len() != 2 {
+		return ErrorReply("syntax error")
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if end-start+1 > maxStringLength {
+		return ErrorReply("blob exceeds maximum allowed size (512MB), use BLOB.GET key offset count")
+	}
+	value := make([]byte, 0)
+	if start <= end {
+		value = make([]byte, 0, end-start+1)
+	}
+	if err = blob.Range(start, end, func(b []byte) bool {
+		value = append(value, b...)
+		return true
+	}); err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply(value)
+}
+
+// BLOB.STAT key，返回[sha256, 长度, 引用数, 未提交的上传长度]，都不存在时返回nil
+func (server *GoRedisServer) OnBLOB_STAT(cmd *Command) (reply *Reply) {
+	blob := server.db(cmd).GetBlob(cmd.StringAtIndex(1))
+	sum, size, refs, err := blob.Stat()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	staged := blob.Staged()
+	if len(sum) == 0 && staged == 0 {
+		return MultiBulksReply(nil)
+	}
+	var hash []byte
+	if len(sum) > 0 {
+		hash = []byte(sum)
+	}
+	return MultiBulksReply([]interface{}{hash, int(size), int(refs), int(staged)})
+}
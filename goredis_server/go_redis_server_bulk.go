@@ -0,0 +1,70 @@
+package goredis_server
+
+// BULK.WRITE 批量导入，每次调用是一批记录，整批在一个WriteBatch里提交，返回记录数作为确认
+// 记录以类型开头：SET key value、HSET key field value、SADD key member、ZADD key score member
+// 不触发WATCHPREFIX通知，指令原样同步到从库
+//
+//	bulk.write hset user:1 name latermoon sadd users user:1 zadd rank 100 user:1
+import (
+	. "GoRedis/goredis"
+	"math"
+	"strings"
+)
+
+// BULK.WRITE record [record ...]
+func (server *GoRedisServer) OnBULK_WRITE(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	args := cmd.Args()
+	w := db.NewBulkWriter()
+	keys := make([][]byte, 0, len(args)/3)
+	for i := 1; i < len(args); {
+		var err error
+		switch strings.ToUpper(string(args[i])) {
+		case "SET":
+			if i+2 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			err = w.Set(args[i+1], args[i+2])
+			keys, i = append(keys, args[i+1]), i+3
+		case "HSET":
+			if i+3 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			err = w.HSet(args[i+1], args[i+2], args[i+3])
+			keys, i = append(keys, args[i+1]), i+4
+		case "SADD":
+			if i+2 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			err = w.SAdd(args[i+1], args[i+2])
+			keys, i = append(keys, args[i+1]), i+3
+		case "ZADD":
+			if i+3 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			score, e := parseScore(string(args[i+2]))
+			if e != nil || math.IsNaN(score) {
+				return ErrorReply(NotFloatError)
+			}
+			err = w.ZAdd(args[i+1], score, args[i+3])
+			keys, i = append(keys, args[i+1]), i+4
+		default:
+			return ErrorReply("syntax error")
+		}
+		if err != nil {
+			return ErrorReply(err)
+		}
+	}
+	// 写入已过期的key之前先删除旧数据
+	if db.HasExpire() && !server.isReplica() {
+		now, onExpired := nowMillis(), server.expiredIn(server.dbIndex(cmd))
+		for _, key := range keys {
+			db.ExpireIfNeeded(key, now, onExpired)
+		}
+	}
+	n, err := w.Flush()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(n)
+}
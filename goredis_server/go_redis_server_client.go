@@ -4,30 +4,223 @@ import (
 	. "GoRedis/goredis"
 	"bytes"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func (server *GoRedisServer) OnCLIENT(session *Session, cmd *Command) (reply *Reply) {
 	switch strings.ToUpper(cmd.StringAtIndex(1)) {
 	case "LIST":
-		reply = server.replyClientList(session, cmd)
+		reply = BulkReply(server.clientList())
+	case "INFO":
+		reply = VerbatimReply("txt", clientInfoLine(session))
+	case "SETINFO":
+		reply = server.clientSetInfo(session, cmd)
+	case "PAUSE":
+		reply = server.clientPauseCommand(cmd)
+	case "UNPAUSE":
+		server.clientPause.Unpause()
+		reply = StatusReply("OK")
+	case "KILL":
+		reply = server.clientKill(session, cmd)
 	default:
 		reply = ErrorReply("not support")
 	}
 	return
 }
 
+// HELLO [protover]，协商协议版本，3时使用RESP3的类型返回double/map等
+func (server *GoRedisServer) OnHELLO(session *Session, cmd *Command) (reply *Reply) {
+	proto := session.Protocol()
+	if cmd.Len() > 1 {
+		var err error
+		if proto, err = cmd.IntAtIndex(1); err != nil {
+			return ErrorReply("Protocol version is not an integer or out of range")
+		}
+		if proto != 2 && proto != 3 {
+			return ErrorReply("NOPROTO unsupported protocol version")
+		}
+	}
+	session.SetProtocol(proto)
+	return MapReply([]interface{}{
+		"server", "goredis",
+		"version", VERSION,
+		"proto", proto,
+		"mode", "standalone",
+		"role", server.info.Role(),
+		"modules", []interface{}{},
+	})
+}
+
 // Get the list of client connections
-func (server *GoRedisServer) replyClientList(session *Session, cmd *Command) (reply *Reply) {
+func (server *GoRedisServer) clientList() string {
 	buf := bytes.Buffer{}
 	server.sessmgr.Enumerate(func(i int, key string, val interface{}) {
 		sess := val.(*Session)
-		lastcmd := sess.GetAttribute("cmd")
+		lastcmd := sess.GetAttribute(S_LAST_COMMAND)
 		if lastcmd == nil {
 			lastcmd = ""
 		}
-		buf.WriteString(fmt.Sprintf("addr=%s i=%d cmd=%s\n", key, i, lastcmd))
+		libname, libver := sessionLibInfo(sess)
+		buf.WriteString(fmt.Sprintf("addr=%s i=%d cmd=%s lib-name=%s lib-ver=%s\n", key, i, lastcmd, libname, libver))
 	})
-	reply = BulkReply(buf.Bytes())
+	return buf.String()
+}
+
+// CLIENT INFO，当前连接的信息，格式与CLIENT LIST的一行相同
+func clientInfoLine(session *Session) string {
+	lastcmd := session.GetAttribute(S_LAST_COMMAND)
+	if lastcmd == nil {
+		lastcmd = ""
+	}
+	libname, libver := sessionLibInfo(session)
+	return fmt.Sprintf("addr=%s db=%d cmd=%s lib-name=%s lib-ver=%s resp=%d\n", session.RemoteAddr(), sessionDB(session), lastcmd, libname, libver, session.Protocol())
+}
+
+// CLIENT SETINFO <LIB-NAME libname | LIB-VER libver>
+// 客户端库在握手时上报名称和版本，用于排查旧版本客户端引起的协议问题
+func (server *GoRedisServer) clientSetInfo(session *Session, cmd *Command) (reply *Reply) {
+	if cmd.Len() != 4 {
+		return ErrorReply(WrongArgumentCount)
+	}
+	value := cmd.StringAtIndex(3)
+	// 与redis一致，不允许包含空格和换行，避免破坏CLIENT LIST的输出格式
+	if strings.ContainsAny(value, " \r\n") {
+		return ErrorReply("lib-name/lib-ver cannot contain spaces, newlines or special characters")
+	}
+	switch strings.ToUpper(cmd.StringAtIndex(2)) {
+	case "LIB-NAME":
+		session.SetAttribute(S_LIB_NAME, value)
+	case "LIB-VER":
+		session.SetAttribute(S_LIB_VER, value)
+	default:
+		return ErrorReply("unrecognized option: " + cmd.StringAtIndex(2))
+	}
+	return StatusReply("OK")
+}
+
+// CLIENT PAUSE timeout [WRITE|ALL]
+// timeout单位为毫秒，默认ALL
+func (server *GoRedisServer) clientPauseCommand(cmd *Command) (reply *Reply) {
+	if cmd.Len() != 3 && cmd.Len() != 4 {
+		return ErrorReply(WrongArgumentCount)
+	}
+	ms, err := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
+	if err != nil || ms < 0 {
+		return ErrorReply("timeout is not an integer or out of range")
+	}
+	all := true
+	if cmd.Len() == 4 {
+		switch strings.ToUpper(cmd.StringAtIndex(3)) {
+		case "WRITE":
+			all = false
+		case "ALL":
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+	server.clientPause.Pause(time.Duration(ms)*time.Millisecond, all)
+	return StatusReply("OK")
+}
+
+// CLIENT KILL addr
+// CLIENT KILL [ADDR addr] [TYPE normal|master|slave|replica] [SKIPME yes|no]
+// 第一种形式返回OK，第二种返回关闭的连接数；主从切换后用TYPE normal断开客户端，让客户端重连到新的主库
+// master为连接主库的同步连接，关闭后会自动重连；slave为从库的同步连接
+func (server *GoRedisServer) clientKill(session *Session, cmd *Command) (reply *Reply) {
+	if cmd.Len() == 3 {
+		sess, ok := server.sessmgr.Get(cmd.StringAtIndex(2)).(*Session)
+		if !ok {
+			return ErrorReply("No such client")
+		}
+		sess.Close()
+		return StatusReply("OK")
+	}
+	if cmd.Len()%2 != 0 {
+		return ErrorReply("syntax error")
+	}
+	addr, typ, skipme := "", "", true
+	for i := 2; i < cmd.Len(); i += 2 {
+		value := cmd.StringAtIndex(i + 1)
+		switch strings.ToUpper(cmd.StringAtIndex(i)) {
+		case "ADDR":
+			addr = value
+		case "TYPE":
+			typ = strings.ToLower(value)
+			if typ == "replica" {
+				typ = "slave"
+			}
+			if typ != "normal" && typ != "master" && typ != "slave" {
+				return ErrorReply("Unknown client type '" + value + "'")
+			}
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+			case "no":
+				skipme = false
+			default:
+				return ErrorReply("syntax error")
+			}
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+	n := 0
+	if typ == "" || typ == "master" {
+		server.slavemgr.Enumerate(func(i int, key string, val interface{}) {
+			if len(addr) == 0 || key == addr {
+				val.(ISlaveClient).Session().Close()
+				n++
+			}
+		})
+	}
+	if typ != "master" {
+		server.sessmgr.Enumerate(func(i int, key string, val interface{}) {
+			sess := val.(*Session)
+			if (skipme && sess == session) || (len(addr) > 0 && key != addr) {
+				return
+			}
+			if slave := sess.GetAttribute(S_STATUS) != nil; len(typ) > 0 && slave != (typ == "slave") {
+				return
+			}
+			sess.Close()
+			n++
+		})
+	}
+	return IntegerReply(n)
+}
+
+func sessionLibInfo(sess *Session) (libname, libver string) {
+	if v, ok := sess.GetAttribute(S_LIB_NAME).(string); ok {
+		libname = v
+	}
+	if v, ok := sess.GetAttribute(S_LIB_VER).(string); ok {
+		libver = v
+	}
 	return
 }
+
+// 按lib-name/lib-ver统计连接数，输出到INFO clients
+// client_lib0:name=redis-py,ver=4.5.1,connections=3
+func (server *GoRedisServer) clientLibInfo() string {
+	libs := make(map[string]int)
+	server.sessmgr.Enumerate(func(i int, key string, val interface{}) {
+		libname, libver := sessionLibInfo(val.(*Session))
+		if len(libname) == 0 && len(libver) == 0 {
+			return
+		}
+		libs[fmt.Sprintf("name=%s,ver=%s", libname, libver)]++
+	})
+	names := make([]string, 0, len(libs))
+	for name := range libs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := bytes.Buffer{}
+	for i, name := range names {
+		buf.WriteString(fmt.Sprintf("client_lib%d:%s,connections=%d\n", i, name, libs[name]))
+	}
+	return buf.String()
+}
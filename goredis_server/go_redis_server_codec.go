@@ -0,0 +1,77 @@
+package goredis_server
+
+// 值编解码(-encryptkey的加密)只作用于string，其它类型的数据不经过codec，明文写入rocksdb
+// 为了不在编码的前缀下悄悄留下明文，匹配codec的key上拒绝写入其它类型：
+// hash/list/set/zset/blob的写指令、SETBIT/BITOP创建的bitmap、DOC_SET/DOC_REVERT，
+// 以及把其它类型的key RENAME/COPY过来；RESTORE在解析出类型之后检查
+// 同步连接上的指令由主库检查过，不再检查；BULK.WRITE、IMPORT、IMPORT.JSON、RDB导入写入原始数据，不检查
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"errors"
+)
+
+var CodecTypeError = errors.New("CODEC only string values can be stored under an encoded key prefix")
+
+// 类别是string但写入的数据不经过codec，以及没有类别的doc指令
+var codecBypassCmds = map[string]bool{
+	"SETBIT":     true,
+	"BITOP":      true,
+	"DOC_SET":    true,
+	"DOC_REVERT": true,
+}
+
+// 写入目标不在typedKeys里的位置
+var codecDstAt = map[string]int{
+	"BITOP": 2,
+	"SMOVE": 2,
+}
+
+func (server *GoRedisServer) checkCodecKeys(session *Session, cmd *Command) error {
+	name := cmd.Name()
+	if session.GetAttribute(S_STATUS) != nil || (!needSync(name) && !pauseWriteCmds[name] && !codecBypassCmds[name]) {
+		return nil
+	}
+	var keys [][]byte
+	switch name {
+	case "RENAME", "RENAMENX", "COPY":
+		src, _ := cmd.ArgAtIndex(1)
+		if t := server.db(cmd).TypeOf(src); t == "none" || t == levelredis.STRING_SUFFIX {
+			return nil
+		}
+		dst, _ := cmd.ArgAtIndex(2)
+		keys = [][]byte{dst}
+	default:
+		if types := keyTypesOf(name); !codecBypassCmds[name] && (types == nil || types[0] == levelredis.STRING_SUFFIX) {
+			return nil
+		}
+		keys = typedKeys(cmd)
+		// STORE类指令的目标key
+		if key, err := cmd.ArgAtIndex(1); err == nil {
+			keys = append(keys, key)
+		}
+		if at, ok := codecDstAt[name]; ok {
+			if key, err := cmd.ArgAtIndex(at); err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		if server.levelRedis.HasCodec(key) {
+			return CodecTypeError
+		}
+	}
+	return nil
+}
+
+// RESTORE的类型在解析payload之后才知道，只检查客户端连接上的指令
+func (server *GoRedisServer) checkRestoreCodec(cmd *Command, key []byte, typ string) error {
+	session, ok := cmd.GetAttribute(C_SESSION).(*Session)
+	if !ok || session.GetAttribute(S_STATUS) != nil || typ == levelredis.STRING_SUFFIX {
+		return nil
+	}
+	if server.levelRedis.HasCodec(key) {
+		return CodecTypeError
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package goredis_server
+
+// COMPACT [prefix]
+// 没有prefix时compact整个rocksdb，否则只compact当前db里以prefix开头的key
+// 在当前连接上同步执行，完成后返回耗时(毫秒)，期间其它连接的读写不受影响
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"time"
+)
+
+func (server *GoRedisServer) OnCOMPACT(cmd *Command) (reply *Reply) {
+	begin := time.Now()
+	if cmd.Len() > 1 {
+		prefix, _ := cmd.ArgAtIndex(1)
+		server.db(cmd).CompactPrefix(prefix)
+		stdlog.Printf("compact db%d prefix %s, %s\n", server.dbIndex(cmd), prefix, time.Since(begin))
+	} else {
+		server.levelRedis.CompactAll()
+		stdlog.Printf("compact all, %s\n", time.Since(begin))
+	}
+	return IntegerReply(int(time.Since(begin) / time.Millisecond))
+}
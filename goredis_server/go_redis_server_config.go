@@ -2,6 +2,9 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +24,20 @@ func (server *GoRedisServer) OnCONFIG(cmd *Command) (reply *Reply) {
 		key := cmd.StringAtIndex(2)
 		value := cmd.StringAtIndex(3)
 		server.config.Set(key, []byte(value))
+		switch key {
+		case slaveMaxLagKey:
+			server.initSlaveMaxLag()
+		case replicaReadOnlyKey:
+			server.initReplicaReadOnly()
+		case largeCollectionThresholdKey, largeCollectionActionKey:
+			server.initLargeCollectionGuard()
+		case slowlogPersistKey, slowlogMaxLenKey:
+			server.initSlowLogStore()
+		case docHistoryLenKey:
+			server.initDocHistory()
+		case appendFsyncKey:
+			server.initAppendFsync()
+		}
 		reply = StatusReply("OK")
 	default:
 		reply = ErrorReply("bad config action: " + action)
@@ -30,6 +47,9 @@ func (server *GoRedisServer) OnCONFIG(cmd *Command) (reply *Reply) {
 
 func (server *GoRedisServer) configGet(cmd *Command) (reply *Reply) {
 	patten := cmd.StringAtIndex(2)
+	if cmd.Len() > 3 && strings.ToUpper(cmd.StringAtIndex(3)) == "WITHSOURCE" {
+		return server.configGetWithSource(patten)
+	}
 	if patten == "*" {
 		bulks := make([]interface{}, 0, 10)
 		keys := server.config.Keys()
@@ -49,3 +69,76 @@ func (server *GoRedisServer) configGet(cmd *Command) (reply *Reply) {
 	}
 	return
 }
+
+// CONFIG GET pattern WITHSOURCE
+// 包括启动参数和运行期配置，每项返回 name, value, source
+func (server *GoRedisServer) configGetWithSource(pattern string) (reply *Reply) {
+	keys := make([]string, 0, len(confRuntimeKeys))
+	for key := range confRuntimeKeys {
+		keys = append(keys, key)
+	}
+	for _, key := range server.config.Keys() {
+		if _, ok := confRuntimeKeys[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	keys = append(append([]string{}, confStartupKeys...), keys...)
+
+	bulks := make([]interface{}, 0, len(keys)*3)
+	for _, key := range keys {
+		if !globMatch(pattern, key) {
+			continue
+		}
+		value, source := server.configValueSource(key)
+		bulks = append(bulks, key, value, source)
+	}
+	return MultiBulksReply(bulks)
+}
+
+// 启动参数返回启动时的值，运行期配置与启动时写入的值不同时，说明被CONFIG SET修改过
+func (server *GoRedisServer) configValueSource(key string) (value string, source string) {
+	opt := server.opt
+	switch key {
+	case "bind":
+		return opt.Host(), opt.Source(key)
+	case "port":
+		return strconv.Itoa(opt.Port()), opt.Source(key)
+	case "dbpath":
+		return opt.DBPath(), opt.Source(key)
+	case "logpath":
+		return opt.LogPath(), opt.Source(key)
+	case "slaveof":
+		if host, port := opt.SlaveOf(); len(host) > 0 {
+			value = fmt.Sprintf("%s %d", host, port)
+		}
+		return value, opt.Source(key)
+	case "warmup":
+		return opt.WarmUp(), opt.Source(key)
+	case "databases":
+		return strconv.Itoa(opt.Databases()), opt.Source(key)
+	case "loadrdb":
+		return opt.LoadRDB(), opt.Source(key)
+	case "restore":
+		return opt.Restore(), opt.Source(key)
+	case "restore-until":
+		if until := opt.RestoreUntil(); until > 0 {
+			value = strconv.FormatInt(until, 10)
+		}
+		return value, opt.Source(key)
+	case "appendonly":
+		if opt.AppendOnly() {
+			return "yes", opt.Source(key)
+		}
+		return "no", opt.Source(key)
+	}
+	stored := server.config.Get(key)
+	value = string(stored)
+	if v, ok := opt.Configs()[key]; ok && v == value {
+		return value, opt.Source(key)
+	}
+	if stored != nil {
+		return value, SourceRuntime
+	}
+	return value, SourceDefault
+}
@@ -0,0 +1,161 @@
+package goredis_server
+
+// CRON.ADD/CRON.DEL/CRON.LIST/CRON.RUN 服务端执行的定时任务
+// 任务保存在系统前缀下，指令通过On()执行，和客户端的写入一样写入同步日志，同步到从库；
+// CRON.ADD/CRON.DEL同步到从库，全量同步的快照也包含任务表，从库不执行任务(包括过期触发)，切换为主库后开始执行
+//
+//	cron.add trim "0 3 * * *" zremrangebyscore events -inf 1000
+//	cron.add cleanup @expire:session: del session_data:$KEY
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"net"
+	"strings"
+	"time"
+)
+
+// 任务不能执行阻塞指令和管理指令
+var cronBlockingCmds = map[string]bool{
+	"BLPOP":      true,
+	"BRPOP":      true,
+	"BRPOPLPUSH": true,
+	"BLMOVE":     true,
+	"BLMPOP":     true,
+	"BZPOPMIN":   true,
+	"BZPOPMAX":   true,
+}
+
+// 等待执行的过期触发，满时丢弃
+const cronExpireQueueLen = 1000
+
+// 任务表的系统前缀，全量同步时发送给从库
+const cronPrefix = PREFIX + "cron:"
+
+func (server *GoRedisServer) initCron() {
+	conn, _ := net.Pipe()
+	server.cronSession = NewSession(conn)
+	server.cronTable = NewCronTable(server.levelRedis, cronPrefix)
+	server.cronExpired = make(chan []byte, cronExpireQueueLen)
+	go server.cronLoop()
+}
+
+// 每分钟执行一次匹配的任务，其余时间处理过期触发
+func (server *GoRedisServer) cronLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now().Truncate(time.Minute)
+	for !server.closing {
+		select {
+		case now := <-ticker.C:
+			minute := now.Truncate(time.Minute)
+			if !minute.After(last) {
+				continue
+			}
+			last = minute
+			if server.isReplica() {
+				continue
+			}
+			jobs := server.cronTable.Jobs(func(job *CronJob) bool {
+				return !job.OnExpire() && job.cron.Match(minute)
+			})
+			for i := range jobs {
+				server.runCronJob(&jobs[i], nil)
+			}
+		case key := <-server.cronExpired:
+			if server.isReplica() {
+				continue
+			}
+			jobs := server.cronTable.Jobs(func(job *CronJob) bool {
+				return job.OnExpire() && strings.HasPrefix(string(key), job.prefix)
+			})
+			for i := range jobs {
+				server.runCronJob(&jobs[i], key)
+			}
+		}
+	}
+}
+
+// 由过期删除调用，持有key的过期锁，不能在这里执行任务
+func (server *GoRedisServer) cronOnExpired(key []byte) {
+	if server.cronExpired == nil {
+		return
+	}
+	select {
+	case server.cronExpired <- key:
+	default:
+		stdlog.Printf("cron expire queue full, drop %s\n", key)
+	}
+}
+
+func (server *GoRedisServer) runCronJob(job *CronJob, key []byte) (reply *Reply) {
+	cmd := NewCommand(job.CommandArgs(key)...)
+	reply = server.On(server.cronSession, cmd)
+	errmsg := ""
+	if reply != nil && reply.Type == ReplyTypeError {
+		errmsg = reply.Value.(string)
+		stdlog.Printf("cron %s [%s] error %s\n", job.Name, cmd, errmsg)
+	}
+	server.cronTable.Done(job.Name, job.Spec, errmsg)
+	return
+}
+
+// CRON.ADD name spec command [arg ...]，同名任务被替换
+func (server *GoRedisServer) OnCRON_ADD(cmd *Command) (reply *Reply) {
+	name := cmd.StringAtIndex(1)
+	args := make([]string, 0, cmd.Len()-3)
+	for _, arg := range cmd.Args()[3:] {
+		args = append(args, string(arg))
+	}
+	job, err := NewCronJob(name, cmd.StringAtIndex(2), args)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	target := NewCommand(job.CommandArgs(nil)...)
+	if err = verifyCommand(target); err != nil {
+		return ErrorReply(err)
+	}
+	switch commandCategory(target.Name()) {
+	case CCateKey, CCateString, CCateHash, CCateList, CCateSet, CCateSortedSet:
+	default:
+		if !strings.HasPrefix(target.Name(), "DOC_") {
+			return ErrorReply("command not allowed in cron: " + target.Name())
+		}
+	}
+	if cronBlockingCmds[target.Name()] {
+		return ErrorReply("command not allowed in cron: " + target.Name())
+	}
+	if err = server.cronTable.Add(job); err != nil {
+		return ErrorReply(err)
+	}
+	return StatusReply("OK")
+}
+
+// CRON.DEL name
+func (server *GoRedisServer) OnCRON_DEL(cmd *Command) (reply *Reply) {
+	if server.cronTable.Remove(cmd.StringAtIndex(1)) {
+		return IntegerReply(1)
+	}
+	return IntegerReply(0)
+}
+
+// CRON.LIST，每个任务返回[name, spec, command, 执行次数, 最后执行时间, 最后的错误]
+func (server *GoRedisServer) OnCRON_LIST(cmd *Command) (reply *Reply) {
+	jobs := server.cronTable.Jobs(nil)
+	bulks := make([]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		bulks = append(bulks, []interface{}{job.Name, job.Spec, strings.Join(job.Args, " "), int(job.Runs), int(job.LastRun), job.LastError})
+	}
+	return MultiBulksReply(bulks)
+}
+
+// CRON.RUN name，立即执行一次，返回指令的结果；过期触发的任务$KEY保持原样
+func (server *GoRedisServer) OnCRON_RUN(cmd *Command) (reply *Reply) {
+	if server.isReplica() {
+		return ErrorReply("cron jobs do not run on slave")
+	}
+	job, ok := server.cronTable.Get(cmd.StringAtIndex(1))
+	if !ok {
+		return ErrorReply("no such cron job")
+	}
+	return server.runCronJob(&job, nil)
+}
@@ -0,0 +1,217 @@
+package goredis_server
+
+// 多db: SELECT index、MOVE key db、SWAPDB index1 index2
+// 每个db是levelredis里的一个raw key前缀(db0没有前缀，其它为@n)，共用同一个rocksdb
+// 连接选择的db保存在session里，On(...)记录到指令的C_DB属性，处理函数通过server.db(cmd)访问
+// 阻塞指令、MIGRATE等不经过Suspend等待，dbs的读取和SWAPDB的交换由dbsMu保护
+// SWAPDB只交换逻辑db到前缀的映射，映射保存在_n，随快照同步到从库
+// 写指令进入同步日志时，db与上一条不同则先写入SELECT
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDatabases = 16
+	dbMapKey         = "_n" // 逻辑db对应的前缀编号，"0,1,2,..."
+	// 同步日志里至少每隔这么多条重复一次SELECT，从任意seq开始发送时向前查找的上限
+	selectCheckpoint = 10000
+)
+
+// 同步日志中SELECT index的开头
+var selectPrefix = []byte("*2\r\n$6\r\nSELECT\r\n")
+
+// 读取SWAPDB之后的映射，创建每个db的LevelRedis，需要在注册codec之后调用
+// 从库收到快照后重新调用
+func (server *GoRedisServer) initDatabases() {
+	n := server.opt.Databases()
+	dbmap := make([]int, 0, n)
+	if value, _ := server.levelRedis.RawGet([]byte(dbMapKey)); len(value) > 0 {
+		for _, s := range strings.Split(string(value), ",") {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				stdlog.Printf("bad db map %s, ignored\n", value)
+				dbmap = dbmap[:0]
+				break
+			}
+			dbmap = append(dbmap, i)
+		}
+	}
+	// databases比上次多时补齐，少时保留多出的映射，调大后仍然可以访问原来的数据
+	for i := len(dbmap); i < n; i++ {
+		dbmap = append(dbmap, i)
+	}
+	dbs := make([]*levelredis.LevelRedis, n)
+	for i := range dbs {
+		dbs[i] = server.physicalDB(dbmap[i])
+	}
+	server.dbsMu.Lock()
+	server.dbmap, server.dbs = dbmap, dbs
+	server.dbsMu.Unlock()
+	// 总是保存，快照里带上映射，从库不会沿用自己原来的映射
+	if err := server.saveDBMap(); err != nil {
+		stdlog.Println("save db map", err)
+	}
+}
+
+// 每个前缀只创建一个LevelRedis，重新加载映射(从库收到快照)时复用
+func (server *GoRedisServer) physicalDB(index int) (db *levelredis.LevelRedis) {
+	if server.physdbs == nil {
+		server.physdbs = make(map[int]*levelredis.LevelRedis)
+	}
+	db, ok := server.physdbs[index]
+	if !ok {
+		db = server.levelRedis.Database(index)
+		server.physdbs[index] = db
+	}
+	return
+}
+
+func (server *GoRedisServer) saveDBMap() error {
+	s := make([]string, len(server.dbmap))
+	for i, index := range server.dbmap {
+		s[i] = strconv.Itoa(index)
+	}
+	return server.levelRedis.RawSet([]byte(dbMapKey), []byte(strings.Join(s, ",")))
+}
+
+func sessionDB(session *Session) int {
+	if index, ok := session.GetAttribute(S_DB).(int); ok {
+		return index
+	}
+	return 0
+}
+
+// 指令所在的db，不经过On(...)的指令(过期删除、同步快照等)在db0
+func (server *GoRedisServer) dbIndex(cmd *Command) int {
+	if index, ok := cmd.GetAttribute(C_DB).(int); ok {
+		return index
+	}
+	return 0
+}
+
+func (server *GoRedisServer) db(cmd *Command) *levelredis.LevelRedis {
+	return server.dbAt(server.dbIndex(cmd))
+}
+
+func (server *GoRedisServer) dbAt(index int) *levelredis.LevelRedis {
+	server.dbsMu.RLock()
+	defer server.dbsMu.RUnlock()
+	return server.dbs[index]
+}
+
+// 全部db的副本，遍历期间SWAPDB不影响
+func (server *GoRedisServer) databases() []*levelredis.LevelRedis {
+	server.dbsMu.RLock()
+	defer server.dbsMu.RUnlock()
+	return append([]*levelredis.LevelRedis{}, server.dbs...)
+}
+
+func (server *GoRedisServer) dbCount() int {
+	server.dbsMu.RLock()
+	defer server.dbsMu.RUnlock()
+	return len(server.dbs)
+}
+
+func (server *GoRedisServer) parseDBIndex(s string) (index int, reply *Reply) {
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrorReply("invalid DB index")
+	}
+	if index < 0 || index >= server.dbCount() {
+		return 0, ErrorReply("DB index is out of range")
+	}
+	return
+}
+
+// SELECT index
+func (server *GoRedisServer) OnSELECT(session *Session, cmd *Command) (reply *Reply) {
+	index, reply := server.parseDBIndex(cmd.StringAtIndex(1))
+	if reply != nil {
+		return
+	}
+	session.SetAttribute(S_DB, index)
+	return StatusReply("OK")
+}
+
+// MOVE key db，目标db存在同名的key时返回0
+func (server *GoRedisServer) OnMOVE(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	index, reply := server.parseDBIndex(cmd.StringAtIndex(2))
+	if reply != nil {
+		return
+	}
+	if index == server.dbIndex(cmd) {
+		return ErrorReply("source and destination objects are the same")
+	}
+	dst := server.dbAt(index)
+	if dst.HasExpire() && !server.isReplica() {
+		dst.ExpireIfNeeded(key, nowMillis(), server.expiredIn(index))
+	}
+	ok, err := server.db(cmd).Move(key, dst)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if ok {
+		return IntegerReply(1)
+	}
+	return IntegerReply(0)
+}
+
+// SWAPDB index1 index2，挂起指令处理后交换映射，不移动数据
+func (server *GoRedisServer) OnSWAPDB(cmd *Command) (reply *Reply) {
+	i, reply := server.parseDBIndex(cmd.StringAtIndex(1))
+	if reply != nil {
+		return
+	}
+	j, reply := server.parseDBIndex(cmd.StringAtIndex(2))
+	if reply != nil {
+		return
+	}
+	server.Suspend()
+	defer server.Resume()
+	server.dbsMu.Lock()
+	server.dbmap[i], server.dbmap[j] = server.dbmap[j], server.dbmap[i]
+	server.dbs[i], server.dbs[j] = server.dbs[j], server.dbs[i]
+	server.dbsMu.Unlock()
+	if err := server.saveDBMap(); err != nil {
+		return ErrorReply(err)
+	}
+	return StatusReply("OK")
+}
+
+// 写入同步日志，db与上一条不同时先写入SELECT
+// 只在processCommandChan里调用，synclogDB不需要加锁
+func (server *GoRedisServer) writeSyncLog(index int, b []byte) {
+	if index != server.synclogDB || server.synclogSince >= selectCheckpoint {
+		server.synclog.Write(NewCommand([]byte("SELECT"), []byte(strconv.Itoa(index))).Bytes())
+		server.synclogDB, server.synclogSince = index, 0
+	}
+	server.synclog.Write(b)
+	server.synclogSince++
+}
+
+// 从seq开始读取同步日志时所在的db，向前查找最近的SELECT
+// 没有找到时是启用多db之前的日志，在db0
+func (server *GoRedisServer) syncLogDBAt(seq int64) int {
+	min := server.synclog.MinSeq()
+	for i := seq - 1; i >= min && i >= seq-selectCheckpoint-1; i-- {
+		val, err := server.synclog.Read(i)
+		if err != nil || val == nil {
+			break
+		}
+		if !bytes.HasPrefix(val, selectPrefix) {
+			continue
+		}
+		if cmd, err := ParseCommand(bytes.NewBuffer(val)); err == nil {
+			if index, err := cmd.IntAtIndex(1); err == nil {
+				return index
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,270 @@
+package goredis_server
+
+// DEBUG指令，用于排查问题
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/keydigest"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// 诊断文件中附带的slowlog大小
+const dumpSlowlogTail = 64 * 1024
+
+// DEBUG DUMPSTATE
+// DEBUG FAULT ...
+// DEBUG DIGEST-VALUE key [key ...]
+// DEBUG LOADRDB [path]
+func (server *GoRedisServer) OnDEBUG(cmd *Command) (reply *Reply) {
+	switch strings.ToUpper(cmd.StringAtIndex(1)) {
+	case "DUMPSTATE":
+		path, err := server.dumpState()
+		if err != nil {
+			return ErrorReply(err)
+		}
+		reply = BulkReply(path)
+	case "FAULT":
+		reply = server.debugFault(cmd)
+	case "RECORD":
+		reply = server.debugRecord(cmd)
+	case "DIGEST-VALUE":
+		db := server.db(cmd)
+		digests := make([]interface{}, 0, cmd.Len()-2)
+		for _, key := range cmd.Args()[2:] {
+			digest, err := server.keyDigest(db, key)
+			if err != nil {
+				return ErrorReply(err)
+			}
+			digests = append(digests, digest)
+		}
+		reply = MultiBulksReply(digests)
+	case "LOADRDB":
+		reply = server.debugLoadRDB(cmd)
+	default:
+		reply = ErrorReply("debug [dumpstate/fault/record/digest-value/loadrdb]")
+	}
+	return
+}
+
+// 录制指令到logpath下的文件，使用main/tool/replay回放
+// DEBUG RECORD START [filename]
+// DEBUG RECORD STOP
+// DEBUG RECORD
+func (server *GoRedisServer) debugRecord(cmd *Command) (reply *Reply) {
+	server.recorderMu.Lock()
+	defer server.recorderMu.Unlock()
+	switch strings.ToUpper(cmd.StringAtIndex(2)) {
+	case "START":
+		if server.recorder != nil {
+			return ErrorReply("recording " + server.recorder.Path())
+		}
+		name := time.Now().Format("record_20060102_150405.log")
+		if cmd.Len() > 3 {
+			name = filepath.Base(cmd.StringAtIndex(3)) // 只允许写入logpath
+		}
+		r, err := NewCmdRecorder(filepath.Join(server.opt.LogPath(), name))
+		if err != nil {
+			return ErrorReply(err)
+		}
+		server.recorder = r
+		stdlog.Println("record start", r.Path())
+		reply = BulkReply(r.Path())
+	case "STOP":
+		r := server.recorder
+		if r == nil {
+			return ErrorReply("not recording")
+		}
+		server.recorder = nil
+		if err := r.Close(); err != nil {
+			return ErrorReply(err)
+		}
+		stdlog.Printf("record stop %s, %d commands\n", r.Path(), r.Count())
+		reply = IntegerReply(int(r.Count()))
+	case "":
+		if r := server.recorder; r != nil {
+			reply = BulkReply(fmt.Sprintf("%s %d", r.Path(), r.Count()))
+		} else {
+			reply = BulkReply(nil)
+		}
+	default:
+		reply = ErrorReply("debug record [start/stop]")
+	}
+	return
+}
+
+func (server *GoRedisServer) currentRecorder() *CmdRecorder {
+	server.recorderMu.Lock()
+	defer server.recorderMu.Unlock()
+	return server.recorder
+}
+
+// 故障注入，仅用于测试环境
+// DEBUG FAULT                       查看当前设置
+// DEBUG FAULT READ-ERROR [0~1]      rocksdb读取失败的概率
+// DEBUG FAULT WRITE-ERROR [0~1]     rocksdb写入失败的概率
+// DEBUG FAULT WRITE-LATENCY [ms]    每次写入增加的延迟
+// DEBUG FAULT REPL-DROP [0~1]       主库丢弃同步日志的概率，从库数据将不一致
+// DEBUG FAULT OFF                   关闭全部故障
+func (server *GoRedisServer) debugFault(cmd *Command) (reply *Reply) {
+	f := server.levelRedis.Fault()
+	if cmd.Len() == 2 {
+		return BulkReply(fmt.Sprintf("read-error:%g\nwrite-error:%g\nwrite-latency:%d\nrepl-drop:%g\n",
+			f.ReadErrorRate, f.WriteErrorRate, f.WriteLatency/time.Millisecond, server.replDropRate))
+	}
+	action := strings.ToUpper(cmd.StringAtIndex(2))
+	if action == "OFF" {
+		server.setFault(levelredis.Fault{})
+		server.replDropRate = 0
+		stdlog.Println("debug fault off")
+		return StatusReply("OK")
+	}
+	if cmd.Len() != 4 {
+		return ErrorReply(WrongArgumentCount)
+	}
+	if action == "WRITE-LATENCY" {
+		ms, err := cmd.IntAtIndex(3)
+		if err != nil || ms < 0 {
+			return ErrorReply("bad latency")
+		}
+		f.WriteLatency = time.Duration(ms) * time.Millisecond
+	} else {
+		rate, err := cmd.FloatAtIndex(3)
+		if err != nil || rate < 0 || rate > 1 {
+			return ErrorReply("rate range: 0 <= rate <= 1")
+		}
+		switch action {
+		case "READ-ERROR":
+			f.ReadErrorRate = rate
+		case "WRITE-ERROR":
+			f.WriteErrorRate = rate
+		case "REPL-DROP":
+			server.replDropRate = rate
+		default:
+			return ErrorReply("unknown fault: " + action)
+		}
+	}
+	server.setFault(f)
+	stdlog.Printf("debug fault %s %s\n", action, cmd.StringAtIndex(3))
+	return StatusReply("OK")
+}
+
+// 每个db的LevelRedis各自注入故障
+func (server *GoRedisServer) setFault(f levelredis.Fault) {
+	for _, db := range server.databases() {
+		db.SetFault(f)
+	}
+}
+
+// 输出诊断信息到logpath下带时间戳的文件，用于提交问题
+// 包括goroutine、配置、INFO、rocksdb状态、slowlog和连接列表
+func (server *GoRedisServer) dumpState() (path string, err error) {
+	path = filepath.Join(server.opt.LogPath(), time.Now().Format("dumpstate_20060102_150405.log"))
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm); err != nil {
+		return
+	}
+	defer f.Close()
+
+	section := func(name string) {
+		fmt.Fprintf(f, "\n========== %s ==========\n", name)
+	}
+
+	fmt.Fprintf(f, "goredis_version:%s\nuid:%s\ntime:%s\n", VERSION, server.UID(), time.Now())
+
+	section("info")
+	f.WriteString(server.defaultInfo())
+	f.WriteString("\n")
+	f.WriteString(server.commandInfo())
+
+	section("config")
+	for _, k := range server.config.Keys() {
+		fmt.Fprintf(f, "%s %s\n", k, server.config.StringForKey(k))
+	}
+
+	section("clients")
+	f.WriteString(server.clientList())
+
+	section("rocksdb")
+	f.WriteString(server.levelRedis.Stats())
+
+	section("slowlog")
+	server.copySlowlogTail(f)
+
+	section("goroutines")
+	err = pprof.Lookup("goroutine").WriteTo(f, 2)
+
+	stdlog.Println("dumpstate", path)
+	return
+}
+
+// slow.log可能很大，只复制末尾部分
+func (server *GoRedisServer) copySlowlogTail(w io.Writer) {
+	f, err := os.Open(server.opt.LogPath() + "/slow.log")
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > dumpSlowlogTail {
+		f.Seek(-dumpSlowlogTail, os.SEEK_END)
+	}
+	io.Copy(w, f)
+}
+
+// key内容的摘要，与redis上按同样方法计算的结果可以直接比较(见libs/keydigest)
+// 用于main/tool/migratediff校验迁移结果，key不存在或已过期时返回keydigest.Missing
+func (server *GoRedisServer) keyDigest(db *levelredis.LevelRedis, key []byte) (string, error) {
+	if at := db.ExpireAt(key); at != -1 && at <= nowMillis() {
+		return keydigest.Missing, nil
+	}
+	t := db.TypeOf(key)
+	var d *keydigest.Digest
+	switch t {
+	case levelredis.STRING_SUFFIX, levelredis.BITMAP_SUFFIX:
+		d = keydigest.New(levelredis.STRING_SUFFIX)
+		value, err := server.getString(db, key)
+		if err != nil {
+			return "", err
+		}
+		d.Add(value)
+	case levelredis.HASH_SUFFIX:
+		d = keydigest.New(t)
+		db.GetHash(string(key)).Enumerate(func(i int, field, value []byte, quit *bool) {
+			d.Add(field, value)
+		})
+	case levelredis.LIST_SUFFIX:
+		d = keydigest.New(t)
+		db.GetList(string(key)).Enumerate(func(i int, value []byte, quit *bool) {
+			d.Add(value)
+		})
+	case levelredis.SET_SUFFIX:
+		d = keydigest.New(t)
+		db.GetSet(string(key)).Enumerate(func(i int, member []byte, quit *bool) {
+			d.Add(member)
+		})
+	case levelredis.ZSET_SUFFIX:
+		d = keydigest.New(t)
+		db.GetSortedSet(string(key)).Enumerate(func(i int, member, score []byte, quit *bool) {
+			d.Add(member, formatScore(score))
+		})
+	case levelredis.DOC_SUFFIX:
+		d = keydigest.New(t)
+		data, _ := json.Marshal(db.GetDoc(string(key)).Get())
+		d.Add(data)
+	case levelredis.BLOB_SUFFIX:
+		d = keydigest.New(t)
+		sum, _, _, _ := db.GetBlob(string(key)).Stat()
+		d.Add([]byte(sum))
+	default:
+		return keydigest.Missing, nil
+	}
+	return d.Sum(), nil
+}
@@ -37,9 +37,17 @@ doc_get(key, "name,sex,photos,setting.mute,version")
 import (
 	. "GoRedis/goredis"
 	"encoding/json"
+	"strconv"
 	"strings"
 )
 
+// 每个doc保留的历史版本数，0表示不保存
+const docHistoryLenKey = "doc-history-len"
+
+func (server *GoRedisServer) initDocHistory() {
+	server.docHistoryLen = int(server.config.IntForKey(docHistoryLenKey, 0))
+}
+
 /*
 doc_set hi '{"name":"latermoon", "sex":"M", "version":10, "setting":{"start":23, "end":8}}'
 doc_set hi '{"$inc":{"version":1}}'
@@ -59,8 +67,8 @@ func (server *GoRedisServer) OnDOC_SET(cmd *Command) (reply *Reply) {
 		return ErrorReply(err)
 	}
 	// 调用LevelDocument更新数据
-	doc := server.levelRedis.GetDoc(key)
-	err = doc.Set(jsonObj)
+	doc := server.db(cmd).GetDoc(key)
+	err = doc.Set(jsonObj, server.docHistoryLen)
 	if err != nil {
 		return ErrorReply(err)
 	}
@@ -71,7 +79,7 @@ func (server *GoRedisServer) OnDOC_SET(cmd *Command) (reply *Reply) {
 func (server *GoRedisServer) OnDOC_GET(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	fields := strings.Split(cmd.StringAtIndex(2), ",")
-	doc := server.levelRedis.GetDoc(key)
+	doc := server.db(cmd).GetDoc(key)
 	result := doc.Get(fields...)
 	if result == nil {
 		return BulkReply(nil)
@@ -83,3 +91,47 @@ func (server *GoRedisServer) OnDOC_GET(cmd *Command) (reply *Reply) {
 	reply = BulkReply(data)
 	return
 }
+
+// DOC_HISTORY key [count]
+// 从新到旧返回历史版本，格式为 毫秒时间戳,json,毫秒时间戳,json...
+func (server *GoRedisServer) OnDOC_HISTORY(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	count := 10
+	if cmd.Len() > 2 {
+		var err error
+		if count, err = strconv.Atoi(cmd.StringAtIndex(2)); err != nil || count <= 0 {
+			return ErrorReply("bad count")
+		}
+	}
+	doc := server.db(cmd).GetDoc(key)
+	versions := doc.History(count)
+	bulks := make([]interface{}, 0, len(versions)*2)
+	for _, v := range versions {
+		data, err := json.Marshal(v.Doc)
+		if err != nil {
+			return ErrorReply(err)
+		}
+		bulks = append(bulks, strconv.FormatInt(v.Time.UnixNano()/1e6, 10), data)
+	}
+	reply = MultiBulksReply(bulks)
+	return
+}
+
+// DOC_REVERT key [n]
+// 回滚到第n个历史版本，0为最新版本，默认1即上一个版本
+func (server *GoRedisServer) OnDOC_REVERT(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	n := 1
+	if cmd.Len() > 2 {
+		var err error
+		if n, err = strconv.Atoi(cmd.StringAtIndex(2)); err != nil || n < 0 {
+			return ErrorReply("bad version")
+		}
+	}
+	doc := server.db(cmd).GetDoc(key)
+	if err := doc.Revert(n, server.docHistoryLen); err != nil {
+		return ErrorReply(err)
+	}
+	reply = StatusReply("OK")
+	return
+}
@@ -0,0 +1,237 @@
+package goredis_server
+
+// DUMP key、RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// 与redis相同的序列化格式(RDB对象 + 2字节版本 + CRC64)，可以在GoRedis和redis之间互相DUMP/RESTORE
+// 支持string(包括bitmap)/hash/list/set/zset，blob和doc没有对应的redis类型，不能DUMP
+// RESTORE同步到从库时改写为绝对时间的RESTORE ... REPLACE ABSTTL
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/rdb"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// RESTORE解析出的对象
+type restoreValue struct {
+	rdb.NopDecoder
+	typ   string
+	value []byte   // string
+	elems [][]byte // hash为field/value，zset为score/member
+}
+
+func (v *restoreValue) Set(key, value []byte, expiry int64) {
+	v.typ, v.value = levelredis.STRING_SUFFIX, value
+}
+
+func (v *restoreValue) StartHash(key []byte, length, expiry int64) {
+	v.typ = levelredis.HASH_SUFFIX
+}
+
+func (v *restoreValue) Hset(key, field, value []byte) {
+	v.elems = append(v.elems, field, value)
+}
+
+func (v *restoreValue) StartSet(key []byte, cardinality, expiry int64) {
+	v.typ = levelredis.SET_SUFFIX
+}
+
+func (v *restoreValue) Sadd(key, member []byte) {
+	v.elems = append(v.elems, member)
+}
+
+func (v *restoreValue) StartList(key []byte, length, expiry int64) {
+	v.typ = levelredis.LIST_SUFFIX
+}
+
+func (v *restoreValue) Rpush(key, value []byte) {
+	v.elems = append(v.elems, value)
+}
+
+func (v *restoreValue) StartZSet(key []byte, cardinality, expiry int64) {
+	v.typ = levelredis.ZSET_SUFFIX
+}
+
+func (v *restoreValue) Zadd(key []byte, score float64, member []byte) {
+	v.elems = append(v.elems, levelredis.Float64ToBytes(score), member)
+}
+
+func (server *GoRedisServer) OnDUMP(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	// 从快照读取，元素数量与写入的元素一致
+	snap := server.db(cmd).Snapshot()
+	defer snap.Close()
+	payload, reply := server.dump(cmd, snap, key)
+	if reply != nil {
+		return
+	}
+	if payload == nil {
+		return BulkReply(nil)
+	}
+	return BulkReply(payload)
+}
+
+// 序列化snap里的key，key不存在时返回nil
+func (server *GoRedisServer) dump(cmd *Command, snap *levelredis.LevelRedis, key []byte) (payload []byte, reply *Reply) {
+	// 超过大集合阈值时停止读取，warn模式下需要完整的数据
+	limit := -1
+	if server.largeThreshold > 0 && !server.largeWarnOnly {
+		limit = int(server.largeThreshold) + 1
+	}
+	var elems [][]byte
+	collect := func(quit *bool, values ...[]byte) {
+		elems = append(elems, values...)
+		if limit > 0 && len(elems) >= limit*len(values) {
+			*quit = true
+		}
+	}
+	buf := &bytes.Buffer{}
+	e := rdb.NewEncoder(buf)
+	switch t := snap.TypeOf(key); t {
+	case "none":
+		return nil, nil
+	case levelredis.STRING_SUFFIX, levelredis.BITMAP_SUFFIX:
+		value, err := server.getString(snap, key)
+		if err != nil {
+			return nil, ErrorReply(err)
+		}
+		e.EncodeType(rdb.TypeString)
+		e.EncodeString(value)
+	case levelredis.HASH_SUFFIX:
+		snap.GetHash(string(key)).Enumerate(func(i int, field, value []byte, quit *bool) {
+			collect(quit, field, value)
+		})
+		if r := server.checkLargeCollection(cmd, int64(len(elems)/2), "HSCAN"); r != nil {
+			return nil, r
+		}
+		e.EncodeType(rdb.TypeHash)
+		e.EncodeLength(uint32(len(elems) / 2))
+		for _, elem := range elems {
+			e.EncodeString(elem)
+		}
+	case levelredis.LIST_SUFFIX:
+		snap.GetList(string(key)).Enumerate(func(i int, value []byte, quit *bool) {
+			collect(quit, value)
+		})
+		if r := server.checkLargeCollection(cmd, int64(len(elems)), "LRANGE with smaller ranges"); r != nil {
+			return nil, r
+		}
+		e.EncodeType(rdb.TypeList)
+		e.EncodeLength(uint32(len(elems)))
+		for _, elem := range elems {
+			e.EncodeString(elem)
+		}
+	case levelredis.SET_SUFFIX:
+		snap.GetSet(string(key)).Enumerate(func(i int, member []byte, quit *bool) {
+			collect(quit, member)
+		})
+		if r := server.checkLargeCollection(cmd, int64(len(elems)), "SSCAN"); r != nil {
+			return nil, r
+		}
+		e.EncodeType(rdb.TypeSet)
+		e.EncodeLength(uint32(len(elems)))
+		for _, elem := range elems {
+			e.EncodeString(elem)
+		}
+	case levelredis.ZSET_SUFFIX:
+		snap.GetSortedSet(string(key)).Enumerate(func(i int, member, score []byte, quit *bool) {
+			collect(quit, member, score)
+		})
+		if r := server.checkLargeCollection(cmd, int64(len(elems)/2), "ZSCAN"); r != nil {
+			return nil, r
+		}
+		e.EncodeType(rdb.TypeZSet)
+		e.EncodeLength(uint32(len(elems) / 2))
+		for i := 0; i < len(elems); i += 2 {
+			e.EncodeString(elems[i])
+			e.EncodeFloat(levelredis.BytesToFloat64(elems[i+1]))
+		}
+	default:
+		return nil, ErrorReply("DUMP is not supported for " + t + " keys")
+	}
+	if err := e.EncodeDumpFooter(); err != nil {
+		return nil, ErrorReply(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (server *GoRedisServer) OnRESTORE(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	payload, _ := cmd.ArgAtIndex(3)
+	ttl, err := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
+	if err != nil {
+		return ErrorReply(NotIntegerError)
+	}
+	if ttl < 0 {
+		return ErrorReply("Invalid TTL value, must be >= 0")
+	}
+	replace, absttl := false, false
+	for i := 4; i < cmd.Len(); i++ {
+		switch strings.ToUpper(cmd.StringAtIndex(i)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absttl = true
+		case "IDLETIME", "FREQ":
+			// 没有LRU/LFU，检查参数后忽略
+			if i+1 >= cmd.Len() {
+				return ErrorReply("syntax error")
+			}
+			if n, err := strconv.ParseInt(cmd.StringAtIndex(i+1), 10, 64); err != nil || n < 0 {
+				return ErrorReply("Invalid IDLETIME or FREQ value")
+			}
+			i++
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+
+	if err = rdb.VerifyDump(payload); err != nil {
+		return ErrorReply("DUMP payload version or checksum are wrong")
+	}
+	v := &restoreValue{}
+	err = rdb.DecodeDump(payload, 0, key, 0, v)
+	if err != nil || len(v.typ) == 0 || (v.typ != levelredis.STRING_SUFFIX && len(v.elems) == 0) {
+		return ErrorReply("Bad data format")
+	}
+	if !replace && db.TypeOf(key) != "none" {
+		return ErrorReply("BUSYKEY Target key name already exists.")
+	}
+	if err = server.checkRestoreCodec(cmd, key, v.typ); err != nil {
+		return ErrorReply(err)
+	}
+
+	now := nowMillis()
+	at := ttl
+	if ttl > 0 && !absttl {
+		at = now + ttl
+	}
+	db.Delete(key)
+	// 与redis一样，已经过期的时间只删除原来的key
+	if at > 0 && at <= now {
+		cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("DEL"), key))
+		return StatusReply("OK")
+	}
+	switch v.typ {
+	case levelredis.STRING_SUFFIX:
+		err = db.Strings().Set(key, v.value)
+	case levelredis.HASH_SUFFIX:
+		db.GetHash(string(key)).Set(v.elems...)
+	case levelredis.LIST_SUFFIX:
+		err = db.GetList(string(key)).RPush(v.elems...)
+	case levelredis.SET_SUFFIX:
+		db.GetSet(string(key)).Add(v.elems...)
+	case levelredis.ZSET_SUFFIX:
+		db.GetSortedSet(string(key)).Add(0, v.elems...)
+	}
+	if err == nil && at > 0 {
+		err = db.SetExpireAt(key, at)
+	}
+	if err != nil {
+		return ErrorReply(err)
+	}
+	cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("RESTORE"), key, []byte(strconv.FormatInt(at, 10)), payload, []byte("REPLACE"), []byte("ABSTTL")))
+	return StatusReply("OK")
+}
@@ -0,0 +1,238 @@
+package goredis_server
+
+// EXPIRE/PEXPIRE/EXPIREAT/PEXPIREAT/TTL/PTTL/PERSIST/EXPIRYSTATS
+// 过期时间保存在levelredis的索引里(见level_expire.go)，删除以主库为准：
+// 主库在指令访问key之前检查是否过期(惰性删除)，后台goroutine按过期时间顺序定期清理，
+// 删除后以DEL的形式进入指令队列，同步到从库、通知前缀订阅；
+// 从库不主动删除，只执行主库同步过来的DEL，TTL/PTTL按本地时间计算
+// 设置过期时间的指令以PEXPIREAT的形式同步，主从使用同一个绝对时间
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	expireSweepInterval = 100 * time.Millisecond
+	expireSweepBatch    = 200 // 每批最多删除的key，删满时立即继续下一批
+)
+
+// 可能包含多个key的指令，检查全部参数，非key的参数只多一次查询
+var multiKeyCmds = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Split("MGET,MSET,DEL,UNLINK,EXISTS,RENAME,RENAMENX,COPY,BITOP,RPOPLPUSH,LMOVE,BLPOP,BRPOP,BRPOPLPUSH,BLMOVE,LMPOP,BLMPOP,SMOVE,SINTER,SUNION,SDIFF,SINTERSTORE,SUNIONSTORE,SDIFFSTORE,ZINTERSTORE,ZUNIONSTORE,BZPOPMIN,BZPOPMAX", ",") {
+		multiKeyCmds[name] = true
+	}
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// 作为从库连接着主库
+func (server *GoRedisServer) isReplica() bool {
+	return server.slavemgr.Len() > 0
+}
+
+func (server *GoRedisServer) initExpireSweeper() {
+	conn, _ := net.Pipe()
+	server.expireSession = NewSession(conn)
+	go func() {
+		for !server.closing {
+			time.Sleep(expireSweepInterval)
+			if !server.isReplica() {
+				server.sweepExpired()
+			}
+		}
+	}()
+}
+
+func (server *GoRedisServer) sweepExpired() {
+	for {
+		// 与On()一样遵守Suspend和CLIENT PAUSE，Suspend等待这一批删除完成
+		server.clientPause.Wait(true)
+		server.enter()
+		if server.closing {
+			server.leave()
+			return
+		}
+		now := nowMillis()
+		full := false
+		for i, db := range server.databases() {
+			if !db.HasExpire() {
+				continue
+			}
+			keys := db.ExpiredKeys(now, expireSweepBatch)
+			for _, key := range keys {
+				db.ExpireIfNeeded(key, now, server.expiredIn(i))
+			}
+			if len(keys) == expireSweepBatch {
+				full = true
+				stdlog.Printf("expire sweep %d keys in db%d\n", len(keys), i)
+			}
+		}
+		server.leave()
+		if !full {
+			return
+		}
+	}
+}
+
+// 在持有key的过期锁时调用，DEL先于之后对这个key的写入进入指令队列
+// 定时任务只在db0
+func (server *GoRedisServer) expiredIn(index int) func(key []byte) {
+	return func(key []byte) {
+		cmd := NewCommand([]byte("DEL"), key)
+		cmd.SetAttribute(C_SESSION, server.expireSession)
+		cmd.SetAttribute(C_ELAPSED, time.Duration(0))
+		cmd.SetAttribute(C_DB, index)
+		server.enqueue(cmd)
+		if index == 0 {
+			server.cronOnExpired(key)
+		}
+	}
+}
+
+// 指令访问key之前删除已过期的key
+func (server *GoRedisServer) expireKeysOf(cmd *Command) {
+	db := server.db(cmd)
+	if !db.HasExpire() || server.isReplica() || cmd.Len() < 2 {
+		return
+	}
+	switch commandCategory(cmd.Name()) {
+	case CCateKey, CCateString, CCateHash, CCateList, CCateSet, CCateSortedSet:
+	default:
+		return
+	}
+	keys := cmd.Args()[1:2]
+	if multiKeyCmds[cmd.Name()] {
+		keys = cmd.Args()[1:]
+	}
+	now, onExpired := nowMillis(), server.expiredIn(server.dbIndex(cmd))
+	for _, key := range keys {
+		db.ExpireIfNeeded(key, now, onExpired)
+	}
+}
+
+// EXPIRE key seconds
+func (server *GoRedisServer) OnEXPIRE(cmd *Command) (reply *Reply) {
+	return server.expire(cmd, 1000, false)
+}
+
+// PEXPIRE key milliseconds
+func (server *GoRedisServer) OnPEXPIRE(cmd *Command) (reply *Reply) {
+	return server.expire(cmd, 1, false)
+}
+
+// EXPIREAT key timestamp
+func (server *GoRedisServer) OnEXPIREAT(cmd *Command) (reply *Reply) {
+	return server.expire(cmd, 1000, true)
+}
+
+// PEXPIREAT key milliseconds-timestamp
+func (server *GoRedisServer) OnPEXPIREAT(cmd *Command) (reply *Reply) {
+	return server.expire(cmd, 1, true)
+}
+
+// 设置成功返回1，key不存在返回0；过期时间已过时直接删除key
+func (server *GoRedisServer) expire(cmd *Command, unit int64, absolute bool) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	n, err := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
+	if err != nil {
+		return ErrorReply(NotIntegerError)
+	}
+	now := nowMillis()
+	base := int64(0)
+	if !absolute {
+		base = now
+	}
+	if n > (math.MaxInt64-base)/unit || n < (math.MinInt64+base)/unit {
+		return ErrorReply("invalid expire time in '" + strings.ToLower(cmd.Name()) + "' command")
+	}
+	at := base + n*unit
+
+	if db.TypeOf(key) == "none" {
+		return IntegerReply(0)
+	}
+	if at <= now {
+		db.Delete(key)
+		cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("DEL"), key))
+		return IntegerReply(1)
+	}
+	if err = db.SetExpireAt(key, at); err != nil {
+		return ErrorReply(err)
+	}
+	cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("PEXPIREAT"), key, []byte(strconv.FormatInt(at, 10))))
+	return IntegerReply(1)
+}
+
+// TTL key，key不存在返回-2，没有过期时间返回-1
+func (server *GoRedisServer) OnTTL(cmd *Command) (reply *Reply) {
+	return server.ttl(cmd, 1000)
+}
+
+// PTTL key
+func (server *GoRedisServer) OnPTTL(cmd *Command) (reply *Reply) {
+	return server.ttl(cmd, 1)
+}
+
+func (server *GoRedisServer) ttl(cmd *Command, unit int64) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	if db.TypeOf(key) == "none" {
+		return IntegerReply(-2)
+	}
+	at := db.ExpireAt(key)
+	if at == -1 {
+		return IntegerReply(-1)
+	}
+	remain := at - nowMillis()
+	if remain < 0 {
+		// 从库上主库的DEL还没有到达
+		return IntegerReply(-2)
+	}
+	// 与redis一致，TTL四舍五入到秒
+	return IntegerReply(int((remain + unit/2) / unit))
+}
+
+// PERSIST key，清除过期时间返回1，key不存在或没有过期时间返回0
+func (server *GoRedisServer) OnPERSIST(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	if server.db(cmd).Persist(key) {
+		return IntegerReply(1)
+	}
+	return IntegerReply(0)
+}
+
+// 即将过期的key分布，用于预估集中过期，需要扫描整个过期索引
+// EXPIRYSTATS [seconds] [buckets]，默认每60秒一个区间，共10个区间
+// 返回 expired, n, 60, n, 120, n, ..., later, n
+// 数字为区间的结束秒数，expired为已过期等待清理的数量，later为超出统计范围的数量
+func (server *GoRedisServer) OnEXPIRYSTATS(cmd *Command) (reply *Reply) {
+	seconds, buckets := 60, 10
+	var err error
+	if cmd.Len() > 1 {
+		if seconds, err = cmd.IntAtIndex(1); err != nil || seconds <= 0 {
+			return ErrorReply("expirystats [seconds] [buckets]")
+		}
+	}
+	if cmd.Len() > 2 {
+		if buckets, err = cmd.IntAtIndex(2); err != nil || buckets <= 0 || buckets > 1000 {
+			return ErrorReply("expirystats [seconds] [buckets]")
+		}
+	}
+	expired, counts, later := server.db(cmd).ExpiryHistogram(nowMillis(), int64(seconds)*1000, buckets)
+	bulks := make([]interface{}, 0, len(counts)*2+4)
+	bulks = append(bulks, "expired", strconv.FormatInt(expired, 10))
+	for i, n := range counts {
+		bulks = append(bulks, strconv.Itoa((i+1)*seconds), strconv.FormatInt(n, 10))
+	}
+	bulks = append(bulks, "later", strconv.FormatInt(later, 10))
+	return MultiBulksReply(bulks)
+}
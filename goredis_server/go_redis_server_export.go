@@ -0,0 +1,111 @@
+package goredis_server
+
+// 按key前缀导出部分数据，用于克隆部分环境
+// EXPORT prefix [prefix ...]                  从快照导出到logpath下的aof格式文件，返回文件路径
+// IMPORT filename [oldprefix newprefix]      回放导出的文件，可以把oldprefix替换为newprefix
+// 导出和导入都在连接当前SELECT的db
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (server *GoRedisServer) OnEXPORT(cmd *Command) (reply *Reply) {
+	prefixes := cmd.Args()[1:]
+	path := filepath.Join(server.opt.LogPath(), time.Now().Format("export_20060102_150405.aof"))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	defer f.Close()
+
+	begin := time.Now()
+	snap := server.db(cmd).Snapshot()
+	defer snap.Close()
+	writer := NewAOFWriter(bufio.NewWriter(f))
+	count := 0
+	for _, prefix := range prefixes {
+		snap.KeyEnumerate(prefix, levelredis.IterForward, func(i int, key, keytype, value []byte, quit *bool) {
+			if !bytes.HasPrefix(key, prefix) {
+				*quit = true
+				return
+			}
+			switch string(keytype) {
+			case "zset":
+				writer.AppendZSet(snap.GetSortedSet(string(key)))
+			case "hash":
+				writer.AppendHash(snap.GetHash(string(key)))
+			case "set":
+				writer.AppendSet(snap.GetSet(string(key)))
+			case "list":
+				writer.AppendList(snap.GetList(string(key)))
+			case "bitmap":
+				writer.AppendString(key, snap.GetBitmap(string(key)).Bytes())
+			case "blob":
+				writer.AppendBlob(snap.GetBlob(string(key)))
+			case "string":
+				var err error
+				if value, err = snap.Strings().Decode(key, value); err != nil {
+					stdlog.Println("export skip", string(key), err)
+					return
+				}
+				writer.AppendString(key, value)
+			default:
+				stdlog.Println("export skip", string(key), string(keytype))
+				return
+			}
+			count++
+		})
+	}
+	if err = writer.Flush(); err != nil {
+		return ErrorReply(err)
+	}
+	stdlog.Printf("export %s, %d keys, %s\n", path, count, time.Since(begin))
+	return BulkReply(path)
+}
+
+func (server *GoRedisServer) OnIMPORT(session *Session, cmd *Command) (reply *Reply) {
+	if cmd.Len() != 2 && cmd.Len() != 4 {
+		return ErrorReply("import filename [oldprefix newprefix]")
+	}
+	path := filepath.Join(server.opt.LogPath(), filepath.Base(cmd.StringAtIndex(1))) // 只允许读取logpath
+	var oldprefix, newprefix []byte
+	if cmd.Len() == 4 {
+		oldprefix, newprefix = cmd.Args()[2], cmd.Args()[3]
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	defer f.Close()
+
+	begin := time.Now()
+	count := 0
+	r := bufio.NewReaderSize(f, 1024*1024)
+	for {
+		c, err := ReadCommand(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrorReply(err)
+		}
+		// 导出的指令第1个参数都是key
+		if oldprefix != nil && c.Len() > 1 && bytes.HasPrefix(c.Args()[1], oldprefix) {
+			c.Args()[1] = append(append([]byte{}, newprefix...), c.Args()[1][len(oldprefix):]...)
+		}
+		// 导入的数据进入指令队列，同步到从库
+		if r := server.execImported(session, c); r != nil && r.Type == ReplyTypeError {
+			return r
+		}
+		count++
+	}
+	stdlog.Printf("import %s, %d commands, %s\n", path, count, time.Since(begin))
+	return IntegerReply(count)
+}
@@ -0,0 +1,349 @@
+package goredis_server
+
+// EXPORT.JSON [prefix ...]、IMPORT.JSON filename
+// 从快照把全部db(指定前缀时只导出前缀下)的key导出到logpath下的json文件，每行一个key，用于审计或迁移到其它系统：
+//
+//	{"db":0,"key":"user:1","type":"hash","expireat":1393660800000,"value":{"name":"latermoon"}}
+//
+// string(包括bitmap)为字符串，hash为对象，list/set为数组，zset为[member,score]数组，doc为原样的对象，blob跳过
+// key或元素不是合法的UTF-8时带上"base64":true，key和全部元素使用base64编码(doc的值除外)
+// 集合先遍历一次检查编码，再遍历一次写入，不需要读入内存
+// 导入时转换为DEL/SET/HMSET/RPUSH/SADD/ZADD/DOC_SET/PEXPIREAT经过On()执行，覆盖同名的key，会同步到从库
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// 导入时每条指令的元素数量
+const jsonImportChunk = 200
+
+type jsonRecord struct {
+	DB       int             `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	ExpireAt int64           `json:"expireat,omitempty"` // 毫秒
+	Base64   bool            `json:"base64,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+func (server *GoRedisServer) OnEXPORT_JSON(cmd *Command) (reply *Reply) {
+	prefixes := cmd.Args()[1:]
+	if len(prefixes) == 0 {
+		prefixes = [][]byte{[]byte("")}
+	}
+	path := filepath.Join(server.opt.LogPath(), time.Now().Format("export_20060102_150405.json"))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	defer f.Close()
+
+	begin := time.Now()
+	server.Suspend()
+	snap := server.levelRedis.Snapshot()
+	dbmap := append([]int{}, server.dbmap[:server.dbCount()]...)
+	server.Resume()
+	defer snap.Close()
+
+	w := bufio.NewWriterSize(f, 1024*1024)
+	count := 0
+	for i, index := range dbmap {
+		db := snap.Database(index)
+		for _, prefix := range prefixes {
+			db.KeyEnumerate(prefix, levelredis.IterForward, func(n int, key, keytype, value []byte, quit *bool) {
+				if !bytes.HasPrefix(key, prefix) {
+					*quit = true
+					return
+				}
+				if writeJSONKey(w, db, i, key, string(keytype), value) {
+					count++
+				}
+			})
+		}
+		if db != snap {
+			db.Close()
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return ErrorReply(err)
+	}
+	stdlog.Printf("export json %s, %d keys, %s\n", path, count, time.Since(begin))
+	return BulkReply(path)
+}
+
+// 写入一行，已过期、blob和无法解码的key跳过，返回是否写入
+func writeJSONKey(w *bufio.Writer, db *levelredis.LevelRedis, index int, key []byte, t string, value []byte) bool {
+	at := db.ExpireAt(key)
+	if at != -1 && at <= nowMillis() {
+		return false
+	}
+	// 第一遍检查编码，string和doc在这里取出值
+	valid := utf8.Valid(key)
+	check := func(quit *bool, values ...[]byte) {
+		for _, v := range values {
+			if !utf8.Valid(v) {
+				valid, *quit = false, true
+			}
+		}
+	}
+	var str, doc []byte
+	var err error
+	switch t {
+	case levelredis.STRING_SUFFIX:
+		if str, err = db.Strings().Decode(key, value); err != nil {
+			stdlog.Println("export json skip", string(key), err)
+			return false
+		}
+		valid = valid && utf8.Valid(str)
+	case levelredis.BITMAP_SUFFIX:
+		t, str = levelredis.STRING_SUFFIX, db.GetBitmap(string(key)).Bytes()
+		valid = valid && utf8.Valid(str)
+	case levelredis.HASH_SUFFIX:
+		db.GetHash(string(key)).Enumerate(func(i int, field, value []byte, quit *bool) {
+			check(quit, field, value)
+		})
+	case levelredis.LIST_SUFFIX:
+		db.GetList(string(key)).Enumerate(func(i int, value []byte, quit *bool) {
+			check(quit, value)
+		})
+	case levelredis.SET_SUFFIX:
+		db.GetSet(string(key)).Enumerate(func(i int, member []byte, quit *bool) {
+			check(quit, member)
+		})
+	case levelredis.ZSET_SUFFIX:
+		db.GetSortedSet(string(key)).Enumerate(func(i int, member, score []byte, quit *bool) {
+			check(quit, member)
+		})
+	case levelredis.DOC_SUFFIX:
+		if doc, err = json.Marshal(db.GetDoc(string(key)).Get()); err != nil {
+			stdlog.Println("export json skip", string(key), err)
+			return false
+		}
+	default:
+		return false
+	}
+
+	enc := func(b []byte) []byte {
+		s := string(b)
+		if !valid {
+			s = base64.StdEncoding.EncodeToString(b)
+		}
+		out, _ := json.Marshal(s)
+		return out
+	}
+	fmt.Fprintf(w, `{"db":%d,"key":%s,"type":"%s"`, index, enc(key), t)
+	if at != -1 {
+		fmt.Fprintf(w, `,"expireat":%d`, at)
+	}
+	if !valid {
+		w.WriteString(`,"base64":true`)
+	}
+	w.WriteString(`,"value":`)
+	// 第二遍写入
+	sep := func(i int) {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+	}
+	switch t {
+	case levelredis.STRING_SUFFIX:
+		w.Write(enc(str))
+	case levelredis.HASH_SUFFIX:
+		w.WriteByte('{')
+		db.GetHash(string(key)).Enumerate(func(i int, field, value []byte, quit *bool) {
+			sep(i)
+			w.Write(enc(field))
+			w.WriteByte(':')
+			w.Write(enc(value))
+		})
+		w.WriteByte('}')
+	case levelredis.LIST_SUFFIX:
+		w.WriteByte('[')
+		db.GetList(string(key)).Enumerate(func(i int, value []byte, quit *bool) {
+			sep(i)
+			w.Write(enc(value))
+		})
+		w.WriteByte(']')
+	case levelredis.SET_SUFFIX:
+		w.WriteByte('[')
+		db.GetSet(string(key)).Enumerate(func(i int, member []byte, quit *bool) {
+			sep(i)
+			w.Write(enc(member))
+		})
+		w.WriteByte(']')
+	case levelredis.ZSET_SUFFIX:
+		w.WriteByte('[')
+		db.GetSortedSet(string(key)).Enumerate(func(i int, member, score []byte, quit *bool) {
+			sep(i)
+			fmt.Fprintf(w, `[%s,"%s"]`, enc(member), formatScore(score))
+		})
+		w.WriteByte(']')
+	case levelredis.DOC_SUFFIX:
+		w.Write(doc)
+	}
+	w.WriteString("}\n")
+	return true
+}
+
+// 只允许读取logpath下的文件，返回导入的key数
+func (server *GoRedisServer) OnIMPORT_JSON(cmd *Command) (reply *Reply) {
+	path := filepath.Join(server.opt.LogPath(), filepath.Base(cmd.StringAtIndex(1)))
+	f, err := os.Open(path)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	defer f.Close()
+
+	// 使用内部会话，SELECT不影响当前连接
+	conn, _ := net.Pipe()
+	session := NewSession(conn)
+	begin := time.Now()
+	count, db := 0, -1
+	dec := json.NewDecoder(bufio.NewReaderSize(f, 1024*1024))
+	for {
+		var rec jsonRecord
+		if err = dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrorReply(fmt.Sprintf("record %d: %s", count+1, err))
+		}
+		cmds, err := rec.commands(nowMillis())
+		if err != nil {
+			return ErrorReply(fmt.Sprintf("record %d: %s", count+1, err))
+		}
+		if len(cmds) == 0 {
+			continue
+		}
+		if rec.DB != db {
+			cmds = append([]*Command{NewCommand(formatByteSlice("SELECT", rec.DB)...)}, cmds...)
+			db = rec.DB
+		}
+		for _, c := range cmds {
+			if r := server.execImported(session, c); r != nil && r.Type == ReplyTypeError {
+				return r
+			}
+		}
+		count++
+	}
+	stdlog.Printf("import json %s, %d keys, %s\n", path, count, time.Since(begin))
+	return IntegerReply(count)
+}
+
+// 转换为写入指令，已经过期时返回nil
+func (rec *jsonRecord) commands(now int64) (cmds []*Command, err error) {
+	if rec.ExpireAt > 0 && rec.ExpireAt <= now {
+		return nil, nil
+	}
+	key, err := rec.decode(rec.Key)
+	if err != nil {
+		return
+	}
+	cmds = []*Command{NewCommand([]byte("DEL"), key)}
+	var elems [][]byte
+	switch rec.Type {
+	case levelredis.STRING_SUFFIX:
+		var s string
+		if err = json.Unmarshal(rec.Value, &s); err != nil {
+			return
+		}
+		var value []byte
+		if value, err = rec.decode(s); err != nil {
+			return
+		}
+		cmds = append(cmds, NewCommand([]byte("SET"), key, value))
+	case levelredis.HASH_SUFFIX:
+		var m map[string]string
+		if err = json.Unmarshal(rec.Value, &m); err != nil {
+			return
+		}
+		for field, value := range m {
+			if elems, err = rec.appendDecoded(elems, field, value); err != nil {
+				return
+			}
+		}
+		cmds = append(cmds, chunkCommands("HMSET", key, elems, 2)...)
+	case levelredis.LIST_SUFFIX, levelredis.SET_SUFFIX:
+		var a []string
+		if err = json.Unmarshal(rec.Value, &a); err != nil {
+			return
+		}
+		if elems, err = rec.appendDecoded(elems, a...); err != nil {
+			return
+		}
+		name := "RPUSH"
+		if rec.Type == levelredis.SET_SUFFIX {
+			name = "SADD"
+		}
+		cmds = append(cmds, chunkCommands(name, key, elems, 1)...)
+	case levelredis.ZSET_SUFFIX:
+		var a [][2]string
+		if err = json.Unmarshal(rec.Value, &a); err != nil {
+			return
+		}
+		for _, pair := range a {
+			if _, err = strconv.ParseFloat(pair[1], 64); err != nil {
+				return
+			}
+			var member []byte
+			if member, err = rec.decode(pair[0]); err != nil {
+				return
+			}
+			elems = append(elems, []byte(pair[1]), member)
+		}
+		cmds = append(cmds, chunkCommands("ZADD", key, elems, 2)...)
+	case levelredis.DOC_SUFFIX:
+		cmds = append(cmds, NewCommand([]byte("DOC_SET"), key, rec.Value))
+	default:
+		return nil, errors.New("unsupported type " + rec.Type)
+	}
+	if rec.ExpireAt > 0 {
+		cmds = append(cmds, NewCommand([]byte("PEXPIREAT"), key, []byte(strconv.FormatInt(rec.ExpireAt, 10))))
+	}
+	return
+}
+
+func (rec *jsonRecord) decode(s string) ([]byte, error) {
+	if rec.Base64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+func (rec *jsonRecord) appendDecoded(elems [][]byte, values ...string) ([][]byte, error) {
+	for _, s := range values {
+		b, err := rec.decode(s)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, b)
+	}
+	return elems, nil
+}
+
+// name key elem ...，每条指令最多jsonImportChunk组元素，width为每组的元素数
+func chunkCommands(name string, key []byte, elems [][]byte, width int) (cmds []*Command) {
+	step := jsonImportChunk * width
+	for i := 0; i < len(elems); i += step {
+		end := i + step
+		if end > len(elems) {
+			end = len(elems)
+		}
+		args := append([][]byte{[]byte(name), key}, elems[i:end]...)
+		cmds = append(cmds, NewCommand(args...))
+	}
+	return
+}
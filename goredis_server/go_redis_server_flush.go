@@ -0,0 +1,73 @@
+package goredis_server
+
+// FLUSHALL/FLUSHDB [ASYNC|SYNC]，FLUSHALL清空全部db，FLUSHDB只清空当前SELECT的db
+// SYNC挂起全部指令，按前缀删除后compact；ASYNC与UNLINK相同，元素由后台删除
+// 系统数据(配置、CRON等)和SWAPDB的映射不受影响
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"strings"
+	"time"
+)
+
+func (server *GoRedisServer) OnFLUSHALL(cmd *Command) (reply *Reply) {
+	return server.flush(cmd, server.databases())
+}
+
+func (server *GoRedisServer) OnFLUSHDB(cmd *Command) (reply *Reply) {
+	return server.flush(cmd, []*levelredis.LevelRedis{server.db(cmd)})
+}
+
+func (server *GoRedisServer) flush(cmd *Command, dbs []*levelredis.LevelRedis) (reply *Reply) {
+	async := false
+	if len(cmd.Args()) > 1 {
+		switch strings.ToUpper(cmd.StringAtIndex(1)) {
+		case "ASYNC":
+			async = true
+		case "SYNC":
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+	name := strings.ToLower(cmd.Name())
+	begin := time.Now()
+	if async {
+		n := 0
+		for _, db := range dbs {
+			n += db.FlushAllAsync()
+		}
+		stdlog.Printf("%s async %d keys, %s\n", name, n, time.Since(begin))
+		server.resetDBSizes(dbs)
+	} else {
+		n, err := server.flushDBs(dbs)
+		if err != nil {
+			return ErrorReply(err)
+		}
+		stdlog.Printf("%s %d records, %s\n", name, n, time.Since(begin))
+	}
+	return StatusReply("OK")
+}
+
+// 挂起全部指令后清空dbs，返回删除的raw key数量；从库全量同步之前也用它清空
+func (server *GoRedisServer) flushDBs(dbs []*levelredis.LevelRedis) (n int64, err error) {
+	server.Suspend()
+	defer server.Resume()
+	for _, db := range dbs {
+		var count int64
+		if count, err = db.FlushAll(); err != nil {
+			break
+		}
+		n += count
+	}
+	server.resetDBSizes(dbs)
+	return
+}
+
+func (server *GoRedisServer) resetDBSizes(dbs []*levelredis.LevelRedis) {
+	server.dbsizeMu.Lock()
+	for _, db := range dbs {
+		delete(server.dbsizes, db)
+	}
+	server.dbsizeMu.Unlock()
+}
@@ -0,0 +1,52 @@
+package goredis_server
+
+// 大集合保护
+// 数据存放在磁盘上，LRANGE 0 -1、ZRANGE 0 -1、HGETALL 这类无界读取会一次扫描整个集合
+// 设置large-collection-threshold后，返回元素超过阈值时拒绝执行或记录警告
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"fmt"
+)
+
+const (
+	largeCollectionThresholdKey = "large-collection-threshold" // 元素数量，0表示不限制
+	largeCollectionActionKey    = "large-collection-action"    // reject/warn，默认reject
+)
+
+func (server *GoRedisServer) initLargeCollectionGuard() {
+	server.largeThreshold = server.config.IntForKey(largeCollectionThresholdKey, 0)
+	server.largeWarnOnly = server.config.StringForKey(largeCollectionActionKey) == "warn"
+}
+
+// 计算[start, stop]实际覆盖的元素数量，负数表示从末尾开始计数
+func rangeSpan(start, stop, length int64) int64 {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if n := stop - start + 1; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// size超过阈值时返回错误，warn模式下只记录日志
+func (server *GoRedisServer) checkLargeCollection(cmd *Command, size int64, alternative string) *Reply {
+	if server.largeThreshold <= 0 || size <= server.largeThreshold {
+		return nil
+	}
+	if server.largeWarnOnly {
+		stdlog.Printf("[%s] large collection %s, size > %d\n", cmd.GetAttribute(C_SESSION).(*Session).RemoteAddr(), cmd, server.largeThreshold)
+		return nil
+	}
+	return ErrorReply(fmt.Sprintf("LARGECOLL %s exceeds %d elements, use %s instead", cmd.Name(), server.largeThreshold, alternative))
+}
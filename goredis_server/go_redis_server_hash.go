@@ -2,12 +2,14 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"math"
+	"strconv"
 )
 
 func (server *GoRedisServer) OnHGET(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	field, _ := cmd.ArgAtIndex(2)
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	val := hash.Get(field)
 	if val == nil {
 		reply = BulkReply(nil)
@@ -17,19 +19,30 @@ func (server *GoRedisServer) OnHGET(cmd *Command) (reply *Reply) {
 	return
 }
 
+// HSET key field value [field value ...]
+// 返回新增的field数量
 func (server *GoRedisServer) OnHSET(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetHash(key)
-	field, _ := cmd.ArgAtIndex(2)
-	value, _ := cmd.ArgAtIndex(3)
-	hash.Set(field, value)
-	return IntegerReply(1)
+	keyvals := cmd.Args()[2:]
+	if len(keyvals)%2 != 0 {
+		return ErrorReply(WrongArgumentCount)
+	}
+	hash := server.db(cmd).GetHash(key)
+	n := hash.Set(keyvals...)
+	return IntegerReply(n)
 }
 
 func (server *GoRedisServer) OnHGETALL(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetHash(key)
-	elems := hash.GetAll(1000)
+	hash := server.db(cmd).GetHash(key)
+	limit := 1000
+	if server.largeThreshold > 0 {
+		limit = int(server.largeThreshold) + 1
+	}
+	elems := hash.GetAll(limit)
+	if r := server.checkLargeCollection(cmd, int64(len(elems)), "HMGET"); r != nil {
+		return r
+	}
 	keyvals := make([]interface{}, 0, len(elems)*2)
 	for _, elem := range elems {
 		keyvals = append(keyvals, elem.Key)
@@ -39,9 +52,60 @@ func (server *GoRedisServer) OnHGETALL(cmd *Command) (reply *Reply) {
 	return
 }
 
+// HKEYS key
+func (server *GoRedisServer) OnHKEYS(cmd *Command) (reply *Reply) {
+	return server.hashFieldsOrValues(cmd, true)
+}
+
+// HVALS key
+func (server *GoRedisServer) OnHVALS(cmd *Command) (reply *Reply) {
+	return server.hashFieldsOrValues(cmd, false)
+}
+
+// 与HGETALL一样受大集合保护限制，按field字节顺序返回
+func (server *GoRedisServer) hashFieldsOrValues(cmd *Command, fields bool) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	hash := server.db(cmd).GetHash(key)
+	limit := 1000
+	if server.largeThreshold > 0 {
+		limit = int(server.largeThreshold) + 1
+	}
+	elems := hash.GetAll(limit)
+	if r := server.checkLargeCollection(cmd, int64(len(elems)), "HMGET"); r != nil {
+		return r
+	}
+	bulks := make([]interface{}, 0, len(elems))
+	for _, elem := range elems {
+		if fields {
+			bulks = append(bulks, elem.Key)
+		} else {
+			bulks = append(bulks, elem.Value)
+		}
+	}
+	reply = MultiBulksReply(bulks)
+	return
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func (server *GoRedisServer) OnHSCAN(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	args, err := parseScanArgs(cmd, 2)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	hash := server.db(cmd).GetHash(key)
+	elems := make([]interface{}, 0, args.count*2)
+	next := hash.Scan(args.cursor, args.count, func(field, value []byte) {
+		if args.Match(field) {
+			elems = append(elems, field, value)
+		}
+	})
+	return scanReply(next, elems)
+}
+
 func (server *GoRedisServer) OnHMGET(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	fields := cmd.Args()[2:]
 	keyvals := make([]interface{}, 0, len(fields))
 	for _, field := range fields {
@@ -59,7 +123,7 @@ func (server *GoRedisServer) OnHMSET(cmd *Command) (reply *Reply) {
 		reply = ErrorReply("Bad field/value paires")
 		return
 	}
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	hash.Set(keyvals...)
 	reply = StatusReply("OK")
 	return
@@ -68,7 +132,7 @@ func (server *GoRedisServer) OnHMSET(cmd *Command) (reply *Reply) {
 func (server *GoRedisServer) OnHEXISTS(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	field, _ := cmd.ArgAtIndex(2)
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	val := hash.Get(field)
 	if val == nil {
 		reply = IntegerReply(0)
@@ -78,9 +142,41 @@ func (server *GoRedisServer) OnHEXISTS(cmd *Command) (reply *Reply) {
 	return
 }
 
+// HINCRBY key field increment
+func (server *GoRedisServer) OnHINCRBY(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	field, _ := cmd.ArgAtIndex(2)
+	incr, err := strconv.ParseInt(cmd.StringAtIndex(3), 10, 64)
+	if err != nil {
+		return ErrorReply("value is not an integer or out of range")
+	}
+	hash := server.db(cmd).GetHash(key)
+	n, err := hash.IncrBy(field, incr)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(int(n))
+}
+
+// HINCRBYFLOAT key field increment
+func (server *GoRedisServer) OnHINCRBYFLOAT(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	field, _ := cmd.ArgAtIndex(2)
+	incr, err := strconv.ParseFloat(cmd.StringAtIndex(3), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		return ErrorReply("value is not a valid float")
+	}
+	hash := server.db(cmd).GetHash(key)
+	newvalue, err := hash.IncrByFloat(field, incr)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply(newvalue)
+}
+
 func (server *GoRedisServer) OnHLEN(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	length := hash.Count()
 	reply = IntegerReply(length)
 	return
@@ -88,7 +184,7 @@ func (server *GoRedisServer) OnHLEN(cmd *Command) (reply *Reply) {
 
 func (server *GoRedisServer) OnHDEL(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetHash(key)
+	hash := server.db(cmd).GetHash(key)
 	fields := cmd.Args()[2:]
 	n := hash.Remove(fields...)
 	reply = IntegerReply(n)
@@ -2,45 +2,72 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
 	"bytes"
 	"fmt"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// RESP3的连接返回verbatim string
 func (server *GoRedisServer) OnINFO(cmd *Command) (reply *Reply) {
 	section := strings.ToLower(cmd.StringAtIndex(1))
 	switch section {
 	case "memory":
-		reply = BulkReply(server.memoryInfo())
+		reply = VerbatimReply("txt", server.memoryInfo())
 	case "server":
-		reply = BulkReply(server.serverInfo())
+		reply = VerbatimReply("txt", server.serverInfo())
+	case "clients":
+		reply = VerbatimReply("txt", server.clientInfo())
 	case "command":
-		reply = BulkReply(server.commandInfo())
+		reply = VerbatimReply("txt", server.commandInfo())
 	case "memstats":
-		reply = BulkReply(server.memstatInfo())
+		reply = VerbatimReply("txt", server.memstatInfo())
 	case "stats":
-		reply = BulkReply(server.statsInfo())
+		reply = VerbatimReply("txt", server.statsInfo())
 	default:
-		buf := bytes.Buffer{}
-		buf.WriteString(server.serverInfo())
-		buf.WriteString("\n")
-		buf.WriteString(server.clientInfo())
-		buf.WriteString("\n")
-		buf.WriteString(server.memoryInfo())
-		buf.WriteString("\n")
-		buf.WriteString(server.persistenceInfo())
-		buf.WriteString("\n")
-		buf.WriteString(server.statsInfo())
-		buf.WriteString("\n")
-		buf.WriteString(server.replicationInfo())
-		reply = BulkReply(buf.String())
+		reply = VerbatimReply("txt", server.defaultInfo())
 	}
 	return
 }
 
+// DBINFO，数据布局版本和编码方式，用于升级和迁移工具判断数据是否兼容
+func (server *GoRedisServer) OnDBINFO(cmd *Command) (reply *Reply) {
+	pending := []interface{}{}
+	for _, m := range server.levelRedis.PendingMigrations() {
+		pending = append(pending, fmt.Sprintf("%d: %s", m.Version, m.Desc))
+	}
+	return MapReply([]interface{}{
+		"layout_version", server.levelRedis.LayoutVersion(),
+		"supported_version", levelredis.SchemaVersion,
+		"score_encoding", "float64",
+		"key_escaping", "none",
+		"compression", "snappy",
+		"value_codecs", strings.Join(server.levelRedis.CodecPrefixes(), ","),
+		"pending_migrations", pending,
+		"databases", server.dbCount(),
+	})
+}
+
+func (server *GoRedisServer) defaultInfo() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(server.serverInfo())
+	buf.WriteString("\n")
+	buf.WriteString(server.clientInfo())
+	buf.WriteString("\n")
+	buf.WriteString(server.memoryInfo())
+	buf.WriteString("\n")
+	buf.WriteString(server.persistenceInfo())
+	buf.WriteString("\n")
+	buf.WriteString(server.statsInfo())
+	buf.WriteString("\n")
+	buf.WriteString(server.replicationInfo())
+	return buf.String()
+}
+
 func (server *GoRedisServer) serverInfo() string {
 	buf := bytes.Buffer{}
 	buf.WriteString("# Server\n")
@@ -53,6 +80,8 @@ func (server *GoRedisServer) clientInfo() string {
 	buf := bytes.Buffer{}
 	buf.WriteString("# Clients\n")
 	buf.WriteString(fmt.Sprintf("connected_clients:%d\n", server.info.connected_clients()))
+	buf.WriteString(fmt.Sprintf("blocked_clients:%d\n", server.listWaiters.Blocked()))
+	buf.WriteString(server.clientLibInfo())
 	return buf.String()
 }
 
@@ -117,6 +146,8 @@ func (server *GoRedisServer) persistenceInfo() string {
 	buf.WriteString("# Persistence\n")
 	buf.WriteString(fmt.Sprintf("db_size:%d\n", server.info.db_size()))
 	buf.WriteString(fmt.Sprintf("db_size_human:%s\n", bytesInHuman(server.info.db_size())))
+	buf.WriteString(server.rdbSaveInfo())
+	buf.WriteString(server.aofInfo())
 	return buf.String()
 }
 
@@ -138,11 +169,18 @@ func (server *GoRedisServer) replicationInfo() string {
 	buf.WriteString(fmt.Sprintf("role:%s\n", server.info.Role()))
 
 	buf.WriteString(fmt.Sprintf("connected_slaves:%d\n", server.info.connected_slaves()))
+	// 与redis 2.8以后的格式相同，offset为从库确认执行完的seq，lag为距离上一次确认的秒数
 	server.syncmgr.Enumerate(func(i int, key string, val interface{}) {
 		sess := val.(*Session)
 		host, port := splitHostPort(sess.GetAttribute(S_HOST).(string))
-		buf.WriteString(fmt.Sprintf("slave%d:%s,%d,%s\n", i, host, port, sess.GetAttribute(S_STATUS)))
+		offset, lag := replAck(sess)
+		buf.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\n", i, host, port, sess.GetAttribute(S_STATUS), offset, lag))
 	})
+	offset := int64(0)
+	if server.synclog.IsEnabled() {
+		offset = server.synclog.MaxSeq()
+	}
+	buf.WriteString(fmt.Sprintf("master_repl_offset:%d\n", offset))
 
 	buf.WriteString(fmt.Sprintf("connected_masters:%d\n", server.info.connected_masters()))
 	server.slavemgr.Enumerate(func(i int, key string, val interface{}) {
@@ -151,6 +189,45 @@ func (server *GoRedisServer) replicationInfo() string {
 		host, port := splitHostPort(sess.RemoteAddr().String())
 		buf.WriteString(fmt.Sprintf("master%d:%s,%d,%s\n", i, host, port, sess.GetAttribute(S_STATUS)))
 	})
+	buf.WriteString(server.masterLinkInfo())
+
+	return buf.String()
+}
+
+// 与redis相同的master_*字段，只输出第一个主库，等待重连时master_link_status为down
+func (server *GoRedisServer) masterLinkInfo() string {
+	addr, downSince := server.masterLinkDown()
+	status := ""
+	var lastRecv time.Time
+	server.slavemgr.Enumerate(func(i int, key string, val interface{}) {
+		if i == 0 {
+			sess := val.(ISlaveClient).Session()
+			addr = sess.RemoteAddr().String()
+			status, _ = sess.GetAttribute(S_STATUS).(string)
+			lastRecv, _ = sess.GetAttribute(S_LAST_RECV).(time.Time)
+		}
+	})
+	if len(addr) == 0 {
+		return ""
+	}
+	host, port := splitHostPort(addr)
+	link, syncing := "down", 0
+	if status == REPL_ONLINE {
+		link = "up"
+	} else if status == REPL_RECV_BULK {
+		syncing = 1
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("master_host:%s\n", host))
+	buf.WriteString(fmt.Sprintf("master_port:%d\n", port))
+	buf.WriteString(fmt.Sprintf("master_link_status:%s\n", link))
+	if !lastRecv.IsZero() {
+		buf.WriteString(fmt.Sprintf("master_last_io_seconds_ago:%d\n", int(time.Since(lastRecv).Seconds())))
+	}
+	buf.WriteString(fmt.Sprintf("master_sync_in_progress:%d\n", syncing))
+	if link == "down" && !downSince.IsZero() {
+		buf.WriteString(fmt.Sprintf("master_link_down_since_seconds:%d\n", int(time.Since(downSince).Seconds())))
+	}
 
 	return buf.String()
 }
@@ -16,15 +16,40 @@ func (server *GoRedisServer) Init() (err error) {
 
 	server.initSignalNotify()
 
+	err = server.initRestore()
+	if err != nil {
+		return
+	}
 	err = server.initLevelDB()
 	if err != nil {
 		return
 	}
+	err = server.initLayoutMigration()
+	if err != nil {
+		return
+	}
+	server.initDatabases()
 	err = server.initSyncLog()
 	if err != nil {
 		return
 	}
+	err = server.initLoadRDB()
+	if err != nil {
+		return
+	}
+	server.initWarmUp()
 	server.config = NewConfig(server.levelRedis, PREFIX+"config:")
+	for key, value := range server.opt.Configs() {
+		server.config.Set(key, []byte(value))
+	}
+	server.initSlaveMaxLag()
+	server.initReplicaReadOnly()
+	server.initLargeCollectionGuard()
+	server.initDocHistory()
+	server.initExpireSweeper()
+	server.initUnlinkWorker()
+	server.initCron()
+	server.initSlowLogStore()
 	// monitor
 	server.initCommandMonitor(server.opt.LogPath() + "/cmd.log")
 	server.initCommandCounterLog("string", []string{"GET", "SET", "MGET", "MSET", "INCR", "DECR", "INCRBY", "DECRBY"})
@@ -37,6 +62,15 @@ func (server *GoRedisServer) Init() (err error) {
 	server.initLeveldbStatsLog(server.opt.LogPath() + "/leveldb.stats.log")
 	server.initExecLog(server.opt.LogPath() + "/exec.time.log")
 	server.initSlowlog(server.opt.LogPath() + "/slow.log")
+	server.initCommandStats()
+	err = server.initRestoreReplay()
+	if err != nil {
+		return
+	}
+	err = server.initAppendOnly()
+	if err != nil {
+		return
+	}
 	stdlog.Printf("init uid %s\n", server.UID())
 	server.initSlaveOf()
 	return
@@ -58,6 +92,16 @@ func (server *GoRedisServer) initSignalNotify() {
 		stdlog.Println("recv signal:", sig)
 		server.Close()
 	}()
+	// kill -USR1 [pid] 输出诊断信息
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for _ = range usr1 {
+			if _, err := server.dumpState(); err != nil {
+				stdlog.Println("dumpstate", err)
+			}
+		}
+	}()
 }
 
 // 关闭服务
@@ -65,6 +109,13 @@ func (server *GoRedisServer) Close() {
 	server.closing = true               // 标记退出
 	server.Suspend()                    // 挂起全部传入数据
 	time.Sleep(time.Millisecond * 2000) // 休息一下，Suspend瞬间可能还有数据库写入
+	server.closeCommandStats()          // 停止定时保存，保存最后一次指令计数
+	server.waitReplicasShutdown()       // 从库收完全部日志后再关闭数据库
+	for index, db := range server.physdbs {
+		if index != 0 {
+			db.Close()
+		}
+	}
 	server.levelRedis.Close()
 	server.levelRedis = nil // 防止调用
 	server.synclog.Close()
@@ -114,6 +165,9 @@ func (server *GoRedisServer) initLevelDB() (err error) {
 		return e1
 	}
 	server.levelRedis = levelredis.NewLevelRedis(db, false)
+	for prefix, codec := range server.opt.ValueCodecs() {
+		server.levelRedis.RegisterCodec(prefix, codec)
+	}
 	server.DeferClosing(func() {
 		opts.Close()
 		cache.Close()
@@ -123,6 +177,36 @@ func (server *GoRedisServer) initLevelDB() (err error) {
 	return
 }
 
+// 检查数据布局版本并执行未完成的迁移，在Listen之前完成
+func (server *GoRedisServer) initLayoutMigration() (err error) {
+	from := server.levelRedis.LayoutVersion()
+	begin := time.Now()
+	err = server.levelRedis.Migrate(func(m levelredis.Migration) {
+		stdlog.Printf("migrate layout to %d: %s\n", m.Version, m.Desc)
+	})
+	if err != nil {
+		return
+	}
+	if from < levelredis.SchemaVersion {
+		stdlog.Printf("migrate layout %d -> %d finish, %s\n", from, levelredis.SchemaVersion, time.Since(begin))
+	}
+	return
+}
+
+// 启动预热，在Listen之前完成
+func (server *GoRedisServer) initWarmUp() {
+	mode := server.opt.WarmUp()
+	if len(mode) == 0 {
+		return
+	}
+	begin := time.Now()
+	stdlog.Printf("warmup %s start\n", mode)
+	scanned, objects := server.levelRedis.WarmUp(mode == "full", func(scanned, objects int64) {
+		stdlog.Printf("warmup %d keys, %d objects\n", scanned, objects)
+	})
+	stdlog.Printf("warmup finish, %d keys, %d objects, %s\n", scanned, objects, time.Since(begin))
+}
+
 // 初始化主从日志
 func (server *GoRedisServer) initSyncLog() error {
 	opts := levelredis.NewOptions()
@@ -144,6 +228,7 @@ func (server *GoRedisServer) initSyncLog() error {
 	}
 	ldb := levelredis.NewLevelRedis(db, false)
 	server.synclog = NewSyncLog(ldb, "sync")
+	server.synclogDB = -1 // 启动后第一条日志前写入SELECT
 	server.DeferClosing(func() {
 		opts.Close()
 		cache.Close()
@@ -3,16 +3,80 @@ package goredis_server
 import (
 	. "GoRedis/goredis"
 	"GoRedis/libs/levelredis"
+	"bytes"
 	"strings"
+	"time"
 )
 
+// KEYS返回的最大数量，避免一次返回整个数据库
+const keysMaxResults = 100000
+
+// RANDOMKEY遇到过期的key时重试的次数
+const randomKeyTries = 16
+
 func (server *GoRedisServer) OnPING(cmd *Command) (reply *Reply) {
 	reply = StatusReply("PONG")
 	return
 }
 
+// KEYS pattern，按pattern中通配符之前的前缀扫描，结果超过keysMaxResults时返回错误
 func (server *GoRedisServer) OnKEYS(cmd *Command) (reply *Reply) {
-	return ErrorReply("use 'KEYSERACH [PREFIX] [LIMIT]' instead")
+	db := server.db(cmd)
+	pattern := cmd.StringAtIndex(1)
+	prefix := globPrefix(pattern)
+	now := nowMillis()
+	hasExpire := db.HasExpire()
+	bulks := make([]interface{}, 0)
+	var last []byte
+	tooMany := false
+	db.Keys([]byte(prefix), func(i int, key, keytype []byte, quit *bool) {
+		// 同一个key只返回一次
+		if bytes.Equal(key, last) || !globMatch(pattern, string(key)) {
+			return
+		}
+		if hasExpire {
+			if at := db.ExpireAt(key); at != -1 && at <= now {
+				return
+			}
+		}
+		if len(bulks) >= keysMaxResults {
+			tooMany = true
+			*quit = true
+			return
+		}
+		last = key
+		bulks = append(bulks, key)
+	})
+	if tooMany {
+		return ErrorReply("too many keys, use SCAN or KEYSEARCH instead")
+	}
+	return MultiBulksReply(bulks)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+// 按key顺序扫描类型登记，游标是上一批最后一个登记，扫描期间的写入不影响遍历
+// COUNT是扫描的登记数，MATCH过滤之后返回的key可能更少，也可能为空
+func (server *GoRedisServer) OnSCAN(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	args, err := parseScanArgs(cmd, 1)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	now := nowMillis()
+	hasExpire := db.HasExpire()
+	elems := make([]interface{}, 0, args.count)
+	next := db.ScanKeys([]byte(globPrefix(args.match)), args.cursor, args.count, func(key, keytype []byte) {
+		if !args.Match(key) {
+			return
+		}
+		if hasExpire {
+			if at := db.ExpireAt(key); at != -1 && at <= now {
+				return
+			}
+		}
+		elems = append(elems, key)
+	})
+	return scanReply(next, elems)
 }
 
 // keys重命名为keysearch
@@ -40,7 +104,7 @@ func (server *GoRedisServer) OnKEYSEARCH(cmd *Command) (reply *Reply) {
 
 	// search
 	bulks := make([]interface{}, 0, count)
-	server.levelRedis.Keys(seekkey, func(i int, key, keytype []byte, quit *bool) {
+	server.db(cmd).Keys(seekkey, func(i int, key, keytype []byte, quit *bool) {
 		if i >= count {
 			*quit = true
 			return
@@ -70,6 +134,7 @@ func (server *GoRedisServer) OnKEYNEXT(cmd *Command) (reply *Reply) {
 }
 
 func (server *GoRedisServer) keyEnumerate(cmd *Command, direction levelredis.IterDirection) (reply *Reply) {
+	db := server.db(cmd)
 	seek, _ := cmd.ArgAtIndex(1)
 	count := 1
 	withtype := false
@@ -100,12 +165,15 @@ func (server *GoRedisServer) keyEnumerate(cmd *Command, direction levelredis.Ite
 		}
 	}
 	bulks := make([]interface{}, 0, bufferSize)
-	server.levelRedis.KeyEnumerate(seek, direction, func(i int, key, keytype, value []byte, quit *bool) {
+	db.KeyEnumerate(seek, direction, func(i int, key, keytype, value []byte, quit *bool) {
 		// stdlog.Println(i, string(key), string(keytype), string(value))
 		bulks = append(bulks, key)
 		if withtype {
 			bulks = append(bulks, keytype)
 			if withvalue {
+				if string(keytype) == "string" {
+					value, _ = db.Strings().Decode(key, value)
+				}
 				bulks = append(bulks, value)
 			}
 		}
@@ -173,31 +241,154 @@ func (server *GoRedisServer) OnRAW_SET(cmd *Command) (reply *Reply) {
 	}
 }
 
-// 官方redis的dbsize输出key数量，这里输出数据库大小
+type dbsizeCache struct {
+	n       int64
+	at      time.Time
+	elapsed time.Duration
+}
+
+// 与redis一致返回key的数量，数据库大小见INFO的db_size
+// 没有维护计数器，每次扫描类型登记，结果缓存一段时间(至少1秒，扫描耗时的10倍)，
+// 避免大库上频繁执行DBSIZE反复扫描；同时执行时等待同一次扫描的结果
 func (server *GoRedisServer) OnDBSIZE(cmd *Command) (reply *Reply) {
-	return StatusReply(bytesInHuman(server.info.db_size()))
+	db := server.db(cmd)
+	server.dbsizeMu.Lock()
+	defer server.dbsizeMu.Unlock()
+	if server.dbsizes == nil {
+		server.dbsizes = make(map[*levelredis.LevelRedis]*dbsizeCache)
+	}
+	c, ok := server.dbsizes[db]
+	if !ok {
+		c = &dbsizeCache{}
+		server.dbsizes[db] = c
+	}
+	ttl := c.elapsed * 10
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	if c.at.IsZero() || time.Since(c.at) > ttl {
+		begin := time.Now()
+		c.n = db.KeyCount()
+		c.at = time.Now()
+		c.elapsed = c.at.Sub(begin)
+	}
+	return IntegerReply(int(c.n))
 }
 
-/**
- * 过期时间，暂不支持
- * 1 if the timeout was set.
- * 0 if key does not exist or the timeout could not be set.
- */
-func (server *GoRedisServer) OnEXPIRE(cmd *Command) (reply *Reply) {
-	reply = IntegerReply(0)
-	return
+// 随机seek类型登记，跳过已过期的key，多次都是过期的key时返回最后一个
+func (server *GoRedisServer) OnRANDOMKEY(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	var key []byte
+	now := nowMillis()
+	for i := 0; i < randomKeyTries; i++ {
+		if key, _ = db.RandomKey(); key == nil {
+			return BulkReply(nil)
+		}
+		if !db.HasExpire() {
+			break
+		}
+		if at := db.ExpireAt(key); at == -1 || at > now {
+			break
+		}
+	}
+	return BulkReply(key)
 }
 
 func (server *GoRedisServer) OnDEL(cmd *Command) (reply *Reply) {
 	keys := cmd.Args()[1:]
-	n := server.levelRedis.Delete(keys...)
+	n := server.db(cmd).Delete(keys...)
 	reply = IntegerReply(n)
 	return
 }
 
+// RENAME key newkey，newkey存在时被覆盖
+func (server *GoRedisServer) OnRENAME(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	newkey, _ := cmd.ArgAtIndex(2)
+	ok, err := server.db(cmd).Rename(key, newkey)
+	if err != nil {
+		return ErrorReply(err)
+	} else if !ok {
+		return ErrorReply("no such key")
+	}
+	return StatusReply("OK")
+}
+
+// RENAMENX key newkey，newkey存在时返回0
+func (server *GoRedisServer) OnRENAMENX(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	newkey, _ := cmd.ArgAtIndex(2)
+	if db.TypeOf(key) == "none" {
+		return ErrorReply("no such key")
+	}
+	if db.TypeOf(newkey) != "none" {
+		return IntegerReply(0)
+	}
+	if _, err := db.Rename(key, newkey); err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(1)
+}
+
+// COPY source destination [DB index] [REPLACE]，destination存在且没有REPLACE时返回0
+// 从快照复制，复制期间source的写入不影响结果
+func (server *GoRedisServer) OnCOPY(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	newkey, _ := cmd.ArgAtIndex(2)
+	index, replace := server.dbIndex(cmd), false
+	for i := 3; i < cmd.Len(); i++ {
+		switch strings.ToUpper(cmd.StringAtIndex(i)) {
+		case "DB":
+			if i+1 >= cmd.Len() {
+				return ErrorReply("syntax error")
+			}
+			i++
+			if index, reply = server.parseDBIndex(cmd.StringAtIndex(i)); reply != nil {
+				return
+			}
+		case "REPLACE":
+			replace = true
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+	src, dst := server.db(cmd), server.dbAt(index)
+	if index == server.dbIndex(cmd) && bytes.Equal(key, newkey) {
+		return ErrorReply("source and destination objects are the same")
+	}
+	if index != server.dbIndex(cmd) && dst.HasExpire() && !server.isReplica() {
+		dst.ExpireIfNeeded(newkey, nowMillis(), server.expiredIn(index))
+	}
+	snap := src.Snapshot()
+	defer snap.Close()
+	ok, err := snap.CopyTo(key, dst, newkey, replace)
+	if err != nil {
+		return ErrorReply(err)
+	} else if !ok {
+		return IntegerReply(0)
+	}
+	return IntegerReply(1)
+}
+
+// EXISTS key [key ...]，与redis一致，重复的key重复计数
+func (server *GoRedisServer) OnEXISTS(cmd *Command) (reply *Reply) {
+	n := 0
+	for _, key := range cmd.Args()[1:] {
+		if server.db(cmd).TypeOf(key) != "none" {
+			n++
+		}
+	}
+	return IntegerReply(n)
+}
+
 func (server *GoRedisServer) OnTYPE(cmd *Command) (reply *Reply) {
 	key, _ := cmd.ArgAtIndex(1)
-	t := server.levelRedis.TypeOf(key)
+	t := server.db(cmd).TypeOf(key)
+	// bitmap对外表现为string
+	if t == levelredis.BITMAP_SUFFIX {
+		t = levelredis.STRING_SUFFIX
+	}
 	if len(t) > 0 {
 		reply = StatusReply(t)
 	} else {
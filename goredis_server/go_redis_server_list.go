@@ -2,16 +2,22 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (server *GoRedisServer) OnLPUSH(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	vals := cmd.Args()[2:]
-	lst := server.levelRedis.GetList(key)
+	lst := server.db(cmd).GetList(key)
 	err := lst.LPush(vals...)
 	if err != nil {
 		return ErrorReply(err)
 	}
+	server.listWaiters.Signal(key)
 	length := int(lst.Len())
 	return IntegerReply(length)
 }
@@ -19,57 +25,279 @@ func (server *GoRedisServer) OnLPUSH(cmd *Command) (reply *Reply) {
 func (server *GoRedisServer) OnRPUSH(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	vals := cmd.Args()[2:]
-	lst := server.levelRedis.GetList(key)
+	lst := server.db(cmd).GetList(key)
 	err := lst.RPush(vals...)
 	if err != nil {
 		return ErrorReply(err)
 	}
+	server.listWaiters.Signal(key)
 	length := int(lst.Len())
 	return IntegerReply(length)
 }
 
+// 元素为nil时返回nil，BulkReply(nil)输出为nil bulk，不需要在每个调用处判断
+func elemValue(elem *levelredis.Element) []byte {
+	if elem == nil {
+		return nil
+	}
+	value, _ := elem.Value.([]byte)
+	return value
+}
+
 func (server *GoRedisServer) OnRPOP(cmd *Command) (reply *Reply) {
-	key := cmd.StringAtIndex(1)
-	lst := server.levelRedis.GetList(key)
-	if lst == nil {
+	elem, err := server.db(cmd).GetList(cmd.StringAtIndex(1)).RPop()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply(elemValue(elem))
+}
+
+func (server *GoRedisServer) OnLPOP(cmd *Command) (reply *Reply) {
+	elem, err := server.db(cmd).GetList(cmd.StringAtIndex(1)).LPop()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply(elemValue(elem))
+}
+
+func (server *GoRedisServer) OnRPOPLPUSH(cmd *Command) (reply *Reply) {
+	return server.moveListElem(server.db(cmd), cmd.StringAtIndex(1), cmd.StringAtIndex(2), false, true)
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func (server *GoRedisServer) OnLMOVE(cmd *Command) (reply *Reply) {
+	from, to := strings.ToUpper(cmd.StringAtIndex(3)), strings.ToUpper(cmd.StringAtIndex(4))
+	if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+		return ErrorReply("syntax error")
+	}
+	return server.moveListElem(server.db(cmd), cmd.StringAtIndex(1), cmd.StringAtIndex(2), from == "LEFT", to == "LEFT")
+}
+
+func (server *GoRedisServer) moveListElem(db *levelredis.LevelRedis, srckey, dstkey string, fromLeft, toLeft bool) (reply *Reply) {
+	src := db.GetList(srckey)
+	if src.Len() == 0 {
 		return BulkReply(nil)
 	}
-	elem, err := lst.RPop()
+	dst := db.GetList(dstkey)
+	elem, err := levelredis.MoveElem(src, dst, fromLeft, toLeft)
 	if err != nil {
 		return ErrorReply(err)
+	} else if elem == nil {
+		return BulkReply(nil)
 	}
-	if elem != nil {
-		reply = BulkReply(elem.Value.([]byte))
-	} else {
-		reply = BulkReply(nil)
+	server.listWaiters.Signal(dstkey)
+	return BulkReply(elemValue(elem))
+}
+
+// BLPOP key [key ...] timeout
+func (server *GoRedisServer) OnBLPOP(cmd *Command) (reply *Reply) {
+	return server.blockingPop(cmd, true)
+}
+
+// BRPOP key [key ...] timeout
+func (server *GoRedisServer) OnBRPOP(cmd *Command) (reply *Reply) {
+	return server.blockingPop(cmd, false)
+}
+
+// 按顺序检查每个key，从第一个非空的list中弹出元素，全部为空时阻塞
+// 返回[key, value]，超时返回nil
+func (server *GoRedisServer) blockingPop(cmd *Command, left bool) (reply *Reply) {
+	timeout, err := parseBlockTimeout(cmd.StringAtIndex(cmd.Len() - 1))
+	if err != nil {
+		return ErrorReply(err)
+	}
+	keys := make([]string, 0, cmd.Len()-2)
+	for _, key := range cmd.Args()[1 : cmd.Len()-1] {
+		keys = append(keys, string(key))
+	}
+	popName := "RPOP"
+	if left {
+		popName = "LPOP"
+	}
+	session, _ := cmd.GetAttribute(C_SESSION).(*Session)
+	reply = server.listWaiters.Wait(session, keys, timeout, server.tracked(cmd, func() *Reply {
+		for _, key := range keys {
+			lst := server.db(cmd).GetList(key)
+			if lst.Len() == 0 {
+				continue
+			}
+			var elem *levelredis.Element
+			var err error
+			if left {
+				elem, err = lst.LPop()
+			} else {
+				elem, err = lst.RPop()
+			}
+			if err != nil {
+				return ErrorReply(err)
+			} else if elem == nil {
+				continue // 被其它客户端抢先
+			}
+			cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte(popName), []byte(key)))
+			return MultiBulksReply([]interface{}{key, elemValue(elem)})
+		}
+		return nil
+	}))
+	if reply == nil {
+		reply = MultiBulksReply(nil)
 	}
 	return
 }
 
-func (server *GoRedisServer) OnLPOP(cmd *Command) (reply *Reply) {
-	key := cmd.StringAtIndex(1)
-	lst := server.levelRedis.GetList(key)
-	if lst == nil {
-		return BulkReply(nil)
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// 返回[key, [value ...]]，全部为空时返回nil
+func (server *GoRedisServer) OnLMPOP(cmd *Command) (reply *Reply) {
+	keys, left, count, err := parseMPopArgs(cmd, 1)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if reply = server.mpop(cmd, keys, left, count, false); reply == nil {
+		reply = MultiBulksReply(nil)
+	}
+	return
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func (server *GoRedisServer) OnBLMPOP(cmd *Command) (reply *Reply) {
+	timeout, err := parseBlockTimeout(cmd.StringAtIndex(1))
+	if err != nil {
+		return ErrorReply(err)
+	}
+	keys, left, count, err := parseMPopArgs(cmd, 2)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	session, _ := cmd.GetAttribute(C_SESSION).(*Session)
+	reply = server.listWaiters.Wait(session, keys, timeout, server.tracked(cmd, func() *Reply {
+		return server.mpop(cmd, keys, left, count, true)
+	}))
+	if reply == nil {
+		reply = MultiBulksReply(nil)
+	}
+	return
+}
+
+// 从idx开始解析 numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseMPopArgs(cmd *Command, idx int) (keys []string, left bool, count int, err error) {
+	numkeys, e := cmd.IntAtIndex(idx)
+	if e != nil || numkeys <= 0 || idx+1+numkeys >= cmd.Len() {
+		err = errors.New("numkeys should be greater than 0")
+		return
+	}
+	for _, key := range cmd.Args()[idx+1 : idx+1+numkeys] {
+		keys = append(keys, string(key))
 	}
-	elem, err := lst.LPop()
+	idx += 1 + numkeys
+	switch strings.ToUpper(cmd.StringAtIndex(idx)) {
+	case "LEFT":
+		left = true
+	case "RIGHT":
+		left = false
+	default:
+		err = errors.New("syntax error")
+		return
+	}
+	count = 1
+	if rest := cmd.Len() - idx - 1; rest == 2 && strings.ToUpper(cmd.StringAtIndex(idx+1)) == "COUNT" {
+		if count, e = cmd.IntAtIndex(idx + 2); e != nil || count <= 0 {
+			err = errors.New("count should be greater than 0")
+		}
+	} else if rest != 0 {
+		err = errors.New("syntax error")
+	}
+	return
+}
+
+// 从第一个非空的list中弹出最多count个元素，全部为空时返回nil
+// 阻塞版本syncAs为true，以LMPOP的形式同步到从库
+func (server *GoRedisServer) mpop(cmd *Command, keys []string, left bool, count int, syncAs bool) *Reply {
+	for _, key := range keys {
+		lst := server.db(cmd).GetList(key)
+		if lst.Len() == 0 {
+			continue
+		}
+		values := make([]interface{}, 0, count)
+		for len(values) < count {
+			var elem *levelredis.Element
+			var err error
+			if left {
+				elem, err = lst.LPop()
+			} else {
+				elem, err = lst.RPop()
+			}
+			if err != nil {
+				return ErrorReply(err)
+			} else if elem == nil {
+				break
+			}
+			values = append(values, elemValue(elem))
+		}
+		if len(values) == 0 {
+			continue // 被其它客户端抢先
+		}
+		if syncAs {
+			cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("LMPOP"), []byte("1"), []byte(key), []byte(listSide(left)), []byte("COUNT"), []byte(strconv.Itoa(len(values)))))
+		}
+		return MultiBulksReply([]interface{}{key, values})
+	}
+	return nil
+}
+
+// BRPOPLPUSH source destination timeout
+func (server *GoRedisServer) OnBRPOPLPUSH(cmd *Command) (reply *Reply) {
+	return server.blockingMove(cmd, cmd.StringAtIndex(3), false, true)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func (server *GoRedisServer) OnBLMOVE(cmd *Command) (reply *Reply) {
+	from, to := strings.ToUpper(cmd.StringAtIndex(3)), strings.ToUpper(cmd.StringAtIndex(4))
+	if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+		return ErrorReply("syntax error")
+	}
+	return server.blockingMove(cmd, cmd.StringAtIndex(5), from == "LEFT", to == "LEFT")
+}
+
+func (server *GoRedisServer) blockingMove(cmd *Command, timeoutArg string, fromLeft, toLeft bool) (reply *Reply) {
+	timeout, err := parseBlockTimeout(timeoutArg)
 	if err != nil {
 		return ErrorReply(err)
 	}
-	if elem != nil {
-		reply = BulkReply(elem.Value.([]byte))
-	} else {
+	srckey, dstkey := cmd.StringAtIndex(1), cmd.StringAtIndex(2)
+	session, _ := cmd.GetAttribute(C_SESSION).(*Session)
+	reply = server.listWaiters.Wait(session, []string{srckey}, timeout, server.tracked(cmd, func() *Reply {
+		r := server.moveListElem(server.db(cmd), srckey, dstkey, fromLeft, toLeft)
+		if r.Type == ReplyTypeBulk && r.Value == nil {
+			return nil
+		}
+		if r.Type != ReplyTypeError {
+			cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte("LMOVE"), []byte(srckey), []byte(dstkey), []byte(listSide(fromLeft)), []byte(listSide(toLeft))))
+		}
+		return r
+	}))
+	if reply == nil {
 		reply = BulkReply(nil)
 	}
 	return
 }
 
-func (server *GoRedisServer) OnLINDEX(cmd *Command) (reply *Reply) {
-	key := cmd.StringAtIndex(1)
-	lst := server.levelRedis.GetList(key)
-	if lst == nil {
-		return BulkReply(nil)
+func listSide(left bool) string {
+	if left {
+		return "LEFT"
 	}
+	return "RIGHT"
+}
+
+// 超时为秒，可以是小数，0表示一直等待
+func parseBlockTimeout(s string) (timeout time.Duration, err error) {
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil || sec < 0 {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+func (server *GoRedisServer) OnLINDEX(cmd *Command) (reply *Reply) {
+	lst := server.db(cmd).GetList(cmd.StringAtIndex(1))
 	idx, err := cmd.IntAtIndex(2)
 	if err != nil {
 		return ErrorReply("bad index")
@@ -77,26 +305,41 @@ func (server *GoRedisServer) OnLINDEX(cmd *Command) (reply *Reply) {
 	elem, e2 := lst.Index(int64(idx))
 	if e2 != nil {
 		return ErrorReply(e2)
-	} else if elem == nil {
-		return BulkReply(nil)
 	}
-	reply = BulkReply(elem.Value.([]byte))
-	return
+	return BulkReply(elemValue(elem))
 }
 
+// LTRIM key start stop，负数表示从表尾开始计数
+// 先用TrimLeft删除stop之后的元素，再用TrimRight删除start之前的元素
 func (server *GoRedisServer) OnLTRIM(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
 	key := cmd.StringAtIndex(1)
-	lst := server.levelRedis.GetList(key)
-	start, e1 := cmd.IntAtIndex(2)
-	end, e2 := cmd.IntAtIndex(3)
+	start, e1 := cmd.Int64AtIndex(2)
+	stop, e2 := cmd.Int64AtIndex(3)
 	if e1 != nil || e2 != nil {
 		return ErrorReply("bad start/stop")
-	} else if start != 0 {
-		return ErrorReply("start must equal to 0 (in GoRedis)")
-	} else if end < start {
-		return ErrorReply("end must greater than start")
 	}
-	lst.TrimLeft(uint(end - start + 1))
+	lst := db.GetList(key)
+	length := lst.Len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= length {
+		// 结果为空，删除整个list
+		db.Delete([]byte(key))
+		return StatusReply("OK")
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	lst.TrimLeft(uint(stop + 1))
+	lst.TrimRight(uint(stop - start + 1))
 	reply = StatusReply("OK")
 	return
 }
@@ -107,13 +350,12 @@ func (server *GoRedisServer) OnLRANGE(cmd *Command) (reply *Reply) {
 	end, e2 := cmd.Int64AtIndex(3)
 	if e1 != nil || e2 != nil {
 		return ErrorReply("bad start/end")
-	} else if start < 0 {
-		return ErrorReply("start > end")
-	} else if end != -1 && start > end {
-		return ErrorReply("start > end")
 	}
 
-	lst := server.levelRedis.GetList(key)
+	lst := server.db(cmd).GetList(key)
+	if r := server.checkLargeCollection(cmd, rangeSpan(start, end, lst.Len()), "LRANGE with smaller ranges"); r != nil {
+		return r
+	}
 	elems, err := lst.Range(start, end)
 	if err != nil {
 		return ErrorReply(err)
@@ -129,7 +371,7 @@ func (server *GoRedisServer) OnLRANGE(cmd *Command) (reply *Reply) {
 
 func (server *GoRedisServer) OnLLEN(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	lst := server.levelRedis.GetList(key)
+	lst := server.db(cmd).GetList(key)
 	if lst == nil {
 		return IntegerReply(0)
 	}
@@ -137,3 +379,59 @@ func (server *GoRedisServer) OnLLEN(cmd *Command) (reply *Reply) {
 	reply = IntegerReply(n)
 	return
 }
+
+// LREM key count value
+func (server *GoRedisServer) OnLREM(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	count, err := cmd.Int64AtIndex(2)
+	if err != nil {
+		return ErrorReply("bad count")
+	}
+	value, _ := cmd.ArgAtIndex(3)
+	lst := server.db(cmd).GetList(key)
+	n, err := lst.Remove(count, value)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(int(n))
+}
+
+// LSET key index value
+func (server *GoRedisServer) OnLSET(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	index, err := cmd.Int64AtIndex(2)
+	if err != nil {
+		return ErrorReply("bad index")
+	}
+	value, _ := cmd.ArgAtIndex(3)
+	lst := server.db(cmd).GetList(key)
+	if err = lst.Set(index, value); err != nil {
+		return ErrorReply(err)
+	}
+	return StatusReply("OK")
+}
+
+// LINSERT key BEFORE|AFTER pivot value
+func (server *GoRedisServer) OnLINSERT(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	var before bool
+	switch strings.ToUpper(cmd.StringAtIndex(2)) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return ErrorReply(BadCommandError)
+	}
+	pivot, _ := cmd.ArgAtIndex(3)
+	value, _ := cmd.ArgAtIndex(4)
+	lst := server.db(cmd).GetList(key)
+	n, err := lst.Insert(before, pivot, value)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if n > 0 {
+		server.listWaiters.Signal(key)
+	}
+	return IntegerReply(int(n))
+}
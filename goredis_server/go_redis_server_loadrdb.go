@@ -0,0 +1,78 @@
+package goredis_server
+
+// 导入redis的RDB文件
+// 启动时: -loadrdb path 或配置文件里的loadrdb path，在监听端口之前导入；
+// 导入过的文件(路径、大小、修改时间相同)记录在_rdb，重启时不重复导入
+// 运行时: DEBUG LOADRDB [path]，默认logpath/dump.rdb，导入期间挂起指令处理
+// 同步日志开启时，导入的数据改写为写指令逐条写入同步日志，从库和AOF从日志重放
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const rdbLoadedKey = "_rdb" // 启动时导入过的RDB文件
+
+func (server *GoRedisServer) loadRDB(filename string) (n int, err error) {
+	begin := time.Now()
+	l := NewRDBLoader(server.databases())
+	// 启动时在监听之前，运行时在Suspend期间，没有其他写入同步日志
+	if server.synclog.IsEnabled() {
+		l.SetSyncLog(func(index int, cmd *Command) {
+			server.writeSyncLog(index, cmd.Bytes())
+		})
+	}
+	err = l.Load(filename)
+	n = l.Keys()
+	if err != nil {
+		stdlog.Printf("load rdb %s, %d keys before error: %s\n", filename, n, err)
+	} else {
+		stdlog.Printf("load rdb %s, %d keys, %s\n", filename, n, time.Since(begin))
+	}
+	return
+}
+
+// 启动时导入，需要在initDatabases之后调用
+func (server *GoRedisServer) initLoadRDB() (err error) {
+	filename := server.opt.LoadRDB()
+	if len(filename) == 0 {
+		return
+	}
+	if filename, err = filepath.Abs(filename); err != nil {
+		return
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	mark := fmt.Sprintf("%s %d %d", filename, info.Size(), info.ModTime().Unix())
+	if value, _ := server.levelRedis.RawGet([]byte(rdbLoadedKey)); string(value) == mark {
+		stdlog.Println("load rdb skipped, already loaded", filename)
+		return
+	}
+	if _, err = server.loadRDB(filename); err != nil {
+		return
+	}
+	return server.levelRedis.RawSet([]byte(rdbLoadedKey), []byte(mark))
+}
+
+// DEBUG LOADRDB [path]
+func (server *GoRedisServer) debugLoadRDB(cmd *Command) (reply *Reply) {
+	if server.isReplica() {
+		return ErrorReply("LOADRDB is not allowed on a replica")
+	}
+	filename := server.opt.LogPath() + "/" + rdbFilename
+	if cmd.Len() > 2 {
+		filename = cmd.StringAtIndex(2)
+	}
+	server.Suspend()
+	n, err := server.loadRDB(filename)
+	server.Resume()
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(n)
+}
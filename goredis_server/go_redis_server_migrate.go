@@ -0,0 +1,175 @@
+package goredis_server
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+// 从快照DUMP，在目标实例(redis或GoRedis)上RESTORE，成功的key从本地删除(COPY时保留)
+// 每次调用新建连接，timeout是连接和每次读写的超时(毫秒)
+// 删除本地的key时改写为DEL同步到从库
+// 传输期间本地又被修改过的key(与快照DUMP的结果或过期时间不同)不删除，返回错误列出这些key
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (server *GoRedisServer) OnMIGRATE(cmd *Command) (reply *Reply) {
+	args := cmd.Args()
+	dbindex, err := strconv.Atoi(cmd.StringAtIndex(4))
+	if err != nil || dbindex < 0 {
+		return ErrorReply(NotIntegerError)
+	}
+	ms, err := strconv.ParseInt(cmd.StringAtIndex(5), 10, 64)
+	if err != nil {
+		return ErrorReply(NotIntegerError)
+	}
+	if ms <= 0 {
+		ms = 1000
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	keep, replace := false, false
+	var auth [][]byte
+	keys := args[3:4]
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			keep = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			auth, i = args[i+1:i+2], i+1
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return ErrorReply("syntax error")
+			}
+			auth, i = args[i+1:i+3], i+2
+		case "KEYS":
+			if len(args[3]) != 0 {
+				return ErrorReply("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys, i = args[i+1:], len(args)
+		default:
+			return ErrorReply("syntax error")
+		}
+	}
+
+	snap := server.db(cmd).Snapshot()
+	defer snap.Close()
+	now := nowMillis()
+	restores := make([]*Command, 0, len(keys))
+	found := make([][]byte, 0, len(keys))
+	payloads := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		ttl := int64(0)
+		if at := snap.ExpireAt(key); at != -1 {
+			if ttl = at - now; ttl <= 0 {
+				continue
+			}
+		}
+		payload, r := server.dump(cmd, snap, key)
+		if r != nil {
+			return r
+		}
+		if payload == nil {
+			continue
+		}
+		restore := [][]byte{[]byte("RESTORE"), key, []byte(strconv.FormatInt(ttl, 10)), payload}
+		if replace {
+			restore = append(restore, []byte("REPLACE"))
+		}
+		restores = append(restores, NewCommand(restore...))
+		found = append(found, key)
+		payloads = append(payloads, payload)
+	}
+	if len(found) == 0 {
+		return StatusReply("NOKEY")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(cmd.StringAtIndex(1), cmd.StringAtIndex(2)), timeout)
+	if err != nil {
+		return ErrorReply("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+	target := NewSession(conn)
+
+	// AUTH、SELECT和RESTORE一次发送，再按顺序读取回复
+	cmds := make([]*Command, 0, len(restores)+2)
+	if len(auth) > 0 {
+		cmds = append(cmds, NewCommand(append([][]byte{[]byte("AUTH")}, auth...)...))
+	}
+	cmds = append(cmds, NewCommand([]byte("SELECT"), []byte(strconv.Itoa(dbindex))))
+	first := len(cmds)
+	cmds = append(cmds, restores...)
+	for _, c := range cmds {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if err = target.WriteCommand(c); err != nil {
+			return ErrorReply("IOERR error or timeout writing to target instance")
+		}
+	}
+	migrated := make([]int, 0, len(found)) // found的下标
+	errmsg := ""
+	for i := range cmds {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		r, err := target.ReadReply()
+		if err != nil {
+			errmsg = "IOERR error or timeout reading to target instance"
+			break
+		}
+		if r.Type == ReplyTypeError {
+			if len(errmsg) == 0 {
+				errmsg = fmt.Sprintf("Target instance replied with error: %s", r.Value)
+			}
+			// AUTH/SELECT失败时RESTORE不会在预期的db里执行，全部不删除
+			if i < first {
+				migrated = migrated[:0]
+				break
+			}
+			continue
+		}
+		if i >= first {
+			migrated = append(migrated, i-first)
+		}
+	}
+
+	if !keep && len(migrated) > 0 {
+		deleted, changed := server.deleteUnchanged(cmd, snap, found, payloads, migrated)
+		if len(deleted) > 0 {
+			cmd.SetAttribute(C_SYNC_AS, NewCommand(append([][]byte{[]byte("DEL")}, deleted...)...))
+		}
+		if len(changed) > 0 && len(errmsg) == 0 {
+			errmsg = fmt.Sprintf("keys modified during migration were kept: %s", bytes.Join(changed, []byte(" ")))
+		}
+	}
+	if len(errmsg) > 0 {
+		return ErrorReply(errmsg)
+	}
+	return StatusReply("OK")
+}
+
+// 挂起写入后逐个与快照比较，相同的才删除，比较和删除之间不会有新的写入
+func (server *GoRedisServer) deleteUnchanged(cmd *Command, snap *levelredis.LevelRedis, found, payloads [][]byte, migrated []int) (deleted, changed [][]byte) {
+	server.Suspend()
+	defer server.Resume()
+	db := server.db(cmd)
+	for _, i := range migrated {
+		key := found[i]
+		payload, r := server.dump(cmd, db, key)
+		if r != nil || db.ExpireAt(key) != snap.ExpireAt(key) || !bytes.Equal(payload, payloads[i]) {
+			stdlog.Printf("migrate %s modified during migration, kept\n", key)
+			changed = append(changed, key)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	if len(deleted) > 0 {
+		db.Delete(deleted...)
+	}
+	return
+}
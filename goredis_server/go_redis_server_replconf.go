@@ -0,0 +1,58 @@
+package goredis_server
+
+// REPLCONF listening-port [port] / capa [...] / ACK [seq]
+// 与redis相同的复制配置指令，offset使用同步日志的seq
+// GoRedis从库连接主库时先发送listening-port，成功时在线同步期间发送ACK，报告已经执行完的seq
+// 主库在INFO replication里据此输出每个从库的offset和lag(距离上一次ACK的秒数)
+import (
+	. "GoRedis/goredis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	replAckInterval      = time.Second
+	replAckCheckInterval = time.Millisecond * 100 // 从库检查seq是否变化的间隔
+)
+
+func (server *GoRedisServer) OnREPLCONF(session *Session, cmd *Command) (reply *Reply) {
+	args := cmd.Args()[1:]
+	if len(args)%2 != 0 {
+		return ErrorReply("syntax error")
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return ErrorReply("invalid port")
+			}
+			session.SetAttribute(S_SLAVE_PORT, port)
+		case "capa":
+		case "ack":
+			// ACK不回复
+			seq, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err == nil {
+				session.SetAttribute(S_ACK_SEQ, seq)
+				session.SetAttribute(S_ACK_TIME, time.Now())
+			}
+			return NOREPLY
+		default:
+			return ErrorReply("Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return StatusReply("OK")
+}
+
+// 从库确认的seq和距离上一次确认的秒数，没有确认过时都为-1
+func replAck(session *Session) (seq, lag int64) {
+	seq, ok := session.GetAttribute(S_ACK_SEQ).(int64)
+	if !ok {
+		return -1, -1
+	}
+	if t, ok := session.GetAttribute(S_ACK_TIME).(time.Time); ok {
+		lag = int64(time.Since(t).Seconds())
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package goredis_server
+
+// 读写分离路由提示
+// 主库通过REPLICAS返回从库列表和延迟，智能客户端据此把读请求分发到从库
+// 从库配置slave-max-lag后，复制延迟超过阈值时拒绝读请求，返回-MAXLAG
+// replica-read-only yes(默认)时从库拒绝普通连接的写入，返回-READONLY，主库同步的指令不受影响
+import (
+	. "GoRedis/goredis"
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	slaveMaxLagKey     = "slave-max-lag" // 秒，0表示不限制
+	replicaReadOnlyKey = "replica-read-only"
+)
+
+// REPLICAS
+// replica0:host=10.80.101.169,port=1602,state=online,lag=3
+// lag为主库尚未发送给该从库的日志条数
+func (server *GoRedisServer) OnREPLICAS(cmd *Command) (reply *Reply) {
+	buf := bytes.Buffer{}
+	maxseq := server.synclog.MaxSeq()
+	server.syncmgr.Enumerate(func(i int, key string, val interface{}) {
+		sess := val.(*Session)
+		host, port := splitHostPort(sess.GetAttribute(S_HOST).(string))
+		lag := int64(-1) // 未进入在线同步
+		if seq, ok := sess.GetAttribute(S_SYNC_SEQ).(int64); ok {
+			lag = maxseq - seq
+		}
+		buf.WriteString(fmt.Sprintf("replica%d:host=%s,port=%d,state=%s,lag=%d\n", i, host, port, sess.GetAttribute(S_STATUS), lag))
+	})
+	return BulkReply(buf.Bytes())
+}
+
+func (server *GoRedisServer) initSlaveMaxLag() {
+	server.slaveMaxLag = server.config.IntForKey(slaveMaxLagKey, 0)
+}
+
+// 从库距离最后一次确认执行到主库最新seq的秒数，GoRedis主库每秒发送一次PING [maxseq]；
+// redis主库没有seq，使用最后一次收到数据的时间；未在线视为无限延迟
+func (server *GoRedisServer) slaveLag() (lag int64) {
+	server.slavemgr.Enumerate(func(i int, key string, val interface{}) {
+		sess := val.(ISlaveClient).Session()
+		last, ok := sess.GetAttribute(S_SYNCED_AT).(time.Time)
+		if !ok {
+			last, ok = sess.GetAttribute(S_LAST_RECV).(time.Time)
+		}
+		if sess.GetAttribute(S_STATUS) != REPL_ONLINE || !ok {
+			lag = -1
+			return
+		}
+		if lag < 0 {
+			return
+		}
+		if sec := int64(time.Since(last).Seconds()); sec > lag {
+			lag = sec
+		}
+	})
+	return
+}
+
+// 从库复制延迟超过slave-max-lag时拒绝读请求
+func (server *GoRedisServer) checkSlaveMaxLag(cmdName string) *Reply {
+	if server.slaveMaxLag <= 0 || server.slavemgr.Len() == 0 || needSync(cmdName) {
+		return nil
+	}
+	switch commandCategory(cmdName) {
+	case CCateKey, CCateString, CCateHash, CCateList, CCateSet, CCateSortedSet:
+	default:
+		return nil
+	}
+	lag := server.slaveLag()
+	if lag < 0 {
+		return ErrorReply("MAXLAG replication not online")
+	}
+	if lag > server.slaveMaxLag {
+		return ErrorReply("MAXLAG replication lag " + strconv.FormatInt(lag, 10) + "s exceeds " + slaveMaxLagKey)
+	}
+	return nil
+}
+
+func (server *GoRedisServer) initReplicaReadOnly() {
+	server.replicaReadOnly = server.config.StringForKey(replicaReadOnlyKey) != "no"
+}
+
+// 作为从库(包括等待重连主库)时拒绝写指令，主库同步连接和内部会话带有S_STATUS
+func (server *GoRedisServer) checkReplicaReadOnly(session *Session, cmdName string) *Reply {
+	if !server.replicaReadOnly || session.GetAttribute(S_STATUS) != nil {
+		return nil
+	}
+	if !needSync(cmdName) && !pauseWriteCmds[cmdName] {
+		return nil
+	}
+	if reconnecting, _ := server.masterLinkDown(); !server.isReplica() && len(reconnecting) == 0 {
+		return nil
+	}
+	return ErrorReply("READONLY You can't write against a read only replica.")
+}
@@ -0,0 +1,122 @@
+package goredis_server
+
+// 按时间点恢复，用于误操作的FLUSHALL/DEL
+// -restore dir：从BACKUP生成的备份启动，dbpath/db0必须不存在；sst文件硬链接，其它文件复制(rocksdb会追加MANIFEST，不能修改备份)
+// -restore-until unixtime：再回放logpath/appendonly.aof里备份之后、unixtime(含)之前的指令
+// 备份的backup.info记录同步日志的seq，AOF追加部分的#SEQ注释给出指令的seq，从seq之后开始回放，#TS超过unixtime时停止
+// 回放前AOF改名为appendonly.aof.pitr_unixtime保留，之后开启AOF不会覆盖
+import (
+	"GoRedis/libs/stdlog"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupInfoFile = "backup.info"
+
+func writeBackupInfo(dir string, seq int64) error {
+	info := fmt.Sprintf("seq:%d\ntime:%d\n", seq, time.Now().Unix())
+	return ioutil.WriteFile(filepath.Join(dir, backupInfoFile), []byte(info), 0644)
+}
+
+// 备份时同步日志的seq，没有开启同步日志时为-1
+func readBackupSeq(dir string) (seq int64, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, backupInfoFile))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "seq:") {
+			return strconv.ParseInt(line[4:], 10, 64)
+		}
+	}
+	return 0, errors.New("no seq in " + backupInfoFile)
+}
+
+// 把备份放到dbpath/db0，需要在initLevelDB之前调用
+func (server *GoRedisServer) initRestore() (err error) {
+	dir := server.opt.Restore()
+	if len(dir) == 0 {
+		return
+	}
+	dbhome := server.opt.DBPath() + "/db0"
+	if _, err = os.Stat(dbhome); err == nil {
+		return errors.New(dbhome + " already exists, move it away before restore")
+	}
+	begin := time.Now()
+	if err = copyBackup(filepath.Join(dir, "db0"), dbhome); err != nil {
+		os.RemoveAll(dbhome)
+		return
+	}
+	stdlog.Printf("restore %s, %s\n", dir, time.Since(begin))
+	return
+}
+
+func copyBackup(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	for _, fi := range files {
+		from, to := filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())
+		// 不在同一个文件系统时复制
+		if strings.HasSuffix(fi.Name(), ".sst") && os.Link(from, to) == nil {
+			continue
+		}
+		if err = copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	r, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// 回放AOF到restore-until，需要在initSyncLog、initDatabases之后，initAppendOnly之前调用
+func (server *GoRedisServer) initRestoreReplay() (err error) {
+	until := server.opt.RestoreUntil()
+	if until <= 0 {
+		return
+	}
+	if len(server.opt.Restore()) == 0 {
+		return errors.New("restore-until requires restore")
+	}
+	seq, err := readBackupSeq(server.opt.Restore())
+	if err != nil {
+		return
+	}
+	if seq < 0 {
+		return errors.New("backup was taken without synclog, enable aof before backup")
+	}
+	filename := server.opt.LogPath() + "/" + aofFilename
+	pitrname := fmt.Sprintf("%s.pitr_%d", filename, time.Now().Unix())
+	if err = os.Rename(filename, pitrname); err != nil {
+		return
+	}
+	stdlog.Printf("restore replay %s after seq %d until %s\n", pitrname, seq, time.Unix(until, 0))
+	return server.replayAOF(pitrname, seq, until)
+}
@@ -0,0 +1,203 @@
+package goredis_server
+
+// SAVE/BGSAVE [SCHEDULE]、LASTSAVE
+// 从快照写出redis可以加载的RDB文件logpath/dump.rdb，先写入临时文件，完成后改名
+// SAVE只阻塞当前连接；同一时间只有一个保存，其余返回错误
+// 只包含string(bitmap按string)/hash/list/set/zset，blob和doc跳过
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/stdlog"
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rdbFilename = "dump.rdb"
+
+var SaveInProgressError = errors.New("Background save already in progress")
+
+type rdbSaveState struct {
+	mu      sync.Mutex
+	running bool
+	start   time.Time     // 正在进行的保存开始的时间
+	last    time.Time     // 最后一次成功保存的时间
+	status  string        // 最后一次保存的结果，ok/err
+	elapsed time.Duration // 最后一次保存的耗时
+}
+
+func (server *GoRedisServer) OnSAVE(cmd *Command) (reply *Reply) {
+	if !server.beginSave() {
+		return ErrorReply(SaveInProgressError)
+	}
+	if err := server.saveRDB(); err != nil {
+		return ErrorReply(err)
+	}
+	return StatusReply("OK")
+}
+
+func (server *GoRedisServer) OnBGSAVE(cmd *Command) (reply *Reply) {
+	if cmd.Len() > 1 && strings.ToUpper(cmd.StringAtIndex(1)) != "SCHEDULE" {
+		return ErrorReply("syntax error")
+	}
+	if !server.beginSave() {
+		return ErrorReply(SaveInProgressError)
+	}
+	go server.saveRDB()
+	return StatusReply("Background saving started")
+}
+
+// 最后一次成功保存的unix时间，没有保存过时为启动时间
+func (server *GoRedisServer) OnLASTSAVE(cmd *Command) (reply *Reply) {
+	s := &server.rdbSave
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last.IsZero() {
+		return IntegerReply(int(server.info.uptime.Unix()))
+	}
+	return IntegerReply(int(s.last.Unix()))
+}
+
+// INFO persistence里与redis相同的rdb_*字段
+func (server *GoRedisServer) rdbSaveInfo() string {
+	s := &server.rdbSave
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inProgress, current, lastsec, status := 0, int64(-1), int64(-1), s.status
+	if s.running {
+		inProgress, current = 1, int64(time.Since(s.start).Seconds())
+	}
+	if len(status) == 0 {
+		status = "ok"
+	} else {
+		lastsec = int64(s.elapsed.Seconds())
+	}
+	last := s.last
+	if last.IsZero() {
+		last = server.info.uptime
+	}
+	return fmt.Sprintf("rdb_bgsave_in_progress:%d\nrdb_last_save_time:%d\nrdb_last_bgsave_status:%s\nrdb_last_bgsave_time_sec:%d\nrdb_current_bgsave_time_sec:%d\n",
+		inProgress, last.Unix(), status, lastsec, current)
+}
+
+func (server *GoRedisServer) beginSave() bool {
+	s := &server.rdbSave
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return false
+	}
+	s.running, s.start = true, time.Now()
+	return true
+}
+
+// 调用前需要beginSave
+func (server *GoRedisServer) saveRDB() (err error) {
+	s := &server.rdbSave
+	begin := time.Now()
+	keys := 0
+	defer func() {
+		s.mu.Lock()
+		s.running, s.elapsed, s.status = false, time.Since(begin), "ok"
+		if err != nil {
+			s.status = "err"
+		} else {
+			s.last = time.Now()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			stdlog.Println("save rdb", err)
+		} else {
+			stdlog.Printf("save rdb %d keys, %s\n", keys, time.Since(begin))
+		}
+	}()
+
+	server.Suspend()
+	snap := server.levelRedis.Snapshot()
+	dbmap := append([]int{}, server.dbmap[:server.dbCount()]...)
+	server.Resume()
+	defer snap.Close()
+
+	filename := server.opt.LogPath() + "/" + rdbFilename
+	tmpname := filename + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(tmpname)
+		}
+	}()
+	bw := bufio.NewWriterSize(f, 1024*1024)
+	w := NewRDBWriter(bw)
+	w.WriteHeader()
+	for i, index := range dbmap {
+		db := snap.Database(index)
+		keys += server.saveDB(w, db, i)
+		if db != snap {
+			db.Close()
+		}
+		if w.Err() != nil {
+			break
+		}
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	if err = bw.Flush(); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	return os.Rename(tmpname, filename)
+}
+
+// 写入一个db，没有key时不写入SELECTDB，返回写入的key数
+func (server *GoRedisServer) saveDB(w *RDBWriter, snap *levelredis.LevelRedis, index int) (n int) {
+	now := nowMillis()
+	snap.KeyEnumerate([]byte(""), levelredis.IterForward, func(i int, key, keytype, value []byte, quit *bool) {
+		at := snap.ExpireAt(key)
+		if at != -1 && at <= now {
+			return
+		}
+		t := string(keytype)
+		switch t {
+		case levelredis.BLOB_SUFFIX, levelredis.DOC_SUFFIX, "none":
+			return
+		}
+		if n == 0 {
+			w.SelectDB(index)
+		}
+		n++
+		switch t {
+		case levelredis.STRING_SUFFIX:
+			if value, err := snap.Strings().Decode(key, value); err == nil {
+				w.AppendString(key, value, at)
+			} else {
+				stdlog.Println("decode string", string(key), err)
+				n--
+			}
+		case levelredis.BITMAP_SUFFIX:
+			w.AppendString(key, snap.GetBitmap(string(key)).Bytes(), at)
+		case levelredis.HASH_SUFFIX:
+			w.AppendHash(snap.GetHash(string(key)), at)
+		case levelredis.LIST_SUFFIX:
+			w.AppendList(snap.GetList(string(key)), at)
+		case levelredis.SET_SUFFIX:
+			w.AppendSet(snap.GetSet(string(key)), at)
+		case levelredis.ZSET_SUFFIX:
+			w.AppendZSet(snap.GetSortedSet(string(key)), at)
+		default:
+			n--
+		}
+		*quit = w.Err() != nil
+	})
+	return
+}
@@ -9,28 +9,23 @@ import (
 func (server *GoRedisServer) OnSADD(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	members := cmd.Args()[2:]
-	hash := server.levelRedis.GetSet(key)
-	// 使用leveldb的mset，无法得知新增key数量
-	fieldVals := make([][]byte, 0, len(members)*2)
-	for _, member := range members {
-		fieldVals = append(fieldVals, member, []byte(""))
-	}
-	n := hash.Set(fieldVals...)
+	set := server.db(cmd).GetSet(key)
+	n := set.Add(members...)
 	return IntegerReply(n)
 }
 
 func (server *GoRedisServer) OnSCARD(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetSet(key)
-	n := hash.Count()
+	set := server.db(cmd).GetSet(key)
+	n := set.Len()
 	return IntegerReply(n)
 }
 
 func (server *GoRedisServer) OnSISMEMBER(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	member, _ := cmd.ArgAtIndex(2)
-	hash := server.levelRedis.GetSet(key)
-	if hash.Exist(member) {
+	set := server.db(cmd).GetSet(key)
+	if set.IsMember(member) {
 		reply = IntegerReply(1)
 	} else {
 		reply = IntegerReply(0)
@@ -38,22 +33,109 @@ func (server *GoRedisServer) OnSISMEMBER(cmd *Command) (reply *Reply) {
 	return
 }
 
+// 元素数量已知，超过大集合阈值时直接拒绝，不再扫描
 func (server *GoRedisServer) OnSMEMBERS(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	hash := server.levelRedis.GetSet(key)
-	elems := hash.GetAll(1000)
-	keys := make([]interface{}, 0, len(elems))
-	for _, elem := range elems {
-		keys = append(keys, elem.Key)
+	set := server.db(cmd).GetSet(key)
+	if r := server.checkLargeCollection(cmd, int64(set.Len()), "SISMEMBER"); r != nil {
+		return r
+	}
+	// 超过阈值时上面已经返回LARGECOLL，其余情况(没有阈值、warn模式)返回全部member，不截断
+	members := set.Members(-1)
+	bulks := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		bulks = append(bulks, member)
 	}
-	reply = MultiBulksReply(keys)
+	reply = MultiBulksReply(bulks)
 	return
 }
 
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func (server *GoRedisServer) OnSSCAN(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	args, err := parseScanArgs(cmd, 2)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	set := server.db(cmd).GetSet(key)
+	elems := make([]interface{}, 0, args.count)
+	next := set.Scan(args.cursor, args.count, func(member []byte) {
+		if args.Match(member) {
+			elems = append(elems, member)
+		}
+	})
+	return scanReply(next, elems)
+}
+
 func (server *GoRedisServer) OnSREM(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	members := cmd.Args()[2:]
-	hash := server.levelRedis.GetSet(key)
-	n := hash.Remove(members...)
+	set := server.db(cmd).GetSet(key)
+	n := set.Remove(members...)
 	return IntegerReply(n)
 }
+
+// SPOP key [count]
+// 随机删除并返回member，同步到从库时改写为SREM，从库删除相同的member
+func (server *GoRedisServer) OnSPOP(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	set := server.db(cmd).GetSet(key)
+	count := 1
+	if cmd.Len() > 2 {
+		var err error
+		if count, err = cmd.IntAtIndex(2); err != nil || count < 0 {
+			return ErrorReply("value is out of range, must be positive")
+		}
+		if r := server.checkLargeCollection(cmd, int64(count), "SPOP with smaller count"); r != nil {
+			return r
+		}
+	}
+	members := set.Pop(count)
+	if len(members) > 0 {
+		args := make([][]byte, 0, len(members)+2)
+		args = append(args, []byte("SREM"), []byte(key))
+		cmd.SetAttribute(C_SYNC_AS, NewCommand(append(args, members...)...))
+	}
+	if cmd.Len() == 2 {
+		if len(members) == 0 {
+			return BulkReply(nil)
+		}
+		return BulkReply(members[0])
+	}
+	bulks := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		bulks = append(bulks, member)
+	}
+	return MultiBulksReply(bulks)
+}
+
+// SRANDMEMBER key [count]
+// count为负数时允许重复，没有count时返回单个member
+func (server *GoRedisServer) OnSRANDMEMBER(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	set := server.db(cmd).GetSet(key)
+	if cmd.Len() == 2 {
+		members := set.RandMember(1, true)
+		if len(members) == 0 {
+			return BulkReply(nil)
+		}
+		return BulkReply(members[0])
+	}
+	count, err := cmd.IntAtIndex(2)
+	if err != nil {
+		return ErrorReply("value is not an integer or out of range")
+	}
+	unique := count >= 0
+	if count < 0 {
+		count = -count
+	}
+	if r := server.checkLargeCollection(cmd, int64(count), "SRANDMEMBER with smaller count"); r != nil {
+		return r
+	}
+	members := set.RandMember(count, unique)
+	bulks := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		bulks = append(bulks, member)
+	}
+	return MultiBulksReply(bulks)
+}
@@ -0,0 +1,86 @@
+package goredis_server
+
+// SINTER/SUNION/SDIFF key [key ...]
+// SINTERSTORE/SUNIONSTORE/SDIFFSTORE destination key [key ...]
+// 从快照读取输入的set，归并多个有序的迭代器得到结果，不在内存中汇总；
+// STORE结果每setStoreChunk个元素写入一次destination，执行期间其他客户端可能读到不完整的destination
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+)
+
+const setStoreChunk = 1000
+
+func (server *GoRedisServer) OnSINTER(cmd *Command) (reply *Reply) {
+	return server.setOp(cmd, levelredis.SetInter)
+}
+
+func (server *GoRedisServer) OnSUNION(cmd *Command) (reply *Reply) {
+	return server.setOp(cmd, levelredis.SetUnion)
+}
+
+func (server *GoRedisServer) OnSDIFF(cmd *Command) (reply *Reply) {
+	return server.setOp(cmd, levelredis.SetDiff)
+}
+
+func (server *GoRedisServer) OnSINTERSTORE(cmd *Command) (reply *Reply) {
+	return server.setStore(cmd, levelredis.SetInter)
+}
+
+func (server *GoRedisServer) OnSUNIONSTORE(cmd *Command) (reply *Reply) {
+	return server.setStore(cmd, levelredis.SetUnion)
+}
+
+func (server *GoRedisServer) OnSDIFFSTORE(cmd *Command) (reply *Reply) {
+	return server.setStore(cmd, levelredis.SetDiff)
+}
+
+func snapshotSets(snap *levelredis.LevelRedis, keys [][]byte) (sets []*levelredis.LevelSet) {
+	sets = make([]*levelredis.LevelSet, len(keys))
+	for i, key := range keys {
+		sets[i] = snap.GetSet(string(key))
+	}
+	return
+}
+
+// 结果数量超过大集合阈值时停止归并并拒绝
+func (server *GoRedisServer) setOp(cmd *Command, op levelredis.SetOp) (reply *Reply) {
+	snap := server.db(cmd).Snapshot()
+	defer snap.Close()
+	sets := snapshotSets(snap, cmd.Args()[1:])
+
+	bulks := make([]interface{}, 0, 10)
+	levelredis.MergeSets(op, sets, func(member []byte) bool {
+		bulks = append(bulks, member)
+		return server.largeThreshold <= 0 || int64(len(bulks)) <= server.largeThreshold
+	})
+	if r := server.checkLargeCollection(cmd, int64(len(bulks)), cmd.Name()+"STORE"); r != nil {
+		return r
+	}
+	return MultiBulksReply(bulks)
+}
+
+func (server *GoRedisServer) setStore(cmd *Command, op levelredis.SetOp) (reply *Reply) {
+	db := server.db(cmd)
+	snap := db.Snapshot()
+	defer snap.Close()
+	sets := snapshotSets(snap, cmd.Args()[2:])
+
+	destkey, _ := cmd.ArgAtIndex(1)
+	db.Delete(destkey)
+	dest := db.GetSet(string(destkey))
+
+	chunk := make([][]byte, 0, setStoreChunk)
+	levelredis.MergeSets(op, sets, func(member []byte) bool {
+		chunk = append(chunk, member)
+		if len(chunk) >= setStoreChunk {
+			dest.Add(chunk...)
+			chunk = chunk[:0]
+		}
+		return true
+	})
+	if len(chunk) > 0 {
+		dest.Add(chunk...)
+	}
+	return IntegerReply(dest.Len())
+}
@@ -7,6 +7,13 @@ import (
 	"net"
 	"runtime/debug"
 	"strings"
+	"time"
+)
+
+const (
+	replReconnectInterval    = time.Second // 第一次重连的间隔，之后每次加倍
+	replReconnectMaxInterval = time.Minute
+	replTimeout              = time.Minute * 2 // 在线同步时超过这个时间没有收到主库的数据(包括PING)视为断线
 )
 
 // 从主库获取数据
@@ -56,30 +63,177 @@ func (server *GoRedisServer) OnSLAVEOF(session *Session, cmd *Command) (reply *R
 		}
 	}
 
+	// 手动执行的SLAVEOF重新开始计算断线时间
+	server.replMu.Lock()
+	if session != nil {
+		server.linkDownSince, server.reconnectBackoff = time.Time{}, 0
+	}
+	gen := server.slaveofGen
+	server.replMu.Unlock()
+
 	// async
 	go func() {
 		client.Session().SetAttribute(S_STATUS, REPL_WAIT)
 		server.slavemgr.Put(remoteHost, client)
+		stop := make(chan bool)
+		go server.superviseMasterLink(client, stop)
 		err := client.Sync()
+		close(stop)
 		if err != nil {
 			slavelog.Printf("[M %s] sync broken %s\n", remoteHost, err)
 		}
+		// 进入过在线同步才重置重连间隔，主库拒绝同步时不会变成每秒重连
+		if client.Session().GetAttribute(S_STATUS) == REPL_ONLINE {
+			server.replMu.Lock()
+			server.linkDownSince, server.reconnectBackoff = time.Now(), 0
+			server.replMu.Unlock()
+		}
 		client.Close()
 		server.slavemgr.Remove(remoteHost)
+		server.reconnectMaster(arg1, arg2, gen)
 	}()
 
+	// 主从切换完成，解除CLIENT PAUSE
+	server.clientPause.Unpause()
 	return StatusReply("OK")
 }
 
+// REPLICAOF host port | NO ONE，与SLAVEOF相同
+func (server *GoRedisServer) OnREPLICAOF(session *Session, cmd *Command) (reply *Reply) {
+	return server.OnSLAVEOF(session, cmd)
+}
+
 // SLAVEOF NO ONE will stop replication
+// 提升为主库：断开主库、取消重连，并记录提升时的同步日志seq，见promotedDiverged
 func (server *GoRedisServer) onSlaveOfNoOne(session *Session, cmd *Command) (reply *Reply) {
 	slavelog.Printf("SLAVEOF NO ONE, will disconnect %d connection(s)\n", server.slavemgr.Len())
 	reply = StatusReply(fmt.Sprintf("disconnect %d connections(s)", server.slavemgr.Len()))
+	server.replMu.Lock()
+	server.slaveofGen++ // 取消等待中的重连
+	reconnecting := server.reconnecting
+	server.reconnecting = ""
+	server.linkDownSince, server.reconnectBackoff = time.Time{}, 0
+	server.replMu.Unlock()
 
+	masters := []string{}
 	server.slavemgr.Enumerate(func(i int, key string, val interface{}) {
 		client := val.(ISlaveClient)
 		client.Close()
 		server.slavemgr.Remove(key)
+		masters = append(masters, key)
 	})
+	// 断线重连中的主库，配置里的key使用ip:port
+	if addr, err := net.ResolveTCPAddr("tcp", reconnecting); len(reconnecting) > 0 && err == nil {
+		masters = append(masters, addr.String())
+	}
+	seq := int64(-1)
+	if server.synclog.IsEnabled() {
+		seq = server.synclog.MaxSeq()
+	}
+	for _, host := range masters {
+		server.config.SetInt(promotedKey(host), seq)
+	}
+	if len(masters) > 0 {
+		slavelog.Printf("promoted to master at seq %d, former master(s) %s\n", seq, masters)
+	}
+	server.clientPause.Unpause()
 	return
 }
+
+// 提升为主库时的同步日志seq，没有开启同步日志时为-1
+func promotedKey(host string) string {
+	return "master:" + host + ":promoted"
+}
+
+// 提升为主库之后是否有过写入，重新作为host的从库时不能从保存的seq继续
+// 没有开启同步日志时无法判断，按有写入处理
+func (server *GoRedisServer) promotedDiverged(host string) (promoted bool, diverged bool) {
+	seq := server.config.IntForKey(promotedKey(host), -2)
+	if seq == -2 {
+		return false, false
+	}
+	return true, seq < 0 || !server.synclog.IsEnabled() || server.synclog.MaxSeq() != seq
+}
+
+// 在线同步期间超过replTimeout没有收到主库的数据时关闭连接，Sync返回后由reconnectMaster重连
+// 对方主机掉电或网络中断时TCP连接不会报错，只能这样发现
+func (server *GoRedisServer) superviseMasterLink(client ISlaveClient, stop chan bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		sess := client.Session()
+		last, ok := sess.GetAttribute(S_LAST_RECV).(time.Time)
+		if sess.GetAttribute(S_STATUS) == REPL_ONLINE && ok && time.Since(last) > replTimeout {
+			slavelog.Printf("[M %s] no data from master for %s, closing\n", sess.RemoteAddr(), time.Since(last))
+			client.Close()
+			return
+		}
+	}
+}
+
+// 同步断开(主库关闭、网络中断、超时)后重连，间隔从replReconnectInterval开始加倍，最长replReconnectMaxInterval
+// 一直重试到成功，期间执行SLAVEOF NO ONE或者关闭服务则取消；gen为建立这条连接时的slaveofGen
+// 连上后同步在新的goroutine里进行，没有进入在线同步就断开时间隔继续加倍
+// GoRedis主库从保存的seq继续增量同步，redis主库重新全量同步
+func (server *GoRedisServer) reconnectMaster(host, port string, gen int) {
+	server.replMu.Lock()
+	if server.closing || server.slaveofGen != gen {
+		server.replMu.Unlock()
+		return
+	}
+	server.reconnecting = net.JoinHostPort(host, port)
+	if server.linkDownSince.IsZero() {
+		server.linkDownSince = time.Now()
+	}
+	server.replMu.Unlock()
+	defer func() {
+		server.replMu.Lock()
+		// 期间SLAVEOF NO ONE已经清空
+		if server.slaveofGen == gen {
+			server.reconnecting = ""
+		}
+		server.replMu.Unlock()
+	}()
+	for {
+		time.Sleep(server.nextReconnectBackoff())
+		if server.closing || server.replCanceled(gen) {
+			return
+		}
+		reply := server.OnSLAVEOF(nil, NewCommand(formatByteSlice("SLAVEOF", host, port)...))
+		if reply != nil && reply.Type != ReplyTypeError {
+			slavelog.Printf("[M %s:%s] reconnected\n", host, port)
+			return
+		}
+		slavelog.Printf("[M %s:%s] reconnect failed (%s)\n", host, port, reply)
+	}
+}
+
+// 下一次重连前的等待时间
+func (server *GoRedisServer) nextReconnectBackoff() time.Duration {
+	server.replMu.Lock()
+	defer server.replMu.Unlock()
+	if server.reconnectBackoff *= 2; server.reconnectBackoff == 0 {
+		server.reconnectBackoff = replReconnectInterval
+	} else if server.reconnectBackoff > replReconnectMaxInterval {
+		server.reconnectBackoff = replReconnectMaxInterval
+	}
+	return server.reconnectBackoff
+}
+
+func (server *GoRedisServer) replCanceled(gen int) bool {
+	server.replMu.Lock()
+	defer server.replMu.Unlock()
+	return server.slaveofGen != gen
+}
+
+// 等待重连的主库和断开的时间
+func (server *GoRedisServer) masterLinkDown() (reconnecting string, since time.Time) {
+	server.replMu.Lock()
+	defer server.replMu.Unlock()
+	return server.reconnecting, server.linkDownSince
+}
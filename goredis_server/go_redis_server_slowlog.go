@@ -0,0 +1,139 @@
+package goredis_server
+
+// 慢查询和指令统计的持久化，用于长期的性能分析
+// config set slowlog-persist yes，慢查询写入系统前缀，重启后可通过SLOWLOG GET查看；no(默认)时只保存在内存
+// config set slowlog-max-len 1000，保留条数
+// config set stats-persist yes，每10秒保存一次指令计数，重启后继续累加
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/counter"
+	"GoRedis/libs/levelredis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slowlogPersistKey = "slowlog-persist"
+	slowlogMaxLenKey  = "slowlog-max-len"
+	statsPersistKey   = "stats-persist"
+)
+
+var statsSaveInterval = time.Second * 10
+
+func (server *GoRedisServer) initSlowLogStore() {
+	maxlen := server.config.IntForKey(slowlogMaxLenKey, 1000)
+	if server.slowlogStore == nil {
+		server.slowlogStore = NewSlowLog(server.levelRedis, PREFIX+"slowlog:", maxlen)
+	} else {
+		server.slowlogStore.SetMaxLen(maxlen)
+	}
+	server.slowlogStore.SetPersist(server.config.StringForKey(slowlogPersistKey) == "yes")
+}
+
+func (server *GoRedisServer) writeSlowLog(cmd *Command, elapsed time.Duration) {
+	args := make([]string, cmd.Len())
+	for i, arg := range cmd.Args() {
+		args[i] = string(arg)
+	}
+	server.slowlogStore.Write(&SlowLogEntry{
+		Time:    time.Now().Unix(),
+		Elapsed: int64(elapsed / time.Microsecond),
+		Addr:    cmd.GetAttribute(C_SESSION).(*Session).RemoteAddr().String(),
+		Args:    args,
+	})
+}
+
+// SLOWLOG GET [count] / LEN / RESET
+func (server *GoRedisServer) OnSLOWLOG(cmd *Command) (reply *Reply) {
+	switch strings.ToUpper(cmd.StringAtIndex(1)) {
+	case "GET":
+		count := 10
+		if cmd.Len() > 2 {
+			var err error
+			if count, err = cmd.IntAtIndex(2); err != nil || count < 0 {
+				return ErrorReply("bad count")
+			}
+		}
+		entries := server.slowlogStore.Get(count)
+		bulks := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			args := make([]interface{}, len(e.Args))
+			for i, arg := range e.Args {
+				args[i] = arg
+			}
+			bulks = append(bulks, []interface{}{int(e.Id), int(e.Time), int(e.Elapsed), args, e.Addr, ""})
+		}
+		reply = MultiBulksReply(bulks)
+	case "LEN":
+		reply = IntegerReply(int(server.slowlogStore.Len()))
+	case "RESET":
+		server.slowlogStore.Reset()
+		reply = StatusReply("OK")
+	default:
+		reply = ErrorReply("slowlog [get/len/reset]")
+	}
+	return
+}
+
+func statsKeyPrefix(group string) []byte {
+	return []byte(PREFIX + "stats:" + group + ":")
+}
+
+// 启动时读取上次保存的指令计数，然后定时保存
+func (server *GoRedisServer) initCommandStats() {
+	if server.config.StringForKey(statsPersistKey) == "yes" {
+		server.loadCounters("cmd", server.cmdCounters)
+		server.loadCounters("cate", server.cmdCateCounters)
+	}
+	server.statsStop, server.statsDone = make(chan bool), make(chan bool)
+	go func() {
+		defer close(server.statsDone)
+		ticker := time.NewTicker(statsSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-server.statsStop:
+				return
+			case <-ticker.C:
+				server.saveCommandStats()
+			}
+		}
+	}()
+}
+
+// Close()关闭数据库之前调用，等待正在进行的保存结束，然后保存最后一次
+func (server *GoRedisServer) closeCommandStats() {
+	if server.statsStop != nil {
+		close(server.statsStop)
+		<-server.statsDone
+	}
+	server.saveCommandStats()
+}
+
+func (server *GoRedisServer) saveCommandStats() {
+	if server.config.StringForKey(statsPersistKey) != "yes" {
+		return
+	}
+	server.saveCounters("cmd", server.cmdCounters)
+	server.saveCounters("cate", server.cmdCateCounters)
+}
+
+func (server *GoRedisServer) loadCounters(group string, counters *counter.Counters) {
+	prefix := statsKeyPrefix(group)
+	server.levelRedis.PrefixEnumerate(prefix, levelredis.IterForward, func(i int, key, value []byte, quit *bool) {
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return
+		}
+		counters.Get(string(key[len(prefix):])).Incr(n)
+	})
+}
+
+func (server *GoRedisServer) saveCounters(group string, counters *counter.Counters) {
+	prefix := string(statsKeyPrefix(group))
+	for _, name := range counters.Names() {
+		n := counters.Get(name).Count()
+		server.levelRedis.RawSet([]byte(prefix+name), []byte(strconv.FormatInt(n, 10)))
+	}
+}
@@ -4,35 +4,65 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"errors"
+	"math"
 	"strconv"
+	"sync"
 )
 
 var maxCmdLock = 100
 
+// 与redis的proto-max-bulk-len一致
+const maxStringLength = 512 * 1024 * 1024
+
 func (server *GoRedisServer) OnGET(cmd *Command) (reply *Reply) {
 	key, _ := cmd.ArgAtIndex(1)
-	value := server.levelRedis.Strings().Get(key)
+	value, err := server.getString(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
 	return BulkReply(value)
 }
 
+// SETBIT创建的bitmap也作为string返回
+func (server *GoRedisServer) getString(db *levelredis.LevelRedis, key []byte) (value []byte, err error) {
+	if value, err = db.Strings().Get(key); err == nil && value == nil {
+		if bm := db.ExistingBitmap(string(key)); bm != nil {
+			value = bm.Bytes()
+		}
+	}
+	return
+}
+
 func (server *GoRedisServer) OnSET(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
 	key, _ := cmd.ArgAtIndex(1)
 	val, _ := cmd.ArgAtIndex(2)
-	server.levelRedis.Strings().Set(key, val)
+	// SET覆盖原来的过期时间，先清除再写入
+	db.Persist(key)
+	server.overwriteKey(db, key)
+	db.Strings().Set(key, val)
 	return StatusReply("OK")
 }
 
 func (server *GoRedisServer) OnMGET(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
 	keys := cmd.Args()[1:]
 	vals := make([]interface{}, len(keys))
 	for i, key := range keys {
-		vals[i] = server.levelRedis.Strings().Get(key)
+		value, err := server.getString(db, key)
+		if err != nil {
+			return ErrorReply(err)
+		}
+		vals[i] = value
 	}
 	reply = MultiBulksReply(vals)
 	return
 }
 
 func (server *GoRedisServer) OnMSET(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
 	keyvals := cmd.Args()[1:]
 	if len(keyvals)%2 != 0 {
 		return ErrorReply(WrongArgumentCount)
@@ -40,89 +70,287 @@ func (server *GoRedisServer) OnMSET(cmd *Command) (reply *Reply) {
 	for i, count := 0, len(keyvals); i < count; i += 2 {
 		key := keyvals[i]
 		val := keyvals[i+1]
-		server.levelRedis.Strings().Set(key, val)
+		db.Persist(key)
+		server.overwriteKey(db, key)
+		db.Strings().Set(key, val)
 	}
 	return StatusReply("OK")
 }
 
-/**
- * 计数器基于字符串，对字符串进行修改
- * TODO 性能需要改进
- * @param chg 增减量，正负数均可
- */
-func (server *GoRedisServer) incrStringKey(key []byte, chg int) (newvalue int, err error) {
-	// 对操作的key进行hash后，有序并发处理
-	hash := inthash(key, maxCmdLock)
-	mu := mutexof("cmd_lock_" + strconv.Itoa(hash))
+var (
+	NotIntegerError = errors.New("value is not an integer or out of range")
+	NotFloatError   = errors.New("value is not a valid float")
+)
+
+// 计数器的当前值，SETBIT创建的bitmap也按字符串解析
+func (server *GoRedisServer) counterValue(db *levelredis.LevelRedis, key []byte) (value []byte, bm *levelredis.LevelBitmap, err error) {
+	if value, err = db.Strings().Get(key); err == nil && value == nil {
+		if bm = db.ExistingBitmap(string(key)); bm != nil {
+			value = bm.Bytes()
+		}
+	}
+	return
+}
+
+// 以string保存新值，原来是bitmap时一并删除
+func (server *GoRedisServer) setCounter(db *levelredis.LevelRedis, key, value []byte, bm *levelredis.LevelBitmap) error {
+	if err := db.Strings().Set(key, value); err != nil {
+		return err
+	}
+	if bm != nil {
+		bm.Drop()
+	}
+	return nil
+}
+
+// 计数器基于字符串，读-改-写在stringKeyLock内完成，chg为增减量，正负数均可
+func (server *GoRedisServer) incrStringKey(db *levelredis.LevelRedis, key []byte, chg int64) (newvalue int64, err error) {
+	mu := stringKeyLock(key)
 	mu.Lock()
 	defer mu.Unlock()
 
-	value := server.levelRedis.Strings().Get(key)
-	var oldvalue int
-	if value == nil {
-		oldvalue = 0
-	} else {
-		oldvalue, err = strconv.Atoi(string(value))
-		if err != nil {
-			return
+	value, bm, err := server.counterValue(db, key)
+	if err != nil {
+		return
+	}
+	var oldvalue int64
+	if value != nil {
+		if oldvalue, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, NotIntegerError
 		}
 	}
-	// update
+	if (chg > 0 && oldvalue > math.MaxInt64-chg) || (chg < 0 && oldvalue < math.MinInt64-chg) {
+		return 0, levelredis.IncrOverflowError
+	}
 	newvalue = oldvalue + chg
-	err = server.levelRedis.Strings().Set(key, []byte(strconv.Itoa(newvalue)))
+	err = server.setCounter(db, key, []byte(strconv.FormatInt(newvalue, 10)), bm)
 	return
 }
 
-func (server *GoRedisServer) OnINCR(cmd *Command) (reply *Reply) {
-	key, _ := cmd.ArgAtIndex(1)
-	newvalue, err := server.incrStringKey(key, 1)
+// 与HINCRBYFLOAT一样保存最短的十进制表示
+func (server *GoRedisServer) incrFloatStringKey(db *levelredis.LevelRedis, key []byte, chg float64) (newvalue []byte, err error) {
+	mu := stringKeyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	value, bm, err := server.counterValue(db, key)
 	if err != nil {
-		reply = ErrorReply(err)
-	} else {
-		reply = IntegerReply(newvalue)
+		return
+	}
+	f := float64(0)
+	if value != nil {
+		if f, err = strconv.ParseFloat(string(value), 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, NotFloatError
+		}
+	}
+	f += chg
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, levelredis.IncrNaNError
 	}
+	newvalue = []byte(strconv.FormatFloat(f, 'f', -1, 64))
+	err = server.setCounter(db, key, newvalue, bm)
 	return
 }
 
+func (server *GoRedisServer) incrReply(db *levelredis.LevelRedis, key []byte, chg int64) *Reply {
+	newvalue, err := server.incrStringKey(db, key, chg)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(int(newvalue))
+}
+
+func (server *GoRedisServer) OnINCR(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	return server.incrReply(server.db(cmd), key, 1)
+}
+
 func (server *GoRedisServer) OnINCRBY(cmd *Command) (reply *Reply) {
 	key, _ := cmd.ArgAtIndex(1)
-	chg, e1 := strconv.Atoi(cmd.StringAtIndex(2))
-	if e1 != nil {
-		reply = ErrorReply(e1)
-		return
-	}
-	newvalue, err := server.incrStringKey(key, chg)
+	chg, err := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
 	if err != nil {
-		reply = ErrorReply(err)
-	} else {
-		reply = IntegerReply(newvalue)
+		return ErrorReply(NotIntegerError)
 	}
-	return
+	return server.incrReply(server.db(cmd), key, chg)
 }
 
 func (server *GoRedisServer) OnDECR(cmd *Command) (reply *Reply) {
 	key, _ := cmd.ArgAtIndex(1)
-	newvalue, err := server.incrStringKey(key, -1)
+	return server.incrReply(server.db(cmd), key, -1)
+}
+
+func (server *GoRedisServer) OnDECRBY(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	chg, err := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
 	if err != nil {
-		reply = ErrorReply(err)
-	} else {
-		reply = IntegerReply(newvalue)
+		return ErrorReply(NotIntegerError)
 	}
-	return
+	if chg == math.MinInt64 {
+		return ErrorReply("decrement would overflow")
+	}
+	return server.incrReply(server.db(cmd), key, -chg)
 }
 
-func (server *GoRedisServer) OnDECRBY(cmd *Command) (reply *Reply) {
+// INCRBYFLOAT key increment
+func (server *GoRedisServer) OnINCRBYFLOAT(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	chg, err := strconv.ParseFloat(cmd.StringAtIndex(2), 64)
+	if err != nil || math.IsNaN(chg) || math.IsInf(chg, 0) {
+		return ErrorReply(NotFloatError)
+	}
+	newvalue, err := server.incrFloatStringKey(server.db(cmd), key, chg)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return BulkReply(newvalue)
+}
+
+// INCRLIMIT key increment limit
+// 增加后不超过limit时执行，返回[新值, 0]；否则不修改，返回[当前值, 1]
+// 用于配额计数，避免GET+INCR的竞争
+func (server *GoRedisServer) OnINCRLIMIT(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
 	key, _ := cmd.ArgAtIndex(1)
 	chg, e1 := strconv.Atoi(cmd.StringAtIndex(2))
-	if e1 != nil {
-		reply = ErrorReply(e1)
-		return
+	limit, e2 := strconv.Atoi(cmd.StringAtIndex(3))
+	if e1 != nil || e2 != nil {
+		return ErrorReply(NotIntegerError)
 	}
-	newvalue, err := server.incrStringKey(key, chg*-1)
+
+	mu := stringKeyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	value, err := db.Strings().Get(key)
 	if err != nil {
-		reply = ErrorReply(err)
-	} else {
-		reply = IntegerReply(newvalue)
+		return ErrorReply(err)
 	}
-	return
+	oldvalue := 0
+	if value != nil {
+		if oldvalue, err = strconv.Atoi(string(value)); err != nil {
+			return ErrorReply(NotIntegerError)
+		}
+	}
+	if oldvalue+chg > limit {
+		return MultiBulksReply([]interface{}{oldvalue, 1})
+	}
+	newvalue := oldvalue + chg
+	if err := db.Strings().Set(key, []byte(strconv.Itoa(newvalue))); err != nil {
+		return ErrorReply(err)
+	}
+	return MultiBulksReply([]interface{}{newvalue, 0})
+}
+
+// 读-改-写的字符串指令共用按key hash的锁
+func stringKeyLock(key []byte) *sync.Mutex {
+	return mutexof("cmd_lock_" + strconv.Itoa(inthash(key, maxCmdLock)))
+}
+
+// APPEND key value
+func (server *GoRedisServer) OnAPPEND(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	appended, _ := cmd.ArgAtIndex(2)
+	mu := stringKeyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if bm := db.ExistingBitmap(string(key)); bm != nil {
+		if bm.Len()+int64(len(appended)) > maxStringLength {
+			return ErrorReply("string exceeds maximum allowed size (512MB)")
+		}
+		if err := bm.SetRange(bm.Len(), appended); err != nil {
+			return ErrorReply(err)
+		}
+		return IntegerReply(int(bm.Len()))
+	}
+	value, err := db.Strings().Get(key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if len(value)+len(appended) > maxStringLength {
+		return ErrorReply("string exceeds maximum allowed size (512MB)")
+	}
+	newvalue := make([]byte, 0, len(value)+len(appended))
+	newvalue = append(append(newvalue, value...), appended...)
+	if err := db.Strings().Set(key, newvalue); err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(len(newvalue))
+}
+
+func (server *GoRedisServer) OnSTRLEN(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	src, err := bitSourceOf(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(int(src.Len()))
+}
+
+// GETRANGE key start end
+// 负数表示从末尾倒数，超出范围的部分被截断
+func (server *GoRedisServer) OnGETRANGE(cmd *Command) (reply *Reply) {
+	key, _ := cmd.ArgAtIndex(1)
+	start, e1 := strconv.ParseInt(cmd.StringAtIndex(2), 10, 64)
+	end, e2 := strconv.ParseInt(cmd.StringAtIndex(3), 10, 64)
+	if e1 != nil || e2 != nil {
+		return ErrorReply("value is not an integer or out of range")
+	}
+	src, err := bitSourceOf(server.db(cmd), key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	start, end = normalizeRange(start, end, src.Len())
+	value := make([]byte, 0)
+	src.Range(start, end, func(b []byte) bool {
+		value = append(value, b...)
+		return true
+	})
+	return BulkReply(value)
+}
+
+// SETRANGE key offset value
+// offset超过当前长度时中间用0x00填充；value为空时不修改，key不存在时也不创建
+func (server *GoRedisServer) OnSETRANGE(cmd *Command) (reply *Reply) {
+	db := server.db(cmd)
+	key, _ := cmd.ArgAtIndex(1)
+	offset, err := strconv.Atoi(cmd.StringAtIndex(2))
+	if err != nil || offset < 0 {
+		return ErrorReply("offset is out of range")
+	}
+	part, _ := cmd.ArgAtIndex(3)
+	if offset+len(part) > maxStringLength {
+		return ErrorReply("string exceeds maximum allowed size (512MB)")
+	}
+	mu := stringKeyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if bm := db.ExistingBitmap(string(key)); bm != nil {
+		if len(part) > 0 {
+			if err := bm.SetRange(int64(offset), part); err != nil {
+				return ErrorReply(err)
+			}
+		}
+		return IntegerReply(int(bm.Len()))
+	}
+	value, err := db.Strings().Get(key)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	if len(part) == 0 {
+		return IntegerReply(len(value))
+	}
+	length := len(value)
+	if offset+len(part) > length {
+		length = offset + len(part)
+	}
+	newvalue := make([]byte, length)
+	copy(newvalue, value)
+	copy(newvalue[offset:], part)
+	if err := db.Strings().Set(key, newvalue); err != nil {
+		return ErrorReply(err)
+	}
+	return IntegerReply(len(newvalue))
 }
@@ -7,10 +7,17 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"time"
 )
 
+const (
+	replShutdownTimeout    = time.Second * 10 // 关闭时等待全部从库的时间
+	replShutdownAckTimeout = time.Second * 3  // 等待单个从库确认的时间
+	replPingInterval       = time.Second      // 在线同步时发送PING [maxseq]的间隔，从库据此计算延迟
+)
+
 // S: SYNC UID [UID] PORT [PORT] SNAP [1/0] SEQ [-1/...]
 func (server *GoRedisServer) OnSYNC(session *Session, cmd *Command) (reply *Reply) {
 	stdlog.Printf("[S %s] %s\n", session.RemoteAddr(), cmd)
@@ -31,6 +38,11 @@ func (server *GoRedisServer) OnSYNC(session *Session, cmd *Command) (reply *Repl
 
 	// 使用从库端口代替socket端口，标识来源
 	h, _ := splitHostPort(session.RemoteAddr().String())
+	if session.GetAttribute("PORT") == nil {
+		if port, ok := session.GetAttribute(S_SLAVE_PORT).(int); ok {
+			session.SetAttribute("PORT", strconv.Itoa(port))
+		}
+	}
 	remoteHost := fmt.Sprintf("%s:%s", h, session.GetAttribute("PORT"))
 	session.SetAttribute(S_HOST, remoteHost)
 	session.SetAttribute(S_STATUS, REPL_WAIT)
@@ -123,7 +135,8 @@ func (server *GoRedisServer) sendSnapshot(session *Session) (nextseq int64, err
 
 	// gogogo
 	snap.RangeEnumerate([]byte{}, []byte{levelredis.MAXBYTE}, levelredis.IterForward, func(i int, key, value []byte, quit *bool) {
-		if bytes.HasPrefix(key, []byte(PREFIX)) {
+		// 系统数据不发送，CRON任务表除外
+		if bytes.HasPrefix(key, []byte(PREFIX)) && !bytes.HasPrefix(key, []byte(cronPrefix)) {
 			return
 		}
 		cmd := NewCommand([]byte("SYNC_RAW"), key, value)
@@ -155,27 +168,47 @@ func (server *GoRedisServer) syncRunloop(session *Session, lastseq int64) (err e
 		return
 	}
 	seq := lastseq
+	session.SetAttribute(S_SYNC_SEQ, seq-1)
+	// 从库的连接在db0，先切换到这条日志所在的db
+	if err = session.WriteCommand(NewCommand(formatByteSlice("SELECT", server.syncLogDBAt(seq))...)); err != nil {
+		return
+	}
 	deplymsec := 10
+	lastping := time.Time{}
 	for {
+		// 不论是否空闲都定时发送，从库收到时执行到maxseq说明这一刻没有延迟
+		if time.Since(lastping) >= replPingInterval {
+			if err = session.WriteCommand(NewCommand(formatByteSlice("PING", server.synclog.MaxSeq())...)); err != nil {
+				break
+			}
+			lastping = time.Now()
+		}
 		var val []byte
 		val, err = server.synclog.Read(seq)
 		if err != nil {
 			break
 		}
 		if val == nil {
+			// 关闭服务时已挂起全部写入，日志发送完毕后通知从库
+			if server.closing {
+				err = server.syncShutdown(session, seq-1)
+				break
+			}
 			time.Sleep(time.Millisecond * time.Duration(deplymsec))
-			deplymsec += 10
-			if deplymsec >= 1000 { // 防死尸
-				if err = session.WriteCommand(NewCommand([]byte("PING"))); err != nil {
-					break
-				}
-				deplymsec = 10
+			if deplymsec < 100 {
+				deplymsec += 10
 			}
 			continue
 		} else {
 			deplymsec = 10
 		}
 
+		// DEBUG FAULT REPL-DROP
+		if server.replDropRate > 0 && rand.Float64() < server.replDropRate {
+			seq++
+			continue
+		}
+
 		seqstr := strconv.FormatInt(seq, 10)
 		if err = session.WriteCommand(NewCommand([]byte("SYNC_SEQ"), []byte(seqstr))); err != nil {
 			break
@@ -184,9 +217,55 @@ func (server *GoRedisServer) syncRunloop(session *Session, lastseq int64) (err e
 		if _, err = session.Write(val); err != nil {
 			break
 		}
+		session.SetAttribute(S_SYNC_SEQ, seq)
 		seq++
 	}
 	// close
 	session.Close()
 	return
 }
+
+// 通知从库主库正在关闭以及最后一条seq，等待从库确认后断开，
+// 从库保存seq，主库重启后从下一条seq继续增量同步，不需要重新传输快照
+func (server *GoRedisServer) syncShutdown(session *Session, lastseq int64) (err error) {
+	remoteHost := session.GetAttribute(S_HOST)
+	if err = session.WriteCommand(NewCommand(formatByteSlice("SYNC_SHUTDOWN", lastseq)...)); err != nil {
+		return
+	}
+	deadline := time.Now().Add(replShutdownAckTimeout)
+	for time.Now().Before(deadline) {
+		if ack, ok := session.GetAttribute(S_SHUTDOWN_ACK).(int64); ok {
+			if ack != lastseq {
+				stdlog.Printf("[S %s] shutdown ack seq %d, expect %d\n", remoteHost, ack, lastseq)
+			} else {
+				stdlog.Printf("[S %s] shutdown ack seq %d\n", remoteHost, ack)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return errors.New("shutdown ack timeout")
+}
+
+// S: SYNC_SHUTDOWN_ACK [SEQ]
+func (server *GoRedisServer) onSyncShutdownAck(session *Session, cmd *Command) (reply *Reply) {
+	if seq, err := cmd.Int64AtIndex(1); err == nil {
+		session.SetAttribute(S_SHUTDOWN_ACK, seq)
+	}
+	return NOREPLY
+}
+
+// 关闭前等待从库收完全部日志并确认，超时后直接关闭
+func (server *GoRedisServer) waitReplicasShutdown() {
+	if server.syncmgr.Len() == 0 {
+		return
+	}
+	stdlog.Printf("wait %d replica(s) to finish sync ...\n", server.syncmgr.Len())
+	deadline := time.Now().Add(replShutdownTimeout)
+	for server.syncmgr.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if n := server.syncmgr.Len(); n > 0 {
+		stdlog.Printf("%d replica(s) not finished, closing\n", n)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 )
 
@@ -50,3 +51,37 @@ func (server *GoRedisServer) OnLEVELDB_PROP(cmd *Command) (reply *Reply) {
 	reply = MultiBulksReply(bulks)
 	return
 }
+
+// 热点key统计，数据来自采样，仅供参考
+// TOPKEYS [OPS/BYTES] [seconds] [count]
+// 返回 key1, value1, key2, value2, ...，value为每秒的操作数或请求字节数
+func (server *GoRedisServer) OnTOPKEYS(cmd *Command) (reply *Reply) {
+	bybytes := false
+	seconds, count := keySamplerWindow, 10
+	var err error
+	if cmd.Len() > 1 {
+		switch strings.ToUpper(cmd.StringAtIndex(1)) {
+		case "OPS":
+		case "BYTES":
+			bybytes = true
+		default:
+			return ErrorReply("topkeys [ops/bytes] [seconds] [count]")
+		}
+	}
+	if cmd.Len() > 2 {
+		if seconds, err = cmd.IntAtIndex(2); err != nil {
+			return ErrorReply(err)
+		}
+	}
+	if cmd.Len() > 3 {
+		if count, err = cmd.IntAtIndex(3); err != nil {
+			return ErrorReply(err)
+		}
+	}
+	ranks := server.keySampler.Top(bybytes, seconds, count)
+	bulks := make([]interface{}, 0, len(ranks)*2)
+	for _, r := range ranks {
+		bulks = append(bulks, r.Key, strconv.FormatInt(r.Value, 10))
+	}
+	return MultiBulksReply(bulks)
+}
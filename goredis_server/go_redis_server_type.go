@@ -0,0 +1,117 @@
+package goredis_server
+
+// 写指令执行前检查key的类型，类型不符时与redis一样返回WRONGTYPE，
+// 避免在已有的key上再创建另一种类型的数据(TYPE只能返回其中一种，DEL也只删除一种)
+// 类型来自levelredis的+[key]type登记，每个key一次seek；读指令不检查，不增加IO
+// SET/MSET与redis一样覆盖任意类型的key
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var WrongTypeError = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// 指令类别允许的key类型，SETBIT创建的bitmap也是string
+var cateKeyTypes = map[CCate][]string{
+	CCateString:    {levelredis.STRING_SUFFIX, levelredis.BITMAP_SUFFIX},
+	CCateHash:      {levelredis.HASH_SUFFIX},
+	CCateList:      {levelredis.LIST_SUFFIX},
+	CCateSet:       {levelredis.SET_SUFFIX},
+	CCateSortedSet: {levelredis.ZSET_SUFFIX},
+}
+
+// 参数中key的位置[first, last, step]，last为负数时从末尾计算，默认只有第一个参数
+// 目标key由指令覆盖(先删除)的STORE类指令只检查源key
+var cmdKeyPositions = map[string][3]int{
+	"SET":         {0, 0, 0},
+	"MSET":        {0, 0, 0},
+	"BITOP":       {3, -1, 1},
+	"RPOPLPUSH":   {1, 2, 1},
+	"LMOVE":       {1, 2, 1},
+	"BRPOPLPUSH":  {1, 2, 1},
+	"BLMOVE":      {1, 2, 1},
+	"BLPOP":       {1, -2, 1},
+	"BRPOP":       {1, -2, 1},
+	"BZPOPMIN":    {1, -2, 1},
+	"BZPOPMAX":    {1, -2, 1},
+	"SINTERSTORE": {2, -1, 1},
+	"SUNIONSTORE": {2, -1, 1},
+	"SDIFFSTORE":  {2, -1, 1},
+}
+
+// numkeys所在的位置，key紧随其后
+var cmdNumKeysAt = map[string]int{
+	"LMPOP":       1,
+	"BLMPOP":      2,
+	"ZUNIONSTORE": 2,
+	"ZINTERSTORE": 2,
+}
+
+// 指令允许的key类型，类型不限的指令返回nil
+func keyTypesOf(cmdName string) []string {
+	if strings.HasPrefix(cmdName, "BLOB.") {
+		return []string{levelredis.BLOB_SUFFIX}
+	}
+	return cateKeyTypes[commandCategory(cmdName)]
+}
+
+func typedKeys(cmd *Command) (keys [][]byte) {
+	args := cmd.Args()
+	name := cmd.Name()
+	if at, ok := cmdNumKeysAt[name]; ok {
+		numkeys, err := strconv.Atoi(cmd.StringAtIndex(at))
+		if err != nil || numkeys <= 0 || at+numkeys >= len(args) {
+			return nil // 由指令自己返回参数错误
+		}
+		// 返回副本，调用者追加key时不能覆盖numkeys之后的参数
+		return append([][]byte{}, args[at+1:at+1+numkeys]...)
+	}
+	pos, ok := cmdKeyPositions[name]
+	if !ok {
+		pos = [3]int{1, 1, 1}
+	}
+	first, last, step := pos[0], pos[1], pos[2]
+	if last < 0 {
+		last += len(args)
+	}
+	for i := first; step > 0 && i <= last && i < len(args); i += step {
+		keys = append(keys, args[i])
+	}
+	return
+}
+
+// 写指令的key已存在且类型不符时返回WrongTypeError
+func (server *GoRedisServer) checkKeyTypes(cmd *Command) error {
+	name := cmd.Name()
+	if !needSync(name) && !pauseWriteCmds[name] {
+		return nil
+	}
+	types := keyTypesOf(name)
+	if types == nil {
+		return nil
+	}
+	for _, key := range typedKeys(cmd) {
+		t := server.db(cmd).TypeOf(key)
+		if t == "none" {
+			continue
+		}
+		ok := false
+		for _, typ := range types {
+			ok = ok || t == typ
+		}
+		if !ok {
+			return WrongTypeError
+		}
+	}
+	return nil
+}
+
+// SET/MSET覆盖其它类型的key，写入string之前删除原来的数据
+func (server *GoRedisServer) overwriteKey(db *levelredis.LevelRedis, key []byte) {
+	if t := db.TypeOf(key); t != "none" && t != levelredis.STRING_SUFFIX {
+		db.Delete(key)
+	}
+}
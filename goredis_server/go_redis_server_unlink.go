@@ -0,0 +1,50 @@
+package goredis_server
+
+// UNLINK key [key ...]，集合类型的元素由后台删除，返回与DEL一致
+// 从库收到UNLINK后同样在后台删除
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"time"
+)
+
+const (
+	unlinkInterval = 100 * time.Millisecond
+	unlinkBatch    = 10 // 每批删除的key数，删满时立即继续下一批
+)
+
+func (server *GoRedisServer) initUnlinkWorker() {
+	for i, db := range server.databases() {
+		if n := db.UnlinkPending(); n > 0 {
+			stdlog.Printf("unlink %d keys pending in db%d\n", n, i)
+		}
+	}
+	go func() {
+		for !server.closing {
+			time.Sleep(unlinkInterval)
+			server.dropUnlinked()
+		}
+	}()
+}
+
+func (server *GoRedisServer) dropUnlinked() {
+	for _, db := range server.databases() {
+		for db.UnlinkPending() > 0 {
+			// 与sweepExpired一样遵守Suspend
+			server.enter()
+			if server.closing {
+				server.leave()
+				return
+			}
+			n := db.DropUnlinked(unlinkBatch)
+			server.leave()
+			if n < unlinkBatch {
+				break
+			}
+		}
+	}
+}
+
+func (server *GoRedisServer) OnUNLINK(cmd *Command) (reply *Reply) {
+	return IntegerReply(server.db(cmd).Unlink(cmd.Args()[1:]...))
+}
@@ -0,0 +1,69 @@
+package goredis_server
+
+// WAIT numreplicas timeout
+// 等待至少numreplicas个从库确认执行完当前连接最后一次写入，返回已确认的从库数，timeout为毫秒，0表示一直等待
+// 写指令写入同步日志之后在writeMark里记下seq，从库通过REPLCONF ACK确认，见go_redis_server_replconf.go
+// 不发送ACK的从库(旧版本GoRedis)不计入
+import (
+	. "GoRedis/goredis"
+	"sync/atomic"
+	"time"
+)
+
+const waitPollInterval = time.Millisecond * 10
+
+// 还没有写入同步日志
+const writePending int64 = -2
+
+// 连接最后一次写入在同步日志里的seq
+type writeMark struct {
+	seq int64 // atomic
+}
+
+// 在On()里指令进入cmdChan之前调用，processCommandChan写入同步日志后设置seq
+func markWrite(session *Session, cmd *Command) {
+	mark := &writeMark{seq: writePending}
+	cmd.SetAttribute(C_WRITE_MARK, mark)
+	session.SetAttribute(S_LAST_WRITE, mark)
+}
+
+func (server *GoRedisServer) OnWAIT(session *Session, cmd *Command) (reply *Reply) {
+	if server.isReplica() {
+		return ErrorReply("WAIT cannot be used with replica instances")
+	}
+	numreplicas, err := cmd.IntAtIndex(1)
+	if err != nil {
+		return ErrorReply(NotIntegerError)
+	}
+	timeout, err := cmd.Int64AtIndex(2)
+	if err != nil {
+		return ErrorReply(NotIntegerError)
+	} else if timeout < 0 {
+		return ErrorReply("timeout is negative")
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	mark, _ := session.GetAttribute(S_LAST_WRITE).(*writeMark)
+	for {
+		n := server.ackedReplicas(mark)
+		if n >= numreplicas || (timeout > 0 && !time.Now().Before(deadline)) || server.closing {
+			return IntegerReply(n)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// 确认执行完mark的从库数，没有写入过时计算全部发送ACK的从库
+func (server *GoRedisServer) ackedReplicas(mark *writeMark) (n int) {
+	target := int64(-1)
+	if mark != nil {
+		if target = atomic.LoadInt64(&mark.seq); target == writePending {
+			return 0
+		}
+	}
+	server.syncmgr.Enumerate(func(i int, key string, val interface{}) {
+		if seq, ok := val.(*Session).GetAttribute(S_ACK_SEQ).(int64); ok && seq >= target {
+			n++
+		}
+	})
+	return
+}
@@ -0,0 +1,88 @@
+package goredis_server
+
+// 前缀订阅，key发生变更时推送给客户端，适用于配置下发
+// 订阅连接当前SELECT的db，每个db有自己的订阅者，SWAPDB之后跟随数据
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"fmt"
+	"sync/atomic"
+)
+
+// WATCHPREFIX prefix
+// 与monitor一样以StatusReply持续输出，每行格式为 "<cmd> <key>"
+// redis-cli -p 1602 watchprefix config:
+func (server *GoRedisServer) OnWATCHPREFIX(session *Session, cmd *Command) (reply *Reply) {
+	if session.GetAttribute(S_UNWATCH) != nil {
+		return ErrorReply("already watching")
+	}
+	prefix, _ := cmd.ArgAtIndex(1)
+
+	remoteHost := session.RemoteAddr().String()
+	watcher := server.dbAt(sessionDB(session)).Watcher()
+	buffer := make(chan string, 10000)
+	// 订阅之前进入队列的指令可能还没有通知，跳过它们，只推送订阅之后的写入
+	since := atomic.LoadInt64(&server.queueSeq)
+	id := watcher.Watch(prefix, func(key []byte, event string) {
+		// 在processCommandChan中回调，不能阻塞，缓冲区满时丢弃并断开连接
+		if server.notifySeq <= since {
+			return
+		}
+		select {
+		case buffer <- fmt.Sprintf("%s %s", event, key):
+		default:
+			session.Close()
+		}
+	})
+
+	// 连接断开时由SessionClosed调用，Unwatch返回后不会再有回调，可以安全关闭buffer
+	session.SetAttribute(S_UNWATCH, func() {
+		watcher.Unwatch(id)
+		close(buffer)
+	})
+	// 注册之后再回复OK，客户端收到OK之后的写入都会推送
+	session.WriteReply(StatusReply("OK"))
+
+	go func() {
+		stdlog.Printf("[%s] watchprefix %s start\n", remoteHost, prefix)
+		for line := range buffer {
+			if err := session.WriteReply(StatusReply(line)); err != nil {
+				session.Close()
+				break
+			}
+		}
+		stdlog.Printf("[%s] watchprefix %s exit\n", remoteHost, prefix)
+	}()
+	return
+}
+
+// 写入指令对应的key，通知指令所在db的前缀订阅者；执行出错的指令没有写入，不通知
+func (server *GoRedisServer) notifyWatchers(cmd *Command) {
+	watcher := server.db(cmd).Watcher()
+	if watcher.Len() == 0 {
+		return
+	}
+	args := cmd.Args()
+	switch cmd.Name() {
+	case "BULK.WRITE", "FLUSHALL", "FLUSHDB", "SWAPDB":
+		// 批量导入、清空和交换db不通知
+		return
+	case "DEL", "UNLINK":
+		for _, key := range args[1:] {
+			watcher.Notify(key, cmd.Name())
+		}
+	case "RENAME", "RENAMENX":
+		watcher.Notify(args[1], cmd.Name())
+		watcher.Notify(args[2], cmd.Name())
+	case "COPY":
+		watcher.Notify(args[2], cmd.Name())
+	case "MSET", "MSETNX":
+		for i := 1; i < len(args); i += 2 {
+			watcher.Notify(args[i], cmd.Name())
+		}
+	default:
+		if len(args) > 1 {
+			watcher.Notify(args[1], cmd.Name())
+		}
+	}
+}
@@ -2,41 +2,120 @@ package goredis_server
 
 import (
 	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// score以float64保存，格式见FormatDouble
+func formatScore(score []byte) []byte {
+	return []byte(FormatDouble(levelredis.BytesToFloat64(score)))
+}
+
+// 支持 inf/-inf/+inf，nan不能排序，与redis一样拒绝
+func parseScore(s string) (f float64, err error) {
+	if f, err = strconv.ParseFloat(s, 64); err != nil || math.IsNaN(f) {
+		return 0, NotFloatError
+	}
+	return
+}
+
+// 区间的边界，"(1.5"表示不包含1.5，转换为相邻的浮点数，isMin表示下界
+func parseScoreBound(s string, isMin bool) (f float64, err error) {
+	if !strings.HasPrefix(s, "(") {
+		return parseScore(s)
+	}
+	if f, err = parseScore(s[1:]); err != nil {
+		return
+	}
+	if isMin {
+		f = math.Nextafter(f, math.Inf(1))
+	} else {
+		f = math.Nextafter(f, math.Inf(-1))
+	}
+	return
+}
+
 // ZADD key score member [score member ...]
 // Add one or more members to a sorted set, or update its score if it already exists
+// 解析ZADD的选项，返回第一个score的位置
+func parseZAddFlags(cmd *Command) (flags levelredis.ZAddFlag, incr bool, idx int) {
+	for idx = 2; idx < cmd.Len(); idx++ {
+		switch strings.ToUpper(cmd.StringAtIndex(idx)) {
+		case "NX":
+			flags |= levelredis.ZAddNX
+		case "XX":
+			flags |= levelredis.ZAddXX
+		case "GT":
+			flags |= levelredis.ZAddGT
+		case "LT":
+			flags |= levelredis.ZAddLT
+		case "CH":
+			flags |= levelredis.ZAddCH
+		case "INCR":
+			incr = true
+		default:
+			return
+		}
+	}
+	return
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 func (server *GoRedisServer) OnZADD(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	scoreMembers := cmd.Args()[2:]
+	flags, incr, i := parseZAddFlags(cmd)
+	if flags&levelredis.ZAddNX != 0 && flags&levelredis.ZAddXX != 0 {
+		return ErrorReply("XX and NX options at the same time are not compatible")
+	}
+	if (flags&levelredis.ZAddGT != 0 && flags&levelredis.ZAddLT != 0) ||
+		(flags&levelredis.ZAddNX != 0 && flags&(levelredis.ZAddGT|levelredis.ZAddLT) != 0) {
+		return ErrorReply("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	scoreMembers := cmd.Args()[i:]
 	count := len(scoreMembers)
-	if count%2 != 0 {
+	if count == 0 || count%2 != 0 {
 		return ErrorReply("Bad argument count")
 	}
+	if incr && count != 2 {
+		return ErrorReply("INCR option supports a single increment-element pair")
+	}
 	args := make([][]byte, count)
 	// format score
 	for i := 0; i < count; i += 2 {
-		scorefloat, err := strconv.ParseFloat(string(scoreMembers[i]), 64)
+		scorefloat, err := parseScore(string(scoreMembers[i]))
 		if err != nil {
-			return ErrorReply("bad score")
+			return ErrorReply(err)
 		}
 		// replace score
-		scoreInt := int64(scorefloat)
-		args[i] = Int64ToBytes(scoreInt)
+		args[i] = levelredis.Float64ToBytes(scorefloat)
 		args[i+1] = scoreMembers[i+1]
 	}
+	zset := server.db(cmd).GetSortedSet(key)
+	// INCR模式与ZINCRBY一致返回新score，不满足条件时返回nil
+	if incr {
+		score, err := zset.AddIncr(flags, args[1], levelredis.BytesToFloat64(args[0]))
+		if err != nil {
+			return ErrorReply(err)
+		} else if score == nil {
+			return BulkReply(nil)
+		}
+		server.listWaiters.Signal(key)
+		return DoubleReply(levelredis.BytesToFloat64(score))
+	}
 	// add
-	zset := server.levelRedis.GetSortedSet(key)
-	n := zset.Add(args...)
+	n := zset.Add(flags, args...)
+	if n > 0 {
+		server.listWaiters.Signal(key)
+	}
 	reply = IntegerReply(n)
 	return
 }
 
 func (server *GoRedisServer) OnZCARD(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	reply = IntegerReply(zset.Len())
 	return
 }
@@ -47,7 +126,7 @@ func (server *GoRedisServer) zrank(cmd *Command, high2low bool) (reply *Reply) {
 	if err != nil {
 		return ErrorReply(err)
 	}
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	idx := zset.Rank(high2low, member)
 	if idx == -1 {
 		return BulkReply(nil)
@@ -76,15 +155,17 @@ func (server *GoRedisServer) rangeByIndex(cmd *Command, high2low bool) (reply *R
 	if cmd.Len() >= 5 && strings.ToUpper(cmd.StringAtIndex(4)) == "WITHSCORES" {
 		withScore = true
 	}
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
+	if r := server.checkLargeCollection(cmd, rangeSpan(int64(start), int64(stop), int64(zset.Len())), "ZRANGE with smaller ranges"); r != nil {
+		return r
+	}
 	scoreMembers := zset.RangeByIndex(high2low, start, stop)
 	count := len(scoreMembers)
 	bulks := make([]interface{}, 0, count)
 	for i := 0; i < count; i += 2 {
 		bulks = append(bulks, scoreMembers[i+1])
 		if withScore {
-			scoreInt := BytesToInt64(scoreMembers[i])
-			bulks = append(bulks, []byte(strconv.FormatInt(scoreInt, 10)))
+			bulks = append(bulks, formatScore(scoreMembers[i]))
 		}
 	}
 	reply = MultiBulksReply(bulks)
@@ -106,8 +187,9 @@ func (server *GoRedisServer) OnZREVRANGE(cmd *Command) (reply *Reply) {
 
 func (server *GoRedisServer) rangeByScore(cmd *Command, high2low bool) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	score1, e1 := cmd.Int64AtIndex(2)
-	score2, e2 := cmd.Int64AtIndex(3)
+	// ZREVRANGEBYSCORE的参数顺序为max min
+	score1, e1 := parseScoreBound(cmd.StringAtIndex(2), !high2low)
+	score2, e2 := parseScoreBound(cmd.StringAtIndex(3), high2low)
 	if e1 != nil || e2 != nil {
 		return ErrorReply("Bad min/max")
 	}
@@ -119,15 +201,14 @@ func (server *GoRedisServer) rangeByScore(cmd *Command, high2low bool) (reply *R
 	if cmd.Len() >= 5 && strings.ToUpper(cmd.StringAtIndex(4)) == "WITHSCORES" {
 		withScore = true
 	}
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	scoreMembers := zset.RangeByScore(high2low, score1, score2, 0, -1)
 	count := len(scoreMembers)
 	bulks := make([]interface{}, 0, count)
 	for i := 0; i < count; i += 2 {
 		bulks = append(bulks, scoreMembers[i+1])
 		if withScore {
-			scoreInt := BytesToInt64(scoreMembers[i])
-			bulks = append(bulks, []byte(strconv.FormatInt(scoreInt, 10)))
+			bulks = append(bulks, formatScore(scoreMembers[i]))
 		}
 	}
 	reply = MultiBulksReply(bulks)
@@ -146,12 +227,26 @@ func (server *GoRedisServer) OnZREVRANGEBYSCORE(cmd *Command) (reply *Reply) {
 	return server.rangeByScore(cmd, true)
 }
 
+// ZCOUNT key min max
+// Count the members in a sorted set with scores within the given values
+func (server *GoRedisServer) OnZCOUNT(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	min, e1 := parseScoreBound(cmd.StringAtIndex(2), true)
+	max, e2 := parseScoreBound(cmd.StringAtIndex(3), false)
+	if e1 != nil || e2 != nil {
+		return ErrorReply("min or max is not a float")
+	}
+	zset := server.db(cmd).GetSortedSet(key)
+	reply = IntegerReply(zset.Count(min, max))
+	return
+}
+
 // ZREM key member [member ...]
 // Remove one or more members from a sorted set
 func (server *GoRedisServer) OnZREM(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
 	members := cmd.Args()[2:]
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	n := zset.Remove(members...)
 	reply = IntegerReply(n)
 	return
@@ -166,7 +261,7 @@ func (server *GoRedisServer) OnZREMRANGEBYRANK(cmd *Command) (reply *Reply) {
 	if e1 != nil || e2 != nil {
 		return ErrorReply("Bad start/stop")
 	}
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	n := zset.RemoveByIndex(start, stop)
 	reply = IntegerReply(n)
 	return
@@ -176,12 +271,12 @@ func (server *GoRedisServer) OnZREMRANGEBYRANK(cmd *Command) (reply *Reply) {
 // Remove all members in a sorted set within the given scores
 func (server *GoRedisServer) OnZREMRANGEBYSCORE(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	min, e1 := cmd.Int64AtIndex(2)
-	max, e2 := cmd.Int64AtIndex(3)
+	min, e1 := parseScoreBound(cmd.StringAtIndex(2), true)
+	max, e2 := parseScoreBound(cmd.StringAtIndex(3), false)
 	if e1 != nil || e2 != nil {
 		return ErrorReply("Bad min/max")
 	}
-	zset := server.levelRedis.GetSortedSet(key)
+	zset := server.db(cmd).GetSortedSet(key)
 	n := zset.RemoveByScore(min, max)
 	reply = IntegerReply(n)
 	return
@@ -189,33 +284,171 @@ func (server *GoRedisServer) OnZREMRANGEBYSCORE(cmd *Command) (reply *Reply) {
 
 func (server *GoRedisServer) OnZINCRBY(cmd *Command) (reply *Reply) {
 	key := cmd.StringAtIndex(1)
-	incrmemt, e1 := cmd.Int64AtIndex(2)
+	incrmemt, e1 := parseScore(cmd.StringAtIndex(2))
+	if e1 != nil {
+		return ErrorReply(e1)
+	}
 	member, e2 := cmd.ArgAtIndex(3)
-	if e1 != nil || e2 != nil {
+	if e2 != nil {
 		return ErrorReply("Bad incrment/member")
 	}
-	zset := server.levelRedis.GetSortedSet(key)
-	score := zset.IncrBy(member, incrmemt)
-	scoreInt := BytesToInt64(score)
-	reply = BulkReply(strconv.FormatInt(scoreInt, 10))
+	zset := server.db(cmd).GetSortedSet(key)
+	score, err := zset.IncrBy(member, incrmemt)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	server.listWaiters.Signal(key)
+	reply = DoubleReply(levelredis.BytesToFloat64(score))
 	return
 }
 
 // ZSCORE key member
 // Get the score associated with the given member in a sorted set
 func (server *GoRedisServer) OnZSCORE(cmd *Command) (reply *Reply) {
-	key, _ := cmd.ArgAtIndex(1)
+	key := cmd.StringAtIndex(1)
 	member, _ := cmd.ArgAtIndex(2)
-	// zset := server.levelRedis.GetSortedSet(key)
-	// score := zset.Score(member)
-	score, err := server.levelRedis.Global().ZScore(key, member)
+	// 通过zset对象读取，未迁移的旧数据会先完成迁移
+	zset := server.db(cmd).GetSortedSet(key)
+	score := zset.Score(member)
+	if score == nil {
+		return BulkReply(nil)
+	}
+	reply = DoubleReply(levelredis.BytesToFloat64(score))
+	return
+}
+
+// ZPOPMIN key [count]
+func (server *GoRedisServer) OnZPOPMIN(cmd *Command) (reply *Reply) {
+	return server.zpop(cmd, false)
+}
+
+// ZPOPMAX key [count]
+func (server *GoRedisServer) OnZPOPMAX(cmd *Command) (reply *Reply) {
+	return server.zpop(cmd, true)
+}
+
+// 返回[member, score, ...]
+func (server *GoRedisServer) zpop(cmd *Command, high2low bool) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	count := 1
+	if cmd.Len() > 2 {
+		var err error
+		if count, err = cmd.IntAtIndex(2); err != nil || count < 0 {
+			return ErrorReply("value is out of range, must be positive")
+		}
+	}
+	zset := server.db(cmd).GetSortedSet(key)
+	scoreMembers := zset.Pop(high2low, count)
+	bulks := make([]interface{}, 0, len(scoreMembers))
+	for i := 0; i < len(scoreMembers); i += 2 {
+		bulks = append(bulks, scoreMembers[i+1], formatScore(scoreMembers[i]))
+	}
+	reply = MultiBulksReply(bulks)
+	return
+}
+
+// BZPOPMIN key [key ...] timeout
+func (server *GoRedisServer) OnBZPOPMIN(cmd *Command) (reply *Reply) {
+	return server.blockingZPop(cmd, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func (server *GoRedisServer) OnBZPOPMAX(cmd *Command) (reply *Reply) {
+	return server.blockingZPop(cmd, true)
+}
+
+// 与BLPOP共用等待队列，返回[key, member, score]，超时返回nil
+func (server *GoRedisServer) blockingZPop(cmd *Command, high2low bool) (reply *Reply) {
+	timeout, err := parseBlockTimeout(cmd.StringAtIndex(cmd.Len() - 1))
 	if err != nil {
 		return ErrorReply(err)
 	}
-	if score == nil {
-		return BulkReply(nil)
+	keys := make([]string, 0, cmd.Len()-2)
+	for _, key := range cmd.Args()[1 : cmd.Len()-1] {
+		keys = append(keys, string(key))
+	}
+	popName := "ZPOPMIN"
+	if high2low {
+		popName = "ZPOPMAX"
+	}
+	session, _ := cmd.GetAttribute(C_SESSION).(*Session)
+	reply = server.listWaiters.Wait(session, keys, timeout, server.tracked(cmd, func() *Reply {
+		for _, key := range keys {
+			zset := server.db(cmd).GetSortedSet(key)
+			if zset.Len() == 0 {
+				continue
+			}
+			scoreMembers := zset.Pop(high2low, 1)
+			if len(scoreMembers) == 0 {
+				continue // 被其它客户端抢先
+			}
+			cmd.SetAttribute(C_SYNC_AS, NewCommand([]byte(popName), []byte(key)))
+			return MultiBulksReply([]interface{}{key, scoreMembers[1], formatScore(scoreMembers[0])})
+		}
+		return nil
+	}))
+	if reply == nil {
+		reply = MultiBulksReply(nil)
+	}
+	return
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+// 返回[cursor, [member, score, ...]]，按member字节顺序扫描
+func (server *GoRedisServer) OnZSCAN(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	args, err := parseScanArgs(cmd, 2)
+	if err != nil {
+		return ErrorReply(err)
+	}
+	zset := server.db(cmd).GetSortedSet(key)
+	elems := make([]interface{}, 0, args.count*2)
+	next := zset.Scan(args.cursor, args.count, func(member, score []byte) {
+		if args.Match(member) {
+			elems = append(elems, member, formatScore(score))
+		}
+	})
+	return scanReply(next, elems)
+}
+
+// ZRANDMEMBER key [count [WITHSCORES]]
+// count为负数时允许重复，没有count时返回单个member
+func (server *GoRedisServer) OnZRANDMEMBER(cmd *Command) (reply *Reply) {
+	key := cmd.StringAtIndex(1)
+	zset := server.db(cmd).GetSortedSet(key)
+	if cmd.Len() == 2 {
+		scoreMembers := zset.RandMember(1, true)
+		if len(scoreMembers) == 0 {
+			return BulkReply(nil)
+		}
+		return BulkReply(scoreMembers[1])
+	}
+	count, err := cmd.IntAtIndex(2)
+	if err != nil {
+		return ErrorReply("value is not an integer or out of range")
 	}
-	scoreInt := BytesToInt64(score)
-	reply = BulkReply(strconv.FormatInt(scoreInt, 10))
+	withScore := false
+	if cmd.Len() > 3 {
+		if strings.ToUpper(cmd.StringAtIndex(3)) != "WITHSCORES" {
+			return ErrorReply("syntax error")
+		}
+		withScore = true
+	}
+	unique := count >= 0
+	if count < 0 {
+		count = -count
+	}
+	if r := server.checkLargeCollection(cmd, int64(count), "ZRANDMEMBER with smaller count"); r != nil {
+		return r
+	}
+	scoreMembers := zset.RandMember(count, unique)
+	bulks := make([]interface{}, 0, len(scoreMembers))
+	for i := 0; i < len(scoreMembers); i += 2 {
+		bulks = append(bulks, scoreMembers[i+1])
+		if withScore {
+			bulks = append(bulks, formatScore(scoreMembers[i]))
+		}
+	}
+	reply = MultiBulksReply(bulks)
 	return
 }
@@ -0,0 +1,157 @@
+package goredis_server
+
+// ZUNIONSTORE/ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+// 从快照读取输入的zset，destination可以同时作为输入；
+// 结果不在内存中汇总，而是每zstoreChunk个元素写入一次destination，写入前读取已有score做聚合，
+// 因此执行期间其他客户端可能读到不完整的destination
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const zstoreChunk = 1000
+
+type zstoreArgs struct {
+	dest      string
+	keys      []string
+	weights   []float64
+	aggregate string
+}
+
+func parseZStoreArgs(cmd *Command) (args *zstoreArgs, errmsg string) {
+	numkeys, e := strconv.Atoi(cmd.StringAtIndex(2))
+	if e != nil || numkeys <= 0 {
+		return nil, "at least 1 input key is needed"
+	}
+	if cmd.Len() < 3+numkeys {
+		return nil, "syntax error"
+	}
+	args = &zstoreArgs{dest: cmd.StringAtIndex(1), aggregate: "SUM"}
+	args.keys = make([]string, numkeys)
+	args.weights = make([]float64, numkeys)
+	for i := 0; i < numkeys; i++ {
+		args.keys[i] = cmd.StringAtIndex(3 + i)
+		args.weights[i] = 1
+	}
+	for i := 3 + numkeys; i < cmd.Len(); i++ {
+		switch strings.ToUpper(cmd.StringAtIndex(i)) {
+		case "WEIGHTS":
+			if i+numkeys >= cmd.Len() {
+				return nil, "syntax error"
+			}
+			for j := 0; j < numkeys; j++ {
+				i++
+				if args.weights[j], e = parseScore(cmd.StringAtIndex(i)); e != nil {
+					return nil, "weight value is not a float"
+				}
+			}
+		case "AGGREGATE":
+			i++
+			args.aggregate = strings.ToUpper(cmd.StringAtIndex(i))
+			if args.aggregate != "SUM" && args.aggregate != "MIN" && args.aggregate != "MAX" {
+				return nil, "syntax error"
+			}
+		default:
+			return nil, "syntax error"
+		}
+	}
+	return
+}
+
+func (a *zstoreArgs) combine(x, y float64) float64 {
+	switch a.aggregate {
+	case "MIN":
+		return math.Min(x, y)
+	case "MAX":
+		return math.Max(x, y)
+	}
+	// 与redis一致，inf + -inf 记为0
+	if sum := x + y; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// inf * 0 记为0
+func weighted(score []byte, weight float64) float64 {
+	if f := levelredis.BytesToFloat64(score) * weight; !math.IsNaN(f) {
+		return f
+	}
+	return 0
+}
+
+func (server *GoRedisServer) OnZUNIONSTORE(cmd *Command) (reply *Reply) {
+	return server.zstore(cmd, false)
+}
+
+func (server *GoRedisServer) OnZINTERSTORE(cmd *Command) (reply *Reply) {
+	return server.zstore(cmd, true)
+}
+
+func (server *GoRedisServer) zstore(cmd *Command, inter bool) (reply *Reply) {
+	db := server.db(cmd)
+	args, errmsg := parseZStoreArgs(cmd)
+	if args == nil {
+		return ErrorReply(errmsg)
+	}
+	snap := db.Snapshot()
+	defer snap.Close()
+	inputs := make([]*levelredis.LevelZSet, len(args.keys))
+	for i, key := range args.keys {
+		inputs[i] = snap.GetSortedSet(key)
+	}
+
+	db.Delete([]byte(args.dest))
+	dest := db.GetSortedSet(args.dest)
+
+	chunk := make([][]byte, 0, zstoreChunk*2)
+	flush := func() {
+		if len(chunk) > 0 {
+			dest.Add(0, chunk...)
+			chunk = chunk[:0]
+		}
+	}
+
+	if inter {
+		// 遍历第一个zset，在其余zset中查找
+		inputs[0].Enumerate(func(i int, member, score []byte, quit *bool) {
+			result := weighted(score, args.weights[0])
+			for j := 1; j < len(inputs); j++ {
+				other := inputs[j].Score(member)
+				if other == nil {
+					return
+				}
+				result = args.combine(result, weighted(other, args.weights[j]))
+			}
+			chunk = append(chunk, levelredis.Float64ToBytes(result), member)
+			if len(chunk) >= zstoreChunk*2 {
+				flush()
+			}
+		})
+		flush()
+	} else {
+		// 依次合并每个zset，已写入destination的元素读出后再聚合
+		for j, input := range inputs {
+			input.Enumerate(func(i int, member, score []byte, quit *bool) {
+				result := weighted(score, args.weights[j])
+				if j > 0 {
+					if old := dest.Score(member); old != nil {
+						result = args.combine(levelredis.BytesToFloat64(old), result)
+					}
+				}
+				chunk = append(chunk, levelredis.Float64ToBytes(result), member)
+				// 同一批次内不会出现重复的member，每个输入结束时都要写入
+				if len(chunk) >= zstoreChunk*2 {
+					flush()
+				}
+			})
+			flush()
+		}
+	}
+	server.listWaiters.Signal(args.dest)
+	reply = IntegerReply(dest.Len())
+	return
+}
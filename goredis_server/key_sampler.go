@@ -0,0 +1,125 @@
+package goredis_server
+
+// 采样统计每个key的访问频率，用于发现需要在上层缓存的热点key
+// 按秒划分的环形缓冲区，只保留最近keySamplerWindow秒的数据
+import (
+	. "GoRedis/goredis"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	keySamplerWindow  = 60    // 秒
+	keySamplerRate    = 10    // 每10条指令采样1条
+	keySamplerMaxKeys = 10000 // 每秒最多记录的key数量，防止内存失控
+)
+
+type keySample struct {
+	ops   int64
+	bytes int64
+}
+
+type keySampleBucket struct {
+	sec  int64
+	keys map[string]*keySample
+}
+
+type KeyRank struct {
+	Key   string
+	Value int64
+}
+
+type KeySampler struct {
+	mu      sync.Mutex
+	n       int64
+	buckets []keySampleBucket
+}
+
+func NewKeySampler() (k *KeySampler) {
+	k = &KeySampler{}
+	k.buckets = make([]keySampleBucket, keySamplerWindow)
+	return
+}
+
+// 由processCommandChan串行调用，bytes为请求参数的总长度
+func (k *KeySampler) Add(key []byte, bytes int) {
+	k.n++
+	if k.n%keySamplerRate != 0 {
+		return
+	}
+	now := time.Now().Unix()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	b := &k.buckets[now%keySamplerWindow]
+	if b.sec != now {
+		b.sec = now
+		b.keys = make(map[string]*keySample)
+	}
+	sample, ok := b.keys[string(key)]
+	if !ok {
+		if len(b.keys) >= keySamplerMaxKeys {
+			return
+		}
+		sample = &keySample{}
+		b.keys[string(key)] = sample
+	}
+	sample.ops++
+	sample.bytes += int64(bytes)
+}
+
+// 最近seconds秒内，按ops或bytes排序的前count个key，结果已按采样率换算为每秒的值
+func (k *KeySampler) Top(bybytes bool, seconds int, count int) (ranks []KeyRank) {
+	if seconds <= 0 || seconds > keySamplerWindow {
+		seconds = keySamplerWindow
+	}
+	now := time.Now().Unix()
+	total := make(map[string]int64)
+	k.mu.Lock()
+	for _, b := range k.buckets {
+		if b.keys == nil || now-b.sec >= int64(seconds) {
+			continue
+		}
+		for key, sample := range b.keys {
+			if bybytes {
+				total[key] += sample.bytes
+			} else {
+				total[key] += sample.ops
+			}
+		}
+	}
+	k.mu.Unlock()
+
+	ranks = make([]KeyRank, 0, len(total))
+	for key, v := range total {
+		ranks = append(ranks, KeyRank{Key: key, Value: v * keySamplerRate / int64(seconds)})
+	}
+	sort.Sort(keyRankSlice(ranks))
+	if count > 0 && len(ranks) > count {
+		ranks = ranks[:count]
+	}
+	return
+}
+
+type keyRankSlice []KeyRank
+
+func (k keyRankSlice) Len() int           { return len(k) }
+func (k keyRankSlice) Less(i, j int) bool { return k[i].Value > k[j].Value }
+func (k keyRankSlice) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+func (server *GoRedisServer) sampleKey(cmd *Command) {
+	switch commandCategory(cmd.Name()) {
+	case CCateKey, CCateString, CCateHash, CCateList, CCateSet, CCateSortedSet:
+	default:
+		return
+	}
+	args := cmd.Args()
+	if len(args) < 2 {
+		return
+	}
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	server.keySampler.Add(args[1], size)
+}
@@ -0,0 +1,132 @@
+package goredis_server
+
+// 阻塞指令BLPOP/BRPOP/BRPOPLPUSH/BLMOVE的等待队列，BZPOPMIN/BZPOPMAX也使用同一个队列
+// 客户端在空list/zset上等待时挂在每个key的队列里，LPUSH/RPUSH/ZADD等写入后唤醒该key上的全部等待者，
+// 被唤醒的客户端重新尝试pop，没有抢到的继续等待
+// 等待期间连接关闭(客户端断开或CLIENT KILL)时直接返回，不再pop，避免元素写到已关闭的连接而丢失
+import (
+	. "GoRedis/goredis"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ListWaiters struct {
+	waiters map[string]map[chan bool]bool
+	blocked int
+	mu      sync.Mutex
+}
+
+func NewListWaiters() (w *ListWaiters) {
+	w = &ListWaiters{}
+	w.waiters = make(map[string]map[chan bool]bool)
+	return
+}
+
+// 当前阻塞的客户端数
+func (w *ListWaiters) Blocked() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.blocked
+}
+
+func (w *ListWaiters) add(keys []string) (ch chan bool) {
+	ch = make(chan bool, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		m, ok := w.waiters[key]
+		if !ok {
+			m = make(map[chan bool]bool)
+			w.waiters[key] = m
+		}
+		m[ch] = true
+	}
+	w.blocked++
+	return
+}
+
+func (w *ListWaiters) remove(keys []string, ch chan bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if m, ok := w.waiters[key]; ok {
+			delete(m, ch)
+			if len(m) == 0 {
+				delete(w.waiters, key)
+			}
+		}
+	}
+	w.blocked--
+}
+
+// 唤醒等待key的全部客户端，不阻塞写入方
+func (w *ListWaiters) Signal(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch, _ := range w.waiters[key] {
+		select {
+		case ch <- true:
+		default:
+		}
+	}
+}
+
+// 反复执行try直到返回非nil，list为空时在keys上等待，timeout为0表示一直等待
+// 超时或者session关闭时返回nil，session为nil时只在超时返回
+func (w *ListWaiters) Wait(session *Session, keys []string, timeout time.Duration, try func() *Reply) (reply *Reply) {
+	if reply = try(); reply != nil {
+		return
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var closed <-chan bool
+	if session != nil {
+		closed = session.Closed()
+		defer watchDisconnect(session)()
+	}
+	for {
+		ch := w.add(keys)
+		// 加入队列后再试一次，避免错过加入之前的push
+		if reply = try(); reply != nil {
+			w.remove(keys, ch)
+			return
+		}
+		select {
+		case <-ch:
+			w.remove(keys, ch)
+			if reply = try(); reply != nil {
+				return
+			}
+		case <-deadline:
+			w.remove(keys, ch)
+			return nil
+		case <-closed:
+			w.remove(keys, ch)
+			return nil
+		}
+	}
+}
+
+// 阻塞期间没有读取连接，客户端断开不会被发现，这里读取一个字节检查
+// 返回的函数在阻塞结束后调用，用读超时打断读取并恢复连接；客户端在阻塞期间发送了下一条指令时不再检查
+func watchDisconnect(session *Session) (stop func()) {
+	done := make(chan bool)
+	var stopping int32
+	go func() {
+		defer close(done)
+		if _, err := session.PeekByte(); err != nil && atomic.LoadInt32(&stopping) == 0 {
+			session.Close()
+		}
+	}()
+	return func() {
+		atomic.StoreInt32(&stopping, 1)
+		session.SetReadDeadline(time.Now())
+		<-done
+		session.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,115 @@
+package goredis_server
+
+// 多端口监听，每个listener有各自的访问策略，用于把业务流量和运维流量分开
+// 配置文件中每个listen指令增加一个listener，地址与-h/-p相同时设置主端口的策略：
+// listen 0.0.0.0:1602 noadmin
+// listen 127.0.0.1:1700 adminonly
+// listen 0.0.0.0:1603 readonly tls /etc/goredis/cert.pem /etc/goredis/key.pem
+// 管理指令为server类别(CONFIG/SLAVEOF/DEBUG/SYNC等)和RAW_*，从库同步需要连接允许管理指令的端口
+import (
+	. "GoRedis/goredis"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type ListenerPolicy struct {
+	Addr      string // host:port
+	ReadOnly  bool   // 拒绝写指令
+	AdminOnly bool   // 只接受管理指令，用于运维端口
+	NoAdmin   bool   // 拒绝管理指令，用于业务端口
+	TLSCert   string // 不为空时只接受TLS连接
+	TLSKey    string
+}
+
+// listen指令的参数: addr [readonly] [adminonly|noadmin] [tls cert key]
+func ParseListenerPolicy(args []string) (p *ListenerPolicy, err error) {
+	if len(args) == 0 {
+		return nil, errors.New("listen address required")
+	}
+	if _, _, err = net.SplitHostPort(args[0]); err != nil {
+		return nil, err
+	}
+	p = &ListenerPolicy{Addr: args[0]}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "readonly":
+			p.ReadOnly = true
+		case "adminonly":
+			p.AdminOnly = true
+		case "noadmin":
+			p.NoAdmin = true
+		case "tls":
+			if i+2 >= len(args) {
+				return nil, errors.New("tls requires cert and key file")
+			}
+			p.TLSCert, p.TLSKey = args[i+1], args[i+2]
+			i += 2
+		default:
+			return nil, errors.New("unknown listen option " + args[i])
+		}
+	}
+	if p.AdminOnly && p.NoAdmin {
+		return nil, errors.New("adminonly and noadmin are exclusive")
+	}
+	return
+}
+
+func (p *ListenerPolicy) String() string {
+	opts := []string{p.Addr}
+	if p.ReadOnly {
+		opts = append(opts, "readonly")
+	}
+	if p.AdminOnly {
+		opts = append(opts, "adminonly")
+	}
+	if p.NoAdmin {
+		opts = append(opts, "noadmin")
+	}
+	if len(p.TLSCert) > 0 {
+		opts = append(opts, "tls")
+	}
+	return strings.Join(opts, " ")
+}
+
+// 客户端库连接时会执行的CLIENT子指令，不算管理指令
+var clientInfoCmds = map[string]bool{"SETNAME": true, "GETNAME": true, "SETINFO": true, "ID": true, "INFO": true}
+
+func isAdminCommand(cmd *Command) bool {
+	cmdName := cmd.Name()
+	if cmdName == "CLIENT" && clientInfoCmds[strings.ToUpper(cmd.StringAtIndex(1))] {
+		return false
+	}
+	return commandCategory(cmdName) == CCateServer || strings.HasPrefix(cmdName, "RAW_")
+}
+
+// 连接类指令(PING/ECHO/QUIT等)不受限制
+func (p *ListenerPolicy) Check(cmd *Command) error {
+	cmdName := cmd.Name()
+	if commandCategory(cmdName) == CCateConnection {
+		return nil
+	}
+	admin := isAdminCommand(cmd)
+	switch {
+	case p.AdminOnly && !admin:
+		return fmt.Errorf("NOPERM %s is not allowed on admin port %s", cmdName, p.Addr)
+	case p.NoAdmin && admin:
+		return fmt.Errorf("NOPERM %s is not allowed on port %s, use the admin port", cmdName, p.Addr)
+	case p.ReadOnly && (needSync(cmdName) || pauseWriteCmds[cmdName]):
+		return fmt.Errorf("NOPERM %s is not allowed on readonly port %s", cmdName, p.Addr)
+	}
+	return nil
+}
+
+func (p *ListenerPolicy) Listen() (listener net.Listener, err error) {
+	if len(p.TLSCert) == 0 {
+		return net.Listen("tcp", p.Addr)
+	}
+	cert, err := tls.LoadX509KeyPair(p.TLSCert, p.TLSKey)
+	if err != nil {
+		return
+	}
+	return tls.Listen("tcp", p.Addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+}
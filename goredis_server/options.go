@@ -1,5 +1,9 @@
 package goredis_server
 
+import (
+	"GoRedis/libs/levelredis"
+)
+
 // 运行配置
 type Options struct {
 	host        string
@@ -8,6 +12,16 @@ type Options struct {
 	logpath     string
 	slaveofHost string
 	slaveofPort int
+	warmup      string // 启动预热: ""/meta/full
+	databases   int    // db数量，默认16
+	loadrdb     string // 启动时导入的redis RDB文件
+	appendonly  bool   // 启动后开启AOF
+	restore     string // 从BACKUP的备份启动
+	restoreTo   int64  // 回放AOF到这个unix时间
+	codecs      map[string]levelredis.ValueCodec
+	configs     map[string]string // 启动时写入的运行期配置
+	sources     map[string]string // 设置项的来源，没有记录的为默认值
+	listeners   []*ListenerPolicy // 主端口之外的listener，或主端口的策略
 }
 
 func NewOptions() (o *Options) {
@@ -54,3 +68,96 @@ func (o *Options) SetSlaveOf(host string, port int) {
 func (o *Options) SlaveOf() (host string, port int) {
 	return o.slaveofHost, o.slaveofPort
 }
+
+func (o *Options) SetWarmUp(mode string) {
+	o.warmup = mode
+}
+
+func (o *Options) WarmUp() string {
+	return o.warmup
+}
+
+func (o *Options) SetDatabases(n int) {
+	o.databases = n
+}
+
+func (o *Options) Databases() int {
+	if o.databases <= 0 {
+		return defaultDatabases
+	}
+	return o.databases
+}
+
+func (o *Options) SetLoadRDB(filename string) {
+	o.loadrdb = filename
+}
+
+func (o *Options) LoadRDB() string {
+	return o.loadrdb
+}
+
+func (o *Options) SetAppendOnly(on bool) {
+	o.appendonly = on
+}
+
+func (o *Options) AppendOnly() bool {
+	return o.appendonly
+}
+
+func (o *Options) SetRestore(dir string, until int64) {
+	o.restore, o.restoreTo = dir, until
+}
+
+func (o *Options) Restore() string {
+	return o.restore
+}
+
+func (o *Options) RestoreUntil() int64 {
+	return o.restoreTo
+}
+
+// 对key前缀下的string值做透明的编解码，比如加密、压缩
+func (o *Options) AddValueCodec(prefix string, codec levelredis.ValueCodec) {
+	if o.codecs == nil {
+		o.codecs = make(map[string]levelredis.ValueCodec)
+	}
+	o.codecs[prefix] = codec
+}
+
+func (o *Options) ValueCodecs() map[string]levelredis.ValueCodec {
+	return o.codecs
+}
+
+// 启动时写入config，覆盖上一次运行时CONFIG SET的值
+func (o *Options) SetConfig(key, value string) {
+	if o.configs == nil {
+		o.configs = make(map[string]string)
+	}
+	o.configs[key] = value
+}
+
+func (o *Options) Configs() map[string]string {
+	return o.configs
+}
+
+func (o *Options) SetSource(name, source string) {
+	if o.sources == nil {
+		o.sources = make(map[string]string)
+	}
+	o.sources[name] = source
+}
+
+func (o *Options) Source(name string) string {
+	if source, ok := o.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+func (o *Options) AddListener(p *ListenerPolicy) {
+	o.listeners = append(o.listeners, p)
+}
+
+func (o *Options) Listeners() []*ListenerPolicy {
+	return o.listeners
+}
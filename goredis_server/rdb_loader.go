@@ -0,0 +1,199 @@
+package goredis_server
+
+// 把redis的RDB文件导入levelredis，用于从redis迁移
+// string/hash/set/zset通过BulkWriter按批提交，list按块RPush
+// RDB里的key覆盖已有的同名key，已过期的key跳过，过期时间在每批提交之后设置
+// 直接写入db，不经过指令处理；设置了SetSyncLog时，每个key同时改写为DEL/SET/HSET/SADD/ZADD/RPUSH/PEXPIREAT交给同步日志
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/rdb"
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	rdbLoadBatch     = 10000 // 每批提交的记录数
+	rdbLoadListChunk = 1000  // list每次RPush的元素数
+)
+
+type rdbExpiry struct {
+	key []byte
+	at  int64
+}
+
+type RDBLoader struct {
+	rdb.NopDecoder
+	dbs      []*levelredis.LevelRedis
+	db       *levelredis.LevelRedis
+	index    int
+	synclog  func(index int, cmd *Command) // nil时不写同步日志
+	w        *levelredis.BulkWriter
+	now      int64
+	skip     bool        // 当前key已过期
+	list     [][]byte    // 当前list未提交的元素
+	expiries []rdbExpiry // 当前批次的过期时间
+	keys     int
+	err      error // 第一次错误，之后的数据全部忽略
+}
+
+func NewRDBLoader(dbs []*levelredis.LevelRedis) (l *RDBLoader) {
+	return &RDBLoader{dbs: dbs, now: nowMillis()}
+}
+
+// 导入的数据按指令交给fn写入同步日志，从库和AOF可以重放
+func (l *RDBLoader) SetSyncLog(fn func(index int, cmd *Command)) {
+	l.synclog = fn
+}
+
+// 导入的key数
+func (l *RDBLoader) Keys() int {
+	return l.keys
+}
+
+func (l *RDBLoader) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = rdb.Decode(bufio.NewReaderSize(f, 1024*1024), l); err != nil {
+		return err
+	}
+	return l.err
+}
+
+func (l *RDBLoader) StartDatabase(n int) {
+	if l.err != nil {
+		return
+	}
+	if n < 0 || n >= len(l.dbs) {
+		l.err = fmt.Errorf("rdb contains db %d, but only %d databases configured", n, len(l.dbs))
+		return
+	}
+	l.db, l.index = l.dbs[n], n
+	l.w = l.db.NewBulkWriter()
+}
+
+func (l *RDBLoader) EndDatabase(n int) {
+	l.flush()
+}
+
+// 新的key开始，删除已有的同名key
+func (l *RDBLoader) startKey(key []byte, expiry int64) bool {
+	l.skip = l.err != nil || (expiry > 0 && expiry <= l.now)
+	if l.skip {
+		return false
+	}
+	l.db.Delete(key)
+	l.log("DEL", key)
+	if expiry > 0 {
+		l.expiries = append(l.expiries, rdbExpiry{key, expiry})
+	}
+	l.keys++
+	return true
+}
+
+func (l *RDBLoader) check(err error) {
+	if l.err == nil && err != nil {
+		l.err = err
+	}
+	if l.err == nil && l.w.Len() >= rdbLoadBatch {
+		l.flush()
+	}
+}
+
+// 提交当前批次，再设置过期时间
+func (l *RDBLoader) flush() {
+	if l.err != nil || l.w == nil {
+		return
+	}
+	if _, l.err = l.w.Flush(); l.err != nil {
+		return
+	}
+	for _, e := range l.expiries {
+		if l.err = l.db.SetExpireAt(e.key, e.at); l.err != nil {
+			return
+		}
+		l.log("PEXPIREAT", e.key, []byte(strconv.FormatInt(e.at, 10)))
+	}
+	l.expiries = l.expiries[:0]
+}
+
+func (l *RDBLoader) Set(key, value []byte, expiry int64) {
+	if l.startKey(key, expiry) {
+		l.log("SET", key, value)
+		l.check(l.w.Set(key, value))
+	}
+}
+
+func (l *RDBLoader) StartHash(key []byte, length, expiry int64) {
+	l.startKey(key, expiry)
+}
+
+func (l *RDBLoader) Hset(key, field, value []byte) {
+	if !l.skip {
+		l.log("HSET", key, field, value)
+		l.check(l.w.HSet(key, field, value))
+	}
+}
+
+func (l *RDBLoader) StartSet(key []byte, cardinality, expiry int64) {
+	l.startKey(key, expiry)
+}
+
+func (l *RDBLoader) Sadd(key, member []byte) {
+	if !l.skip {
+		l.log("SADD", key, member)
+		l.check(l.w.SAdd(key, member))
+	}
+}
+
+func (l *RDBLoader) StartZSet(key []byte, cardinality, expiry int64) {
+	l.startKey(key, expiry)
+}
+
+func (l *RDBLoader) Zadd(key []byte, score float64, member []byte) {
+	if !l.skip {
+		l.log("ZADD", key, []byte(strconv.FormatFloat(score, 'f', -1, 64)), member)
+		l.check(l.w.ZAdd(key, score, member))
+	}
+}
+
+func (l *RDBLoader) StartList(key []byte, length, expiry int64) {
+	l.startKey(key, expiry)
+	l.list = l.list[:0]
+}
+
+func (l *RDBLoader) Rpush(key, value []byte) {
+	if l.skip {
+		return
+	}
+	l.list = append(l.list, value)
+	if len(l.list) >= rdbLoadListChunk {
+		l.pushList(key)
+	}
+}
+
+func (l *RDBLoader) EndList(key []byte) {
+	if !l.skip {
+		l.pushList(key)
+	}
+}
+
+func (l *RDBLoader) pushList(key []byte) {
+	if l.err != nil || len(l.list) == 0 {
+		return
+	}
+	l.log("RPUSH", append([][]byte{key}, l.list...)...)
+	l.check(l.db.GetList(string(key)).RPush(l.list...))
+	l.list = l.list[:0]
+}
+
+func (l *RDBLoader) log(name string, args ...[]byte) {
+	if l.synclog != nil && l.err == nil {
+		l.synclog(l.index, NewCommand(append([][]byte{[]byte(name)}, args...)...))
+	}
+}
@@ -0,0 +1,130 @@
+package goredis_server
+
+// 把levelredis的数据写成redis可以加载的RDB文件(版本6)
+// RDB需要先写入集合的元素数量，集合先遍历一次计数，再遍历一次写入，
+// 只能在快照上使用，两次遍历的结果才一致，也不需要把集合读入内存
+import (
+	"GoRedis/libs/levelredis"
+	"GoRedis/libs/rdb"
+	"io"
+)
+
+type RDBWriter struct {
+	w   io.Writer
+	e   *rdb.Encoder
+	err error // 第一次写入错误，之后的写入全部忽略
+}
+
+func NewRDBWriter(w io.Writer) (r *RDBWriter) {
+	r = &RDBWriter{w: w}
+	r.e = rdb.NewEncoder(r)
+	return
+}
+
+func (r *RDBWriter) Write(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, r.err = r.w.Write(p)
+	return n, r.err
+}
+
+func (r *RDBWriter) Err() error {
+	return r.err
+}
+
+func (r *RDBWriter) WriteHeader() error {
+	r.e.EncodeHeader()
+	return r.err
+}
+
+func (r *RDBWriter) SelectDB(n int) {
+	r.e.EncodeDatabase(n)
+}
+
+// 写入EOF和CRC64
+func (r *RDBWriter) Close() error {
+	r.e.EncodeFooter()
+	return r.err
+}
+
+// 过期时间(毫秒)、类型和key，at为-1时不过期
+// 没有元素的集合不写入，redis加载时会跳过或报错
+func (r *RDBWriter) writeKey(key []byte, typ rdb.ValueType, at int64) {
+	if at != -1 {
+		r.e.EncodeExpiry(uint64(at))
+	}
+	r.e.EncodeType(typ)
+	r.e.EncodeString(key)
+}
+
+func (r *RDBWriter) AppendString(key, value []byte, at int64) {
+	r.writeKey(key, rdb.TypeString, at)
+	r.e.EncodeString(value)
+}
+
+func (r *RDBWriter) AppendHash(h *levelredis.LevelHash, at int64) {
+	n := 0
+	h.Enumerate(func(i int, field, value []byte, quit *bool) {
+		n++
+	})
+	if n == 0 {
+		return
+	}
+	r.writeKey([]byte(h.Key()), rdb.TypeHash, at)
+	r.e.EncodeLength(uint32(n))
+	h.Enumerate(func(i int, field, value []byte, quit *bool) {
+		r.e.EncodeString(field)
+		r.e.EncodeString(value)
+		*quit = r.err != nil
+	})
+}
+
+func (r *RDBWriter) AppendList(l *levelredis.LevelList, at int64) {
+	n := 0
+	l.Enumerate(func(i int, value []byte, quit *bool) {
+		n++
+	})
+	if n == 0 {
+		return
+	}
+	r.writeKey([]byte(l.Key()), rdb.TypeList, at)
+	r.e.EncodeLength(uint32(n))
+	l.Enumerate(func(i int, value []byte, quit *bool) {
+		r.e.EncodeString(value)
+		*quit = r.err != nil
+	})
+}
+
+func (r *RDBWriter) AppendSet(s *levelredis.LevelSet, at int64) {
+	n := 0
+	s.Enumerate(func(i int, member []byte, quit *bool) {
+		n++
+	})
+	if n == 0 {
+		return
+	}
+	r.writeKey([]byte(s.Key()), rdb.TypeSet, at)
+	r.e.EncodeLength(uint32(n))
+	s.Enumerate(func(i int, member []byte, quit *bool) {
+		r.e.EncodeString(member)
+		*quit = r.err != nil
+	})
+}
+
+func (r *RDBWriter) AppendZSet(z *levelredis.LevelZSet, at int64) {
+	n := 0
+	z.Enumerate(func(i int, member, score []byte, quit *bool) {
+		n++
+	})
+	if n == 0 {
+		return
+	}
+	r.writeKey([]byte(z.Key()), rdb.TypeZSet, at)
+	r.e.EncodeLength(uint32(n))
+	z.Enumerate(func(i int, member, score []byte, quit *bool) {
+		r.e.EncodeString(member)
+		r.e.EncodeFloat(levelredis.BytesToFloat64(score))
+		*quit = r.err != nil
+	})
+}
@@ -0,0 +1,330 @@
+package goredis_server
+
+// 兼容redis.conf格式的配置文件，可以沿用已有的部署工具
+// 每行一条指令，支持#注释、单双引号参数、include和1k/1kb/1gb等单位，后出现的指令覆盖前面的
+// 已知指令映射为启动参数或运行期配置(CONFIG SET可修改的项)，其它指令只输出警告
+// 配置分层: 默认值 < 配置文件 < 环境变量 < 命令行参数，每项记录生效的来源
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const confMaxIncludeDepth = 10
+
+// 设置项的来源
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceRuntime = "config-set" // 运行期间CONFIG SET修改，包括上一次运行时修改并保存的值
+)
+
+// 环境变量前缀，GOREDIS_SLAVE_MAX_LAG 对应指令 slave-max-lag
+const confEnvPrefix = "GOREDIS_"
+
+// 启动参数，按CONFIG GET输出的顺序
+var confStartupKeys = []string{"bind", "port", "dbpath", "logpath", "slaveof", "warmup", "databases", "loadrdb", "appendonly", "restore", "restore-until"}
+
+// 运行期配置，启动时写入config，值为数字的允许使用单位
+var confRuntimeKeys = map[string]bool{
+	slaveMaxLagKey:              true,
+	replicaReadOnlyKey:          false,
+	largeCollectionThresholdKey: true,
+	largeCollectionActionKey:    false,
+	slowlogPersistKey:           false,
+	slowlogMaxLenKey:            true,
+	statsPersistKey:             false,
+	docHistoryLenKey:            true,
+	appendFsyncKey:              false,
+}
+
+// 参数数量固定的指令，包括指令本身
+var confArgCount = map[string]int{
+	"port": 2, "dir": 2, "dbpath": 2, "logpath": 2, "warmup": 2, "databases": 2, "loadrdb": 2, "appendonly": 2, "restore": 2, "restore-until": 2, "slaveof": 3, "replicaof": 3,
+}
+
+// 读取配置文件写入opt，返回不支持的指令等警告
+func LoadConfFile(filename string, opt *Options) (warnings []string, err error) {
+	warnings = make([]string, 0)
+	err = parseConfFile(filename, 0, func(pos string, args []string) error {
+		warning, err := opt.ApplyDirective(args, SourceFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", pos, err)
+		}
+		if len(warning) > 0 {
+			warnings = append(warnings, pos+": "+warning)
+		}
+		return nil
+	})
+	return
+}
+
+// 读取GOREDIS_开头的环境变量，值的格式与配置文件中指令的参数相同；
+// 不是已知指令的变量直接忽略，比如 -encryptkey env:GOREDIS_KEYS 使用的变量
+func LoadConfEnv(environ []string, opt *Options) (err error) {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, confEnvPrefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.Replace(kv[len(confEnvPrefix):i], "_", "-", -1))
+		if _, ok := confRuntimeKeys[name]; !ok && name != "bind" && confArgCount[name] == 0 {
+			continue
+		}
+		args, e := splitConfLine(kv[i+1:])
+		if e != nil {
+			return fmt.Errorf("%s: %s", kv[:i], e)
+		}
+		if _, e := opt.ApplyDirective(append([]string{name}, args...), SourceEnv); e != nil {
+			return fmt.Errorf("%s: %s", kv[:i], e)
+		}
+	}
+	return
+}
+
+// 执行一条配置指令，args[0]为指令名，source记录为生效的来源
+func (opt *Options) ApplyDirective(args []string, source string) (warning string, err error) {
+	name := strings.ToLower(args[0])
+	if n, ok := confArgCount[name]; len(args) < 2 || (ok && len(args) != n) {
+		return "", errors.New("wrong number of arguments for " + name)
+	}
+	switch name {
+	case "bind":
+		opt.SetHost(args[1])
+		opt.SetSource(name, source)
+		if len(args) > 2 {
+			warning = "bind only uses the first address " + args[1]
+		}
+	case "port":
+		port, e := strconv.Atoi(args[1])
+		if e != nil || port <= 0 || port > 65535 {
+			return "", errors.New("invalid port " + args[1])
+		}
+		opt.SetPort(port)
+		opt.SetSource(name, source)
+	case "dir":
+		// redis的dir同时存放数据和日志
+		opt.SetDBPath(args[1])
+		opt.SetLogPath(args[1])
+		opt.SetSource("dbpath", source)
+		opt.SetSource("logpath", source)
+	case "dbpath":
+		opt.SetDBPath(args[1])
+		opt.SetSource(name, source)
+	case "logpath":
+		opt.SetLogPath(args[1])
+		opt.SetSource(name, source)
+	case "slaveof", "replicaof":
+		if strings.ToLower(args[1]) == "no" && strings.ToLower(args[2]) == "one" {
+			opt.SetSlaveOf("", 0)
+		} else {
+			port, e := strconv.Atoi(args[2])
+			if e != nil {
+				return "", errors.New("invalid master port " + args[2])
+			}
+			opt.SetSlaveOf(args[1], port)
+		}
+		opt.SetSource("slaveof", source)
+	case "listen":
+		// 可以出现多次，每次增加一个listener
+		p, e := ParseListenerPolicy(args[1:])
+		if e != nil {
+			return "", e
+		}
+		opt.AddListener(p)
+		opt.SetSource(name, source)
+	case "warmup":
+		if args[1] != "meta" && args[1] != "full" {
+			return "", errors.New("warmup must be meta or full")
+		}
+		opt.SetWarmUp(args[1])
+		opt.SetSource(name, source)
+	case "databases":
+		n, e := strconv.Atoi(args[1])
+		if e != nil || n <= 0 {
+			return "", errors.New("invalid databases " + args[1])
+		}
+		opt.SetDatabases(n)
+		opt.SetSource(name, source)
+	case "loadrdb":
+		opt.SetLoadRDB(args[1])
+		opt.SetSource(name, source)
+	case "appendonly":
+		if args[1] != "yes" && args[1] != "no" {
+			return "", errors.New("appendonly must be yes or no")
+		}
+		opt.SetAppendOnly(args[1] == "yes")
+		opt.SetSource(name, source)
+	case "restore":
+		opt.SetRestore(args[1], opt.RestoreUntil())
+		opt.SetSource(name, source)
+	case "restore-until":
+		until, e := strconv.ParseInt(args[1], 10, 64)
+		if e != nil || until <= 0 {
+			return "", errors.New("invalid restore-until " + args[1])
+		}
+		opt.SetRestore(opt.Restore(), until)
+		opt.SetSource(name, source)
+	default:
+		numeric, ok := confRuntimeKeys[name]
+		if !ok {
+			return "unsupported directive " + args[0], nil
+		}
+		if len(args) != 2 {
+			return "", errors.New("wrong number of arguments for " + name)
+		}
+		value := args[1]
+		if numeric {
+			n, e := parseConfNumber(value)
+			if e != nil {
+				return "", e
+			}
+			value = strconv.FormatInt(n, 10)
+		}
+		opt.SetConfig(name, value)
+		opt.SetSource(name, source)
+	}
+	return
+}
+
+// 逐行解析，fn的pos参数为"文件名:行号"
+func parseConfFile(filename string, depth int, fn func(pos string, args []string) error) (err error) {
+	if depth > confMaxIncludeDepth {
+		return errors.New("too many nested includes: " + filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		pos := fmt.Sprintf("%s:%d", filename, lineno)
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		args, e := splitConfLine(line)
+		if e != nil {
+			return fmt.Errorf("%s: %s", pos, e)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.ToLower(args[0]) != "include" {
+			if err = fn(pos, args); err != nil {
+				return
+			}
+			continue
+		}
+		// 与redis一致，相对路径基于当前工作目录，支持通配符
+		if len(args) != 2 {
+			return fmt.Errorf("%s: wrong number of arguments for include", pos)
+		}
+		files, e := filepath.Glob(args[1])
+		if e != nil {
+			return fmt.Errorf("%s: %s", pos, e)
+		}
+		if len(files) == 0 {
+			files = []string{args[1]}
+		}
+		for _, name := range files {
+			if err = parseConfFile(name, depth+1, fn); err != nil {
+				return
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// 按空白分隔参数，支持"..."(可使用\n \t \" \\ \xhh转义)和'...'
+func splitConfLine(line string) (args []string, err error) {
+	args = make([]string, 0, 4)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		arg := make([]byte, 0, 16)
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for ; i < len(line) && line[i] != quote; i++ {
+				if quote == '"' && line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 't':
+						arg = append(arg, '\t')
+					case 'r':
+						arg = append(arg, '\r')
+					case 'x':
+						if i+2 >= len(line) {
+							return nil, errors.New("bad \\x escape")
+						}
+						b, e := strconv.ParseUint(line[i+1:i+3], 16, 8)
+						if e != nil {
+							return nil, errors.New("bad \\x escape")
+						}
+						arg = append(arg, byte(b))
+						i += 2
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if quote == '\'' && line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else {
+					arg = append(arg, line[i])
+				}
+			}
+			if i >= len(line) {
+				return nil, errors.New("unbalanced quotes")
+			}
+			i++
+			// 引号之后必须是空白或行尾
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, errors.New("closing quote must be followed by a space")
+			}
+		default:
+			for ; i < len(line) && line[i] != ' ' && line[i] != '\t'; i++ {
+				arg = append(arg, line[i])
+			}
+		}
+		args = append(args, string(arg))
+	}
+	return
+}
+
+// 与redis一致，k/m/g为1000的倍数，kb/mb/gb为1024的倍数，不区分大小写
+func parseConfNumber(s string) (n int64, err error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	}
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mul = lower[:len(lower)-len(u.suffix)], u.mul
+			break
+		}
+	}
+	if n, err = strconv.ParseInt(lower, 10, 64); err != nil {
+		return 0, errors.New("invalid number " + s)
+	}
+	return n * mul, nil
+}
@@ -0,0 +1,176 @@
+package goredis_server
+
+// *SCAN指令共用的游标和参数处理
+// 游标是上一批最后一个key的字节，编码成十进制数字，兼容把游标当作整数处理的客户端
+// "0"表示开始和结束
+import (
+	. "GoRedis/goredis"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var BadCursorError = errors.New("invalid cursor")
+
+const (
+	scanDefaultCount = 10
+	scanMaxCount     = 10000
+)
+
+// 首字节固定为1，避免key的前导0字节在转换为整数时丢失
+func encodeScanCursor(key []byte) string {
+	if key == nil {
+		return "0"
+	}
+	b := make([]byte, len(key)+1)
+	b[0] = 1
+	copy(b[1:], key)
+	return new(big.Int).SetBytes(b).String()
+}
+
+func decodeScanCursor(cursor string) (key []byte, err error) {
+	if cursor == "0" {
+		return nil, nil
+	}
+	n, ok := new(big.Int).SetString(cursor, 10)
+	if !ok || n.Sign() <= 0 {
+		return nil, BadCursorError
+	}
+	b := n.Bytes()
+	if b[0] != 1 {
+		return nil, BadCursorError
+	}
+	return b[1:], nil
+}
+
+type scanArgs struct {
+	cursor []byte
+	match  string // 为空表示不过滤
+	count  int
+}
+
+// 解析 cursor [MATCH pattern] [COUNT count]，cursor位于idx
+func parseScanArgs(cmd *Command, idx int) (args *scanArgs, err error) {
+	args = &scanArgs{count: scanDefaultCount}
+	if args.cursor, err = decodeScanCursor(cmd.StringAtIndex(idx)); err != nil {
+		return
+	}
+	for i := idx + 1; i < cmd.Len(); i += 2 {
+		if i+1 >= cmd.Len() {
+			return nil, BadCommandError
+		}
+		switch strings.ToUpper(cmd.StringAtIndex(i)) {
+		case "MATCH":
+			args.match = cmd.StringAtIndex(i + 1)
+			if args.match == "*" {
+				args.match = ""
+			}
+		case "COUNT":
+			if args.count, err = cmd.IntAtIndex(i + 1); err != nil {
+				return
+			}
+			if args.count < 1 || args.count > scanMaxCount {
+				return nil, errors.New("count range: 1 <= count <= 10000")
+			}
+		default:
+			return nil, BadCommandError
+		}
+	}
+	return
+}
+
+func (s *scanArgs) Match(b []byte) bool {
+	return len(s.match) == 0 || globMatch(s.match, string(b))
+}
+
+// 返回 [cursor, [elems...]]
+func scanReply(next []byte, elems []interface{}) *Reply {
+	if elems == nil {
+		elems = []interface{}{}
+	}
+	return MultiBulksReply([]interface{}{encodeScanCursor(next), elems})
+}
+
+// pattern中第一个通配符之前的固定前缀，用于缩小扫描范围
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i != -1 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// redis风格的glob匹配，支持 * ? [abc] [^a-z] 和 \ 转义
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+				} else if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == s[0] {
+					matched = true
+				}
+				pattern = pattern[1:]
+			}
+			if matched == not {
+				return false
+			}
+			s = s[1:]
+			if len(pattern) == 0 {
+				// 缺少']'，与redis一致视为匹配到结尾
+				return len(s) == 0
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
@@ -10,24 +10,40 @@ import (
 	"GoRedis/libs/stdlog"
 	"bufio"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var slavelog = stdlog.Log("slaveof")
 
+// 快照指令的并发数，同一个key的指令由同一个worker按顺序执行，list分块RPUSH不会乱序
+const rdbWorkers = 10
+
+type rdbJob struct {
+	db  int
+	cmd *Command
+}
+
 type SlaveClient struct {
 	ISlaveClient
 	session  *Session
 	server   *GoRedisServer
 	buffer   chan *Command // 缓存实时指令
-	rdbjobs  chan int      // 并发工作
+	rdbjobs  []chan rdbJob // 按key分配给worker的快照指令
 	wg       sync.WaitGroup
 	broken   bool // 无效连接
 	counters *counter.Counters
 	synclog  *stat.Writer
+	replid   string     // PSYNC，主库的replid，不支持PSYNC时为空
+	offset   int64      // 已经执行完的复制偏移量，atomic
+	writeMu  sync.Mutex // ACK在不同的goroutine发送
 }
 
 func NewSlaveClient(server *GoRedisServer, session *Session) (s *SlaveClient, err error) {
@@ -35,7 +51,6 @@ func NewSlaveClient(server *GoRedisServer, session *Session) (s *SlaveClient, er
 	s.server = server
 	s.session = session
 	s.buffer = make(chan *Command, 1000*10000)
-	s.rdbjobs = make(chan int, 10)
 	s.counters = counter.NewCounters()
 	os.Mkdir(s.directory(), os.ModePerm)
 	err = s.initLog()
@@ -73,19 +88,34 @@ func (s *SlaveClient) rdbfilename() string {
 
 // 开始同步
 func (s *SlaveClient) Sync() (err error) {
-
-	err = s.session.WriteCommand(NewCommand([]byte("SYNC")))
-	if err != nil {
+	// 主库的INFO里显示从库的端口，redis 2.8之前返回错误，忽略
+	if err = s.session.WriteCommand(NewCommand(formatByteSlice("REPLCONF", "listening-port", s.server.opt.Port())...)); err != nil {
+		return
+	}
+	if _, err = s.session.ReadReply(); err != nil {
 		return
 	}
 
-	rdbsaved := false
+	var partial bool
+	if partial, err = s.psync(); err != nil {
+		return
+	}
+	if len(s.replid) > 0 {
+		go s.ackLoop()
+	}
+	// 部分同步没有RDB，直接执行实时指令
+	rdbsaved := partial
+	if partial {
+		s.session.SetAttribute(S_STATUS, REPL_ONLINE)
+		go s.recvCmd()
+	}
 	for {
 		var c byte
 		c, err = s.session.PeekByte()
 		if err != nil {
 			break
 		}
+		s.session.SetAttribute(S_LAST_RECV, time.Now())
 		if !rdbsaved && c == '$' {
 			s.session.SetAttribute(S_STATUS, REPL_RECV_BULK)
 			err = s.recvRdb()
@@ -131,10 +161,113 @@ func (s *SlaveClient) recvCmd() {
 			break
 		}
 		s.counters.Get("proc").Incr(1)
-		s.server.On(s.session, cmd)
+		// REPLCONF GETACK *，主库执行WAIT或者检查从库时要求立即确认
+		if cmd.Name() == "REPLCONF" && strings.ToUpper(cmd.StringAtIndex(1)) == "GETACK" {
+			s.sendAck()
+		} else {
+			s.server.On(s.session, cmd)
+		}
+		if len(s.replid) > 0 {
+			atomic.AddInt64(&s.offset, commandSize(cmd))
+			s.savePsync()
+		}
+	}
+}
+
+// 主库发送的指令在复制流里的字节数，与主库计算offset的方式一致
+func commandSize(cmd *Command) (n int64) {
+	n = int64(len(strconv.Itoa(cmd.Len())) + 3) // *N\r\n
+	for _, arg := range cmd.Args() {
+		n += int64(len(strconv.Itoa(len(arg))) + 3 + len(arg) + 2) // $len\r\n arg\r\n
+	}
+	return
+}
+
+func psyncKey(host string) string {
+	return "master:" + host + ":psync"
+}
+
+// PSYNC replid offset，主库返回+CONTINUE时从保存的位置继续，不需要传输RDB；
+// +FULLRESYNC时记下新的replid和offset，RDB载入完成之前不保存；
+// 不支持PSYNC的主库(redis 2.8之前)改用SYNC，这时不需要发送ACK
+func (s *SlaveClient) psync() (partial bool, err error) {
+	host := s.session.RemoteAddr().String()
+	replid, offset, db := "?", int64(-1), 0
+	if promoted, diverged := s.server.promotedDiverged(host); diverged {
+		slavelog.Printf("[M %s] written after promoted, full resync\n", host)
+	} else {
+		if promoted {
+			s.server.config.Set(promotedKey(host), nil)
+		}
+		// replid offset db，db是执行到offset时主库连接SELECT的db
+		if fields := strings.Fields(s.server.config.StringForKey(psyncKey(host))); len(fields) == 3 {
+			n, e1 := strconv.ParseInt(fields[1], 10, 64)
+			d, e2 := strconv.Atoi(fields[2])
+			if e1 == nil && e2 == nil {
+				replid, offset, db = fields[0], n, d
+			}
+		}
+	}
+	if err = s.session.WriteCommand(NewCommand(formatByteSlice("PSYNC", replid, offset+1)...)); err != nil {
+		return
+	}
+	var reply *Reply
+	if reply, err = s.session.ReadReply(); err != nil {
+		return
+	}
+	status, _ := reply.Value.(string)
+	fields := strings.Fields(status)
+	switch {
+	case reply.Type == ReplyTypeError:
+		slavelog.Printf("[M %s] PSYNC not supported(%s), use SYNC\n", host, status)
+		err = s.session.WriteCommand(NewCommand([]byte("SYNC")))
+	case len(fields) > 0 && fields[0] == "CONTINUE":
+		// redis 4.0之后主库切换时replid可能变化
+		if len(fields) > 1 {
+			replid = fields[1]
+		}
+		slavelog.Printf("[M %s] partial resync from offset %d\n", host, offset+1)
+		s.replid, s.offset, partial = replid, offset, true
+		s.session.SetAttribute(S_DB, db)
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		// RDB载入完成之前断开时，下一次仍然需要全量同步
+		s.server.config.Set(psyncKey(host), nil)
+		if s.offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return
+		}
+		s.replid = fields[1]
+		slavelog.Printf("[M %s] full resync %s %d\n", host, s.replid, s.offset)
+	default:
+		err = fmt.Errorf("bad PSYNC reply %s", status)
+	}
+	return
+}
+
+// 保存执行到的位置，重连或者重启后用于PSYNC
+func (s *SlaveClient) savePsync() {
+	db, _ := s.session.GetAttribute(S_DB).(int)
+	value := fmt.Sprintf("%s %d %d", s.replid, atomic.LoadInt64(&s.offset), db)
+	s.server.config.Set(psyncKey(s.session.RemoteAddr().String()), []byte(value))
+}
+
+// PSYNC的从库需要每秒发送REPLCONF ACK [offset]，否则主库在repl-timeout之后断开
+// RDB传输和载入期间也要发送，这时主库忽略offset
+func (s *SlaveClient) ackLoop() {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.broken || s.sendAck() != nil {
+			return
+		}
 	}
 }
 
+func (s *SlaveClient) sendAck() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.session.WriteCommand(NewCommand(formatByteSlice("REPLCONF", "ACK", atomic.LoadInt64(&s.offset))...))
+}
+
 func (s *SlaveClient) recvRdb() (err error) {
 	var f *os.File
 	f, err = os.OpenFile(s.rdbfilename(), os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.ModePerm)
@@ -186,33 +319,78 @@ func (s *SlaveClient) RdbSizeCallback(totalsize int64) {
 
 func (s *SlaveClient) RdbRecvFinishCallback(r *bufio.Reader) {
 	slavelog.Printf("[M %s] rdb recv finish, start decoding... \n", s.session.RemoteAddr())
+	// 全量同步之后与主库一致，从库上主库没有的key也要删除
+	begin := time.Now()
+	if _, err := s.server.flushDBs(s.server.databases()); err != nil {
+		slavelog.Printf("[M %s] flushall before full sync error %s\n", s.session.RemoteAddr(), err)
+		s.Close()
+		return
+	}
+	slavelog.Printf("[M %s] flushall before full sync, %s\n", s.session.RemoteAddr(), time.Since(begin))
 	// decode
+	s.startRdbWorkers()
 	dec := newRdbDecoder(s)
 	err := rdb.Decode(r, dec)
 	if err != nil {
 		// must cancel
 		slavelog.Printf("[M %s] decode error %s\n", s.session.RemoteAddr(), err)
+		s.stopRdbWorkers()
 		s.Close()
 	}
 	return
 }
 
-func (s *SlaveClient) rdbDecodeCommand(cmd *Command) {
+func (s *SlaveClient) startRdbWorkers() {
+	s.rdbjobs = make([]chan rdbJob, rdbWorkers)
+	for i := range s.rdbjobs {
+		s.rdbjobs[i] = make(chan rdbJob, 1000)
+		s.wg.Add(1)
+		go s.rdbWorker(s.rdbjobs[i])
+	}
+}
+
+// 每个db使用一个内部会话，与主库连接上的SELECT互不影响
+func (s *SlaveClient) rdbWorker(jobs chan rdbJob) {
+	defer s.wg.Done()
+	sessions := make(map[int]*Session)
+	for job := range jobs {
+		session, ok := sessions[job.db]
+		if !ok {
+			conn, _ := net.Pipe()
+			session = NewSession(conn)
+			session.SetAttribute(S_DB, job.db)
+			session.SetAttribute(S_STATUS, REPL_RECV_BULK) // 不受CLIENT PAUSE影响
+			sessions[job.db] = session
+		}
+		s.server.On(session, job.cmd)
+	}
+}
+
+// 等待已经分配的指令执行完
+func (s *SlaveClient) stopRdbWorkers() {
+	for _, jobs := range s.rdbjobs {
+		close(jobs)
+	}
+	s.rdbjobs = nil
+	s.wg.Wait()
+}
+
+func (s *SlaveClient) rdbDecodeCommand(db int, cmd *Command) {
 	// slavelog.Printf("[M %s] rdb decode %s\n", client.RemoteAddr(), cmd)
 	s.counters.Get("rdb").Incr(1)
-	s.rdbjobs <- 1
-	s.wg.Add(1)
-	go func() {
-		s.server.On(s.session, cmd)
-		<-s.rdbjobs
-		s.wg.Done()
-	}()
+	h := fnv.New32a()
+	h.Write(cmd.Args()[1])
+	s.rdbjobs[h.Sum32()%rdbWorkers] <- rdbJob{db, cmd}
 }
 
 func (s *SlaveClient) rdbDecodeFinish(n int64) {
 	slavelog.Printf("[M %s] rdb decode finish, items: %d\n", s.session.RemoteAddr(), n)
+	s.stopRdbWorkers()
+	if len(s.replid) > 0 {
+		s.savePsync()
+		s.server.config.Set(promotedKey(s.session.RemoteAddr().String()), nil)
+	}
 	s.session.SetAttribute(S_STATUS, REPL_ONLINE)
-	s.wg.Wait()
 	go s.recvCmd() // 开始消化command
 }
 
@@ -239,6 +417,7 @@ type rdbDecoder struct {
 	i        int
 	keyCount int64
 	bufsize  int
+	expiry   int64 // 当前key的过期时间，毫秒
 	client   *SlaveClient
 	// 数据缓冲
 	hashEntry [][]byte
@@ -257,6 +436,27 @@ func newRdbDecoder(s *SlaveClient) (dec *rdbDecoder) {
 
 func (p *rdbDecoder) StartDatabase(n int) {
 	p.db = n
+	if n >= p.client.server.dbCount() {
+		slavelog.Printf("[M %s] rdb db%d out of range, keys skipped, increase databases\n", p.client.session.RemoteAddr(), n)
+	}
+}
+
+// 开始一个key，载入RDB之前已经清空从库
+func (p *rdbDecoder) startKey(key []byte, expiry int64) {
+	p.keyCount++
+	p.expiry = expiry
+}
+
+func (p *rdbDecoder) endKey(key []byte) {
+	if p.expiry > 0 {
+		p.send(NewCommand([]byte("PEXPIREAT"), key, []byte(strconv.FormatInt(p.expiry, 10))))
+	}
+}
+
+func (p *rdbDecoder) send(cmd *Command) {
+	if p.db < p.client.server.dbCount() {
+		p.client.rdbDecodeCommand(p.db, cmd)
+	}
 }
 
 func (p *rdbDecoder) EndDatabase(n int) {
@@ -268,9 +468,10 @@ func (p *rdbDecoder) EndRDB() {
 
 // Set
 func (p *rdbDecoder) Set(key, value []byte, expiry int64) {
-	cmd := NewCommand([]byte("SET"), key, value)
-	p.client.rdbDecodeCommand(cmd)
 	p.keyCount++
+	p.expiry = expiry
+	p.send(NewCommand([]byte("SET"), key, value))
+	p.endKey(key)
 }
 
 func (p *rdbDecoder) StartHash(key []byte, length, expiry int64) {
@@ -281,7 +482,7 @@ func (p *rdbDecoder) StartHash(key []byte, length, expiry int64) {
 	}
 	p.hashEntry = append(p.hashEntry, []byte("HMSET"))
 	p.hashEntry = append(p.hashEntry, key)
-	p.keyCount++
+	p.startKey(key, expiry)
 }
 
 func (p *rdbDecoder) Hset(key, field, value []byte) {
@@ -289,7 +490,7 @@ func (p *rdbDecoder) Hset(key, field, value []byte) {
 	p.hashEntry = append(p.hashEntry, value)
 	if len(p.hashEntry) >= p.bufsize {
 		cmd := NewCommand(p.hashEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 		p.hashEntry = make([][]byte, 0, p.bufsize)
 		p.hashEntry = append(p.hashEntry, []byte("HMSET"))
 		p.hashEntry = append(p.hashEntry, key)
@@ -300,8 +501,9 @@ func (p *rdbDecoder) Hset(key, field, value []byte) {
 func (p *rdbDecoder) EndHash(key []byte) {
 	if len(p.hashEntry) > 2 {
 		cmd := NewCommand(p.hashEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 	}
+	p.endKey(key)
 }
 
 func (p *rdbDecoder) StartSet(key []byte, cardinality, expiry int64) {
@@ -312,14 +514,14 @@ func (p *rdbDecoder) StartSet(key []byte, cardinality, expiry int64) {
 	}
 	p.setEntry = append(p.setEntry, []byte("SADD"))
 	p.setEntry = append(p.setEntry, key)
-	p.keyCount++
+	p.startKey(key, expiry)
 }
 
 func (p *rdbDecoder) Sadd(key, member []byte) {
 	p.setEntry = append(p.setEntry, member)
 	if len(p.setEntry) >= p.bufsize {
 		cmd := NewCommand(p.setEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 		p.setEntry = make([][]byte, 0, p.bufsize)
 		p.setEntry = append(p.setEntry, []byte("SADD"))
 		p.setEntry = append(p.setEntry, key)
@@ -330,8 +532,9 @@ func (p *rdbDecoder) Sadd(key, member []byte) {
 func (p *rdbDecoder) EndSet(key []byte) {
 	if len(p.setEntry) > 2 {
 		cmd := NewCommand(p.setEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 	}
+	p.endKey(key)
 }
 
 func (p *rdbDecoder) StartList(key []byte, length, expiry int64) {
@@ -342,7 +545,7 @@ func (p *rdbDecoder) StartList(key []byte, length, expiry int64) {
 	}
 	p.listEntry = append(p.listEntry, []byte("RPUSH"))
 	p.listEntry = append(p.listEntry, key)
-	p.keyCount++
+	p.startKey(key, expiry)
 	p.i = 0
 }
 
@@ -350,7 +553,7 @@ func (p *rdbDecoder) Rpush(key, value []byte) {
 	p.listEntry = append(p.listEntry, value)
 	if len(p.listEntry) >= p.bufsize {
 		cmd := NewCommand(p.listEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 		p.listEntry = make([][]byte, 0, p.bufsize)
 		p.listEntry = append(p.listEntry, []byte("RPUSH"))
 		p.listEntry = append(p.listEntry, key)
@@ -362,8 +565,9 @@ func (p *rdbDecoder) Rpush(key, value []byte) {
 func (p *rdbDecoder) EndList(key []byte) {
 	if len(p.listEntry) > 2 {
 		cmd := NewCommand(p.listEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 	}
+	p.endKey(key)
 }
 
 func (p *rdbDecoder) StartZSet(key []byte, cardinality, expiry int64) {
@@ -374,16 +578,16 @@ func (p *rdbDecoder) StartZSet(key []byte, cardinality, expiry int64) {
 	}
 	p.zsetEntry = append(p.zsetEntry, []byte("ZADD"))
 	p.zsetEntry = append(p.zsetEntry, key)
-	p.keyCount++
+	p.startKey(key, expiry)
 	p.i = 0
 }
 
 func (p *rdbDecoder) Zadd(key []byte, score float64, member []byte) {
-	p.zsetEntry = append(p.zsetEntry, []byte(strconv.FormatInt(int64(score), 10)))
+	p.zsetEntry = append(p.zsetEntry, []byte(strconv.FormatFloat(score, 'f', -1, 64)))
 	p.zsetEntry = append(p.zsetEntry, member)
 	if len(p.zsetEntry) >= p.bufsize {
 		cmd := NewCommand(p.zsetEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 		p.zsetEntry = make([][]byte, 0, p.bufsize)
 		p.zsetEntry = append(p.zsetEntry, []byte("ZADD"))
 		p.zsetEntry = append(p.zsetEntry, key)
@@ -395,6 +599,7 @@ func (p *rdbDecoder) Zadd(key []byte, score float64, member []byte) {
 func (p *rdbDecoder) EndZSet(key []byte) {
 	if len(p.zsetEntry) > 2 {
 		cmd := NewCommand(p.zsetEntry...)
-		p.client.rdbDecodeCommand(cmd)
+		p.send(cmd)
 	}
+	p.endKey(key)
 }
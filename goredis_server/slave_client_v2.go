@@ -6,6 +6,9 @@ import (
 	"GoRedis/libs/stat"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ISlaveClient interface {
@@ -19,6 +22,12 @@ type SlaveClientV2 struct {
 	session  *Session
 	server   *GoRedisServer
 	lastseq  int64
+	resync   bool       // 提升为主库后有过写入，全量同步前清空数据
+	replconf bool       // 主库支持REPLCONF，在线同步时每秒发送ACK
+	ackseq   int64      // 已经执行完的seq，atomic
+	pingseq  int64      // 还没有执行到的PING [maxseq]，-1表示没有
+	pingtime time.Time  // 收到pingseq的时间
+	writeMu  sync.Mutex // ACK和SYNC_SHUTDOWN_ACK在不同的goroutine发送
 	counters *counter.Counters
 	synclog  *stat.Writer
 }
@@ -55,12 +64,24 @@ func (s *SlaveClientV2) Session() *Session {
 }
 
 func (s *SlaveClientV2) Sync() (err error) {
+	// 旧版本的主库不认识REPLCONF，返回错误时不发送ACK
+	if err = s.session.WriteCommand(NewCommand(formatByteSlice("REPLCONF", "listening-port", s.server.opt.Port())...)); err != nil {
+		return
+	}
+	reply, err := s.session.ReadReply()
+	if err != nil {
+		return
+	}
+	s.replconf = reply.Type != ReplyTypeError
+
 	s.lastseq = s.masterSeq(s.session.RemoteAddr().String())
 	args := formatByteSlice("SYNC", "UID", s.server.UID(), "PORT", s.server.opt.Port())
-	if s.lastseq < 0 {
+	if s.lastseq < -1 {
+		// 没有同步过
 		args = append(args, formatByteSlice("SNAP", "1")...)
 	} else {
-		args = append(args, formatByteSlice("SEQ", s.lastseq)...)
+		// 从已执行的下一条开始
+		args = append(args, formatByteSlice("SEQ", s.lastseq+1)...)
 	}
 	synccmd := NewCommand(args...)
 	slavelog.Printf("[M %s] %s\n", s.session.RemoteAddr(), synccmd)
@@ -80,16 +101,35 @@ func (s *SlaveClientV2) Sync() (err error) {
 		case "SYNC_RAW_START":
 			s.Session().SetAttribute(S_STATUS, REPL_RECV_BULK)
 			slavelog.Printf("[M %s] recv bulk start\n", s.session.RemoteAddr())
+			if s.resync {
+				if err = s.flushAll(); err != nil {
+					return
+				}
+			}
 		case "SYNC_RAW":
 			s.counters.Get("raw").Incr(1)
 			s.server.OnRAW_SET(cmd)
 		case "SYNC_RAW_END":
+			// SYNC_RAW_END [count] [lastseq] [curseq]，快照包含lastseq之前的全部日志
 			slavelog.Printf("[M %s] recv bulk finish\n", s.session.RemoteAddr())
+			// 快照里有主库SWAPDB之后的映射
+			s.server.Suspend()
+			s.server.initDatabases()
+			// 快照里的_t和过期索引是直接写入的，需要重新登记，否则后台不会删除残留的元素
+			for _, db := range s.server.physdbs {
+				db.Reload()
+			}
+			s.server.Resume()
+			s.server.cronTable.Reload()
+			if seq, e := cmd.Int64AtIndex(2); e == nil {
+				s.lastseq = seq
+				s.updateMasterSeq(s.session.RemoteAddr().String(), s.lastseq)
+			}
+			s.server.config.Set(promotedKey(s.session.RemoteAddr().String()), nil)
 		case "SYNC_SEQ_START":
 			slavelog.Printf("[M %s] sync online ...\n", s.session.RemoteAddr())
 			s.Session().SetAttribute(S_STATUS, REPL_ONLINE)
-			s.recvCommandSeq(cmd) // 进入后只有出错才退出
-			break
+			return s.recvCommandSeq(cmd) // 进入后只有出错或主库关闭才退出
 		default:
 			s.server.On(s.session, cmd)
 		}
@@ -106,16 +146,26 @@ func (s *SlaveClientV2) Close() {
 // 收取快照完成后，开始收取实时数据
 func (s *SlaveClientV2) recvCommandSeq(cmd *Command) (err error) {
 	session := s.session
+	atomic.StoreInt64(&s.ackseq, s.lastseq)
+	s.pingseq = -1
+	session.SetAttribute(S_SYNCED_AT, time.Now())
+	if s.replconf {
+		go s.ackLoop()
+	}
 	for {
 		// SYNC_SEQ
 		cmd, err = session.ReadCommand()
 		if err != nil {
 			break
 		}
+		session.SetAttribute(S_LAST_RECV, time.Now())
 		cmdName := cmd.Name()
 		switch cmdName {
 		case "PING":
+			s.onPing(cmd)
 			continue
+		case "SYNC_SHUTDOWN":
+			return s.onMasterShutdown(cmd)
 		case "SYNC_SEQ":
 			s.lastseq, err = cmd.Int64AtIndex(1)
 			if err != nil {
@@ -125,17 +175,95 @@ func (s *SlaveClientV2) recvCommandSeq(cmd *Command) (err error) {
 			s.counters.Get("cmd").Incr(1)
 			s.server.On(session, cmd)
 			s.updateMasterSeq(session.RemoteAddr().String(), s.lastseq)
+			atomic.StoreInt64(&s.ackseq, s.lastseq)
+			if s.pingseq >= 0 && s.lastseq >= s.pingseq {
+				session.SetAttribute(S_SYNCED_AT, s.pingtime)
+				s.pingseq = -1
+			}
 		}
 	}
 	return
 }
 
+// PING [maxseq]，已经执行到maxseq时记录为当前时间，否则等执行到时记录收到PING的时间；
+// 旧版主库的PING没有参数，视为没有延迟
+func (s *SlaveClientV2) onPing(cmd *Command) {
+	maxseq, err := cmd.Int64AtIndex(1)
+	if err != nil || s.lastseq >= maxseq {
+		s.session.SetAttribute(S_SYNCED_AT, time.Now())
+		s.pingseq = -1
+		return
+	}
+	if s.pingseq < 0 {
+		s.pingseq, s.pingtime = maxseq, time.Now()
+	}
+}
+
+// 发送REPLCONF ACK [seq]，seq变化后尽快发送(WAIT等待的就是它)，没有变化时每秒一次，连接关闭后退出
+func (s *SlaveClientV2) ackLoop() {
+	ticker := time.NewTicker(replAckCheckInterval)
+	defer ticker.Stop()
+	lastseq, lastack := int64(-2), time.Time{}
+	for range ticker.C {
+		seq := atomic.LoadInt64(&s.ackseq)
+		if seq == lastseq && time.Since(lastack) < replAckInterval {
+			continue
+		}
+		s.writeMu.Lock()
+		err := s.session.WriteCommand(NewCommand(formatByteSlice("REPLCONF", "ACK", seq)...))
+		s.writeMu.Unlock()
+		if err != nil {
+			return
+		}
+		lastseq, lastack = seq, time.Now()
+	}
+}
+
+// SYNC_SHUTDOWN [SEQ]，主库已发送全部日志，保存seq后确认
+func (s *SlaveClientV2) onMasterShutdown(cmd *Command) (err error) {
+	finalseq, err := cmd.Int64AtIndex(1)
+	if err != nil {
+		return
+	}
+	host := s.session.RemoteAddr().String()
+	if finalseq != s.lastseq {
+		slavelog.Printf("[M %s] master shutdown at seq %d, received %d\n", host, finalseq, s.lastseq)
+	} else {
+		slavelog.Printf("[M %s] master shutdown at seq %d\n", host, finalseq)
+	}
+	s.updateMasterSeq(host, s.lastseq)
+	s.writeMu.Lock()
+	err = s.session.WriteCommand(NewCommand(formatByteSlice("SYNC_SHUTDOWN_ACK", s.lastseq)...))
+	s.writeMu.Unlock()
+	if err != nil {
+		return
+	}
+	return MasterShutdownError
+}
+
+// 提升为主库之后有过写入时返回-2，重新全量同步
 func (s *SlaveClientV2) masterSeq(host string) (seq int64) {
 	key := "master:" + host + ":seq"
 	seq = s.server.config.IntForKey(key, -2)
+	if promoted, diverged := s.server.promotedDiverged(host); diverged {
+		slavelog.Printf("[M %s] written after promoted, full resync\n", host)
+		seq, s.resync = -2, true
+	} else if promoted {
+		s.server.config.Set(promotedKey(host), nil)
+	}
 	return
 }
 
+// 清空全部db，丢弃提升为主库之后的写入
+func (s *SlaveClientV2) flushAll() error {
+	begin := time.Now()
+	if _, err := s.server.flushDBs(s.server.databases()); err != nil {
+		return err
+	}
+	slavelog.Printf("[M %s] flushall before resync, %s\n", s.session.RemoteAddr(), time.Since(begin))
+	return nil
+}
+
 func (s *SlaveClientV2) updateMasterSeq(host string, seq int64) {
 	key := "master:" + host + ":seq"
 	s.server.config.SetInt(key, seq)
@@ -0,0 +1,160 @@
+package goredis_server
+
+import (
+	"GoRedis/libs/levelredis"
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// 慢查询日志，开启持久化时保存在系统前缀下，重启后仍然可以通过SLOWLOG GET查看
+// slowlog:id:[seq] = {"time":..., "us":..., "addr":..., "args":[...]}
+// 没有开启持久化时与redis一样只保存在内存里
+type SlowLog struct {
+	db      *levelredis.LevelRedis
+	prefix  []byte
+	minseq  int64 // 最早的一条
+	seq     int64 // 最新的一条，永远递增
+	maxlen  int64 // 保留条数
+	persist bool
+	ring    []*SlowLogEntry // 没有持久化时的记录，旧的在前
+	mu      sync.Mutex
+}
+
+type SlowLogEntry struct {
+	Id      int64    `json:"-"`
+	Time    int64    `json:"time"` // unix秒
+	Elapsed int64    `json:"us"`   // 微秒
+	Addr    string   `json:"addr"`
+	Args    []string `json:"args"`
+}
+
+func NewSlowLog(db *levelredis.LevelRedis, prefix string, maxlen int64) (s *SlowLog) {
+	s = &SlowLog{
+		db:     db,
+		prefix: []byte(prefix),
+		minseq: 0,
+		seq:    -1,
+		maxlen: maxlen,
+	}
+	s.initSeq()
+	return
+}
+
+func (s *SlowLog) initSeq() {
+	prefix := s.idPrefix()
+	s.db.PrefixEnumerate(prefix, levelredis.IterForward, func(i int, key, value []byte, quit *bool) {
+		s.minseq = s.splitSeqkey(key)
+		*quit = true
+	})
+	s.db.PrefixEnumerate(prefix, levelredis.IterBackward, func(i int, key, value []byte, quit *bool) {
+		s.seq = s.splitSeqkey(key)
+		*quit = true
+	})
+}
+
+func (s *SlowLog) idPrefix() []byte {
+	return bytes.Join([][]byte{s.prefix, []byte("id:")}, []byte(""))
+}
+
+func (s *SlowLog) seqkey(seq int64) []byte {
+	return bytes.Join([][]byte{s.prefix, []byte("id:"), Int64ToBytes(seq)}, []byte(""))
+}
+
+func (s *SlowLog) splitSeqkey(seqkey []byte) (seq int64) {
+	return BytesToInt64(seqkey[len(s.idPrefix()):])
+}
+
+func (s *SlowLog) SetMaxLen(maxlen int64) {
+	s.mu.Lock()
+	s.maxlen = maxlen
+	s.trimRing()
+	s.mu.Unlock()
+}
+
+// 切换是否持久化，切换之前的记录不迁移
+func (s *SlowLog) SetPersist(persist bool) {
+	s.mu.Lock()
+	s.persist = persist
+	s.mu.Unlock()
+}
+
+func (s *SlowLog) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.persist {
+		return int64(len(s.ring))
+	}
+	return s.seq - s.minseq + 1
+}
+
+func (s *SlowLog) trimRing() {
+	if n := int64(len(s.ring)) - s.maxlen; n > 0 {
+		s.ring = append(s.ring[:0], s.ring[n:]...)
+	}
+}
+
+// 写入一条记录，超出maxlen时删除最旧的记录
+func (s *SlowLog) Write(entry *SlowLogEntry) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.persist {
+		s.seq++
+		entry.Id = s.seq
+		s.ring = append(s.ring, entry)
+		s.trimRing()
+		return
+	}
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	seq := s.seq + 1
+	if err = s.db.RawSet(s.seqkey(seq), val); err != nil {
+		return
+	}
+	s.seq = seq
+	for s.seq-s.minseq+1 > s.maxlen && s.minseq <= s.seq {
+		s.db.RawDel(s.seqkey(s.minseq))
+		s.minseq++
+	}
+	return
+}
+
+// 最新的count条记录，新的在前
+func (s *SlowLog) Get(count int) (entries []*SlowLogEntry) {
+	s.mu.Lock()
+	persist := s.persist
+	if !persist {
+		for i := len(s.ring) - 1; i >= 0 && len(entries) < count; i-- {
+			entries = append(entries, s.ring[i])
+		}
+	}
+	s.mu.Unlock()
+	if !persist {
+		return
+	}
+	entries = make([]*SlowLogEntry, 0, count)
+	s.db.PrefixEnumerate(s.idPrefix(), levelredis.IterBackward, func(i int, key, value []byte, quit *bool) {
+		if i >= count {
+			*quit = true
+			return
+		}
+		entry := &SlowLogEntry{}
+		if err := json.Unmarshal(value, entry); err != nil {
+			return
+		}
+		entry.Id = s.splitSeqkey(key)
+		entries = append(entries, entry)
+	})
+	return
+}
+
+func (s *SlowLog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ring = nil
+	for ; s.minseq <= s.seq; s.minseq++ {
+		s.db.RawDel(s.seqkey(s.minseq))
+	}
+}
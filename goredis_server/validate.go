@@ -23,44 +23,113 @@ const (
 // 存放指令格式规则，参数范围
 var cmdrules = map[string][]interface{}{
 	// key
-	"DEL":     []interface{}{2, -1},
-	"TYPE":    []interface{}{2, 2},
-	"KEYNEXT": []interface{}{2, -1},
+	"DEL":       []interface{}{2, -1},
+	"UNLINK":    []interface{}{2, -1},
+	"EXISTS":    []interface{}{2, -1},
+	"TYPE":      []interface{}{2, 2},
+	"RENAME":    []interface{}{3, 3},
+	"RENAMENX":  []interface{}{3, 3},
+	"COPY":      []interface{}{3, 6},
+	"KEYS":      []interface{}{2, 2},
+	"SCAN":      []interface{}{2, 6},
+	"RANDOMKEY": []interface{}{1, 1},
+	"FLUSHALL":  []interface{}{1, 2},
+	"FLUSHDB":   []interface{}{1, 2},
+	"MOVE":      []interface{}{3, 3},
+	"DUMP":      []interface{}{2, 2},
+	"RESTORE":   []interface{}{4, -1},
+	"MIGRATE":   []interface{}{6, -1},
+	"SWAPDB":    []interface{}{3, 3},
+	"EXPIRE":    []interface{}{3, 3},
+	"PEXPIRE":   []interface{}{3, 3},
+	"EXPIREAT":  []interface{}{3, 3},
+	"PEXPIREAT": []interface{}{3, 3},
+	"TTL":       []interface{}{2, 2},
+	"PTTL":      []interface{}{2, 2},
+	"PERSIST":   []interface{}{2, 2},
+	"KEYNEXT":   []interface{}{2, -1},
 	// string
-	"GET":    []interface{}{2, 2},
-	"SET":    []interface{}{3, -1},
-	"MGET":   []interface{}{2, -1},
-	"MSET":   []interface{}{3, -1},
-	"INCR":   []interface{}{2, 2},
-	"DECR":   []interface{}{2, 2},
-	"INCRBY": []interface{}{3, 3},
-	"DECRBY": []interface{}{3, 3},
+	"GET":         []interface{}{2, 2},
+	"SET":         []interface{}{3, -1},
+	"MGET":        []interface{}{2, -1},
+	"MSET":        []interface{}{3, -1},
+	"INCR":        []interface{}{2, 2},
+	"DECR":        []interface{}{2, 2},
+	"INCRBY":      []interface{}{3, 3},
+	"DECRBY":      []interface{}{3, 3},
+	"INCRBYFLOAT": []interface{}{3, 3},
+	"INCRLIMIT":   []interface{}{4, 4},
+	"APPEND":      []interface{}{3, 3},
+	"STRLEN":      []interface{}{2, 2},
+	"GETRANGE":    []interface{}{4, 4},
+	"SETRANGE":    []interface{}{4, 4},
+	"SETBIT":      []interface{}{4, 4},
+	"GETBIT":      []interface{}{3, 3},
+	"BITCOUNT":    []interface{}{2, 5},
+	"BITPOS":      []interface{}{3, 6},
+	"BITOP":       []interface{}{4, -1},
+	"BLOB.PUT":    []interface{}{3, 3},
+	"BLOB.LINK":   []interface{}{3, 3},
+	"BLOB.APPEND": []interface{}{3, 3},
+	"BLOB.COMMIT": []interface{}{2, 2},
+	"BLOB.ABORT":  []interface{}{2, 2},
+	"BLOB.GET":    []interface{}{2, 4},
+	"BLOB.STAT":   []interface{}{2, 2},
 	// hash
-	"HGET":    []interface{}{3, 3},
-	"HSET":    []interface{}{4, 4},
-	"HMGET":   []interface{}{3, -1},
-	"HMSET":   []interface{}{4, -1},
-	"HGETALL": []interface{}{2, 2},
-	"HLEN":    []interface{}{2, 2},
-	"HDEL":    []interface{}{3, -1},
+	"HGET":         []interface{}{3, 3},
+	"HSET":         []interface{}{4, -1},
+	"HMGET":        []interface{}{3, -1},
+	"HMSET":        []interface{}{4, -1},
+	"HGETALL":      []interface{}{2, 2},
+	"HKEYS":        []interface{}{2, 2},
+	"HVALS":        []interface{}{2, 2},
+	"HSCAN":        []interface{}{3, 7},
+	"HINCRBY":      []interface{}{4, 4},
+	"HINCRBYFLOAT": []interface{}{4, 4},
+	"HEXISTS":      []interface{}{3, 3},
+	"HLEN":         []interface{}{2, 2},
+	"HDEL":         []interface{}{3, -1},
 	// set
-	"SADD":      []interface{}{3, -1},
-	"SCARD":     []interface{}{2, 2},
-	"SISMEMBER": []interface{}{3, 3},
-	"SMEMBERS":  []interface{}{2, 2},
-	"SREM":      []interface{}{3, -1},
+	"SADD":        []interface{}{3, -1},
+	"SCARD":       []interface{}{2, 2},
+	"SISMEMBER":   []interface{}{3, 3},
+	"SMEMBERS":    []interface{}{2, 2},
+	"SREM":        []interface{}{3, -1},
+	"SPOP":        []interface{}{2, 3},
+	"SRANDMEMBER": []interface{}{2, 3},
+	"SSCAN":       []interface{}{3, 7},
+	"SINTER":      []interface{}{2, -1},
+	"SUNION":      []interface{}{2, -1},
+	"SDIFF":       []interface{}{2, -1},
+	"SINTERSTORE": []interface{}{3, -1},
+	"SUNIONSTORE": []interface{}{3, -1},
+	"SDIFFSTORE":  []interface{}{3, -1},
 	// list
-	"LPUSH":  []interface{}{3, -1},
-	"RPUSH":  []interface{}{3, -1},
-	"LPOP":   []interface{}{2, 2},
-	"RPOP":   []interface{}{2, 2},
-	"LINDEX": []interface{}{3, 3},
-	"LTRIM":  []interface{}{4, 4},
-	"LRANGE": []interface{}{4, 4},
-	"LLEN":   []interface{}{2, 2},
+	"LPUSH":      []interface{}{3, -1},
+	"RPUSH":      []interface{}{3, -1},
+	"LPOP":       []interface{}{2, 2},
+	"RPOP":       []interface{}{2, 2},
+	"LINDEX":     []interface{}{3, 3},
+	"LTRIM":      []interface{}{4, 4},
+	"LRANGE":     []interface{}{4, 4},
+	"LLEN":       []interface{}{2, 2},
+	"LREM":       []interface{}{4, 4},
+	"LSET":       []interface{}{4, 4},
+	"LINSERT":    []interface{}{5, 5},
+	"RPOPLPUSH":  []interface{}{3, 3},
+	"LMOVE":      []interface{}{5, 5},
+	"BLPOP":      []interface{}{3, -1},
+	"BRPOP":      []interface{}{3, -1},
+	"BRPOPLPUSH": []interface{}{4, 4},
+	"BLMOVE":     []interface{}{6, 6},
+	"LMPOP":      []interface{}{4, -1},
+	"BLMPOP":     []interface{}{5, -1},
 	// zset
 	"ZADD":             []interface{}{4, -1},
 	"ZCARD":            []interface{}{2, 2},
+	"ZCOUNT":           []interface{}{4, 4},
+	"ZUNIONSTORE":      []interface{}{4, -1},
+	"ZINTERSTORE":      []interface{}{4, -1},
 	"ZRANK":            []interface{}{3, 3},
 	"ZREVRANK":         []interface{}{3, 3},
 	"ZRANGE":           []interface{}{4, 5},
@@ -72,9 +141,73 @@ var cmdrules = map[string][]interface{}{
 	"ZREMRANGEBYSCORE": []interface{}{4, 4},
 	"ZINCRBY":          []interface{}{4, 4},
 	"ZSCORE":           []interface{}{3, 3},
+	"ZSCAN":            []interface{}{3, 7},
+	"ZRANDMEMBER":      []interface{}{2, 4},
+	"ZPOPMIN":          []interface{}{2, 3},
+	"ZPOPMAX":          []interface{}{2, 3},
+	"BZPOPMIN":         []interface{}{3, -1},
+	"BZPOPMAX":         []interface{}{3, -1},
+	// doc
+	"DOC_HISTORY": []interface{}{2, 3},
+	"DOC_REVERT":  []interface{}{2, 3},
 	// server
-	"CLIENT": []interface{}{2, 2},
-	"AOF":    []interface{}{2, 2},
+	"CLIENT":       []interface{}{2, -1},
+	"HELLO":        []interface{}{1, 2},
+	"SELECT":       []interface{}{2, 2},
+	"DBINFO":       []interface{}{1, 1},
+	"SAVE":         []interface{}{1, 1},
+	"BGSAVE":       []interface{}{1, 2},
+	"LASTSAVE":     []interface{}{1, 1},
+	"AOF":          []interface{}{2, 2},
+	"BGREWRITEAOF": []interface{}{1, 1},
+	"BACKUP":       []interface{}{1, 2},
+	"COMPACT":      []interface{}{1, 2},
+	"REPLCONF":     []interface{}{3, -1},
+	"WAIT":         []interface{}{3, 3},
+	"EXPORT":       []interface{}{2, -1},
+	"IMPORT":       []interface{}{2, 4},
+	"EXPORT.JSON":  []interface{}{1, -1},
+	"IMPORT.JSON":  []interface{}{2, 2},
+	"REPLICAS":     []interface{}{1, 1},
+	"TOPKEYS":      []interface{}{1, 4},
+	"EXPIRYSTATS":  []interface{}{1, 3},
+	"DEBUG":        []interface{}{2, -1},
+	"SLOWLOG":      []interface{}{2, 3},
+	"CRON.ADD":     []interface{}{4, -1},
+	"CRON.DEL":     []interface{}{2, 2},
+	"CRON.LIST":    []interface{}{1, 1},
+	"CRON.RUN":     []interface{}{2, 2},
+	"BULK.WRITE":   []interface{}{3, -1},
+	// watch
+	"WATCHPREFIX": []interface{}{2, 2},
+}
+
+// 第一个参数不是key的指令，不做非法字符检查，比如KEYS的模式可以包含[]
+var notKeyArgCmds = map[string]bool{
+	"KEYS":        true,
+	"SCAN":        true,
+	"SWAPDB":      true,
+	"MIGRATE":     true,
+	"BITOP":       true,
+	"LMPOP":       true,
+	"BLMPOP":      true,
+	"CLIENT":      true,
+	"HELLO":       true,
+	"SELECT":      true,
+	"AOF":         true,
+	"BACKUP":      true,
+	"REPLCONF":    true,
+	"WAIT":        true,
+	"IMPORT":      true,
+	"IMPORT.JSON": true,
+	"TOPKEYS":     true,
+	"EXPIRYSTATS": true,
+	"DEBUG":       true,
+	"SLOWLOG":     true,
+	"CRON.ADD":    true,
+	"CRON.DEL":    true,
+	"CRON.RUN":    true,
+	"BULK.WRITE":  true,
 }
 
 // 验证指令参数数量、非法字符等
@@ -103,7 +236,7 @@ func verifyCommand(cmd *Command) error {
 	}
 
 	// 拒绝使用内部关键字 #[]
-	if cmd.Len() > 1 {
+	if cmd.Len() > 1 && !notKeyArgCmds[name] {
 		key := cmd.StringAtIndex(1)
 		if strings.ContainsAny(key, "#[] ") {
 			return WrongCommandKey
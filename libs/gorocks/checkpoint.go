@@ -0,0 +1,58 @@
+package gorocks
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include "rocksdb/c.h"
+#include "rocksdb/version.h"
+
+// The checkpoint C API first shipped in RocksDB 5.14. Older libraries do not
+// declare it, so the call is compiled only when the headers are new enough.
+#if ROCKSDB_MAJOR > 5 || (ROCKSDB_MAJOR == 5 && ROCKSDB_MINOR >= 14)
+#define GOROCKS_CHECKPOINT 1
+static void gorocks_checkpoint(rocksdb_t* db, const char* dir, char** errptr) {
+	rocksdb_checkpoint_t* cp = rocksdb_checkpoint_object_create(db, errptr);
+	if (*errptr != NULL) {
+		return;
+	}
+	// A log_size_for_flush of 0 always flushes the memtable, so no WAL is copied.
+	rocksdb_checkpoint_create(cp, dir, 0, errptr);
+	rocksdb_checkpoint_object_destroy(cp);
+}
+#else
+#define GOROCKS_CHECKPOINT 0
+static void gorocks_checkpoint(rocksdb_t* db, const char* dir, char** errptr) {
+	*errptr = strdup("checkpoint requires RocksDB 5.14 or later");
+}
+#endif
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// CheckpointSupported reports whether the linked RocksDB headers provide the
+// checkpoint C API, which was added in RocksDB 5.14.
+func CheckpointSupported() bool {
+	return C.GOROCKS_CHECKPOINT != 0
+}
+
+// Checkpoint creates an openable snapshot of the database in dir, which
+// must not exist yet. SST files are hard-linked when dir is on the same
+// filesystem and copied otherwise; writes are not blocked.
+//
+// Requires RocksDB 5.14 or later. Against older headers the package still
+// builds, and Checkpoint returns a DatabaseError without touching dir.
+func (db *DB) Checkpoint(dir string) error {
+	var errStr *C.char
+	ldir := C.CString(dir)
+	defer C.free(unsafe.Pointer(ldir))
+	C.gorocks_checkpoint(db.Ldb, ldir, &errStr)
+	if errStr != nil {
+		gs := C.GoString(errStr)
+		C.free(unsafe.Pointer(errStr))
+		return DatabaseError(gs)
+	}
+	return nil
+}
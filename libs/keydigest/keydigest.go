@@ -0,0 +1,85 @@
+package keydigest
+
+// 与存储实现无关的key内容摘要，用于比较两个实例(redis或GoRedis)的数据是否一致
+// 按读取指令的返回值计算：string为GET，list为LRANGE 0 -1，hash为HGETALL，
+// set为SMEMBERS，zset为ZRANGE 0 -1 WITHSCORES；无序的类型先排序，score统一格式化
+// GoRedis的DEBUG DIGEST-VALUE和main/tool/migratediff使用同样的计算方法
+//
+//	d := keydigest.New("hash")
+//	d.Add([]byte("name"), []byte("latermoon"))
+//	sum := d.Sum()
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strconv"
+)
+
+// key不存在时的摘要，与redis的DEBUG DIGEST-VALUE一致
+const Missing = "0000000000000000000000000000000000000000"
+
+type Digest struct {
+	typ   string
+	elems [][]byte
+}
+
+func New(typ string) (d *Digest) {
+	d = &Digest{typ: typ}
+	return
+}
+
+// hash和zset按field/member、value/score的顺序成对添加
+func (d *Digest) Add(elems ...[]byte) {
+	d.elems = append(d.elems, elems...)
+}
+
+func (d *Digest) Sum() string {
+	elems := d.elems
+	switch d.typ {
+	case "set":
+		sort.Sort(byBytes(elems))
+	case "hash", "zset":
+		pairs := make([][2][]byte, 0, len(elems)/2)
+		for i := 0; i+1 < len(elems); i += 2 {
+			value := elems[i+1]
+			if d.typ == "zset" {
+				value = normalizeScore(value)
+			}
+			pairs = append(pairs, [2][]byte{elems[i], value})
+		}
+		sort.Sort(byField(pairs))
+		elems = make([][]byte, 0, len(pairs)*2)
+		for _, p := range pairs {
+			elems = append(elems, p[0], p[1])
+		}
+	}
+	h := sha1.New()
+	h.Write([]byte(d.typ))
+	for _, e := range elems {
+		h.Write([]byte("\n" + strconv.Itoa(len(e)) + ":"))
+		h.Write(e)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 不同实现输出score的格式不同，比如1e+21和1.0000000000000000e+21
+func normalizeScore(score []byte) []byte {
+	f, err := strconv.ParseFloat(string(score), 64)
+	if err != nil {
+		return score
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+type byBytes [][]byte
+
+func (b byBytes) Len() int           { return len(b) }
+func (b byBytes) Less(i, j int) bool { return bytes.Compare(b[i], b[j]) < 0 }
+func (b byBytes) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type byField [][2][]byte
+
+func (b byField) Len() int           { return len(b) }
+func (b byField) Less(i, j int) bool { return bytes.Compare(b[i][0], b[j][0]) < 0 }
+func (b byField) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
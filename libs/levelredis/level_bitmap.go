@@ -0,0 +1,221 @@
+package levelredis
+
+// 分块保存的bitmap，SETBIT只读写offset所在的块，不需要重写整个value
+// +[key]bitmap = 字节长度
+// _b[key][chunk idx 8字节] = 块数据，最多BitmapChunkSize字节，末尾的0可以省略
+// 不存在的块视为全0，写入后全为0的块被删除，稀疏的大bitmap只占用实际置位的块
+import (
+	"GoRedis/libs/gorocks"
+	"strconv"
+	"sync"
+)
+
+const BitmapChunkSize = 4096
+
+type LevelBitmap struct {
+	LevelElem
+	redis  *LevelRedis
+	key    string
+	mu     sync.RWMutex
+	length int64 // 字节长度，即GET返回的长度
+}
+
+func NewLevelBitmap(redis *LevelRedis, key string) (l *LevelBitmap) {
+	l = &LevelBitmap{}
+	l.redis = redis
+	l.key = key
+	l.initInfo()
+	return
+}
+
+func (l *LevelBitmap) initInfo() {
+	value, _ := l.redis.RawGet(l.infoKey())
+	if value != nil {
+		l.length, _ = strconv.ParseInt(string(value), 10, 64)
+	}
+}
+
+func (l *LevelRedis) bitmapInfoKey(key string) []byte {
+	return joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, key, SEP_RIGHT, BITMAP_SUFFIX)
+}
+
+// key以bitmap保存时返回实例，否则返回nil，不会为不存在的key创建缓存
+func (l *LevelRedis) ExistingBitmap(key string) *LevelBitmap {
+	if value, _ := l.RawGet(l.bitmapInfoKey(key)); value == nil {
+		return nil
+	}
+	return l.GetBitmap(key)
+}
+
+func (l *LevelBitmap) Key() string {
+	return l.key
+}
+
+func (l *LevelBitmap) Size() int {
+	return 1
+}
+
+func (l *LevelBitmap) infoKey() []byte {
+	return l.redis.bitmapInfoKey(l.key)
+}
+
+func (l *LevelBitmap) chunkPrefix() []byte {
+	return joinStringBytes(l.redis.ns, BITMAP_PREFIX, SEP_LEFT, l.key, SEP_RIGHT)
+}
+
+func (l *LevelBitmap) chunkKey(idx int64) []byte {
+	return append(l.chunkPrefix(), Int64ToBytes(idx)...)
+}
+
+func (l *LevelBitmap) chunk(idx int64) []byte {
+	value, _ := l.redis.RawGet(l.chunkKey(idx))
+	return value
+}
+
+func (l *LevelBitmap) Len() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.length
+}
+
+func (l *LevelBitmap) GetBit(offset int64) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	pos := offset / 8
+	data := l.chunk(pos / BitmapChunkSize)
+	i := pos % BitmapChunkSize
+	if i >= int64(len(data)) {
+		return 0
+	}
+	return int(data[i]>>uint(7-offset%8)) & 1
+}
+
+// 返回原来的值
+func (l *LevelBitmap) SetBit(offset int64, on bool) (old int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pos := offset / 8
+	idx, i := pos/BitmapChunkSize, pos%BitmapChunkSize
+	data := l.chunk(idx)
+	if i >= int64(len(data)) {
+		data = append(data, make([]byte, i+1-int64(len(data)))...)
+	}
+	mask := byte(1) << uint(7-offset%8)
+	if data[i]&mask != 0 {
+		old = 1
+	}
+	if on {
+		data[i] |= mask
+	} else {
+		data[i] &^= mask
+	}
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	l.putChunk(batch, idx, data)
+	length := l.length
+	if pos+1 > length {
+		length = pos + 1
+		batch.Put(l.infoKey(), []byte(strconv.FormatInt(length, 10)))
+	}
+	if err = l.redis.WriteBatch(batch); err == nil {
+		l.length = length
+	}
+	return
+}
+
+// 全为0的块直接删除
+func (l *LevelBitmap) putChunk(batch *gorocks.WriteBatch, idx int64, data []byte) {
+	for _, b := range data {
+		if b != 0 {
+			batch.Put(l.chunkKey(idx), data)
+			return
+		}
+	}
+	batch.Delete(l.chunkKey(idx))
+}
+
+// 从offset字节开始写入，超过当前长度时自动扩展
+func (l *LevelBitmap) SetRange(offset int64, value []byte) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	for written := int64(0); written < int64(len(value)); {
+		pos := offset + written
+		idx, i := pos/BitmapChunkSize, pos%BitmapChunkSize
+		n := BitmapChunkSize - i
+		if rest := int64(len(value)) - written; n > rest {
+			n = rest
+		}
+		data := l.chunk(idx)
+		if i+n > int64(len(data)) {
+			data = append(data, make([]byte, i+n-int64(len(data)))...)
+		}
+		copy(data[i:], value[written:written+n])
+		l.putChunk(batch, idx, data)
+		written += n
+	}
+	length := l.length
+	if end := offset + int64(len(value)); end > length {
+		length = end
+	}
+	batch.Put(l.infoKey(), []byte(strconv.FormatInt(length, 10)))
+	if err = l.redis.WriteBatch(batch); err == nil {
+		l.length = length
+	}
+	return
+}
+
+// 按块读取[start, end]字节，不存在的部分补0，fn返回false时停止
+func (l *LevelBitmap) Range(start, end int64, fn func(b []byte) bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if end >= l.length {
+		end = l.length - 1
+	}
+	for pos := start; pos <= end; {
+		idx, i := pos/BitmapChunkSize, pos%BitmapChunkSize
+		n := BitmapChunkSize - i
+		if rest := end - pos + 1; n > rest {
+			n = rest
+		}
+		buf := make([]byte, n)
+		if data := l.chunk(idx); i < int64(len(data)) {
+			copy(buf, data[i:])
+		}
+		if !fn(buf) {
+			return
+		}
+		pos += n
+	}
+}
+
+// 全部内容，用于GET和导出
+func (l *LevelBitmap) Bytes() (value []byte) {
+	value = make([]byte, 0, l.Len())
+	l.Range(0, l.Len()-1, func(b []byte) bool {
+		value = append(value, b...)
+		return true
+	})
+	return
+}
+
+func (l *LevelBitmap) Type() string {
+	return BITMAP_SUFFIX
+}
+
+func (l *LevelBitmap) Drop() (ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	l.redis.PrefixEnumerate(l.chunkPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
+		batch.Delete(key)
+	})
+	batch.Delete(l.infoKey())
+	l.redis.WriteBatch(batch)
+	l.length = 0
+	ok = true
+	return
+}
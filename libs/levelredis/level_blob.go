@@ -0,0 +1,426 @@
+package levelredis
+
+// 按内容寻址的blob，内容以sha256保存一份，多个key引用同一内容时只增加引用计数
+// +[key]blob = sha256(hex)
+// _o[sha256] = "size,refs"
+// _o[sha256]#[chunk idx 8字节] = 块数据，每块BlobChunkSize字节
+// 分段上传时数据先写入 _u[key] = 已上传长度，_u[key]#[chunk idx 8字节] = 块数据，
+// 提交时按块计算sha256，内容已存在时只增加引用，否则把块转存到_o下
+// 引用计数的修改由LevelRedis.blobMu串行执行，引用降为0时删除内容
+import (
+	"GoRedis/libs/gorocks"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const BlobChunkSize = 64 * 1024
+
+// 提交上传时每写入多少块执行一次WriteBatch
+const blobCommitBatch = 64
+
+var (
+	BlobNoUploadError = errors.New("no pending upload")
+	BlobBadMetaError  = errors.New("bad blob meta")
+)
+
+type LevelBlob struct {
+	LevelElem
+	redis  *LevelRedis
+	key    string
+	mu     sync.RWMutex
+	hash   string // 为空表示key不存在
+	staged int64  // 未提交的上传长度
+}
+
+func NewLevelBlob(redis *LevelRedis, key string) (l *LevelBlob) {
+	l = &LevelBlob{}
+	l.redis = redis
+	l.key = key
+	l.initInfo()
+	return
+}
+
+func (l *LevelBlob) initInfo() {
+	if value, _ := l.redis.RawGet(l.infoKey()); value != nil {
+		l.hash = string(value)
+	}
+	if value, _ := l.redis.RawGet(l.uploadKey()); value != nil {
+		l.staged, _ = strconv.ParseInt(string(value), 10, 64)
+	}
+}
+
+func (l *LevelBlob) Key() string {
+	return l.key
+}
+
+func (l *LevelBlob) Size() int {
+	return 1
+}
+
+func (l *LevelBlob) infoKey() []byte {
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, BLOB_SUFFIX)
+}
+
+func (l *LevelBlob) uploadKey() []byte {
+	return joinStringBytes(l.redis.ns, BLOB_UPLOAD_PREFIX, SEP_LEFT, l.key, SEP_RIGHT)
+}
+
+func (l *LevelBlob) uploadChunkKey(idx int64) []byte {
+	return append(joinStringBytes(l.redis.ns, BLOB_UPLOAD_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, SEP), Int64ToBytes(idx)...)
+}
+
+func (l *LevelRedis) blobMetaKey(sum string) []byte {
+	return joinStringBytes(l.ns, BLOB_PREFIX, SEP_LEFT, sum, SEP_RIGHT)
+}
+
+func (l *LevelRedis) blobChunkKey(sum string, idx int64) []byte {
+	return append(joinStringBytes(l.ns, BLOB_PREFIX, SEP_LEFT, sum, SEP_RIGHT, SEP), Int64ToBytes(idx)...)
+}
+
+func blobChunkCount(size int64) int64 {
+	return (size + BlobChunkSize - 1) / BlobChunkSize
+}
+
+// 内容不存在时ok=false
+func (l *LevelRedis) blobMeta(sum string) (size, refs int64, ok bool, err error) {
+	value, err := l.RawGet(l.blobMetaKey(sum))
+	if err != nil || value == nil {
+		return
+	}
+	pairs := strings.Split(string(value), ",")
+	if len(pairs) != 2 {
+		return 0, 0, false, BlobBadMetaError
+	}
+	size, _ = strconv.ParseInt(pairs[0], 10, 64)
+	refs, _ = strconv.ParseInt(pairs[1], 10, 64)
+	return size, refs, true, nil
+}
+
+// 修改内容的引用计数，降为0时删除内容，调用方持有blobMu
+func (l *LevelRedis) refBlob(batch *gorocks.WriteBatch, sum string, delta int64) error {
+	size, refs, ok, err := l.blobMeta(sum)
+	if err != nil || !ok {
+		return err
+	}
+	refs += delta
+	if refs > 0 {
+		batch.Put(l.blobMetaKey(sum), []byte(strconv.FormatInt(size, 10)+","+strconv.FormatInt(refs, 10)))
+		return nil
+	}
+	for i := int64(0); i < blobChunkCount(size); i++ {
+		batch.Delete(l.blobChunkKey(sum, i))
+	}
+	batch.Delete(l.blobMetaKey(sum))
+	return nil
+}
+
+// 指向新的内容sum，并释放原来引用的内容，调用方持有blobMu和l.mu
+func (l *LevelBlob) pointTo(batch *gorocks.WriteBatch, sum string) (err error) {
+	if len(l.hash) > 0 {
+		if err = l.redis.refBlob(batch, l.hash, -1); err != nil {
+			return
+		}
+	}
+	batch.Put(l.infoKey(), []byte(sum))
+	return
+}
+
+// 写入完整的value，返回sha256
+func (l *LevelBlob) Put(value []byte) (sum string, err error) {
+	digest := sha256.Sum256(value)
+	sum = hex.EncodeToString(digest[:])
+
+	l.redis.blobMu.Lock()
+	defer l.redis.blobMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sum == l.hash {
+		return
+	}
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	size, refs, ok, err := l.redis.blobMeta(sum)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		size = int64(len(value))
+		for i := int64(0); i < blobChunkCount(size); i++ {
+			end := (i + 1) * BlobChunkSize
+			if end > size {
+				end = size
+			}
+			batch.Put(l.redis.blobChunkKey(sum, i), value[i*BlobChunkSize:end])
+		}
+	}
+	batch.Put(l.redis.blobMetaKey(sum), []byte(strconv.FormatInt(size, 10)+","+strconv.FormatInt(refs+1, 10)))
+	if err = l.pointTo(batch, sum); err != nil {
+		return "", err
+	}
+	if err = l.redis.WriteBatch(batch); err != nil {
+		return "", err
+	}
+	l.hash = sum
+	return
+}
+
+// 指向已存在的内容，内容不存在时返回false，用于客户端已知sha256时免去重复上传
+func (l *LevelBlob) Link(sum string) (ok bool, err error) {
+	l.redis.blobMu.Lock()
+	defer l.redis.blobMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _, ok, err = l.redis.blobMeta(sum)
+	if err != nil || !ok || sum == l.hash {
+		return
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	if err = l.redis.refBlob(batch, sum, 1); err != nil {
+		return false, err
+	}
+	if err = l.pointTo(batch, sum); err != nil {
+		return false, err
+	}
+	if err = l.redis.WriteBatch(batch); err != nil {
+		return false, err
+	}
+	l.hash = sum
+	return
+}
+
+// 追加到未提交的上传，返回已上传的长度
+func (l *LevelBlob) Append(data []byte) (staged int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	for written := int64(0); written < int64(len(data)); {
+		pos := l.staged + written
+		idx, i := pos/BlobChunkSize, pos%BlobChunkSize
+		n := BlobChunkSize - i
+		if rest := int64(len(data)) - written; n > rest {
+			n = rest
+		}
+		var chunk []byte
+		if i > 0 {
+			if chunk, err = l.redis.RawGet(l.uploadChunkKey(idx)); err != nil {
+				return l.staged, err
+			}
+			if int64(len(chunk)) < i {
+				return l.staged, BlobBadMetaError
+			}
+		}
+		chunk = append(chunk[:i], data[written:written+n]...)
+		batch.Put(l.uploadChunkKey(idx), chunk)
+		written += n
+	}
+	staged = l.staged + int64(len(data))
+	batch.Put(l.uploadKey(), []byte(strconv.FormatInt(staged, 10)))
+	if err = l.redis.WriteBatch(batch); err != nil {
+		return l.staged, err
+	}
+	l.staged = staged
+	return
+}
+
+// 按块读取未提交的上传
+func (l *LevelBlob) rangeUpload(fn func(idx int64, chunk []byte) error) (err error) {
+	for i := int64(0); i < blobChunkCount(l.staged); i++ {
+		chunk, err := l.redis.RawGet(l.uploadChunkKey(i))
+		if err != nil {
+			return err
+		}
+		if err = fn(i, chunk); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (l *LevelBlob) dropUpload(batch *gorocks.WriteBatch) {
+	for i := int64(0); i < blobChunkCount(l.staged); i++ {
+		batch.Delete(l.uploadChunkKey(i))
+	}
+	batch.Delete(l.uploadKey())
+}
+
+// 提交上传的内容，返回sha256
+// 内容不存在时先分批转存块数据，最后在同一个WriteBatch里写入元信息、key和清理上传，
+// 中途宕机只会留下没有元信息的块，下次上传相同内容时被覆盖
+func (l *LevelBlob) Commit() (sum string, err error) {
+	l.redis.blobMu.Lock()
+	defer l.redis.blobMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if value, err := l.redis.RawGet(l.uploadKey()); err != nil {
+		return "", err
+	} else if value == nil {
+		return "", BlobNoUploadError
+	}
+	digest := sha256.New()
+	if err = l.rangeUpload(func(idx int64, chunk []byte) error {
+		digest.Write(chunk)
+		return nil
+	}); err != nil {
+		return
+	}
+	sum = hex.EncodeToString(digest.Sum(nil))
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	l.dropUpload(batch)
+	if sum == l.hash {
+		// 内容未变，只清理上传
+		if err = l.redis.WriteBatch(batch); err == nil {
+			l.staged = 0
+		}
+		return
+	}
+	size, refs, ok, err := l.redis.blobMeta(sum)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		size = l.staged
+		copied := gorocks.NewWriteBatch()
+		defer func() {
+			copied.Close()
+		}()
+		if err = l.rangeUpload(func(idx int64, chunk []byte) error {
+			copied.Put(l.redis.blobChunkKey(sum, idx), chunk)
+			if (idx+1)%blobCommitBatch != 0 {
+				return nil
+			}
+			if err := l.redis.WriteBatch(copied); err != nil {
+				return err
+			}
+			copied.Close()
+			copied = gorocks.NewWriteBatch()
+			return nil
+		}); err != nil {
+			return "", err
+		}
+		if err = l.redis.WriteBatch(copied); err != nil {
+			return "", err
+		}
+	}
+
+	batch.Put(l.redis.blobMetaKey(sum), []byte(strconv.FormatInt(size, 10)+","+strconv.FormatInt(refs+1, 10)))
+	if err = l.pointTo(batch, sum); err != nil {
+		return "", err
+	}
+	if err = l.redis.WriteBatch(batch); err != nil {
+		return "", err
+	}
+	l.hash = sum
+	l.staged = 0
+	return
+}
+
+// 放弃未提交的上传，没有上传时返回false
+func (l *LevelBlob) Abort() (ok bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if value, _ := l.redis.RawGet(l.uploadKey()); value == nil {
+		return false, nil
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	l.dropUpload(batch)
+	if err = l.redis.WriteBatch(batch); err != nil {
+		return false, err
+	}
+	l.staged = 0
+	return true, nil
+}
+
+// 返回sha256、长度和引用数，key不存在时sum为空
+func (l *LevelBlob) Stat() (sum string, size, refs int64, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.hash) == 0 {
+		return
+	}
+	size, refs, _, err = l.redis.blobMeta(l.hash)
+	return l.hash, size, refs, err
+}
+
+func (l *LevelBlob) Staged() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.staged
+}
+
+// 按块读取[start, end]字节，fn返回false时停止
+func (l *LevelBlob) Range(start, end int64, fn func(b []byte) bool) (err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.hash) == 0 {
+		return
+	}
+	size, _, _, err := l.redis.blobMeta(l.hash)
+	if err != nil {
+		return
+	}
+	if end >= size {
+		end = size - 1
+	}
+	for pos := start; pos <= end; {
+		idx, i := pos/BlobChunkSize, pos%BlobChunkSize
+		chunk, err := l.redis.RawGet(l.redis.blobChunkKey(l.hash, idx))
+		if err != nil {
+			return err
+		}
+		n := int64(len(chunk)) - i
+		if rest := end - pos + 1; n > rest {
+			n = rest
+		}
+		if n <= 0 {
+			return BlobBadMetaError
+		}
+		if !fn(chunk[i : i+n]) {
+			return nil
+		}
+		pos += n
+	}
+	return
+}
+
+func (l *LevelBlob) Type() string {
+	return BLOB_SUFFIX
+}
+
+// 删除key和未提交的上传，释放引用的内容
+func (l *LevelBlob) Drop() (ok bool) {
+	l.redis.blobMu.Lock()
+	defer l.redis.blobMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	if len(l.hash) > 0 {
+		if err := l.redis.refBlob(batch, l.hash, -1); err != nil {
+			return false
+		}
+	}
+	batch.Delete(l.infoKey())
+	l.dropUpload(batch)
+	if err := l.redis.WriteBatch(batch); err != nil {
+		return false
+	}
+	l.hash = ""
+	l.staged = 0
+	ok = true
+	return
+}
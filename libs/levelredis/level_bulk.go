@@ -0,0 +1,164 @@
+package levelredis
+
+// 批量导入，用于ETL等大量写入的场景
+// 记录先在内存中按key合并，Flush时所有key写入同一个WriteBatch，
+// set/zset的元素数量每个key只计算和写入一次
+//
+//	w := redis.NewBulkWriter()
+//	w.HSet([]byte("user:1"), []byte("name"), []byte("latermoon"))
+//	w.ZAdd([]byte("rank"), 100, []byte("user:1"))
+//	n, err := w.Flush()
+import (
+	"GoRedis/libs/gorocks"
+	"errors"
+	"sort"
+)
+
+var BulkWrongTypeError = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// 同一个key在一批记录里的全部写入
+type bulkKey struct {
+	typ     string
+	value   []byte            // string
+	fields  [][]byte          // hash的field/value
+	members [][]byte          // set的member
+	scores  map[string][]byte // zset的member -> score，同一member以最后一次为准
+}
+
+type BulkWriter struct {
+	redis *LevelRedis
+	keys  map[string]*bulkKey
+	count int
+}
+
+func (l *LevelRedis) NewBulkWriter() (w *BulkWriter) {
+	w = &BulkWriter{redis: l}
+	w.Reset()
+	return
+}
+
+// 未提交的记录数
+func (w *BulkWriter) Len() int {
+	return w.count
+}
+
+// 放弃未提交的记录
+func (w *BulkWriter) Reset() {
+	w.keys = make(map[string]*bulkKey)
+	w.count = 0
+}
+
+func (w *BulkWriter) get(key []byte, typ string) (k *bulkKey, err error) {
+	k, ok := w.keys[string(key)]
+	if !ok {
+		k = &bulkKey{typ: typ}
+		w.keys[string(key)] = k
+	} else if k.typ != typ {
+		return nil, BulkWrongTypeError
+	}
+	w.count++
+	return
+}
+
+func (w *BulkWriter) Set(key, value []byte) (err error) {
+	k, err := w.get(key, STRING_SUFFIX)
+	if err == nil {
+		k.value = value
+	}
+	return
+}
+
+func (w *BulkWriter) HSet(key, field, value []byte) (err error) {
+	k, err := w.get(key, HASH_SUFFIX)
+	if err == nil {
+		k.fields = append(k.fields, field, value)
+	}
+	return
+}
+
+func (w *BulkWriter) SAdd(key, member []byte) (err error) {
+	k, err := w.get(key, SET_SUFFIX)
+	if err == nil {
+		k.members = append(k.members, member)
+	}
+	return
+}
+
+func (w *BulkWriter) ZAdd(key []byte, score float64, member []byte) (err error) {
+	k, err := w.get(key, ZSET_SUFFIX)
+	if err == nil {
+		if k.scores == nil {
+			k.scores = make(map[string][]byte)
+		}
+		k.scores[string(member)] = Float64ToBytes(score)
+	}
+	return
+}
+
+// 在一个WriteBatch里提交全部记录，返回提交的记录数
+// 任何一个key的类型不一致时整批放弃，不写入任何数据
+func (w *BulkWriter) Flush() (n int, err error) {
+	defer w.Reset()
+	if w.count == 0 {
+		return
+	}
+	keys := make([]string, 0, len(w.keys))
+	for key, k := range w.keys {
+		if t := w.redis.TypeOf([]byte(key)); t != "none" && t != k.typ {
+			return 0, BulkWrongTypeError
+		}
+		keys = append(keys, key)
+	}
+	// 按key的顺序加锁，多个BulkWriter之间不会死锁
+	sort.Strings(keys)
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	var unlocks []func()
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+	for _, key := range keys {
+		k := w.keys[key]
+		switch k.typ {
+		case STRING_SUFFIX:
+			if w.redis.HasExpire() {
+				w.redis.Persist([]byte(key))
+			}
+			var value []byte
+			if value, err = w.redis.Strings().encode([]byte(key), k.value); err != nil {
+				return
+			}
+			batch.Put(w.redis.Strings().stringKey([]byte(key)), value)
+		case HASH_SUFFIX:
+			h := w.redis.GetHash(key)
+			h.mu.Lock()
+			unlocks = append(unlocks, h.mu.Unlock)
+			h.set(batch, k.fields...)
+		case SET_SUFFIX:
+			s := w.redis.GetSet(key)
+			s.mu.Lock()
+			unlocks = append(unlocks, s.mu.Unlock)
+			s.add(batch, k.members...)
+		case ZSET_SUFFIX:
+			z := w.redis.GetSortedSet(key)
+			z.mu.Lock()
+			unlocks = append(unlocks, z.mu.Unlock)
+			scoreMembers := make([][]byte, 0, len(k.scores)*2)
+			for member, score := range k.scores {
+				scoreMembers = append(scoreMembers, score, []byte(member))
+			}
+			z.add(batch, 0, scoreMembers...)
+		}
+	}
+	if err = w.redis.WriteBatch(batch); err != nil {
+		// 缓存的对象里已经计入了新的元素数量，需要丢弃后重新读取
+		for _, key := range keys {
+			w.redis.lruCache.Delete(key)
+		}
+		return 0, err
+	}
+	return w.count, nil
+}
@@ -0,0 +1,49 @@
+package levelredis
+
+// string值的编解码，按key前缀注册，写入时Encode，读取时Decode，
+// 用于对敏感前缀做加密、压缩或者JSON规范化
+// 只作用于string类型，必须在启动时注册，运行期间不能修改
+// 同步到从库的是编码后的数据，从库需要注册相同的codec
+import (
+	"bytes"
+)
+
+type ValueCodec interface {
+	Encode(key, value []byte) ([]byte, error)
+	Decode(key, value []byte) ([]byte, error)
+}
+
+type prefixCodec struct {
+	prefix []byte
+	codec  ValueCodec
+}
+
+type codecList []*prefixCodec
+
+// 最长前缀匹配
+func (c codecList) match(key []byte) (codec ValueCodec) {
+	matched := -1
+	for _, pc := range c {
+		if len(pc.prefix) > matched && bytes.HasPrefix(key, pc.prefix) {
+			codec, matched = pc.codec, len(pc.prefix)
+		}
+	}
+	return
+}
+
+func (l *LevelRedis) RegisterCodec(prefix string, codec ValueCodec) {
+	l.lstring.codecs = append(l.lstring.codecs, &prefixCodec{prefix: []byte(prefix), codec: codec})
+}
+
+// 已注册codec的前缀，用于DBINFO
+func (l *LevelRedis) CodecPrefixes() (prefixes []string) {
+	for _, pc := range l.lstring.codecs {
+		prefixes = append(prefixes, string(pc.prefix))
+	}
+	return
+}
+
+// key是否匹配已注册的codec，非string类型不经过codec，用于拒绝在这些key上写入
+func (l *LevelRedis) HasCodec(key []byte) bool {
+	return l.lstring.codecs.match(key) != nil
+}
@@ -0,0 +1,129 @@
+package levelredis
+
+// AES-GCM加密的ValueCodec，密文格式: 0x00 'E' [keyid] [nonce 12] [ciphertext]
+// 支持多个密钥，始终使用current加密，按keyid选择密钥解密，
+// 轮换密钥时加入新密钥并设为current，旧数据在下一次写入时以新密钥加密
+// 没有密文前缀的值视为加密之前写入的明文，原样返回
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var aesMagic = []byte{0, 'E'}
+
+type AESCodec struct {
+	aeads   map[byte]cipher.AEAD
+	current byte
+}
+
+// keys为keyid到16/24/32字节密钥的映射，可以来自文件、环境变量或KMS
+func NewAESCodec(keys map[byte][]byte, current byte) (c *AESCodec, err error) {
+	c = &AESCodec{aeads: make(map[byte]cipher.AEAD), current: current}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %s", id, err)
+		}
+		if c.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := c.aeads[current]; !ok {
+		return nil, errors.New("current key not found")
+	}
+	return
+}
+
+// 每行 "keyid hexkey"，#开头为注释，最后一行为当前使用的密钥
+func ParseAESKeys(r io.Reader) (keys map[byte][]byte, current byte, err error) {
+	keys = make(map[byte][]byte)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, 0, errors.New("bad key line: " + line)
+		}
+		id, e1 := strconv.ParseUint(fields[0], 10, 8)
+		key, e2 := hex.DecodeString(fields[1])
+		if e1 != nil || e2 != nil {
+			return nil, 0, errors.New("bad key line: " + fields[0])
+		}
+		keys[byte(id)] = key
+		current = byte(id)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if len(keys) == 0 {
+		err = errors.New("no key found")
+	}
+	return
+}
+
+// spec为 file:/path/to/keyfile 或 env:VARNAME，内容格式见ParseAESKeys，
+// env中可以用 ; 代替换行
+func LoadAESCodec(spec string) (c *AESCodec, err error) {
+	var r io.Reader
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		f, err := os.Open(spec[len("file:"):])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	case strings.HasPrefix(spec, "env:"):
+		r = strings.NewReader(strings.Replace(os.Getenv(spec[len("env:"):]), ";", "\n", -1))
+	default:
+		return nil, errors.New("key spec must be file:path or env:NAME")
+	}
+	keys, current, err := ParseAESKeys(r)
+	if err != nil {
+		return
+	}
+	return NewAESCodec(keys, current)
+}
+
+func (c *AESCodec) Encode(key, value []byte) (out []byte, err error) {
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	out = make([]byte, 0, len(aesMagic)+1+len(nonce)+len(value)+aead.Overhead())
+	out = append(out, aesMagic...)
+	out = append(out, c.current)
+	out = append(out, nonce...)
+	// key作为附加数据，密文不能被移动到其它key下使用
+	return aead.Seal(out, nonce, value, key), nil
+}
+
+func (c *AESCodec) Decode(key, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, aesMagic) || len(value) < len(aesMagic)+1 {
+		return value, nil
+	}
+	id := value[len(aesMagic)]
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %d", id)
+	}
+	value = value[len(aesMagic)+1:]
+	if len(value) < aead.NonceSize() {
+		return nil, errors.New("bad ciphertext")
+	}
+	return aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], key)
+}
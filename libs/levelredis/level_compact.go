@@ -0,0 +1,20 @@
+package levelredis
+
+// 手动compact，大量删除zset/list等元素之后回收空间
+// rocksdb删除只写入墓碑，等到后台compact才释放磁盘，墓碑过多时遍历也会变慢
+import (
+	"GoRedis/libs/gorocks"
+)
+
+// compact整个rocksdb，包括全部db和系统数据，期间写入不受影响
+func (l *LevelRedis) CompactAll() {
+	l.db.CompactRange(gorocks.Range{})
+}
+
+// compact当前db里以prefix开头的key，类型登记、元素、过期时间等每种前缀各compact一次
+func (l *LevelRedis) CompactPrefix(prefix []byte) {
+	for _, p := range flushPrefixes {
+		start := joinStringBytes(l.ns, p, SEP_LEFT, string(prefix))
+		l.db.CompactRange(gorocks.Range{Start: start, Limit: joinBytes(start, []byte{MAXBYTE})})
+	}
+}
@@ -0,0 +1,173 @@
+package levelredis
+
+// 复制key，从快照按前缀遍历元素，改写前缀后分批写入，不需要把集合读入内存
+// 类型登记和过期时间在最后一批写入，之前newkey不可见，中途失败只留下没有登记的元素，下次写入同名key时被覆盖
+import (
+	"GoRedis/libs/gorocks"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+// 每批写入的元素数
+const copyBatchSize = 10000
+
+// 复制为dst里的newkey，key不存在时返回false；newkey已存在时，replace为false返回false，否则先删除
+// 检查和删除在newkey的对象锁和过期锁里进行，直到写入完成，期间不会有其它写入插进来
+// l一般是快照，dst与l是同一个db时blob只增加内容的引用，跨db时按块复制内容
+func (l *LevelRedis) CopyTo(key []byte, dst *LevelRedis, newkey []byte, replace bool) (ok bool, err error) {
+	t := l.TypeOf(key)
+	if t == "none" {
+		return false, nil
+	}
+	dst.waitUnlinked(string(newkey))
+	// blob的引用和上传由对象自己加锁，这里不持有
+	if t != STRING_SUFFIX && t != BLOB_SUFFIX {
+		if mu := elemLocker(dst.GetElem(string(newkey), t)); mu != nil {
+			mu.Lock()
+			defer mu.Unlock()
+		}
+	}
+	defer dst.expireLock(newkey)()
+	defer dst.lruCache.Delete(string(newkey))
+	if ok = dst.clearCopyTarget(newkey, t, replace); !ok {
+		return
+	}
+	if t == BLOB_SUFFIX {
+		if ok, err = l.copyBlob(key, dst, newkey); !ok || err != nil {
+			return
+		}
+		return true, dst.copyExpire(newkey, l.ExpireAt(key))
+	}
+
+	infokey := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, t)
+	newinfokey := joinStringBytes(dst.ns, KEY_PREFIX, SEP_LEFT, string(newkey), SEP_RIGHT, t)
+	value, err := l.RawGet(infokey)
+	if err != nil {
+		return
+	}
+	// string的codec可能与key相关，按新key重新编码
+	if t == STRING_SUFFIX {
+		if value, err = l.Strings().Decode(key, value); err != nil {
+			return
+		}
+		if value, err = dst.Strings().encode(newkey, value); err != nil {
+			return
+		}
+	}
+
+	batch := gorocks.NewWriteBatch()
+	defer func() {
+		batch.Close()
+	}()
+	if prefix, has := elemPrefixes[t]; has {
+		oldprefix := joinStringBytes(l.ns, prefix, SEP_LEFT, string(key), SEP_RIGHT)
+		newprefix := joinStringBytes(dst.ns, prefix, SEP_LEFT, string(newkey), SEP_RIGHT)
+		l.PrefixEnumerate(oldprefix, IterForward, func(i int, k, v []byte, quit *bool) {
+			batch.Put(joinBytes(newprefix, k[len(oldprefix):]), v)
+			if (i+1)%copyBatchSize != 0 {
+				return
+			}
+			if err = dst.WriteBatch(batch); err != nil {
+				*quit = true
+				return
+			}
+			batch.Close()
+			batch = gorocks.NewWriteBatch()
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	batch.Put(newinfokey, value)
+	if at := l.ExpireAt(key); at != -1 {
+		atomic.StoreInt32(&dst.hasExpire, 1)
+		batch.Put(dst.expireKey(newkey), []byte(strconv.FormatInt(at, 10)))
+		batch.Put(dst.expireIndexKey(at, newkey), []byte{})
+	}
+	err = dst.WriteBatch(batch)
+	return err == nil, err
+}
+
+// 同一个db里先尝试引用相同的内容，内容已经被删除(快照之后)或者跨db时重新上传
+// newkey未提交的上传被放弃
+func (l *LevelRedis) copyBlob(key []byte, dst *LevelRedis, newkey []byte) (ok bool, err error) {
+	src := l.GetBlob(string(key))
+	sum, _, _, err := src.Stat()
+	if err != nil || len(sum) == 0 {
+		return false, err
+	}
+	b := dst.GetBlob(string(newkey))
+	if l.ns == dst.ns {
+		if ok, err = b.Link(sum); ok || err != nil {
+			return
+		}
+	}
+	// 空内容也需要一次上传才能提交
+	if _, err = b.Abort(); err != nil {
+		return
+	}
+	if _, err = b.Append(nil); err != nil {
+		return
+	}
+	var werr error
+	if err = src.Range(0, math.MaxInt64, func(chunk []byte) bool {
+		_, werr = b.Append(chunk)
+		return werr == nil
+	}); err != nil {
+		return
+	}
+	if werr != nil {
+		return false, werr
+	}
+	if _, err = b.Commit(); err != nil {
+		return
+	}
+	return true, nil
+}
+
+// 调用者持有key的过期锁
+func (l *LevelRedis) copyExpire(key []byte, at int64) error {
+	if at == -1 {
+		return nil
+	}
+	atomic.StoreInt32(&l.hasExpire, 1)
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Put(l.expireKey(key), []byte(strconv.FormatInt(at, 10)))
+	batch.Put(l.expireIndexKey(at, key), []byte{})
+	return l.WriteBatch(batch)
+}
+
+// 复制之前检查newkey，不存在或者已删除时返回true，调用者持有newkey的过期锁和t类型的对象锁
+// 与t同类型时对象锁已经持有，不能调用Drop，直接删除类型登记和元素
+func (l *LevelRedis) clearCopyTarget(key []byte, t string, replace bool) bool {
+	e := l.TypeOf(key)
+	if e == "none" {
+		return true
+	} else if !replace {
+		return false
+	}
+	l.persist(key)
+	switch {
+	case e == STRING_SUFFIX:
+		l.Strings().Delete(key)
+	case e == t && e != BLOB_SUFFIX:
+		batch := gorocks.NewWriteBatch()
+		defer batch.Close()
+		if prefix, has := elemPrefixes[e]; has {
+			l.PrefixEnumerate(joinStringBytes(l.ns, prefix, SEP_LEFT, string(key), SEP_RIGHT), IterForward, func(i int, k, v []byte, quit *bool) {
+				batch.Delete(k)
+			})
+		}
+		batch.Delete(joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, e))
+		l.WriteBatch(batch)
+	default:
+		if elem := l.GetElem(string(key), e); elem != nil {
+			elem.Drop()
+		}
+	}
+	l.lruCache.Delete(string(key))
+	return true
+}
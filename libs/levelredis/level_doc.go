@@ -1,9 +1,14 @@
 package levelredis
 
+// 可选的历史版本，每次写入后把完整的doc保存到 _d[key]h#[int64 unixnano]，
+// 只保留最近N个版本，用于审计和回滚
 import (
+	"GoRedis/libs/gorocks"
 	"GoRedis/libs/msgpackgo/codec"
+	"errors"
 	"reflect"
 	"sync"
+	"time"
 )
 
 var docmh *codec.MsgpackHandle
@@ -57,7 +62,7 @@ func (l *LevelDoc) initOnce() {
 }
 
 func (l *LevelDoc) docKey() []byte {
-	return joinStringBytes(KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, DOC_SUFFIX)
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, DOC_SUFFIX)
 }
 
 func (l *LevelDoc) docValue() (out []byte) {
@@ -66,17 +71,99 @@ func (l *LevelDoc) docValue() (out []byte) {
 	return
 }
 
-func (l *LevelDoc) Set(m map[string]interface{}) (err error) {
+func (l *LevelDoc) historyPrefix() []byte {
+	return joinStringBytes(l.redis.ns, DOC_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "h", SEP)
+}
+
+func (l *LevelDoc) historyKey(version int64) []byte {
+	return joinBytes(l.historyPrefix(), Int64ToBytes(version))
+}
+
+// history为保留的历史版本数，0表示不保存历史
+func (l *LevelDoc) Set(m map[string]interface{}, history int) (err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	err = l.doc.Set(m)
 	if err == nil {
-		err = l.redis.RawSet(l.docKey(), l.docValue())
+		err = l.write(history)
 	}
 	return
 }
 
+// 写入doc，同时在一个WriteBatch中追加历史版本并删除超出的旧版本
+func (l *LevelDoc) write(history int) (err error) {
+	value := l.docValue()
+	if history <= 0 {
+		return l.redis.RawSet(l.docKey(), value)
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Put(l.docKey(), value)
+
+	// 从新到旧，保留history-1个旧版本
+	version := time.Now().UnixNano()
+	l.redis.PrefixEnumerate(l.historyPrefix(), IterBackward, func(i int, key, value []byte, quit *bool) {
+		if i == 0 {
+			// 时钟回拨时保持版本递增
+			if last := BytesToInt64(key[len(key)-8:]); version <= last {
+				version = last + 1
+			}
+		}
+		if i >= history-1 {
+			batch.Delete(key)
+		}
+	})
+	batch.Put(l.historyKey(version), value)
+	return l.redis.WriteBatch(batch)
+}
+
+type DocVersion struct {
+	Time time.Time
+	Doc  map[string]interface{}
+}
+
+// 从新到旧返回最多count个历史版本
+func (l *LevelDoc) History(count int) (versions []*DocVersion) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	versions = make([]*DocVersion, 0, count)
+	l.redis.PrefixEnumerate(l.historyPrefix(), IterBackward, func(i int, key, value []byte, quit *bool) {
+		if i >= count {
+			*quit = true
+			return
+		}
+		m := make(map[string]interface{})
+		if err := codec.NewDecoderBytes(value, docmh).Decode(&m); err != nil {
+			return
+		}
+		versions = append(versions, &DocVersion{Time: time.Unix(0, BytesToInt64(key[len(key)-8:])), Doc: m})
+	})
+	return
+}
+
+// 整体替换为第n个历史版本，0为最新版本，回滚本身也作为一个新版本保存
+func (l *LevelDoc) Revert(n int, history int) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var value []byte
+	l.redis.PrefixEnumerate(l.historyPrefix(), IterBackward, func(i int, key, val []byte, quit *bool) {
+		if i == n {
+			value = val
+			*quit = true
+		}
+	})
+	if value == nil {
+		return errors.New("no such version")
+	}
+	m := make(map[string]interface{})
+	if err = codec.NewDecoderBytes(value, docmh).Decode(&m); err != nil {
+		return
+	}
+	l.doc = NewMapDoc(m)
+	return l.write(history)
+}
+
 func (l *LevelDoc) Get(fields ...string) (result map[string]interface{}) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -96,6 +183,9 @@ func (l *LevelDoc) Drop() (ok bool) {
 	if in != nil {
 		l.redis.RawDel(l.docKey())
 	}
+	l.redis.PrefixEnumerate(l.historyPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
+		l.redis.RawDel(key)
+	})
 	l.doc = nil
 	ok = true
 	return
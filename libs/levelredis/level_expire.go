@@ -0,0 +1,152 @@
+package levelredis
+
+// key的过期时间索引，结构与zset相同，过期时间(毫秒)作为score
+// _x[key] = 过期时间
+// _x#[过期时间 8字节]key = ""
+// 按过期时间有序，清理时从头扫描到当前时间即可；
+// 没有设置过任何过期时间时hasExpire为0，读写路径上的检查不产生IO
+import (
+	"GoRedis/libs/gorocks"
+	"strconv"
+	"sync/atomic"
+)
+
+const expireLockCount = 64
+
+func (l *LevelRedis) expireKey(key []byte) []byte {
+	return joinBytes([]byte(l.ns+EXPIRE_PREFIX+SEP_LEFT), key, []byte(SEP_RIGHT))
+}
+
+func (l *LevelRedis) expireIndexPrefix() []byte {
+	return []byte(l.ns + EXPIRE_PREFIX + SEP)
+}
+
+func (l *LevelRedis) expireIndexKey(at int64, key []byte) []byte {
+	return joinBytes(l.expireIndexPrefix(), Int64ToBytes(at), key)
+}
+
+// 打开时检查索引是否为空
+func (l *LevelRedis) initExpire() {
+	if first, _ := l.prefixBounds(l.expireIndexPrefix()); first != nil {
+		atomic.StoreInt32(&l.hasExpire, 1)
+	}
+}
+
+func (l *LevelRedis) HasExpire() bool {
+	return atomic.LoadInt32(&l.hasExpire) == 1
+}
+
+// 同一个key的过期设置、清除和删除串行执行
+func (l *LevelRedis) expireLock(key []byte) func() {
+	mu := &l.expireMus[SumOfStringChars(string(key))%expireLockCount]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// 返回过期时间(毫秒)，没有设置时返回-1
+func (l *LevelRedis) ExpireAt(key []byte) (at int64) {
+	if !l.HasExpire() {
+		return -1
+	}
+	value, _ := l.RawGet(l.expireKey(key))
+	if value == nil {
+		return -1
+	}
+	at, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return
+}
+
+// 设置过期时间(毫秒)，覆盖原来的设置
+func (l *LevelRedis) SetExpireAt(key []byte, at int64) error {
+	defer l.expireLock(key)()
+	atomic.StoreInt32(&l.hasExpire, 1)
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	if old := l.ExpireAt(key); old != -1 {
+		batch.Delete(l.expireIndexKey(old, key))
+	}
+	batch.Put(l.expireKey(key), []byte(strconv.FormatInt(at, 10)))
+	batch.Put(l.expireIndexKey(at, key), []byte{})
+	return l.WriteBatch(batch)
+}
+
+// 清除过期时间，返回是否设置过
+func (l *LevelRedis) Persist(key []byte) (ok bool) {
+	if !l.HasExpire() {
+		return false
+	}
+	defer l.expireLock(key)()
+	return l.persist(key)
+}
+
+func (l *LevelRedis) persist(key []byte) bool {
+	at := l.ExpireAt(key)
+	if at == -1 {
+		return false
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Delete(l.expireKey(key))
+	batch.Delete(l.expireIndexKey(at, key))
+	return l.WriteBatch(batch) == nil
+}
+
+// key已过期时删除，返回是否删除；fn在删除后、释放锁之前调用，用于按顺序写入同步日志
+func (l *LevelRedis) ExpireIfNeeded(key []byte, now int64, fn func(key []byte)) (deleted bool) {
+	if !l.HasExpire() {
+		return false
+	}
+	defer l.expireLock(key)()
+	at := l.ExpireAt(key)
+	if at == -1 || at > now {
+		return false
+	}
+	l.Delete(key) // 同时清除过期时间
+	if fn != nil {
+		fn(key)
+	}
+	return true
+}
+
+// 按过期时间顺序返回最多limit个已过期(过期时间<=now)的key
+func (l *LevelRedis) ExpiredKeys(now int64, limit int) (keys [][]byte) {
+	if !l.HasExpire() {
+		return
+	}
+	prefix := l.expireIndexPrefix()
+	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		if i >= limit || len(key) < len(prefix)+8 || BytesToInt64(key[len(prefix):len(prefix)+8]) > now {
+			*quit = true
+			return
+		}
+		keys = append(keys, key[len(prefix)+8:])
+	})
+	return
+}
+
+// 按过期时间统计key数量，从now开始每window毫秒一个区间，共buckets个区间
+// expired为已过期、等待清理的数量，later为超出统计范围的数量；需要扫描整个过期索引
+func (l *LevelRedis) ExpiryHistogram(now, window int64, buckets int) (expired int64, counts []int64, later int64) {
+	counts = make([]int64, buckets)
+	if !l.HasExpire() {
+		return
+	}
+	prefix := l.expireIndexPrefix()
+	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		if len(key) < len(prefix)+8 {
+			return
+		}
+		at := BytesToInt64(key[len(prefix) : len(prefix)+8])
+		if at <= now {
+			expired++
+		} else if n := (at - now - 1) / window; n < int64(buckets) {
+			counts[n]++
+		} else {
+			later++
+		}
+	})
+	return
+}
@@ -0,0 +1,70 @@
+package levelredis
+
+// 故障注入，用于测试应用在GoRedis降级时的表现，由DEBUG FAULT控制
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var FaultInjectedError = errors.New("fault injected")
+
+type Fault struct {
+	ReadErrorRate  float64       // 0~1，RawGet返回错误的概率
+	WriteErrorRate float64       // 0~1，RawSet/RawDel/WriteBatch返回错误的概率
+	WriteLatency   time.Duration // 每次写入前的延迟
+}
+
+func (f Fault) enabled() bool {
+	return f.ReadErrorRate > 0 || f.WriteErrorRate > 0 || f.WriteLatency > 0
+}
+
+type faultInjector struct {
+	on    int32 // 未开启时只有一次原子读取的开销
+	mu    sync.RWMutex
+	fault Fault
+}
+
+func (l *LevelRedis) SetFault(f Fault) {
+	l.fi.mu.Lock()
+	l.fi.fault = f
+	l.fi.mu.Unlock()
+	if f.enabled() {
+		atomic.StoreInt32(&l.fi.on, 1)
+	} else {
+		atomic.StoreInt32(&l.fi.on, 0)
+	}
+}
+
+func (l *LevelRedis) Fault() Fault {
+	l.fi.mu.RLock()
+	defer l.fi.mu.RUnlock()
+	return l.fi.fault
+}
+
+func (l *LevelRedis) injectRead() error {
+	if atomic.LoadInt32(&l.fi.on) == 0 {
+		return nil
+	}
+	f := l.Fault()
+	if f.ReadErrorRate > 0 && rand.Float64() < f.ReadErrorRate {
+		return FaultInjectedError
+	}
+	return nil
+}
+
+func (l *LevelRedis) injectWrite() error {
+	if atomic.LoadInt32(&l.fi.on) == 0 {
+		return nil
+	}
+	f := l.Fault()
+	if f.WriteLatency > 0 {
+		time.Sleep(f.WriteLatency)
+	}
+	if f.WriteErrorRate > 0 && rand.Float64() < f.WriteErrorRate {
+		return FaultInjectedError
+	}
+	return nil
+}
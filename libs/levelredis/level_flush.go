@@ -0,0 +1,71 @@
+package levelredis
+
+// FLUSHALL，删除全部用户数据，保留系统数据(__goredis:)和布局版本(_v)
+// 同步方式按前缀分批删除后compact；异步方式与UNLINK相同，只删除类型登记，元素由后台删除
+import (
+	"GoRedis/libs/gorocks"
+	"sync/atomic"
+)
+
+// 每个WriteBatch删除的raw key数量
+const flushBatchSize = 10000
+
+// 用户数据的全部前缀
+var flushPrefixes = []string{KEY_PREFIX, BITMAP_PREFIX, DOC_PREFIX, HASH_PREFIX, LIST_PREFIX, BLOB_PREFIX, SET_PREFIX, UNLINK_PREFIX, BLOB_UPLOAD_PREFIX, EXPIRE_PREFIX, ZSET_PREFIX}
+
+// 返回删除的raw key数量，调用者需要保证期间没有写入
+func (l *LevelRedis) FlushAll() (n int64, err error) {
+	for _, prefix := range flushPrefixes {
+		for {
+			batch := gorocks.NewWriteBatch()
+			count := 0
+			l.PrefixEnumerate([]byte(l.ns+prefix), IterForward, func(i int, key, value []byte, quit *bool) {
+				batch.Delete(key)
+				count++
+				*quit = count >= flushBatchSize
+			})
+			if count > 0 {
+				err = l.WriteBatch(batch)
+			}
+			batch.Close()
+			if err != nil {
+				return
+			}
+			n += int64(count)
+			if count < flushBatchSize {
+				break
+			}
+		}
+	}
+	l.lruCache.Clear()
+	atomic.StoreInt32(&l.hasExpire, 0)
+	// 正在删除的UNLINK由认领的goroutine结束
+	l.unlinkMu.Lock()
+	for key, task := range l.unlinking {
+		if !task.claimed {
+			close(task.done)
+			delete(l.unlinking, key)
+		}
+	}
+	atomic.StoreInt32(&l.unlinkCount, int32(len(l.unlinking)))
+	l.unlinkMu.Unlock()
+	for _, prefix := range flushPrefixes {
+		l.db.CompactRange(gorocks.Range{Start: []byte(l.ns + prefix), Limit: append([]byte(l.ns+prefix), MAXBYTE)})
+	}
+	return
+}
+
+// 返回删除的key数量，期间的写入不受影响
+func (l *LevelRedis) FlushAllAsync() (n int) {
+	var after []byte
+	for {
+		keys := make([][]byte, 0, flushBatchSize)
+		after = l.ScanKeys(nil, after, flushBatchSize, func(key, keytype []byte) {
+			keys = append(keys, copyBytes(key))
+		})
+		n += l.Unlink(keys...)
+		if after == nil {
+			return
+		}
+	}
+}
@@ -11,7 +11,8 @@ func newGlobal(redis *LevelRedis) (g *global) {
 	return
 }
 
+// score的编码与zset的版本有关，需要经过LevelZSet读取
 func (g *global) ZScore(key, member []byte) (score []byte, err error) {
-	score, err = g.redis.RawGet(zmemberKey(key, member))
+	score = g.redis.GetSortedSet(string(key)).Score(member)
 	return
 }
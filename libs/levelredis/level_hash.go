@@ -3,40 +3,36 @@ package levelredis
 import (
 	"GoRedis/libs/gorocks"
 	"bytes"
+	"errors"
+	"math"
+	"strconv"
 	"sync"
 )
 
+var (
+	HashNotIntegerError = errors.New("hash value is not an integer")
+	HashNotFloatError   = errors.New("hash value is not a float")
+	IncrOverflowError   = errors.New("increment or decrement would overflow")
+	IncrNaNError        = errors.New("increment would produce NaN or Infinity")
+)
+
 type HashElem struct {
 	Key   []byte
 	Value []byte
 }
 
-// 使用userForSet控制实现set还是hash
 type LevelHash struct {
 	LevelElem
 	redis *LevelRedis
 	// key
 	entryKey string
 	mu       sync.RWMutex
-	// for SET
-	userForSet bool
-}
-
-// 构造方法1
-func NewLevelSet(redis *LevelRedis, key string) (l *LevelHash) {
-	l = &LevelHash{}
-	l.redis = redis
-	l.entryKey = key
-	l.userForSet = true
-	return
 }
 
-// 构造方法2
 func NewLevelHash(redis *LevelRedis, entryKey string) (l *LevelHash) {
 	l = &LevelHash{}
 	l.redis = redis
 	l.entryKey = entryKey
-	l.userForSet = false
 	return
 }
 
@@ -49,11 +45,7 @@ func (l *LevelHash) Size() int {
 }
 
 func (l *LevelHash) infoKey() []byte {
-	if l.userForSet {
-		return joinStringBytes(KEY_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, SET_SUFFIX)
-	} else {
-		return joinStringBytes(KEY_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, HASH_SUFFIX)
-	}
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, HASH_SUFFIX)
 }
 
 func (l *LevelHash) infoValue() []byte {
@@ -65,11 +57,7 @@ func (l *LevelHash) fieldKey(field []byte) []byte {
 }
 
 func (l *LevelHash) fieldPrefix() []byte {
-	if l.userForSet {
-		return joinStringBytes(SET_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT)
-	} else {
-		return joinStringBytes(HASH_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT)
-	}
+	return joinStringBytes(l.redis.ns, HASH_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT)
 }
 
 // 从fieldkey中提取field
@@ -90,25 +78,84 @@ func (l *LevelHash) get(field []byte) (val []byte) {
 	return
 }
 
+// 返回新增的field数量，不包括被覆盖的field
 func (l *LevelHash) Set(fieldVals ...[]byte) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
-	n = 0
+	n = l.set(batch, fieldVals...)
+	if len(fieldVals) > 0 {
+		l.redis.WriteBatch(batch)
+	}
+	return
+}
+
+// 写入batch但不提交，调用者需持有锁
+func (l *LevelHash) set(batch *gorocks.WriteBatch, fieldVals ...[]byte) (n int) {
+	added := make(map[string]bool)
 	for i := 0; i < len(fieldVals); i += 2 {
-		fieldkey := l.fieldKey(fieldVals[i])
-		batch.Put(fieldkey, fieldVals[i+1])
-		n++
+		field := fieldVals[i]
+		if !added[string(field)] && l.get(field) == nil {
+			added[string(field)] = true
+			n++
+		}
+		batch.Put(l.fieldKey(field), fieldVals[i+1])
 	}
-	if n > 0 {
+	if len(fieldVals) > 0 {
 		batch.Put(l.infoKey(), l.infoValue())
-		l.redis.WriteBatch(batch)
 	}
 	return
 }
 
+// field不存在时视为0，返回新值
+func (l *LevelHash) IncrBy(field []byte, incr int64) (n int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old := l.get(field); old != nil {
+		if n, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+			return 0, HashNotIntegerError
+		}
+	}
+	if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+		return 0, IncrOverflowError
+	}
+	n += incr
+	err = l.put(field, []byte(strconv.FormatInt(n, 10)))
+	return
+}
+
+// 与IncrBy一样，按字符串保存，返回新值
+func (l *LevelHash) IncrByFloat(field []byte, incr float64) (newvalue []byte, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f := float64(0)
+	if old := l.get(field); old != nil {
+		if f, err = strconv.ParseFloat(string(old), 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, HashNotFloatError
+		}
+	}
+	f += incr
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, IncrNaNError
+	}
+	newvalue = []byte(strconv.FormatFloat(f, 'f', -1, 64))
+	err = l.put(field, newvalue)
+	return
+}
+
+// 写入单个field，同时写入元信息
+func (l *LevelHash) put(field, value []byte) error {
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Put(l.fieldKey(field), value)
+	batch.Put(l.infoKey(), l.infoValue())
+	return l.redis.WriteBatch(batch)
+}
+
 func (l *LevelHash) GetAll(limit int) (elems []*HashElem) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -131,7 +178,15 @@ func (l *LevelHash) Enumerate(fn func(i int, field, value []byte, quit *bool)) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	l.redis.PrefixEnumerate(l.fieldPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
-		fn(i, l.fieldKey(key), value, quit)
+		fn(i, l.fieldInKey(key), value, quit)
+	})
+}
+
+// 从after之后按field字节顺序扫描最多count个field，返回最后一个field，扫描结束时返回nil
+func (l *LevelHash) Scan(after []byte, count int, fn func(field, value []byte)) (next []byte) {
+	prefix := l.fieldPrefix()
+	return l.redis.ScanPrefix(prefix, after, count, func(key, value []byte) {
+		fn(key[len(prefix):], value)
 	})
 }
 
@@ -180,11 +235,7 @@ func (l *LevelHash) Count() (n int) {
 }
 
 func (l *LevelHash) Type() string {
-	if l.userForSet {
-		return SET_SUFFIX
-	} else {
-		return HASH_SUFFIX
-	}
+	return HASH_SUFFIX
 }
 
 func (l *LevelHash) Drop() (ok bool) {
@@ -67,7 +67,7 @@ func (l *LevelList) Size() int {
 
 // __key:[entry key]:list =
 func (l *LevelList) infoKey() []byte {
-	return joinStringBytes(KEY_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, LIST_SUFFIX)
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, LIST_SUFFIX)
 }
 
 func (l *LevelList) infoValue() []byte {
@@ -75,7 +75,7 @@ func (l *LevelList) infoValue() []byte {
 }
 
 func (l *LevelList) keyPrefix() []byte {
-	return joinStringBytes(LIST_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT)
+	return joinStringBytes(l.redis.ns, LIST_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT)
 }
 
 // _l[key]#11005 = hello
@@ -88,12 +88,12 @@ func (l *LevelList) idxKey(idx int64) []byte {
 		sign = "1"
 	}
 	idxStr := string(Int64ToBytes(idx))
-	return joinStringBytes(LIST_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, SEP, sign, idxStr)
+	return joinStringBytes(l.redis.ns, LIST_PREFIX, SEP_LEFT, l.entryKey, SEP_RIGHT, SEP, sign, idxStr)
 }
 
 func (l *LevelList) splitIndexKey(idxkey []byte) (idx int64) {
-	pos := bytes.LastIndex(idxkey, []byte(SEP))
-	idx = BytesToInt64(idxkey[pos+1+1:]) // +1 skip sign "0/1"
+	// idx固定为最后8个字节，其中可能包含"#"，不能用LastIndex(SEP)定位
+	idx = BytesToInt64(idxkey[len(idxkey)-8:])
 	return
 }
 
@@ -140,86 +140,103 @@ func (l *LevelList) RPush(values ...[]byte) (err error) {
 }
 
 func (l *LevelList) RPop() (e *Element, err error) {
+	return l.pop(false)
+}
+
+func (l *LevelList) LPop() (e *Element, err error) {
+	return l.pop(true)
+}
+
+// 从表头(left=true)或表尾弹出一个元素，list为空时返回nil
+// 跳过数据缺失的idx(异常宕机等原因留下的空洞)并一起移动游标，避免list卡在空洞上无法继续弹出
+func (l *LevelList) pop(left bool) (e *Element, err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.len() == 0 {
-		return nil, nil
-	}
 	// backup
 	oldstart, oldend := l.start, l.end
-
-	// get
-	idx := l.end
-	e = &Element{}
-	e.Value, err = l.redis.RawGet(l.idxKey(idx))
-	if err != nil || e.Value == nil {
-		return
-	}
-
-	// 只剩下一个元素时，删除infoKey(0)
-	shouldReset := l.len() == 1
-	// 删除数据, 更新左游标
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
-	batch.Delete(l.idxKey(idx))
-	if shouldReset {
+	for e == nil && l.len() > 0 {
+		idx := l.end
+		if left {
+			idx = l.start
+		}
+		var value []byte
+		if value, err = l.redis.RawGet(l.idxKey(idx)); err != nil {
+			l.start, l.end = oldstart, oldend
+			return nil, err
+		}
+		batch.Delete(l.idxKey(idx))
+		if left {
+			l.start++
+		} else {
+			l.end--
+		}
+		if value != nil {
+			e = &Element{Value: value}
+		}
+	}
+	if l.start == oldstart && l.end == oldend {
+		return
+	}
+	// 全部弹出时删除infoKey
+	if l.len() == 0 {
 		l.start = 0
 		l.end = -1
 		batch.Delete(l.infoKey())
 	} else {
-		l.end--
 		batch.Put(l.infoKey(), l.infoValue())
 	}
 	err = l.redis.WriteBatch(batch)
 	if err != nil {
 		// 回退
 		l.start, l.end = oldstart, oldend
+		e = nil
 	}
 	return
 }
 
-func (l *LevelList) LPop() (e *Element, err error) {
+// 保留左边
+func (l *LevelList) TrimLeft(count uint) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.len() == 0 {
-		return nil, nil
-	}
-	// backup
-	oldstart, oldend := l.start, l.end
-
-	// get
-	idx := l.start
-	e = &Element{}
-	e.Value, err = l.redis.RawGet(l.idxKey(idx))
-	if err != nil || e.Value == nil {
+	oldlen := l.len()
+	if oldlen == 0 || oldlen <= int64(count) {
 		return
 	}
-	// 只剩下一个元素时，删除infoKey(0)
-	shouldReset := l.len() == 1
-	// 删除数据, 更新左游标
+	oldstart, oldend := l.start, l.end
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
-	batch.Delete(l.idxKey(idx))
+
+	for i := int64(count); i < oldlen; i++ {
+		idx := oldstart + i
+		// fmt.Println("LTRIM", l.entryKey, "i=", i, ", idx=", idx)
+		batch.Delete(l.idxKey(idx))
+		l.end--
+	}
+	shouldReset := l.len() == 0
 	if shouldReset {
 		l.start = 0
 		l.end = -1
 		batch.Delete(l.infoKey())
 	} else {
-		l.start++
 		batch.Put(l.infoKey(), l.infoValue())
 	}
-	err = l.redis.WriteBatch(batch)
+
+	err := l.redis.WriteBatch(batch)
 	if err != nil {
 		// 回退
 		l.start, l.end = oldstart, oldend
+		return
 	}
+	n = int(oldlen - l.len())
 	return
 }
 
-// 保留左边
-func (l *LevelList) TrimLeft(count uint) (n int) {
+// 保留右边
+func (l *LevelList) TrimRight(count uint) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -231,11 +248,9 @@ func (l *LevelList) TrimLeft(count uint) (n int) {
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
 
-	for i := int64(count); i < oldlen; i++ {
-		idx := oldstart + i
-		// fmt.Println("LTRIM", l.entryKey, "i=", i, ", idx=", idx)
-		batch.Delete(l.idxKey(idx))
-		l.end--
+	for i := int64(0); i < oldlen-int64(count); i++ {
+		batch.Delete(l.idxKey(oldstart + i))
+		l.start++
 	}
 	shouldReset := l.len() == 0
 	if shouldReset {
@@ -250,35 +265,43 @@ func (l *LevelList) TrimLeft(count uint) (n int) {
 	if err != nil {
 		// 回退
 		l.start, l.end = oldstart, oldend
+		return
 	}
+	n = int(oldlen - l.len())
 	return
 }
 
+// 返回[start, stop]之间的元素，负数表示从表尾开始计数(-1为最后一个)，超出范围的部分被截断
+// 通过一次RangeEnumerate读取，而不是逐个Index
 func (l *LevelList) Range(start, stop int64) (elems []*Element, err error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	if start < 0 || (stop != -1 && start > stop) {
-		err = errors.New("bad start/stop")
-		return
+	length := l.len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return make([]*Element, 0), nil
 	}
 
-	min := l.idxKey(l.start + start)
-	var max []byte
-	if stop == -1 {
-		max = l.idxKey(l.end)
-		elems = make([]*Element, 0, 100)
-	} else {
-		max = l.idxKey(l.start + stop)
-		buflen := stop - start // 预分配
-		if buflen > 1000 {
-			buflen = 1000
-		}
-		elems = make([]*Element, 0, buflen)
+	buflen := stop - start + 1 // 预分配
+	if buflen > 1000 {
+		buflen = 1000
 	}
+	elems = make([]*Element, 0, buflen)
 
 	keyPrefix := l.keyPrefix()
-	l.redis.RangeEnumerate(min, max, IterForward, func(i int, key, value []byte, quit *bool) {
+	l.redis.RangeEnumerate(l.idxKey(l.start+start), l.idxKey(l.start+stop), IterForward, func(i int, key, value []byte, quit *bool) {
 		if !bytes.HasPrefix(key, keyPrefix) {
 			*quit = true
 			return
@@ -290,19 +313,255 @@ func (l *LevelList) Range(start, stop int64) (elems []*Element, err error) {
 	return
 }
 
+// 删除值等于value的元素，count>0从表头开始删除count个，count<0从表尾开始，count=0全部删除
+// list通过连续的idx寻址，删除后需要把被删元素之后的数据依次前移(count<0时后移)，保持idx连续，
+// 因此LREM的成本是O(N)，所有改动在同一个WriteBatch里完成
+func (l *LevelList) Remove(count int64, value []byte) (n int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.len() == 0 {
+		return
+	}
+	oldstart, oldend := l.start, l.end
+
+	limit := count
+	direction, shift := IterForward, int64(-1)
+	if count < 0 {
+		limit = -count
+		direction, shift = IterBackward, 1
+	}
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	keyPrefix := l.keyPrefix()
+	l.redis.RangeEnumerate(l.idxKey(l.start), l.idxKey(l.end), direction, func(i int, key, val []byte, quit *bool) {
+		if !bytes.HasPrefix(key, keyPrefix) {
+			*quit = true
+			return
+		}
+		if (limit == 0 || n < limit) && bytes.Equal(val, value) {
+			n++
+			return
+		}
+		if n > 0 {
+			batch.Put(l.idxKey(l.splitIndexKey(key)+shift*n), val)
+		}
+	})
+	if n == 0 {
+		return
+	}
+
+	// 删除移动后空出来的一端
+	for i := int64(0); i < n; i++ {
+		if direction == IterForward {
+			batch.Delete(l.idxKey(l.end - i))
+		} else {
+			batch.Delete(l.idxKey(l.start + i))
+		}
+	}
+	if direction == IterForward {
+		l.end -= n
+	} else {
+		l.start += n
+	}
+	if l.len() == 0 {
+		l.start = 0
+		l.end = -1
+		batch.Delete(l.infoKey())
+	} else {
+		batch.Put(l.infoKey(), l.infoValue())
+	}
+	err = l.redis.WriteBatch(batch)
+	if err != nil {
+		// 回退
+		l.start, l.end = oldstart, oldend
+		n = 0
+	}
+	return
+}
+
+// i<0表示从表尾开始计数
 func (l *LevelList) Index(i int64) (e *Element, err error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
+	if i < 0 {
+		i += l.len()
+	}
 	if i < 0 || i >= l.len() {
 		return nil, nil
 	}
-	idx := l.start + i
-	e = &Element{}
-	e.Value, err = l.redis.RawGet(l.idxKey(idx))
-	if err != nil || e.Value == nil {
+	value, err := l.redis.RawGet(l.idxKey(l.start + i))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return &Element{Value: value}, nil
+}
+
+// 在第一个等于pivot的元素之前(before=true)或之后插入value，返回插入后的长度
+// pivot不存在返回-1，list为空返回0
+// 插入点把list分为两段，只移动较短的一段：左段整体左移(start--)或右段整体右移(end++)，
+// 所以最坏情况下需要改写N/2个元素
+func (l *LevelList) Insert(before bool, pivot, value []byte) (n int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	length := l.len()
+	if length == 0 {
+		return 0, nil
+	}
+
+	// 查找pivot位置
+	pos := int64(-1)
+	keyPrefix := l.keyPrefix()
+	l.redis.RangeEnumerate(l.idxKey(l.start), l.idxKey(l.end), IterForward, func(i int, key, val []byte, quit *bool) {
+		if !bytes.HasPrefix(key, keyPrefix) {
+			*quit = true
+			return
+		}
+		if bytes.Equal(val, pivot) {
+			pos = l.splitIndexKey(key) - l.start
+			*quit = true
+		}
+	})
+	if pos == -1 {
+		return -1, nil
+	}
+	// 新元素插入后位于第ins个
+	ins := pos
+	if !before {
+		ins++
+	}
+
+	oldstart, oldend := l.start, l.end
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	if ins <= length/2 {
+		// [0, ins)左移
+		if ins > 0 {
+			l.redis.RangeEnumerate(l.idxKey(l.start), l.idxKey(l.start+ins-1), IterForward, func(i int, key, val []byte, quit *bool) {
+				batch.Put(l.idxKey(l.splitIndexKey(key)-1), val)
+			})
+		}
+		batch.Put(l.idxKey(l.start+ins-1), value)
+		l.start--
+	} else {
+		// [ins, len)右移
+		if ins < length {
+			l.redis.RangeEnumerate(l.idxKey(l.start+ins), l.idxKey(l.end), IterForward, func(i int, key, val []byte, quit *bool) {
+				batch.Put(l.idxKey(l.splitIndexKey(key)+1), val)
+			})
+		}
+		batch.Put(l.idxKey(l.start+ins), value)
+		l.end++
+	}
+	batch.Put(l.infoKey(), l.infoValue())
+	if err = l.redis.WriteBatch(batch); err != nil {
+		// 回退
+		l.start, l.end = oldstart, oldend
 		return
 	}
+	return l.len(), nil
+}
+
+var IndexOutOfRangeError = errors.New("index out of range")
+
+// 覆盖第i个元素，i<0表示从表尾开始计数
+func (l *LevelList) Set(i int64, value []byte) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if i < 0 {
+		i += l.len()
+	}
+	if i < 0 || i >= l.len() {
+		return IndexOutOfRangeError
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Put(l.idxKey(l.start+i), value)
+	return l.redis.WriteBatch(batch)
+}
+
+// 从src的一端弹出元素并压入dst的一端(RPOPLPUSH/LMOVE)，fromLeft/toLeft表示操作表头还是表尾
+// 删除和写入在同一个WriteBatch里完成，避免中途宕机丢失元素；src和dst可以是同一个list(旋转)
+func MoveElem(src, dst *LevelList, fromLeft, toLeft bool) (e *Element, err error) {
+	// 按key的顺序加锁，避免两个方向相反的LMOVE互相等待
+	if src == dst {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+	} else if src.entryKey < dst.entryKey {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+		dst.mu.Lock()
+		defer dst.mu.Unlock()
+	} else {
+		dst.mu.Lock()
+		defer dst.mu.Unlock()
+		src.mu.Lock()
+		defer src.mu.Unlock()
+	}
+
+	if src.len() == 0 {
+		return nil, nil
+	}
+	// backup
+	srcstart, srcend := src.start, src.end
+	dststart, dstend := dst.start, dst.end
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	// pop，与pop()一样跳过数据缺失的idx
+	var value []byte
+	for value == nil && src.len() > 0 {
+		idx := src.end
+		if fromLeft {
+			idx = src.start
+		}
+		if value, err = src.redis.RawGet(src.idxKey(idx)); err != nil {
+			src.start, src.end = srcstart, srcend
+			return nil, err
+		}
+		batch.Delete(src.idxKey(idx))
+		if fromLeft {
+			src.start++
+		} else {
+			src.end--
+		}
+	}
+	if src.len() == 0 {
+		src.start = 0
+		src.end = -1
+		batch.Delete(src.infoKey())
+	} else {
+		batch.Put(src.infoKey(), src.infoValue())
+	}
+	if value == nil {
+		// 只有空洞，清理后按空list处理
+		if err = src.redis.WriteBatch(batch); err != nil {
+			src.start, src.end = srcstart, srcend
+		}
+		return nil, err
+	}
+	e = &Element{Value: value}
+	// push，同一个batch里后写入的操作覆盖之前的删除
+	if toLeft {
+		dst.start--
+		batch.Put(dst.idxKey(dst.start), value)
+	} else {
+		dst.end++
+		batch.Put(dst.idxKey(dst.end), value)
+	}
+	batch.Put(dst.infoKey(), dst.infoValue())
+
+	err = src.redis.WriteBatch(batch)
+	if err != nil {
+		// 回退
+		src.start, src.end = srcstart, srcend
+		dst.start, dst.end = dststart, dstend
+		e = nil
+	}
 	return
 }
 
@@ -323,6 +582,8 @@ func (l *LevelList) len() int64 {
 }
 
 func (l *LevelList) Len() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return l.len()
 }
 
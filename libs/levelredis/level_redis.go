@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"errors"
 	"math"
+	"strconv"
 	"sync"
 )
 
@@ -45,6 +46,21 @@ zset
 	_z[user_rank]s#-2#100422 = ""
 	_z[user_rank]s#1#100423 = ""
 	_z[user_rank]s#2#300000 = ""
+bitmap
+	+[online]bitmap = "8193"
+	_b[online][0 8字节] = "\x80"
+	_b[online][2 8字节] = "\x01"
+blob
+	+[mail:1]blob = "9f86d08..."
+	_o[9f86d08...] = "4,2"
+	_o[9f86d08...]#[0 8字节] = "test"
+expire
+	_x[name] = "1404381616000"
+	_x#[1404381616000 8字节]name = ""
+db
+	db0没有前缀，db1以后的每个raw key以@index开头，系统数据只在db0
+	@1+[name]string = "latermoon"
+	@1_h[info]name = "latermoon"
 */
 
 // 共用字段
@@ -53,6 +69,7 @@ const (
 	SEP_LEFT   = "["
 	SEP_RIGHT  = "]"
 	KEY_PREFIX = "+"
+	DB_PREFIX  = "@" // db1以后的raw key以@index开头
 )
 
 // 字节最大范围
@@ -66,14 +83,22 @@ const (
 	SET_SUFFIX    = "set"
 	ZSET_SUFFIX   = "zset"
 	DOC_SUFFIX    = "doc"
+	BITMAP_SUFFIX = "bitmap"
+	BLOB_SUFFIX   = "blob"
 )
 
 // 数据结构的key前缀
 const (
-	HASH_PREFIX = "_h"
-	LIST_PREFIX = "_l"
-	SET_PREFIX  = "_s"
-	ZSET_PREFIX = "_z"
+	HASH_PREFIX        = "_h"
+	LIST_PREFIX        = "_l"
+	SET_PREFIX         = "_s"
+	ZSET_PREFIX        = "_z"
+	DOC_PREFIX         = "_d" // doc的历史版本
+	BITMAP_PREFIX      = "_b"
+	BLOB_PREFIX        = "_o" // 按sha256保存的blob内容
+	BLOB_UPLOAD_PREFIX = "_u" // 未提交的blob上传
+	EXPIRE_PREFIX      = "_x" // 过期时间索引
+	UNLINK_PREFIX      = "_t" // 等待后台删除元素的key
 )
 
 // 枚举方向
@@ -108,19 +133,41 @@ type LevelRedis struct {
 	mus      []sync.Mutex  // Key Hash线程池
 	lstring  *LevelString
 	g        *global
+	watcher  *PrefixWatcher // 前缀订阅
+	fi       faultInjector  // 故障注入
 	// stats
 	muCount  sync.Mutex
 	counters map[string]int64
 	snap     *gorocks.Snapshot
+	blobMu   sync.Mutex // blob内容的引用计数
+	// 过期时间
+	hasExpire int32
+	expireMus [expireLockCount]sync.Mutex
+	// UNLINK
+	unlinkMu    sync.Mutex
+	unlinking   map[string]*unlinkTask
+	unlinkCount int32
+	// 多db
+	ns     string      // raw key的前缀，db0为空
+	parent *LevelRedis // db1以后共用db0的gorocks.DB
 }
 
 // snapshot，快照模式
 func NewLevelRedis(db *gorocks.DB, snapshot bool) (l *LevelRedis) {
+	var snap *gorocks.Snapshot
+	if snapshot {
+		snap = db.NewSnapshot() //必须调用Close()释放snap
+	}
+	return newLevelRedis(db, snap, "")
+}
+
+func newLevelRedis(db *gorocks.DB, snap *gorocks.Snapshot, ns string) (l *LevelRedis) {
 	l = &LevelRedis{}
 	l.db = db
+	l.ns = ns
 	l.ro = gorocks.NewReadOptions()
-	if snapshot {
-		l.snap = l.db.NewSnapshot() //必须调用Close()释放snap
+	if snap != nil {
+		l.snap = snap
 		l.ro.SetSnapshot(l.snap)
 		l.ro.SetFillCache(false)
 		l.wo = nil // snapshot模式下禁止写入数据
@@ -130,35 +177,57 @@ func NewLevelRedis(db *gorocks.DB, snapshot bool) (l *LevelRedis) {
 	l.counters = map[string]int64{"get": 0, "set": 0, "batch": 0, "del": 0, "enum": 0, "lru_hit": 0, "lru_miss": 0}
 	l.lstring = NewLevelString(l)
 	l.g = newGlobal(l)
+	l.watcher = NewPrefixWatcher()
 	l.lruCache = lru.NewLRUCache(lruCacheSize)
 	l.mus = make([]sync.Mutex, objCacheCreateThread)
 	// 初始化最大的key，对于Enumerate从后面开始扫描key非常重要
 	// 使iter.Seek(key)必定Valid=true
-	if !snapshot {
+	if snap == nil {
 		maxkey := []byte{MAXBYTE}
 		l.RawSet(maxkey, nil)
 	}
+	l.initExpire()
+	l.initUnlink()
 	return
 }
 
-func (l *LevelRedis) Snapshot() *LevelRedis {
-	return NewLevelRedis(l.db, true)
+func (l *LevelRedis) Snapshot() (snap *LevelRedis) {
+	snap = newLevelRedis(l.db, l.db.NewSnapshot(), l.ns)
+	snap.lstring.codecs = l.lstring.codecs
+	return
 }
 
 func (l *LevelRedis) DB() (db *gorocks.DB) {
 	return l.db
 }
 
+// 同一个gorocks.DB里的第index个db，index为0时返回自己
+// db0的raw key没有前缀，与单db时的数据一致；其它db的每个raw key以@index开头
+// 与db0共用codec和前缀订阅，需要在注册codec之后调用
+// 在快照上调用时使用同一个快照
+func (l *LevelRedis) Database(index int) (d *LevelRedis) {
+	if index == 0 {
+		return l
+	}
+	d = newLevelRedis(l.db, l.snap, DB_PREFIX+strconv.Itoa(index))
+	d.lstring.codecs = l.lstring.codecs
+	d.parent = l
+	return
+}
+
 func (l *LevelRedis) Close() {
 	l.ro.Close()
 	if l.wo != nil {
 		l.wo.Close()
 	}
-	// 处于snap模式的话，db不属于自己，只关闭其他变量
+	// 处于snap模式或者不是db0的话，db不属于自己，只关闭其他变量
+	// 快照由db0释放
 	if l.snap != nil {
-		l.db.ReleaseSnapshot(l.snap)
+		if l.parent == nil {
+			l.db.ReleaseSnapshot(l.snap)
+		}
 		l.snap = nil
-	} else {
+	} else if l.parent == nil {
 		l.db.Close()
 	}
 }
@@ -217,6 +286,10 @@ func (l *LevelRedis) Counter(name string) int64 {
 	return l.counters[name]
 }
 
+func (l *LevelRedis) Watcher() *PrefixWatcher {
+	return l.watcher
+}
+
 func (l *LevelRedis) Strings() (s *LevelString) {
 	return l.lstring
 }
@@ -224,6 +297,9 @@ func (l *LevelRedis) Strings() (s *LevelString) {
 // 获取原始key的内容
 func (l *LevelRedis) RawGet(key []byte) (value []byte, err error) {
 	l.incrCounter("get")
+	if err = l.injectRead(); err != nil {
+		return
+	}
 	value, err = l.db.Get(l.ro, key)
 	return
 }
@@ -233,6 +309,9 @@ func (l *LevelRedis) RawSet(key []byte, value []byte) error {
 		return errors.New("RawSet not allowed")
 	}
 	l.incrCounter("set")
+	if err := l.injectWrite(); err != nil {
+		return err
+	}
 	return l.db.Put(l.wo, key, value)
 }
 
@@ -241,6 +320,9 @@ func (l *LevelRedis) RawDel(key []byte) error {
 		return errors.New("RawDel not allowed")
 	}
 	l.incrCounter("del")
+	if err := l.injectWrite(); err != nil {
+		return err
+	}
 	return l.db.Delete(l.wo, key)
 }
 
@@ -249,18 +331,28 @@ func (l *LevelRedis) WriteBatch(w *gorocks.WriteBatch) error {
 		return errors.New("WriteBatch not allowed")
 	}
 	l.incrCounter("batch")
+	if err := l.injectWrite(); err != nil {
+		return err
+	}
 	return l.db.Write(l.wo, w)
 }
 
 // 使用LRUCache管理string以外的数据结构实例
-func (l *LevelRedis) objFromCache(key string, fn func() interface{}) (obj interface{}) {
+// key被清空后可能以另一种类型重新创建，缓存中的实例类型不一致时需要重新构造
+func (l *LevelRedis) objFromCache(key string, typ string, fn func() interface{}) (obj interface{}) {
 	// 因为level对象构造需要时间，这里使用多个mutex来多线程处理，同一个key只会hash到同一个mutex里
-	mu := l.mus[SumOfStringChars(key)%objCacheCreateThread]
+	// UNLINK之后还没删除的元素必须先删除，否则会出现在新建的对象里
+	l.waitUnlinked(key)
+
+	mu := &l.mus[SumOfStringChars(key)%objCacheCreateThread] // 取指针，复制Mutex会让锁失效
 	mu.Lock()
 	defer mu.Unlock()
 
 	var ok bool
 	obj, ok = l.lruCache.Get(key)
+	if ok && obj.(LevelElem).Type() != typ {
+		ok = false
+	}
 	if !ok {
 		obj = fn()
 		l.lruCache.Set(key, obj.(lru.Value))
@@ -282,6 +374,12 @@ func (l *LevelRedis) GetElem(key string, typ string) (e LevelElem) {
 		return l.GetSet(key)
 	case ZSET_SUFFIX:
 		return l.GetSortedSet(key)
+	case DOC_SUFFIX:
+		return l.GetDoc(key)
+	case BITMAP_SUFFIX:
+		return l.GetBitmap(key)
+	case BLOB_SUFFIX:
+		return l.GetBlob(key)
 	default:
 		e = nil
 	}
@@ -289,42 +387,56 @@ func (l *LevelRedis) GetElem(key string, typ string) (e LevelElem) {
 }
 
 func (l *LevelRedis) GetList(key string) (lst *LevelList) {
-	obj := l.objFromCache(key, func() interface{} {
+	obj := l.objFromCache(key, LIST_SUFFIX, func() interface{} {
 		return NewLevelList(l, key)
 	})
 	return obj.(*LevelList)
 }
 
 func (l *LevelRedis) GetHash(key string) (h *LevelHash) {
-	obj := l.objFromCache(key, func() interface{} {
+	obj := l.objFromCache(key, HASH_SUFFIX, func() interface{} {
 		return NewLevelHash(l, key)
 	})
 	return obj.(*LevelHash)
 }
 
-func (l *LevelRedis) GetSet(key string) (s *LevelHash) {
-	obj := l.objFromCache(key, func() interface{} {
+func (l *LevelRedis) GetSet(key string) (s *LevelSet) {
+	obj := l.objFromCache(key, SET_SUFFIX, func() interface{} {
 		return NewLevelSet(l, key)
 	})
-	return obj.(*LevelHash)
+	return obj.(*LevelSet)
 }
 
 func (l *LevelRedis) GetSortedSet(key string) (z *LevelZSet) {
-	obj := l.objFromCache(key, func() interface{} {
+	obj := l.objFromCache(key, ZSET_SUFFIX, func() interface{} {
 		return NewLevelZSet(l, key)
 	})
 	return obj.(*LevelZSet)
 }
 
 func (l *LevelRedis) GetDoc(key string) (d *LevelDoc) {
-	obj := l.objFromCache(key, func() interface{} {
+	obj := l.objFromCache(key, DOC_SUFFIX, func() interface{} {
 		return NewLevelDoc(l, key)
 	})
 	return obj.(*LevelDoc)
 }
 
+func (l *LevelRedis) GetBitmap(key string) (b *LevelBitmap) {
+	obj := l.objFromCache(key, BITMAP_SUFFIX, func() interface{} {
+		return NewLevelBitmap(l, key)
+	})
+	return obj.(*LevelBitmap)
+}
+
+func (l *LevelRedis) GetBlob(key string) (b *LevelBlob) {
+	obj := l.objFromCache(key, BLOB_SUFFIX, func() interface{} {
+		return NewLevelBlob(l, key)
+	})
+	return obj.(*LevelBlob)
+}
+
 func (l *LevelRedis) TypeOf(key []byte) (t string) {
-	prefix := joinStringBytes(KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT)
+	prefix := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT)
 	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
 		right := bytes.LastIndex(key, []byte(SEP_RIGHT))
 		t = string(key[right+1:])
@@ -337,35 +449,52 @@ func (l *LevelRedis) TypeOf(key []byte) (t string) {
 }
 
 func (l *LevelRedis) Delete(keys ...[]byte) (n int) {
-	for _, keybytes := range keys {
-		key := string(keybytes)
-		t := l.TypeOf(keybytes)
-
-		if t == "string" {
-			n += l.Strings().Delete(keybytes)
-		} else if t == "none" {
-			continue
-		} else {
-			// 使用相同的lock来处理对象的创建和删除
-			mu := l.mus[SumOfStringChars(key)%objCacheCreateThread]
-			mu.Lock()
-			defer mu.Unlock()
-
-			elem := l.GetElem(key, t)
-			if elem != nil {
-				if ok := elem.Drop(); ok {
-					n++
-				}
-			}
-			l.lruCache.Delete(key)
+	for _, key := range keys {
+		if l.deleteKey(key) {
+			n++
 		}
 	}
 	return
 }
 
+func (l *LevelRedis) deleteKey(keybytes []byte) (ok bool) {
+	key := string(keybytes)
+	if l.HasExpire() {
+		l.persist(keybytes)
+	}
+	t := l.TypeOf(keybytes)
+	if t == STRING_SUFFIX {
+		return l.Strings().Delete(keybytes) > 0
+	} else if t == "none" {
+		return false
+	}
+	// 使用相同的lock来处理对象的创建和删除
+	mu := &l.mus[SumOfStringChars(key)%objCacheCreateThread]
+	mu.Lock()
+	defer mu.Unlock()
+
+	if elem := l.GetElem(key, t); elem != nil {
+		ok = elem.Drop()
+	}
+	l.lruCache.Delete(key)
+	return
+}
+
+// 扫描类型登记统计key的数量，需要遍历全部key
+func (l *LevelRedis) KeyCount() (n int64) {
+	var last []byte
+	l.Keys(nil, func(i int, key, keytype []byte, quit *bool) {
+		if !bytes.Equal(key, last) {
+			n++
+			last = key
+		}
+	})
+	return
+}
+
 // keys前缀扫描
 func (l *LevelRedis) Keys(prefix []byte, fn func(i int, key, keytype []byte, quit *bool)) {
-	rawprefix := joinStringBytes(KEY_PREFIX, SEP_LEFT, string(prefix))
+	rawprefix := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(prefix))
 	l.PrefixEnumerate(rawprefix, IterForward, func(i int, key, value []byte, quit *bool) {
 		left := bytes.Index(key, []byte(SEP_LEFT))
 		right := bytes.LastIndex(key, []byte(SEP_RIGHT))
@@ -376,15 +505,16 @@ func (l *LevelRedis) Keys(prefix []byte, fn func(i int, key, keytype []byte, qui
 // 前缀扫描
 func (l *LevelRedis) PrefixEnumerate(prefix []byte, direction IterDirection, fn func(i int, key, value []byte, quit *bool)) {
 	min := prefix
-	/**
-	*
-	 */
-	max := append(prefix, MAXBYTE)
+	// 不能用prefix+0xFF做上界，score编码后首字节可能就是0xFF(如+inf)
+	max := prefixEnd(prefix)
 	j := -1
 	l.RangeEnumerate(min, max, direction, func(i int, key, value []byte, quit *bool) {
 		if bytes.HasPrefix(key, prefix) {
 			j++
 			fn(j, key, value, quit)
+		} else if bytes.Equal(key, max) {
+			// 反向扫描时Seek(max)可能正好停在上界，跳过即可
+			return
 		} else {
 			/**
 			 * 根据leveldb 的 key有序，因此具有相同前缀的key必定是在一起的
@@ -411,9 +541,9 @@ func (l *LevelRedis) KeyEnumerate(seek []byte, direction IterDirection, fn func(
 	}
 	defer iter.Close()
 
-	minkey := joinStringBytes(KEY_PREFIX, SEP_LEFT, string(seek))
+	minkey := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(seek))
 	maxkey := []byte{MAXBYTE}
-	prefix := joinStringBytes(KEY_PREFIX, SEP_LEFT)
+	prefix := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT)
 	l.Enumerate(iter, minkey, maxkey, direction, func(i int, key, value []byte, quit *bool) {
 		if !bytes.HasPrefix(key, prefix) {
 			*quit = true
@@ -451,6 +581,10 @@ func (l *LevelRedis) Enumerate(iter *gorocks.Iterator, min, max []byte, directio
 			iter.SeekToLast()
 		} else {
 			iter.Seek(max)
+			// max之后已经没有数据，比如最后一个key的反向扫描，从最后一条开始
+			if !iter.Valid() {
+				iter.SeekToLast()
+			}
 		}
 	} else {
 		if len(min) == 0 {
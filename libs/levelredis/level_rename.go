@@ -0,0 +1,130 @@
+package levelredis
+
+// 重命名key，类型登记、元素和过期时间在同一个WriteBatch里改写前缀
+// 数据按key名组织，没有间接层，元素越多耗时越长，百万级的集合需要数秒
+// MOVE到另一个db同样是改写前缀
+import (
+	"GoRedis/libs/gorocks"
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// blob的内容按sha256在各自的db里计数引用，不能直接移动
+var MoveBlobError = errors.New("blob keys can not be moved between databases")
+
+// 各类型元素的前缀，string的值保存在类型登记里，blob的内容按sha256保存，与key无关
+var elemPrefixes = map[string]string{
+	HASH_SUFFIX:   HASH_PREFIX,
+	LIST_SUFFIX:   LIST_PREFIX,
+	SET_SUFFIX:    SET_PREFIX,
+	ZSET_SUFFIX:   ZSET_PREFIX,
+	DOC_SUFFIX:    DOC_PREFIX,
+	BITMAP_SUFFIX: BITMAP_PREFIX,
+}
+
+// 对象的写锁，改写期间阻止通过缓存对象的写入
+func elemLocker(e LevelElem) sync.Locker {
+	switch obj := e.(type) {
+	case *LevelHash:
+		return &obj.mu
+	case *LevelList:
+		return &obj.mu
+	case *LevelSet:
+		return &obj.mu
+	case *LevelZSet:
+		return &obj.mu
+	case *LevelDoc:
+		return &obj.mu
+	case *LevelBitmap:
+		return &obj.mu
+	case *LevelBlob:
+		return &obj.mu
+	}
+	return nil
+}
+
+// newkey已存在时先删除，与redis一致保留过期时间，key不存在时返回false
+// 未提交的blob上传不随key移动
+func (l *LevelRedis) Rename(key, newkey []byte) (ok bool, err error) {
+	if l.TypeOf(key) == "none" {
+		return false, nil
+	}
+	if bytes.Equal(key, newkey) {
+		return true, nil
+	}
+	l.Delete(newkey)
+	return l.moveKey(key, l, newkey)
+}
+
+// 移动到另一个db，key不存在或者目标db里已经存在时返回false
+func (l *LevelRedis) Move(key []byte, dst *LevelRedis) (ok bool, err error) {
+	if dst == l || dst.TypeOf(key) != "none" {
+		return false, nil
+	}
+	if l.TypeOf(key) == BLOB_SUFFIX {
+		return false, MoveBlobError
+	}
+	return l.moveKey(key, dst, key)
+}
+
+// 改写为dst里的newkey，newkey必须不存在
+func (l *LevelRedis) moveKey(key []byte, dst *LevelRedis, newkey []byte) (ok bool, err error) {
+	t := l.TypeOf(key)
+	if t == "none" {
+		return false, nil
+	}
+	dst.waitUnlinked(string(newkey))
+
+	if t != STRING_SUFFIX {
+		if mu := elemLocker(l.GetElem(string(key), t)); mu != nil {
+			mu.Lock()
+			defer mu.Unlock()
+		}
+	}
+	defer l.expireLock(key)()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	infokey := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, t)
+	newinfokey := joinStringBytes(dst.ns, KEY_PREFIX, SEP_LEFT, string(newkey), SEP_RIGHT, t)
+	value, err := l.RawGet(infokey)
+	if err != nil {
+		return
+	}
+	// string的codec可能与key相关，按新key重新编码
+	if t == STRING_SUFFIX {
+		if value, err = l.Strings().Decode(key, value); err != nil {
+			return
+		}
+		if value, err = dst.Strings().encode(newkey, value); err != nil {
+			return
+		}
+	}
+	batch.Delete(infokey)
+	batch.Put(newinfokey, value)
+
+	if prefix, ok := elemPrefixes[t]; ok {
+		oldprefix := joinStringBytes(l.ns, prefix, SEP_LEFT, string(key), SEP_RIGHT)
+		newprefix := joinStringBytes(dst.ns, prefix, SEP_LEFT, string(newkey), SEP_RIGHT)
+		l.PrefixEnumerate(oldprefix, IterForward, func(i int, k, v []byte, quit *bool) {
+			batch.Delete(k)
+			batch.Put(joinBytes(newprefix, k[len(oldprefix):]), v)
+		})
+	}
+
+	if at := l.ExpireAt(key); at != -1 {
+		atomic.StoreInt32(&dst.hasExpire, 1)
+		batch.Delete(l.expireKey(key))
+		batch.Delete(l.expireIndexKey(at, key))
+		batch.Put(dst.expireKey(newkey), []byte(strconv.FormatInt(at, 10)))
+		batch.Put(dst.expireIndexKey(at, newkey), []byte{})
+	}
+
+	err = l.WriteBatch(batch)
+	l.lruCache.Delete(string(key))
+	dst.lruCache.Delete(string(newkey))
+	return err == nil, err
+}
@@ -0,0 +1,235 @@
+package levelredis
+
+// 基于key字节的游标扫描，供SCAN/HSCAN/SSCAN/ZSCAN使用
+// 游标记录的是上一批最后一个key(去掉prefix)，而不是迭代器位置，
+// 所以leveldb compaction、重启以及扫描期间的写入都不会使游标失效：
+// 1、扫描开始前已存在、且期间未被删除的key，保证被返回且只返回一次
+// 2、扫描期间新增的key，位于游标之后的会被返回，之前的不会
+// 3、已删除的key不会被返回
+// 另外提供随机seek，供ZRANDMEMBER/SRANDMEMBER/SPOP抽样使用
+import (
+	"GoRedis/libs/gorocks"
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+)
+
+// 从after之后开始扫描prefix下最多count个key，after为nil表示从头开始
+// 返回next为最后一个被扫描的key去掉prefix的部分，nil表示已经扫描完毕
+func (l *LevelRedis) ScanPrefix(prefix, after []byte, count int, fn func(key, value []byte)) (next []byte) {
+	min := make([]byte, 0, len(prefix)+len(after)+1)
+	min = append(min, prefix...)
+	if after != nil {
+		min = append(min, after...)
+		min = append(min, 0) // after的下一个key
+	}
+	max := make([]byte, 0, len(prefix)+1)
+	max = append(max, prefix...)
+	max = append(max, MAXBYTE)
+
+	n := 0
+	var last []byte
+	l.RangeEnumerate(min, max, IterForward, func(i int, key, value []byte, quit *bool) {
+		if !bytes.HasPrefix(key, prefix) {
+			*quit = true
+			return
+		}
+		// 多读一条，用来判断是否还有剩余数据，避免返回一个空的最后批次
+		if n >= count {
+			next = last
+			*quit = true
+			return
+		}
+		fn(key, value)
+		last = key[len(prefix):]
+		n++
+	})
+	return
+}
+
+// 扫描全部key的类型登记(+[key]type)，返回的游标是最后一个登记去掉"+["的部分，
+// prefix用于MATCH的固定前缀，只扫描这个范围，游标在范围之外时从范围开头开始或直接结束
+func (l *LevelRedis) ScanKeys(prefix, after []byte, count int, fn func(key, keytype []byte)) (next []byte) {
+	if after != nil && !bytes.HasPrefix(after, prefix) {
+		if bytes.Compare(after, prefix) > 0 {
+			return nil
+		}
+		after = nil
+	}
+	if after != nil {
+		after = after[len(prefix):]
+	}
+	rawprefix := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(prefix))
+	next = l.ScanPrefix(rawprefix, after, count, func(key, value []byte) {
+		right := bytes.LastIndex(key, []byte(SEP_RIGHT))
+		if right < len(l.ns+KEY_PREFIX+SEP_LEFT) {
+			return
+		}
+		fn(key[len(l.ns+KEY_PREFIX+SEP_LEFT):right], key[right+1:])
+	})
+	if next != nil {
+		next = joinBytes(prefix, next)
+	}
+	return
+}
+
+// 前缀迭代器，按key字节顺序遍历prefix下的key，用于同时遍历多个集合做归并
+// 快照上创建的迭代器读取快照数据，使用完必须Close
+type PrefixIterator struct {
+	iter   *gorocks.Iterator
+	ro     *gorocks.ReadOptions // 非快照时创建，Close时释放
+	prefix []byte
+	key    []byte // 当前key去掉prefix的部分，nil表示已经结束
+}
+
+func (l *LevelRedis) NewPrefixIterator(prefix []byte) (p *PrefixIterator) {
+	p = &PrefixIterator{prefix: copyBytes(prefix)}
+	if l.snap != nil {
+		p.iter = l.db.NewIterator(l.ro)
+	} else {
+		p.ro = gorocks.NewReadOptions()
+		p.ro.SetFillCache(false)
+		p.iter = l.db.NewIterator(p.ro)
+	}
+	p.iter.Seek(p.prefix)
+	p.load()
+	return
+}
+
+func (p *PrefixIterator) load() {
+	p.key = nil
+	if p.iter.Valid() {
+		if key := p.iter.Key(); bytes.HasPrefix(key, p.prefix) {
+			p.key = copyBytes(key[len(p.prefix):])
+		}
+	}
+}
+
+func (p *PrefixIterator) Valid() bool {
+	return p.key != nil
+}
+
+// 当前key去掉prefix的部分
+func (p *PrefixIterator) Key() []byte {
+	return p.key
+}
+
+func (p *PrefixIterator) Next() {
+	p.iter.Next()
+	p.load()
+}
+
+// 定位到第一个大于等于key的位置
+func (p *PrefixIterator) Seek(key []byte) {
+	p.iter.Seek(append(copyBytes(p.prefix), key...))
+	p.load()
+}
+
+func (p *PrefixIterator) Close() {
+	p.iter.Close()
+	if p.ro != nil {
+		p.ro.Close()
+	}
+}
+
+// prefix下的第一个和最后一个key，没有时返回nil
+func (l *LevelRedis) prefixBounds(prefix []byte) (first, last []byte) {
+	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		first = key
+		*quit = true
+	})
+	l.PrefixEnumerate(prefix, IterBackward, func(i int, key, value []byte, quit *bool) {
+		last = key
+		*quit = true
+	})
+	return
+}
+
+// 取first和last公共前缀之后的8个字节作为整数，在两者之间随机取值后seek
+func (l *LevelRedis) randomSeek(first, last []byte) (key, value []byte) {
+	cp := 0
+	for cp < len(first) && cp < len(last) && first[cp] == last[cp] {
+		cp++
+	}
+	lo, hi := make([]byte, 8), make([]byte, 8)
+	copy(lo, first[cp:])
+	copy(hi, last[cp:])
+	a, b := binary.BigEndian.Uint64(lo), binary.BigEndian.Uint64(hi)
+	r := make([]byte, 8)
+	binary.BigEndian.PutUint64(r, a+uint64(rand.Float64()*float64(b-a)))
+	seek := joinBytes(first[:cp], r)
+	l.RangeEnumerate(seek, last, IterForward, func(i int, k, v []byte, quit *bool) {
+		key, value = k, v
+		*quit = true
+	})
+	// 公共前缀之后不足8个字节时seek可能越过last
+	if key == nil {
+		key = last
+		value, _ = l.RawGet(last)
+	}
+	return
+}
+
+// 在prefix下随机抽取count个key，total为prefix下的key数，unique为false时可能重复
+// 抽样较少时在第一个和最后一个key之间随机seek，避免全量扫描，key分布不均匀时抽样也不均匀
+// unique时最多seek 3×count次，重复太多(分布不均匀)或count接近total时改为遍历一次做蓄水池抽样
+func (l *LevelRedis) randomPrefixKeys(prefix []byte, total, count int, unique bool) (keys, values [][]byte) {
+	if total <= 0 || count <= 0 {
+		return
+	}
+	if unique && count > total {
+		count = total
+	}
+	if !unique || count*3 <= total {
+		first, last := l.prefixBounds(prefix)
+		if first == nil {
+			return
+		}
+		seen := make(map[string]bool)
+		for tries := 0; len(keys) < count && tries < count*3; tries++ {
+			key, value := l.randomSeek(first, last)
+			if unique {
+				if seen[string(key)] {
+					continue
+				}
+				seen[string(key)] = true
+			}
+			keys, values = append(keys, key), append(values, value)
+		}
+		if len(keys) == count {
+			return
+		}
+		keys, values = nil, nil
+	}
+	keys, values = make([][]byte, 0, count), make([][]byte, 0, count)
+	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		if i < count {
+			keys, values = append(keys, key), append(values, value)
+		} else if j := rand.Intn(i + 1); j < count {
+			keys[j], values[j] = key, value
+		}
+	})
+	// 蓄水池里的顺序与key的顺序相关，打乱后返回
+	for i := len(keys) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		keys[i], keys[j] = keys[j], keys[i]
+		values[i], values[j] = values[j], values[i]
+	}
+	return
+}
+
+// 在类型登记(+[key]type)中随机seek，返回一个key，没有key时返回nil
+// key的字节分布不均匀时，各个key被选中的概率不相等
+func (l *LevelRedis) RandomKey() (key, keytype []byte) {
+	prefix := []byte(l.ns + KEY_PREFIX + SEP_LEFT)
+	first, last := l.prefixBounds(prefix)
+	if first == nil {
+		return
+	}
+	raw, _ := l.randomSeek(first, last)
+	right := bytes.LastIndex(raw, []byte(SEP_RIGHT))
+	if right < len(prefix) {
+		return
+	}
+	return copyBytes(raw[len(prefix):right]), copyBytes(raw[right+1:])
+}
@@ -0,0 +1,96 @@
+package levelredis
+
+// 数据布局的版本，保存在 _v = 版本号
+// 没有版本记录的非空数据库为版本0；新建的数据库直接写入当前版本
+// 启动时按顺序执行未完成的迁移，每完成一个迁移写入一次版本号，中断后从下一个迁移继续，
+// 所以每个迁移必须可以重复执行；数据库版本高于程序支持的版本时拒绝打开，避免旧程序写坏新布局
+//
+// 版本历史
+// 1: zset的score统一为float64编码(zsetVersion 1)，set的元素数量保存在元信息里，
+//    之前这两项在第一次访问key时迁移
+// 2: db1以后的数据以@index为前缀，不需要改写数据；
+//    旧程序看不到这些数据，FLUSHALL也不会删除，所以不允许旧程序打开
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+const SCHEMA_KEY = "_v"
+
+// 程序支持的数据布局版本
+const SchemaVersion = 2
+
+var SchemaTooNewError = errors.New("db layout is newer than this binary, upgrade goredis first")
+
+type Migration struct {
+	Version int    // 迁移完成后的版本
+	Desc    string // 用于日志和DBINFO
+	Run     func(l *LevelRedis) error
+}
+
+var migrations = []Migration{
+	{1, "rewrite int64 zset scores and count legacy sets", migrateLazyObjects},
+	{2, "reserve @index prefix for databases", func(l *LevelRedis) error { return nil }},
+}
+
+// 数据库记录的布局版本
+func (l *LevelRedis) LayoutVersion() (version int) {
+	value, _ := l.RawGet([]byte(SCHEMA_KEY))
+	if value == nil {
+		// 没有版本记录时，空数据库视为最新版本
+		if first, _ := l.prefixBounds([]byte(KEY_PREFIX + SEP_LEFT)); first == nil {
+			return SchemaVersion
+		}
+		return 0
+	}
+	version, _ = strconv.Atoi(string(value))
+	return
+}
+
+// 尚未执行的迁移
+func (l *LevelRedis) PendingMigrations() (pending []Migration) {
+	version := l.LayoutVersion()
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return
+}
+
+// 按顺序执行未完成的迁移，fn在每个迁移开始前调用
+func (l *LevelRedis) Migrate(fn func(m Migration)) (err error) {
+	if l.LayoutVersion() > SchemaVersion {
+		return SchemaTooNewError
+	}
+	for _, m := range l.PendingMigrations() {
+		if fn != nil {
+			fn(m)
+		}
+		if err = m.Run(l); err != nil {
+			return
+		}
+		if err = l.RawSet([]byte(SCHEMA_KEY), []byte(strconv.Itoa(m.Version))); err != nil {
+			return
+		}
+	}
+	return l.RawSet([]byte(SCHEMA_KEY), []byte(strconv.Itoa(SchemaVersion)))
+}
+
+// 访问一次需要迁移的zset和set，由initOnce完成迁移
+func migrateLazyObjects(l *LevelRedis) error {
+	l.KeyEnumerate([]byte{}, IterForward, func(i int, key, keytype, value []byte, quit *bool) {
+		switch string(keytype) {
+		case ZSET_SUFFIX:
+			if !bytes.Contains(value, []byte(",")) || bytes.HasSuffix(value, []byte(",0")) {
+				l.GetSortedSet(string(key))
+			}
+		case SET_SUFFIX:
+			if len(value) == 0 {
+				l.GetSet(string(key))
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,329 @@
+package levelredis
+
+// 基于leveldb实现的set，member作为key保存，元素数量和zset一样保存在元信息里
+// +[key]set = count
+// _s[key]member = ""
+// 早期的set由LevelHash实现，元信息为空，第一次访问时重新计数并保存
+import (
+	"GoRedis/libs/gorocks"
+	"bytes"
+	"strconv"
+	"sync"
+)
+
+// 多个set的集合运算
+type SetOp int
+
+const (
+	SetUnion SetOp = iota
+	SetInter
+	SetDiff // 第一个set减去其余的set
+)
+
+type LevelSet struct {
+	LevelElem
+	redis      *LevelRedis
+	key        string
+	mu         sync.RWMutex
+	totalCount int
+}
+
+func NewLevelSet(redis *LevelRedis, key string) (l *LevelSet) {
+	l = &LevelSet{}
+	l.redis = redis
+	l.key = key
+	l.totalCount = -1
+	l.initOnce()
+	return
+}
+
+func (l *LevelSet) Key() string {
+	return l.key
+}
+
+func (l *LevelSet) Size() int {
+	return 1
+}
+
+func (l *LevelSet) initOnce() {
+	if l.totalCount != -1 {
+		return
+	}
+	l.totalCount = 0
+	value, _ := l.redis.RawGet(l.infoKey())
+	if value == nil {
+		return
+	}
+	if len(value) > 0 {
+		l.totalCount, _ = strconv.Atoi(string(value))
+		return
+	}
+	l.redis.PrefixEnumerate(l.memberPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
+		l.totalCount++
+	})
+	if l.redis.snap == nil {
+		l.redis.RawSet(l.infoKey(), l.infoValue())
+	}
+}
+
+func (l *LevelSet) infoKey() []byte {
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, SET_SUFFIX)
+}
+
+func (l *LevelSet) infoValue() []byte {
+	return []byte(strconv.Itoa(l.totalCount))
+}
+
+func (l *LevelSet) memberPrefix() []byte {
+	return joinStringBytes(l.redis.ns, SET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT)
+}
+
+func (l *LevelSet) memberKey(member []byte) []byte {
+	return append(l.memberPrefix(), member...)
+}
+
+func (l *LevelSet) isMember(member []byte) bool {
+	val, _ := l.redis.RawGet(l.memberKey(member))
+	return val != nil
+}
+
+// 返回新增的member数量
+func (l *LevelSet) Add(members ...[]byte) (n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	if n = l.add(batch, members...); n > 0 {
+		l.redis.WriteBatch(batch)
+	}
+	return
+}
+
+// 写入batch但不提交，调用者需持有锁
+func (l *LevelSet) add(batch *gorocks.WriteBatch, members ...[]byte) (n int) {
+	added := make(map[string]bool)
+	for _, member := range members {
+		if added[string(member)] || l.isMember(member) {
+			continue
+		}
+		added[string(member)] = true
+		batch.Put(l.memberKey(member), []byte{})
+		n++
+	}
+	if n > 0 {
+		l.totalCount += n
+		batch.Put(l.infoKey(), l.infoValue())
+	}
+	return
+}
+
+// 返回删除的member数量
+func (l *LevelSet) Remove(members ...[]byte) (n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	removed := make(map[string]bool)
+	for _, member := range members {
+		if removed[string(member)] || !l.isMember(member) {
+			continue
+		}
+		removed[string(member)] = true
+		batch.Delete(l.memberKey(member))
+		n++
+	}
+	if n > 0 {
+		l.totalCount -= n
+		l.putCount(batch)
+		l.redis.WriteBatch(batch)
+	}
+	return
+}
+
+// 随机删除并返回最多count个member
+func (l *LevelSet) Pop(count int) (members [][]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	members = l.randMember(count, true)
+	if len(members) == 0 {
+		return
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	for _, member := range members {
+		batch.Delete(l.memberKey(member))
+	}
+	l.totalCount -= len(members)
+	l.putCount(batch)
+	l.redis.WriteBatch(batch)
+	return
+}
+
+// 全部删除时同时删除元信息
+func (l *LevelSet) putCount(batch *gorocks.WriteBatch) {
+	if l.totalCount <= 0 {
+		l.totalCount = 0
+		batch.Delete(l.infoKey())
+	} else {
+		batch.Put(l.infoKey(), l.infoValue())
+	}
+}
+
+func (l *LevelSet) IsMember(member []byte) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.isMember(member)
+}
+
+func (l *LevelSet) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.totalCount
+}
+
+// 按member字节顺序返回，最多limit个，-1表示不限制
+func (l *LevelSet) Members(limit int) (members [][]byte) {
+	members = make([][]byte, 0, 10)
+	l.Enumerate(func(i int, member []byte, quit *bool) {
+		if limit != -1 && i >= limit {
+			*quit = true
+			return
+		}
+		members = append(members, member)
+	})
+	return
+}
+
+// 随机返回count个member，unique为false时可能重复，抽样方式见LevelRedis.randomPrefixKeys
+func (l *LevelSet) RandMember(count int, unique bool) (members [][]byte) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.randMember(count, unique)
+}
+
+func (l *LevelSet) randMember(count int, unique bool) (members [][]byte) {
+	members = make([][]byte, 0, 2)
+	prefix := l.memberPrefix()
+	keys, _ := l.redis.randomPrefixKeys(prefix, l.totalCount, count, unique)
+	for _, key := range keys {
+		members = append(members, key[len(prefix):])
+	}
+	return
+}
+
+func (l *LevelSet) Enumerate(fn func(i int, member []byte, quit *bool)) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	prefix := l.memberPrefix()
+	l.redis.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		fn(i, key[len(prefix):], quit)
+	})
+}
+
+// 从after之后按member字节顺序扫描最多count个member，返回最后一个member，扫描结束时返回nil
+func (l *LevelSet) Scan(after []byte, count int, fn func(member []byte)) (next []byte) {
+	prefix := l.memberPrefix()
+	return l.redis.ScanPrefix(prefix, after, count, func(key, value []byte) {
+		fn(key[len(prefix):])
+	})
+}
+
+func (l *LevelSet) Type() string {
+	return SET_SUFFIX
+}
+
+func (l *LevelSet) Drop() (ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	l.redis.PrefixEnumerate(l.memberPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
+		batch.Delete(key)
+	})
+	batch.Delete(l.infoKey())
+	l.redis.WriteBatch(batch)
+	l.totalCount = 0
+	ok = true
+	return
+}
+
+// 同时遍历多个set做归并，按member字节顺序输出结果，不在内存中保存中间结果，fn返回false时停止
+// 多个set需要来自同一个LevelRedis或同一个快照，遍历期间不加锁
+func MergeSets(op SetOp, sets []*LevelSet, fn func(member []byte) bool) {
+	iters := make([]*PrefixIterator, len(sets))
+	for i, s := range sets {
+		iters[i] = s.redis.NewPrefixIterator(s.memberPrefix())
+		defer iters[i].Close()
+	}
+	switch op {
+	case SetUnion:
+		for {
+			var min []byte
+			for _, it := range iters {
+				if it.Valid() && (min == nil || bytes.Compare(it.Key(), min) < 0) {
+					min = it.Key()
+				}
+			}
+			if min == nil {
+				return
+			}
+			for _, it := range iters {
+				if it.Valid() && bytes.Equal(it.Key(), min) {
+					it.Next()
+				}
+			}
+			if !fn(min) {
+				return
+			}
+		}
+	case SetInter:
+		// 所有迭代器跳到当前最大的member，全部相等时输出
+		for {
+			var max []byte
+			for _, it := range iters {
+				if !it.Valid() {
+					return
+				}
+				if max == nil || bytes.Compare(it.Key(), max) > 0 {
+					max = it.Key()
+				}
+			}
+			matched := true
+			for _, it := range iters {
+				if !bytes.Equal(it.Key(), max) {
+					it.Seek(max)
+					matched = false
+				}
+			}
+			if matched {
+				if !fn(max) {
+					return
+				}
+				for _, it := range iters {
+					it.Next()
+				}
+			}
+		}
+	case SetDiff:
+		first := iters[0]
+		for ; first.Valid(); first.Next() {
+			member := first.Key()
+			found := false
+			for _, it := range iters[1:] {
+				if it.Valid() && bytes.Compare(it.Key(), member) < 0 {
+					it.Seek(member)
+				}
+				if it.Valid() && bytes.Equal(it.Key(), member) {
+					found = true
+				}
+			}
+			if !found && !fn(member) {
+				return
+			}
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package levelredis
 
 type LevelString struct {
-	redis *LevelRedis
+	redis  *LevelRedis
+	codecs codecList
 }
 
 func NewLevelString(redis *LevelRedis) (l *LevelString) {
@@ -11,19 +12,30 @@ func NewLevelString(redis *LevelRedis) (l *LevelString) {
 }
 
 func (l *LevelString) stringKey(key []byte) []byte {
-	return joinStringBytes(KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, STRING_SUFFIX)
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, STRING_SUFFIX)
 }
 
-func (l *LevelString) Get(key []byte) (value []byte) {
-	value, _ = l.redis.RawGet(l.stringKey(key))
+// 读取或解码失败时返回错误，不能当作key不存在
+func (l *LevelString) Get(key []byte) (value []byte, err error) {
+	if value, err = l.redis.RawGet(l.stringKey(key)); err == nil && value != nil {
+		value, err = l.Decode(key, value)
+	}
 	return
 }
 
+// 解码直接从rocksdb读取的string值，用于KeyEnumerate等绕过Get的场景
+func (l *LevelString) Decode(key, value []byte) ([]byte, error) {
+	if codec := l.codecs.match(key); codec != nil {
+		return codec.Decode(key, value)
+	}
+	return value, nil
+}
+
 func (l *LevelString) Delete(keys ...[]byte) (n int) {
 	n = 0
 	for _, key := range keys {
-		val := l.Get(key)
-		if val != nil {
+		// 只判断是否存在，不需要解码
+		if val, _ := l.redis.RawGet(l.stringKey(key)); val != nil {
 			l.redis.RawDel(l.stringKey(key))
 			n++
 		}
@@ -31,6 +43,17 @@ func (l *LevelString) Delete(keys ...[]byte) (n int) {
 	return
 }
 
-func (l *LevelString) Set(key []byte, value []byte) error {
+func (l *LevelString) Set(key []byte, value []byte) (err error) {
+	if value, err = l.encode(key, value); err != nil {
+		return
+	}
 	return l.redis.RawSet(l.stringKey(key), value)
 }
+
+// 按key匹配的codec编码，用于直接写入WriteBatch
+func (l *LevelString) encode(key, value []byte) ([]byte, error) {
+	if codec := l.codecs.match(key); codec != nil {
+		return codec.Encode(key, value)
+	}
+	return value, nil
+}
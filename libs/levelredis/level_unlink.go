@@ -0,0 +1,181 @@
+package levelredis
+
+// UNLINK，集合类型的key先从类型登记中移除，元素由后台删除
+// _t[key] = type，元素删除完成后清除，重启后继续删除
+// 删除完成之前再次访问同一个key时，在objFromCache里同步完成删除
+import (
+	"GoRedis/libs/gorocks"
+	"bytes"
+	"sync/atomic"
+)
+
+// 后台删除时每个WriteBatch包含的元素数
+const unlinkBatchSize = 10000
+
+type unlinkTask struct {
+	typ     string
+	claimed bool          // 已经有goroutine在删除
+	done    chan struct{} // 删除完成时关闭
+}
+
+func (l *LevelRedis) unlinkKey(key []byte) []byte {
+	return joinBytes([]byte(l.ns+UNLINK_PREFIX+SEP_LEFT), key, []byte(SEP_RIGHT))
+}
+
+// 加载上次没有完成的删除
+func (l *LevelRedis) initUnlink() {
+	l.unlinking = make(map[string]*unlinkTask)
+	if l.snap != nil {
+		return
+	}
+	l.loadUnlink()
+}
+
+// 调用者持有unlinkMu或者还没有开始使用，已经登记的任务保留
+func (l *LevelRedis) loadUnlink() {
+	prefix := []byte(l.ns + UNLINK_PREFIX + SEP_LEFT)
+	l.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
+		right := bytes.LastIndex(key, []byte(SEP_RIGHT))
+		if right < len(prefix) {
+			return
+		}
+		if _, ok := l.unlinking[string(key[len(prefix):right])]; ok {
+			return
+		}
+		l.unlinking[string(key[len(prefix):right])] = &unlinkTask{typ: string(value), done: make(chan struct{})}
+	})
+	atomic.StoreInt32(&l.unlinkCount, int32(len(l.unlinking)))
+}
+
+// 通过RawSet直接写入数据之后(从库接收快照)，重新加载过期索引和未完成的删除，并丢弃缓存的对象
+func (l *LevelRedis) Reload() {
+	l.lruCache.Clear()
+	l.initExpire()
+	l.unlinkMu.Lock()
+	l.loadUnlink()
+	l.unlinkMu.Unlock()
+}
+
+// 返回删除的key数量，与Delete一致；string、doc、blob直接删除
+func (l *LevelRedis) Unlink(keys ...[]byte) (n int) {
+	for _, key := range keys {
+		t := l.TypeOf(key)
+		prefix, ok := elemPrefixes[t]
+		if !ok || t == DOC_SUFFIX {
+			if l.deleteKey(key) {
+				n++
+			}
+			continue
+		}
+		if l.unlink(key, t, prefix) {
+			n++
+		}
+	}
+	return
+}
+
+func (l *LevelRedis) unlink(key []byte, t string, prefix string) bool {
+	// 与Delete一样持有对象的锁，之后通过缓存对象的写入会被objFromCache拦下
+	if mu := elemLocker(l.GetElem(string(key), t)); mu != nil {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	if l.HasExpire() {
+		l.Persist(key)
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	batch.Delete(joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, t))
+	batch.Put(l.unlinkKey(key), []byte(t))
+	if err := l.WriteBatch(batch); err != nil {
+		return false
+	}
+	l.unlinkMu.Lock()
+	l.unlinking[string(key)] = &unlinkTask{typ: t, done: make(chan struct{})}
+	atomic.StoreInt32(&l.unlinkCount, int32(len(l.unlinking)))
+	l.unlinkMu.Unlock()
+	l.lruCache.Delete(string(key))
+	return true
+}
+
+// 等待中的删除数量
+func (l *LevelRedis) UnlinkPending() int {
+	return int(atomic.LoadInt32(&l.unlinkCount))
+}
+
+// 后台调用，删除最多limit个key的元素，返回删除的key数量
+func (l *LevelRedis) DropUnlinked(limit int) (n int) {
+	for n < limit {
+		l.unlinkMu.Lock()
+		var key string
+		var task *unlinkTask
+		for k, t := range l.unlinking {
+			if !t.claimed {
+				key, task = k, t
+				break
+			}
+		}
+		if task != nil {
+			task.claimed = true
+		}
+		l.unlinkMu.Unlock()
+		if task == nil {
+			return
+		}
+		l.dropUnlinked(key, task)
+		n++
+	}
+	return
+}
+
+// key的元素正在后台删除时，认领删除或者等待删除完成
+func (l *LevelRedis) waitUnlinked(key string) {
+	if atomic.LoadInt32(&l.unlinkCount) == 0 {
+		return
+	}
+	l.unlinkMu.Lock()
+	task, ok := l.unlinking[key]
+	claim := ok && !task.claimed
+	if claim {
+		task.claimed = true
+	}
+	l.unlinkMu.Unlock()
+	if claim {
+		l.dropUnlinked(key, task)
+	} else if ok {
+		<-task.done
+	}
+}
+
+func (l *LevelRedis) dropUnlinked(key string, task *unlinkTask) {
+	prefix := joinStringBytes(l.ns, elemPrefixes[task.typ], SEP_LEFT, key, SEP_RIGHT)
+	for {
+		batch := gorocks.NewWriteBatch()
+		count := 0
+		l.PrefixEnumerate(prefix, IterForward, func(i int, k, v []byte, quit *bool) {
+			batch.Delete(k)
+			count++
+			*quit = count >= unlinkBatchSize
+		})
+		if count == 0 {
+			batch.Delete(l.unlinkKey([]byte(key)))
+		}
+		err := l.WriteBatch(batch)
+		batch.Close()
+		if err != nil {
+			// 交给后台下次重试，等待的goroutine继续等待
+			l.unlinkMu.Lock()
+			task.claimed = false
+			l.unlinkMu.Unlock()
+			return
+		}
+		if count == 0 {
+			break
+		}
+	}
+	l.unlinkMu.Lock()
+	delete(l.unlinking, key)
+	atomic.StoreInt32(&l.unlinkCount, int32(len(l.unlinking)))
+	l.unlinkMu.Unlock()
+	close(task.done)
+}
@@ -3,6 +3,7 @@ package levelredis
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 	"strings"
 )
 
@@ -24,6 +25,19 @@ func copyBytes(src []byte) (dst []byte) {
 	return
 }
 
+// 前缀扫描的上界，prefix按字节串加一，所有带该前缀的key都小于它
+func prefixEnd(prefix []byte) []byte {
+	end := copyBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < MAXBYTE {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// 全部是0xFF，没有更大的同长度前缀，退回旧的上界
+	return append(end, MAXBYTE)
+}
+
 // 范围判断 min <= v <= max
 func between(v, min, max []byte) bool {
 	return bytes.Compare(v, min) >= 0 && bytes.Compare(v, max) <= 0
@@ -56,3 +70,30 @@ func Int64ToBytes(i int64) []byte {
 func BytesToInt64(buf []byte) int64 {
 	return int64(binary.BigEndian.Uint64(buf))
 }
+
+// 保持数值顺序的float64编码，用于zset的score
+// 正数翻转符号位，负数全部按位取反，编码后按字节比较的结果与数值大小一致
+func Float64ToBytes(f float64) []byte {
+	if f == 0 {
+		f = 0 // -0与+0统一编码
+	}
+	bits := math.Float64bits(f)
+	if f >= 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	var buf = make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func BytesToFloat64(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
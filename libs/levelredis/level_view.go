@@ -0,0 +1,46 @@
+package levelredis
+
+// 一致性读视图，供嵌入GoRedis的应用在同一个快照上执行多步读取
+// View期间固定一个快照，在快照上构造的list/zset等实例只从快照读取元信息(长度、游标、计数)，
+// 并缓存在快照自己的LRUCache里，主库的并发写入对回调不可见；
+// 回调返回后快照被释放，ReadTx及从它得到的实例都不能在回调以外使用
+
+// 只读接口，不提供任何写操作
+type ReadTx interface {
+	// string，SETBIT创建的bitmap也作为string返回
+	Get(key []byte) ([]byte, error)
+	TypeOf(key []byte) string
+	GetList(key string) *LevelList
+	GetHash(key string) *LevelHash
+	GetSet(key string) *LevelSet
+	GetSortedSet(key string) *LevelZSet
+	GetDoc(key string) *LevelDoc
+	GetBitmap(key string) *LevelBitmap
+	GetBlob(key string) *LevelBlob
+	Keys(prefix []byte, fn func(i int, key, keytype []byte, quit *bool))
+	PrefixEnumerate(prefix []byte, direction IterDirection, fn func(i int, key, value []byte, quit *bool))
+	RawGet(key []byte) (value []byte, err error)
+}
+
+type readTx struct {
+	*LevelRedis
+}
+
+func (tx readTx) Get(key []byte) (value []byte, err error) {
+	if value, err = tx.Strings().Get(key); err == nil && value == nil {
+		if bm := tx.ExistingBitmap(string(key)); bm != nil {
+			value = bm.Bytes()
+		}
+	}
+	return
+}
+
+// 在一致性读视图中执行fn，返回fn的错误；本身已是快照时直接复用
+func (l *LevelRedis) View(fn func(tx ReadTx) error) error {
+	if l.snap != nil {
+		return fn(readTx{l})
+	}
+	snap := l.Snapshot()
+	defer snap.Close()
+	return fn(readTx{snap})
+}
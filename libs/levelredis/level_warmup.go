@@ -0,0 +1,51 @@
+package levelredis
+
+// 启动预热，在接受请求前把key的元信息(以及可选的全部数据)读入rocksdb的block cache，
+// 同时为list/zset等结构预先创建对象放入LRU，减少冷启动时的延迟抖动
+import (
+	"GoRedis/libs/gorocks"
+	"bytes"
+)
+
+// full=false只扫描 +[key]type 元信息，full=true扫描整个数据库
+// fn用于输出进度，每扫描100万条回调一次
+func (l *LevelRedis) WarmUp(full bool, fn func(scanned, objects int64)) (scanned, objects int64) {
+	ro := gorocks.NewReadOptions()
+	ro.SetFillCache(true)
+	defer ro.Close()
+	iter := l.db.NewIterator(ro)
+	defer iter.Close()
+
+	metaPrefix := joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT)
+	var min, max []byte
+	if full {
+		min, max = []byte{}, []byte{MAXBYTE}
+	} else {
+		min, max = metaPrefix, append(joinStringBytes(l.ns, KEY_PREFIX, SEP_LEFT), MAXBYTE)
+	}
+
+	l.Enumerate(iter, min, max, IterForward, func(i int, key, value []byte, quit *bool) {
+		scanned++
+		if scanned%1000000 == 0 && fn != nil {
+			fn(scanned, objects)
+		}
+		if !bytes.HasPrefix(key, metaPrefix) || objects >= int64(lruCacheSize) {
+			return
+		}
+		left := bytes.Index(key, []byte(SEP_LEFT))
+		right := bytes.LastIndex(key, []byte(SEP_RIGHT))
+		if left == -1 || right == -1 {
+			return
+		}
+		// 只有list、zset需要在创建对象时读取元信息
+		switch typ := string(key[right+1:]); typ {
+		case LIST_SUFFIX, ZSET_SUFFIX:
+			l.GetElem(string(key[left+1:right]), typ)
+			objects++
+		}
+	})
+	if fn != nil {
+		fn(scanned, objects)
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package levelredis
+
+// 按前缀订阅key的变更，适用于配置下发等场景
+// 由上层写入路径调用Notify，回调在调用方的goroutine中执行，不能阻塞
+import (
+	"bytes"
+	"sync"
+)
+
+type WatchFunc func(key []byte, event string)
+
+type prefixWatch struct {
+	prefix []byte
+	fn     WatchFunc
+}
+
+type PrefixWatcher struct {
+	mu      sync.RWMutex
+	seq     int64
+	watches map[int64]*prefixWatch
+}
+
+func NewPrefixWatcher() (p *PrefixWatcher) {
+	p = &PrefixWatcher{}
+	p.watches = make(map[int64]*prefixWatch)
+	return
+}
+
+// 注册前缀回调，返回的id用于Unwatch
+func (p *PrefixWatcher) Watch(prefix []byte, fn WatchFunc) (id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	id = p.seq
+	p.watches[id] = &prefixWatch{prefix: prefix, fn: fn}
+	return
+}
+
+func (p *PrefixWatcher) Unwatch(id int64) {
+	p.mu.Lock()
+	delete(p.watches, id)
+	p.mu.Unlock()
+}
+
+func (p *PrefixWatcher) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.watches)
+}
+
+// key发生变更，event一般为指令名称，如SET、HDEL
+func (p *PrefixWatcher) Notify(key []byte, event string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, w := range p.watches {
+		if bytes.HasPrefix(key, w.prefix) {
+			w.fn(key, event)
+		}
+	}
+}
@@ -5,16 +5,56 @@ package levelredis
 import (
 	"GoRedis/libs/gorocks"
 	"bytes"
+	"errors"
+	"math"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 )
 
+// score编码版本，和元素数量一起保存在zsetKey的值里: "count,version"
+// 0: score为int64，_z[key]s#[0/1][int64]#member，浮点数会被截断
+// 1: score为保序编码的float64(Float64ToBytes)，_z[key]s#[float64]#member
+// 旧版本的zset在第一次访问时迁移，快照中的旧数据只读兼容
+const zsetVersion = 1
+
+// ZADD的选项，可以组合使用
+type ZAddFlag int
+
+const (
+	ZAddNX ZAddFlag = 1 << iota // 只添加新元素
+	ZAddXX                      // 只更新已有元素
+	ZAddGT                      // 新score更大时才更新，不影响添加新元素
+	ZAddLT                      // 新score更小时才更新
+	ZAddCH                      // 返回新增和score被修改的元素总数
+)
+
+// 按flags判断是否写入，oldscore为nil表示新元素
+func zaddAllowed(flags ZAddFlag, oldscore []byte, newscore float64) bool {
+	if oldscore == nil {
+		return flags&ZAddXX == 0
+	}
+	if flags&ZAddNX != 0 {
+		return false
+	}
+	old := BytesToFloat64(oldscore)
+	if flags&ZAddGT != 0 && newscore <= old {
+		return false
+	}
+	if flags&ZAddLT != 0 && newscore >= old {
+		return false
+	}
+	return true
+}
+
 type LevelZSet struct {
 	LevelElem
 	redis      *LevelRedis
 	key        string
 	mu         sync.RWMutex
 	totalCount int
+	version    int
 }
 
 func NewLevelZSet(redis *LevelRedis, key string) (l *LevelZSet) {
@@ -36,80 +76,122 @@ func (l *LevelZSet) Size() int {
 
 func (l *LevelZSet) initOnce() {
 	if l.totalCount == -1 {
+		l.totalCount = 0
+		l.version = zsetVersion
 		value, _ := l.redis.RawGet(l.zsetKey())
 		if value != nil {
-			l.totalCount, _ = strconv.Atoi(string(value))
-		} else {
-			l.totalCount = 0
+			pairs := strings.Split(string(value), ",")
+			l.totalCount, _ = strconv.Atoi(pairs[0])
+			l.version = 0
+			if len(pairs) > 1 {
+				l.version, _ = strconv.Atoi(pairs[1])
+			}
 		}
+		if l.version < zsetVersion && l.redis.snap == nil {
+			l.migrate()
+		}
+	}
+}
+
+// 把int64编码的score重写为float64编码，所有改动在同一个WriteBatch里完成
+func (l *LevelZSet) migrate() {
+	members := make([][]byte, 0, l.totalCount)
+	scores := make([][]byte, 0, l.totalCount)
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	// 先删除全部旧的scoreKey再写入新的，避免新旧key相同时被删除
+	l.redis.PrefixEnumerate(l.scoreKeyPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
+		score, member := l.splitScoreKey(key)
+		members = append(members, member)
+		scores = append(scores, score)
+		batch.Delete(key)
+	})
+	for i, member := range members {
+		batch.Put(l.memberKey(member), scores[i])
+		batch.Put(l.scoreKey(member, scores[i]), nil)
+	}
+	oldversion := l.version
+	l.version = zsetVersion
+	batch.Put(l.zsetKey(), l.zsetValue())
+	if err := l.redis.WriteBatch(batch); err != nil {
+		l.version = oldversion
+		os.Stderr.WriteString("zset migrate error: " + l.key + ", " + err.Error() + "\n")
 	}
 }
 
 func (l *LevelZSet) zsetKey() []byte {
-	return joinStringBytes(KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, ZSET_SUFFIX)
+	return joinStringBytes(l.redis.ns, KEY_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, ZSET_SUFFIX)
 }
 
 func (l *LevelZSet) zsetValue() []byte {
-	s := strconv.Itoa(l.totalCount)
+	s := strconv.Itoa(l.totalCount) + "," + strconv.Itoa(l.version)
 	return []byte(s)
 }
 
-func zmemberKey(key, member []byte) []byte {
-	return joinStringBytes(ZSET_PREFIX, SEP_LEFT, string(key), SEP_RIGHT, "m", SEP, string(member))
-}
-
 func (l *LevelZSet) memberKey(member []byte) []byte {
-	return joinStringBytes(ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "m", SEP, string(member))
+	return joinStringBytes(l.redis.ns, ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "m", SEP, string(member))
 }
 
+// _z[user_rank]s#[score 8字节]#100428 = ""
 func (l *LevelZSet) scoreKey(member []byte, score []byte) []byte {
-	scoreint := BytesToInt64(score)
-	var sign string // 正负数
-	if scoreint < 0 {
-		sign = "0"
-	} else {
-		sign = "1"
-	}
-	return joinStringBytes(ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "s", SEP, sign, string(score), SEP, string(member))
+	return joinStringBytes(l.redis.ns, ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "s", SEP, string(score), SEP, string(member))
 }
 
 func (l *LevelZSet) scoreKeyPrefix() []byte {
-	return joinStringBytes(ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "s", SEP)
+	return joinStringBytes(l.redis.ns, ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "s", SEP)
 }
 
-func (l *LevelZSet) scoreKeyPrefixWith(scoreint int64) []byte {
-	var sign string // 正负数
-	if scoreint < 0 {
-		sign = "0"
-	} else {
-		sign = "1"
-	}
-	return joinStringBytes(ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT, "s", SEP, sign, string(Int64ToBytes(scoreint)))
+func (l *LevelZSet) scoreKeyPrefixWith(score float64) []byte {
+	return joinBytes(l.scoreKeyPrefix(), Float64ToBytes(score))
 }
 
-// _z[user_rank]s#1378000907596#100428 = ""
+// score固定为8字节，member中可以包含"#"
+// 返回的score总是float64编码
 func (l *LevelZSet) splitScoreKey(scorekey []byte) (score, member []byte) {
-	pos2 := bytes.LastIndex(scorekey, []byte(SEP))
-	sepr := bytes.Index(scorekey, []byte(SEP_RIGHT))
-	pos1 := bytes.Index(scorekey[sepr:], []byte(SEP)) + sepr
-	member = copyBytes(scorekey[pos2+1:])
-	score = copyBytes(scorekey[pos1+1+1 : pos2]) // +1 skip sign "0/1"
+	rest := scorekey[len(l.scoreKeyPrefix()):]
+	if l.version == 0 {
+		score = Float64ToBytes(float64(BytesToInt64(rest[1:9]))) // skip sign "0/1"
+		member = copyBytes(rest[10:])
+	} else {
+		score = copyBytes(rest[:8])
+		member = copyBytes(rest[9:])
+	}
 	return
 }
 
-func (l *LevelZSet) Add(scoreMembers ...[]byte) (n int) {
+// flags为0时与redis默认行为一致，新增或覆盖
+func (l *LevelZSet) Add(flags ZAddFlag, scoreMembers ...[]byte) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
+	n = l.add(batch, flags, scoreMembers...)
+	err := l.redis.WriteBatch(batch)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// 写入batch但不提交，调用者需持有锁，scoreMembers中的member不能重复
+func (l *LevelZSet) add(batch *gorocks.WriteBatch, flags ZAddFlag, scoreMembers ...[]byte) (n int) {
 	count := len(scoreMembers)
 	for i := 0; i < count; i += 2 {
 		score := scoreMembers[i]
 		member, memberkey := scoreMembers[i+1], l.memberKey(scoreMembers[i+1])
+		oldscore := l.score(member)
+		if !zaddAllowed(flags, oldscore, BytesToFloat64(score)) {
+			continue
+		}
 		// remove old score
-		oldscore, _ := l.redis.RawGet(memberkey)
 		if oldscore != nil {
+			if bytes.Equal(oldscore, score) {
+				continue
+			}
 			batch.Delete(l.scoreKey(member, oldscore))
+			if flags&ZAddCH != 0 {
+				n++
+			}
 		} else {
 			l.totalCount++
 			// The number of elements added to the sorted sets, not including elements already existing for which the score was updated.
@@ -121,46 +203,60 @@ func (l *LevelZSet) Add(scoreMembers ...[]byte) (n int) {
 		batch.Put(l.scoreKey(member, score), nil)
 	}
 	batch.Put(l.zsetKey(), l.zsetValue())
-	err := l.redis.WriteBatch(batch)
-	if err != nil {
-		panic(err)
-	}
 	return
 }
 
+// 只有一次RawGet，而写入都通过WriteBatch原子提交，读到的总是某次写入前或写入后的完整状态，
+// 所以不需要加锁，排行榜这类读多写少的场景下ZSCORE不会被ZADD阻塞
 func (l *LevelZSet) Score(member []byte) (score []byte) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
 	return l.score(member)
 }
 
 func (l *LevelZSet) score(member []byte) (score []byte) {
 	score, _ = l.redis.RawGet(l.memberKey(member))
+	if score != nil && l.version == 0 {
+		score = Float64ToBytes(float64(BytesToInt64(score)))
+	}
 	return
 }
 
-func (l *LevelZSet) IncrBy(member []byte, incr int64) (newscore []byte) {
+func (l *LevelZSet) IncrBy(member []byte, incr float64) (newscore []byte, err error) {
+	return l.AddIncr(0, member, incr)
+}
+
+var ScoreNaNError = errors.New("resulting score is not a number (NaN)")
+
+// ZADD INCR，flags不允许更新时返回nil，inf与-inf相加时返回ScoreNaNError
+func (l *LevelZSet) AddIncr(flags ZAddFlag, member []byte, incr float64) (newscore []byte, err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	score := l.score(member)
+	result := incr
+	if score != nil {
+		result += BytesToFloat64(score)
+	}
+	if math.IsNaN(result) {
+		return nil, ScoreNaNError
+	}
+	if !zaddAllowed(flags, score, result) {
+		return nil, nil
+	}
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
 
 	oldcount := l.totalCount
+	newscore = Float64ToBytes(result)
 	if score == nil {
-		newscore = Int64ToBytes(incr)
 		l.totalCount++
 	} else {
 		batch.Delete(l.scoreKey(member, score))
-		scoreInt := BytesToInt64(score)
-		newscore = Int64ToBytes(scoreInt + incr)
 	}
 	batch.Put(l.memberKey(member), newscore)
 	batch.Put(l.scoreKey(member, newscore), nil)
 	if l.totalCount != oldcount {
 		batch.Put(l.zsetKey(), l.zsetValue())
 	}
-	err := l.redis.WriteBatch(batch)
+	err = l.redis.WriteBatch(batch)
 	if err != nil {
 		l.totalCount = oldcount
 		panic(err) // need refect
@@ -169,52 +265,73 @@ func (l *LevelZSet) IncrBy(member []byte, incr int64) (newscore []byte) {
 }
 
 // 返回-1表示member不存在
+// score索引按score排序而不是member，所以先取得member的score，得到它在索引中的确切key，
+// 再从对应的一端扫描到这个key为止，扫描的个数就是排名
 func (l *LevelZSet) Rank(high2low bool, member []byte) (idx int) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	// 对于不存在的key，先检查一次，减少扫描成本
-	if l.score(member) == nil {
+	score := l.score(member)
+	if score == nil {
 		return -1
 	}
+	target := l.scoreKey(member, score)
 	direction := IterForward
 	if high2low {
 		direction = IterBackward
 	}
 	idx = -1
 	l.redis.PrefixEnumerate(l.scoreKeyPrefix(), direction, func(i int, key, value []byte, quit *bool) {
-		_, curmember := l.splitScoreKey(key)
-		// fmt.Println(i, string(key), string(curmember), string(member))
-		rtn := bytes.Compare(member, curmember)
-		if rtn == 0 {
+		if bytes.Equal(key, target) {
 			idx = i
 			*quit = true
-		} else if high2low && rtn > 0 {
-			*quit = true
-			return
-		} else if !high2low && rtn < 0 {
-			*quit = true
-			return
 		}
 	})
 	return
 }
 
+// 把redis风格的start/stop(负数表示从末尾开始计数)转换为[0, length)内的区间，ok=false表示区间为空
+func normalizeIndexRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop, start <= stop
+}
+
+// high2low时start/stop是逆序的排名，先根据缓存的totalCount规范化区间，
+// 再沿对应方向扫描，第i个元素的排名就是i，不需要正反向换算
 func (l *LevelZSet) RangeByIndex(high2low bool, start, stop int) (scoreMembers [][]byte) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	scoreMembers = make([][]byte, 0, 2)
+	start, stop, ok := normalizeIndexRange(start, stop, l.len())
+	if !ok {
+		return
+	}
 	direction := IterForward
 	if high2low {
 		direction = IterBackward
 	}
-	scoreMembers = make([][]byte, 0, 2)
 	l.redis.PrefixEnumerate(l.scoreKeyPrefix(), direction, func(i int, key, value []byte, quit *bool) {
 		if i < start {
 			return
-		} else if i >= start && (stop == -1 || i <= stop) {
-			score, member := l.splitScoreKey(key)
-			scoreMembers = append(scoreMembers, score)
-			scoreMembers = append(scoreMembers, member)
-		} else {
+		} else if i > stop {
+			*quit = true
+			return
+		}
+		score, member := l.splitScoreKey(key)
+		scoreMembers = append(scoreMembers, score)
+		scoreMembers = append(scoreMembers, member)
+		if i == stop {
 			*quit = true
 		}
 	})
@@ -230,7 +347,35 @@ func (l *LevelZSet) Enumerate(fn func(i int, member, score []byte, quit *bool))
 	})
 }
 
-func (l *LevelZSet) RangeByScore(high2low bool, min, max int64, offset, count int) (scoreMembers [][]byte) {
+// 按member字节顺序扫描，after为上一批最后一个member，返回nil表示扫描完毕
+func (l *LevelZSet) Scan(after []byte, count int, fn func(member, score []byte)) (next []byte) {
+	prefix := l.memberKey(nil)
+	return l.redis.ScanPrefix(prefix, after, count, func(key, value []byte) {
+		if l.version == 0 {
+			value = Float64ToBytes(float64(BytesToInt64(value)))
+		}
+		fn(key[len(prefix):], value)
+	})
+}
+
+// 随机返回count个元素，unique为false时可能重复，抽样方式见LevelRedis.randomPrefixKeys
+func (l *LevelZSet) RandMember(count int, unique bool) (scoreMembers [][]byte) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	scoreMembers = make([][]byte, 0, 2)
+	prefix := l.memberKey(nil)
+	keys, values := l.redis.randomPrefixKeys(prefix, l.len(), count, unique)
+	for i, key := range keys {
+		value := values[i]
+		if l.version == 0 {
+			value = Float64ToBytes(float64(BytesToInt64(value)))
+		}
+		scoreMembers = append(scoreMembers, value, key[len(prefix):])
+	}
+	return
+}
+
+func (l *LevelZSet) RangeByScore(high2low bool, min, max float64, offset, count int) (scoreMembers [][]byte) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	direction := IterForward
@@ -255,13 +400,28 @@ func (l *LevelZSet) RangeByScore(high2low bool, min, max int64, offset, count in
 	return
 }
 
+// min <= score <= max 的元素数量，需要扫描区间内的索引
+func (l *LevelZSet) Count(min, max float64) (n int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if min > max {
+		return 0
+	}
+	min2 := l.scoreKeyPrefixWith(min)
+	max2 := joinBytes(l.scoreKeyPrefixWith(max), []byte{MAXBYTE})
+	l.redis.RangeEnumerate(min2, max2, IterForward, func(i int, key, value []byte, quit *bool) {
+		n++
+	})
+	return
+}
+
 func (l *LevelZSet) Remove(members ...[]byte) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
 	for _, member := range members {
-		score, _ := l.redis.RawGet(l.memberKey(member))
+		score := l.score(member)
 		if score == nil {
 			continue
 		}
@@ -282,15 +442,58 @@ func (l *LevelZSet) Remove(members ...[]byte) (n int) {
 	return
 }
 
+// 弹出score最小(high2low为最大)的count个元素，元素和索引在同一个WriteBatch中删除
+func (l *LevelZSet) Pop(high2low bool, count int) (scoreMembers [][]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	scoreMembers = make([][]byte, 0, 2)
+	if count <= 0 || l.len() == 0 {
+		return
+	}
+	direction := IterForward
+	if high2low {
+		direction = IterBackward
+	}
+	batch := gorocks.NewWriteBatch()
+	defer batch.Close()
+	n := 0
+	l.redis.PrefixEnumerate(l.scoreKeyPrefix(), direction, func(i int, key, value []byte, quit *bool) {
+		score, member := l.splitScoreKey(key)
+		batch.Delete(l.memberKey(member))
+		batch.Delete(key)
+		scoreMembers = append(scoreMembers, score, member)
+		n++
+		if n >= count {
+			*quit = true
+		}
+	})
+	l.totalCount -= n
+	if l.totalCount == 0 {
+		batch.Delete(l.zsetKey())
+	} else {
+		batch.Put(l.zsetKey(), l.zsetValue())
+	}
+	err := l.redis.WriteBatch(batch)
+	if err != nil {
+		l.totalCount += n
+		panic(err)
+	}
+	return
+}
+
 func (l *LevelZSet) RemoveByIndex(start, stop int) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	start, stop, ok := normalizeIndexRange(start, stop, l.len())
+	if !ok {
+		return
+	}
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
 	l.redis.PrefixEnumerate(l.scoreKeyPrefix(), IterForward, func(i int, key, value []byte, quit *bool) {
 		if i < start {
 			return
-		} else if i >= start && (stop == -1 || i <= stop) {
+		} else if i <= stop {
 			score, member := l.splitScoreKey(key)
 			batch.Delete(l.memberKey(member))
 			batch.Delete(l.scoreKey(member, score))
@@ -312,7 +515,7 @@ func (l *LevelZSet) RemoveByIndex(start, stop int) (n int) {
 	return
 }
 
-func (l *LevelZSet) RemoveByScore(min, max int64) (n int) {
+func (l *LevelZSet) RemoveByScore(min, max float64) (n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	min2 := l.scoreKeyPrefixWith(min)
@@ -343,6 +546,8 @@ func (l *LevelZSet) len() (n int) {
 }
 
 func (l *LevelZSet) Len() (n int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return l.len()
 }
 
@@ -358,7 +563,7 @@ func (l *LevelZSet) Drop() (ok bool) {
 	}
 	batch := gorocks.NewWriteBatch()
 	defer batch.Close()
-	prefix := joinStringBytes(ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT)
+	prefix := joinStringBytes(l.redis.ns, ZSET_PREFIX, SEP_LEFT, l.key, SEP_RIGHT)
 	l.redis.PrefixEnumerate(prefix, IterForward, func(i int, key, value []byte, quit *bool) {
 		batch.Delete(key)
 	})
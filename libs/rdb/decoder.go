@@ -66,7 +66,7 @@ func Decode(r io.Reader, d Decoder) error {
 // database, key or expiry, so they must be included in the function call (but
 // can be zero values).
 func DecodeDump(dump []byte, db int, key []byte, expiry int64, d Decoder) error {
-	err := verifyDump(dump)
+	err := VerifyDump(dump)
 	if err != nil {
 		return err
 	}
@@ -102,23 +102,48 @@ const (
 	TypeZSet   ValueType = 3
 	TypeHash   ValueType = 4
 
-	TypeHashZipmap  ValueType = 9
-	TypeListZiplist ValueType = 10
-	TypeSetIntset   ValueType = 11
-	TypeZSetZiplist ValueType = 12
-	TypeHashZiplist ValueType = 13
+	TypeZSet2   ValueType = 5 // scores saved as binary double (RDB 8+)
+	TypeModule  ValueType = 6
+	TypeModule2 ValueType = 7
+
+	TypeHashZipmap    ValueType = 9
+	TypeListZiplist   ValueType = 10
+	TypeSetIntset     ValueType = 11
+	TypeZSetZiplist   ValueType = 12
+	TypeHashZiplist   ValueType = 13
+	TypeListQuicklist ValueType = 14 // Redis 3.2+
+
+	TypeStreamListpacks  ValueType = 15
+	TypeHashListpack     ValueType = 16 // Redis 7.0+
+	TypeZSetListpack     ValueType = 17
+	TypeListQuicklist2   ValueType = 18
+	TypeStreamListpacks2 ValueType = 19
+	TypeSetListpack      ValueType = 20 // Redis 7.2+
+	TypeStreamListpacks3 ValueType = 21
 )
 
+// 可以解析的最高RDB版本，Redis 7.4对应12
+const maxVersion = 12
+
 const (
 	rdb6bitLen  = 0
 	rdb14bitLen = 1
 	rdb32bitLen = 2
 	rdbEncVal   = 3
 
-	rdbFlagExpiryMS = 0xfc
-	rdbFlagExpiry   = 0xfd
-	rdbFlagSelectDB = 0xfe
-	rdbFlagEOF      = 0xff
+	rdb64bitLen = 0x81
+
+	rdbFlagFunction2   = 0xf5
+	rdbFlagFunctionPre = 0xf6
+	rdbFlagModuleAux   = 0xf7
+	rdbFlagIdle        = 0xf8
+	rdbFlagFreq        = 0xf9
+	rdbFlagAux         = 0xfa
+	rdbFlagResizeDB    = 0xfb
+	rdbFlagExpiryMS    = 0xfc
+	rdbFlagExpiry      = 0xfd
+	rdbFlagSelectDB    = 0xfe
+	rdbFlagEOF         = 0xff
 
 	rdbEncInt8  = 0
 	rdbEncInt16 = 1
@@ -135,6 +160,11 @@ const (
 	rdbZiplistInt24 = 0xf0
 	rdbZiplistInt8  = 0xfe
 	rdbZiplistInt4  = 15
+
+	rdbQuicklistNodePlain  = 1
+	rdbQuicklistNodePacked = 2
+
+	rdbListpackEOF = 0xff
 )
 
 func (d *decode) decode() error {
@@ -174,7 +204,34 @@ func (d *decode) decode() error {
 			if err != nil {
 				return err
 			}
+			firstDB = false
 			d.event.StartDatabase(int(db))
+		case rdbFlagAux:
+			// redis-ver, redis-bits, ctime, used-mem...
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+		case rdbFlagResizeDB:
+			// db_size, expires_size
+			if _, _, err := d.readLength(); err != nil {
+				return err
+			}
+			if _, _, err := d.readLength(); err != nil {
+				return err
+			}
+		case rdbFlagIdle:
+			if _, _, err := d.readLength(); err != nil {
+				return err
+			}
+		case rdbFlagFreq:
+			if _, err := d.r.ReadByte(); err != nil {
+				return err
+			}
+		case rdbFlagModuleAux, rdbFlagFunction2, rdbFlagFunctionPre:
+			return fmt.Errorf("rdb: unsupported opcode %d (modules/functions)", objType)
 		case rdbFlagEOF:
 			d.event.EndDatabase(int(db))
 			d.event.EndRDB()
@@ -188,6 +245,7 @@ func (d *decode) decode() error {
 			if err != nil {
 				return err
 			}
+			expiry = 0
 		}
 	}
 
@@ -230,7 +288,7 @@ func (d *decode) readObject(key []byte, typ ValueType, expiry int64) error {
 			d.event.Sadd(key, member)
 		}
 		d.event.EndSet(key)
-	case TypeZSet:
+	case TypeZSet, TypeZSet2:
 		cardinality, _, err := d.readLength()
 		if err != nil {
 			return err
@@ -241,7 +299,12 @@ func (d *decode) readObject(key []byte, typ ValueType, expiry int64) error {
 			if err != nil {
 				return err
 			}
-			score, err := d.readFloat64()
+			var score float64
+			if typ == TypeZSet2 {
+				score, err = d.readBinaryFloat64()
+			} else {
+				score, err = d.readFloat64()
+			}
 			if err != nil {
 				return err
 			}
@@ -276,6 +339,16 @@ func (d *decode) readObject(key []byte, typ ValueType, expiry int64) error {
 		return d.readZiplistZset(key, expiry)
 	case TypeHashZiplist:
 		return d.readZiplistHash(key, expiry)
+	case TypeListQuicklist, TypeListQuicklist2:
+		return d.readQuicklist(key, expiry, typ == TypeListQuicklist2)
+	case TypeHashListpack:
+		return d.readListpackHash(key, expiry)
+	case TypeZSetListpack:
+		return d.readListpackZset(key, expiry)
+	case TypeSetListpack:
+		return d.readListpackSet(key, expiry)
+	case TypeModule, TypeModule2, TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return fmt.Errorf("rdb: unsupported object type %d for key %s", typ, key)
 	default:
 		return fmt.Errorf("rdb: unknown object type %d for key %s", typ, key)
 	}
@@ -530,6 +603,291 @@ func readZiplistEntry(buf *sliceBuffer) ([]byte, error) {
 	return nil, fmt.Errorf("rdb: unknown ziplist header byte: %d", header)
 }
 
+// quicklist是ziplist(或7.0以后的listpack)组成的链表
+// v2格式中每个节点前多一个container字段: 1=PLAIN单个大元素, 2=PACKED
+// 因为StartList需要提前知道总长度，这里先读出全部节点再逐个展开
+func (d *decode) readQuicklist(key []byte, expiry int64, v2 bool) error {
+	count, _, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	nodes := make([][]byte, count)
+	plains := make([]bool, count)
+	var length int64
+	for i := uint32(0); i < count; i++ {
+		container := uint32(rdbQuicklistNodePacked)
+		if v2 {
+			if container, _, err = d.readLength(); err != nil {
+				return err
+			}
+		}
+		if nodes[i], err = d.readString(); err != nil {
+			return err
+		}
+		switch {
+		case container == rdbQuicklistNodePlain:
+			plains[i] = true
+			length++
+		case v2:
+			n, err := countListpackEntries(newSliceBuffer(nodes[i]))
+			if err != nil {
+				return err
+			}
+			length += n
+		default:
+			n, err := readZiplistLength(newSliceBuffer(nodes[i]))
+			if err != nil {
+				return err
+			}
+			length += n
+		}
+	}
+
+	d.event.StartList(key, length, expiry)
+	for i, node := range nodes {
+		if plains[i] {
+			d.event.Rpush(key, node)
+			continue
+		}
+		buf := newSliceBuffer(node)
+		if v2 {
+			n, err := readListpackLength(buf)
+			if err != nil {
+				return err
+			}
+			for j := int64(0); j < n; j++ {
+				entry, err := readListpackEntry(buf)
+				if err != nil {
+					return err
+				}
+				d.event.Rpush(key, entry)
+			}
+		} else {
+			n, err := readZiplistLength(buf)
+			if err != nil {
+				return err
+			}
+			for j := int64(0); j < n; j++ {
+				entry, err := readZiplistEntry(buf)
+				if err != nil {
+					return err
+				}
+				d.event.Rpush(key, entry)
+			}
+		}
+	}
+	d.event.EndList(key)
+	return nil
+}
+
+func (d *decode) readListpackHash(key []byte, expiry int64) error {
+	listpack, err := d.readString()
+	if err != nil {
+		return err
+	}
+	buf := newSliceBuffer(listpack)
+	length, err := readListpackLength(buf)
+	if err != nil {
+		return err
+	}
+	length /= 2
+	d.event.StartHash(key, length, expiry)
+	for i := int64(0); i < length; i++ {
+		field, err := readListpackEntry(buf)
+		if err != nil {
+			return err
+		}
+		value, err := readListpackEntry(buf)
+		if err != nil {
+			return err
+		}
+		d.event.Hset(key, field, value)
+	}
+	d.event.EndHash(key)
+	return nil
+}
+
+func (d *decode) readListpackZset(key []byte, expiry int64) error {
+	listpack, err := d.readString()
+	if err != nil {
+		return err
+	}
+	buf := newSliceBuffer(listpack)
+	cardinality, err := readListpackLength(buf)
+	if err != nil {
+		return err
+	}
+	cardinality /= 2
+	d.event.StartZSet(key, cardinality, expiry)
+	for i := int64(0); i < cardinality; i++ {
+		member, err := readListpackEntry(buf)
+		if err != nil {
+			return err
+		}
+		scoreBytes, err := readListpackEntry(buf)
+		if err != nil {
+			return err
+		}
+		score, err := strconv.ParseFloat(string(scoreBytes), 64)
+		if err != nil {
+			return err
+		}
+		d.event.Zadd(key, score, member)
+	}
+	d.event.EndZSet(key)
+	return nil
+}
+
+func (d *decode) readListpackSet(key []byte, expiry int64) error {
+	listpack, err := d.readString()
+	if err != nil {
+		return err
+	}
+	buf := newSliceBuffer(listpack)
+	cardinality, err := readListpackLength(buf)
+	if err != nil {
+		return err
+	}
+	d.event.StartSet(key, cardinality, expiry)
+	for i := int64(0); i < cardinality; i++ {
+		member, err := readListpackEntry(buf)
+		if err != nil {
+			return err
+		}
+		d.event.Sadd(key, member)
+	}
+	d.event.EndSet(key)
+	return nil
+}
+
+// listpack头部: total-bytes(4) num-elements(2)
+// num-elements为65535时表示元素过多，需要逐个扫描计数
+func readListpackLength(buf *sliceBuffer) (int64, error) {
+	buf.Seek(4, 0) // skip total-bytes
+	lenBytes, err := buf.Slice(2)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(binary.LittleEndian.Uint16(lenBytes))
+	if n != 65535 {
+		return n, nil
+	}
+	if n, err = countListpackEntries(buf); err != nil {
+		return 0, err
+	}
+	_, err = buf.Seek(6, 0)
+	return n, err
+}
+
+func countListpackEntries(buf *sliceBuffer) (int64, error) {
+	buf.Seek(6, 0)
+	var n int64
+	for {
+		b, err := buf.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b == rdbListpackEOF {
+			break
+		}
+		buf.Seek(-1, 1)
+		if _, err = readListpackEntry(buf); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// listpack元素: <encoding-type><element-data><element-tot-len>
+func readListpackEntry(buf *sliceBuffer) (entry []byte, err error) {
+	header, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var size int // encoding + data, 用于跳过backlen
+	switch {
+	case header&0x80 == 0: // 0xxxxxxx 7bit uint
+		entry, size = []byte(strconv.FormatInt(int64(header&0x7f), 10)), 1
+	case header&0xc0 == 0x80: // 10xxxxxx 6bit str len
+		n := int(header & 0x3f)
+		entry, err = buf.Slice(n)
+		size = 1 + n
+	case header&0xe0 == 0xc0: // 110xxxxx 13bit int
+		b, e := buf.ReadByte()
+		if e != nil {
+			return nil, e
+		}
+		v := int64(header&0x1f)<<8 | int64(b)
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		entry, size = []byte(strconv.FormatInt(v, 10)), 2
+	case header&0xf0 == 0xe0: // 1110xxxx 12bit str len
+		b, e := buf.ReadByte()
+		if e != nil {
+			return nil, e
+		}
+		n := int(header&0x0f)<<8 | int(b)
+		entry, err = buf.Slice(n)
+		size = 2 + n
+	case header == 0xf0: // 32bit str len
+		lenBytes, e := buf.Slice(4)
+		if e != nil {
+			return nil, e
+		}
+		n := int(binary.LittleEndian.Uint32(lenBytes))
+		entry, err = buf.Slice(n)
+		size = 5 + n
+	case header == 0xf1: // 16bit int
+		b, e := buf.Slice(2)
+		if e != nil {
+			return nil, e
+		}
+		entry, size = []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10)), 3
+	case header == 0xf2: // 24bit int
+		b, e := buf.Slice(3)
+		if e != nil {
+			return nil, e
+		}
+		v := int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+		entry, size = []byte(strconv.FormatInt(v, 10)), 4
+	case header == 0xf3: // 32bit int
+		b, e := buf.Slice(4)
+		if e != nil {
+			return nil, e
+		}
+		entry, size = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10)), 5
+	case header == 0xf4: // 64bit int
+		b, e := buf.Slice(8)
+		if e != nil {
+			return nil, e
+		}
+		entry, size = []byte(strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10)), 9
+	default:
+		return nil, fmt.Errorf("rdb: unknown listpack header byte: %d", header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.Seek(int64(listpackBacklenSize(size)), 1)
+	return entry, err
+}
+
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
 func (d *decode) readIntset(key []byte, expiry int64) error {
 	intset, err := d.readString()
 	if err != nil {
@@ -585,7 +943,7 @@ func (d *decode) checkHeader() error {
 	}
 
 	version, _ := strconv.ParseInt(string(header[5:]), 10, 64)
-	if version < 1 || version > 6 {
+	if version < 1 || version > maxVersion {
 		return fmt.Errorf("rdb: invalid RDB version number %d", version)
 	}
 
@@ -704,6 +1062,15 @@ func (d *decode) readFloat64() (float64, error) {
 	panic("not reached")
 }
 
+// ZSET_2 stores scores as 8 byte little-endian IEEE 754 doubles.
+func (d *decode) readBinaryFloat64() (float64, error) {
+	u, err := d.readUint64()
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(u), nil
+}
+
 func (d *decode) readLength() (uint32, bool, error) {
 	b, err := d.r.ReadByte()
 	if err != nil {
@@ -726,6 +1093,14 @@ func (d *decode) readLength() (uint32, bool, error) {
 		// The next 6 bits indicate the encoding type.
 		return uint32(b & 0x3f), true, nil
 	default:
+		// 0x81 (RDB 9+): the next 8 bytes are a big-endian 64bit length.
+		if b == rdb64bitLen {
+			_, err := io.ReadFull(d.r, d.intBuf)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint32(binary.BigEndian.Uint64(d.intBuf)), false, nil
+		}
 		// When the first two bits are 10, the next 6 bits are discarded.
 		// The next 4 bytes are the length.
 		length, err := d.readUint32Big()
@@ -735,12 +1110,13 @@ func (d *decode) readLength() (uint32, bool, error) {
 	panic("not reached")
 }
 
-func verifyDump(d []byte) error {
+// VerifyDump checks the version footer and CRC64 of a DUMP payload.
+func VerifyDump(d []byte) error {
 	if len(d) < 10 {
 		return fmt.Errorf("rdb: invalid dump length")
 	}
 	version := binary.LittleEndian.Uint16(d[len(d)-10:])
-	if version != uint16(Version) {
+	if version < 1 || version > maxVersion {
 		return fmt.Errorf("rdb: invalid version %d, expecting %d", version, Version)
 	}
 
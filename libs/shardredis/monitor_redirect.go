@@ -12,7 +12,7 @@ import (
 var syncCmdlist = "DEL,EXPIRE,PERSIST,PEXPIRE,PEXPIREAT,RENAME,RENAMENX,SORT,APPEND,DECR,DECRBY,INCR,INCRBY,INCRBYFLOAT,MSET,MSETNX,PSETEX,SET,SETBIT,SETEX,SETNX,SETRANGE,HDEL,HINCRBY,HINCRBYFLOAT,HMSET,HSET,HSETNX,BLPOP,BRPOP,BRPOPLPUSH,LINSERT,LPOP,LPUSH,LPUSHX,LREM,LSET,LTRIM,RPOP,RPOPLRUSH,RPUSH,RPUSHX,SADD,SDIFFSTORE,SINTERSTORE,SMOVE,SPOP,SREM,SUNIONSTORE,ZADD,ZINCRBY,ZINTERSTORE,ZREM,ZREMRANGEBYRANK,ZREMRANGEBYSCORE,ZREVRANK,ZUNIONSTORE"
 
 // 跳过指令
-var ignoreCmdList = "DUMP,KEYS,MIGRATE,MOVE,OBJECT,RESTORE,SCAN,EVAL,EVALSHA,SCRIPT,DISCARD,EXEC,MULTI,UNWATCH,WATCH,PSUBSCRIBE,PUBSUB,PUBLISH,PUNSUBSCRIBE,SUBSCRIBE,UNSUBSCRIBE,BGREWRITEAOF,BGSAVE,CLIENT,CONFIG,DBSIZE,DEBUG,FLUSHALL,FLUSHDB,INFO,LASTSAVE,MONITOR,SAVE,SHUTDOWM,SLAVEOF,SLOWLOG,SYNC,TIME"
+var ignoreCmdList = "DUMP,KEYS,MIGRATE,MOVE,OBJECT,RESTORE,SCAN,EVAL,EVALSHA,SCRIPT,DISCARD,EXEC,MULTI,UNWATCH,WATCH,PSUBSCRIBE,PUBSUB,PUBLISH,PUNSUBSCRIBE,SUBSCRIBE,UNSUBSCRIBE,BGREWRITEAOF,BGSAVE,CLIENT,CONFIG,DBSIZE,DEBUG,FLUSHALL,FLUSHDB,INFO,LASTSAVE,MONITOR,SAVE,SHUTDOWM,SLAVEOF,REPLICAOF,SLOWLOG,SYNC,TIME"
 
 var needSync = map[string]bool{}
 var ignoreSync = map[string]bool{}
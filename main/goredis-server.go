@@ -10,9 +10,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +24,12 @@ import (
 // go run goredis-server.go -procs 8 -p 17600
 // go run goredis-server.go -slaveof localhost:1603
 // go run goredis-server.go -dbpath /data/ -logpath /home/logs/
+// go run goredis-server.go -warmup meta
+// go run goredis-server.go -loadrdb /data/redis/dump.rdb
+// go run goredis-server.go -restore /data/1602/backup_20140301_030000 -restore-until 1393660800
+// go run goredis-server.go -encryptkey file:/etc/goredis/keys
+// go run goredis-server.go -conf /etc/redis/redis.conf -p 1603
+// GOREDIS_PORT=1603 GOREDIS_SLAVEOF="10.0.0.1 1602" go run goredis-server.go
 func main() {
 	version := flag.Bool("v", false, "print version")
 	host := flag.String("h", "0.0.0.0", "server host")
@@ -31,6 +39,12 @@ func main() {
 	repair := flag.Bool("repair", false, "repair rocksdb")
 	dbpath := flag.String("dbpath", "/data/", "rocksdb path, recommend use SSD")
 	logpath := flag.String("logpath", "/data/", "all logs, include synclog,aof")
+	warmup := flag.String("warmup", "", "warm up before listen: meta/full")
+	loadrdb := flag.String("loadrdb", "", "load a redis dump.rdb before listen, skipped if already loaded")
+	flag.String("restore", "", "boot from a BACKUP dir, dbpath must not contain db0")
+	flag.Int64("restore-until", 0, "after -restore, replay appendonly.aof up to this unix time")
+	encryptkey := flag.String("encryptkey", "", "encrypt string values with AES-GCM, other types are refused, file:path or env:NAME")
+	conf := flag.String("conf", "", "redis.conf style config file, overridden by GOREDIS_* env and command line flags")
 	flag.Parse()
 
 	if *version {
@@ -38,43 +52,77 @@ func main() {
 		return
 	}
 
-	if !dirExist(*dbpath) {
-		stdlog.Println("-dbpath", *dbpath, "not exist")
-		return
-	}
-	if !dirExist(*logpath) {
-		stdlog.Println("-logpath", *logpath, "not exist")
-		return
-	}
-
-	runtime.GOMAXPROCS(*procs)
-
 	// Options
 	opt := goredis_server.NewOptions()
 	opt.SetHost(*host)
 	opt.SetPort(*port)
-	opt.SetDBPath(joinGoRedisPath(*dbpath, *port))
-	opt.SetLogPath(joinGoRedisPath(*logpath, *port))
-	// ensure
-	os.Mkdir(opt.DBPath(), os.ModePerm)
-	os.Mkdir(opt.LogPath(), os.ModePerm)
-
-	// split -slaveof host:port
-	if len(*slaveof) > 0 {
-		hostPort := strings.Split(*slaveof, ":")
-		if len(hostPort) != 2 {
-			panic("bad slaveof")
+	opt.SetDBPath(*dbpath)
+	opt.SetLogPath(*logpath)
+	opt.SetWarmUp(*warmup)
+	opt.SetLoadRDB(*loadrdb)
+	// 默认值 < 配置文件 < 环境变量 < 命令行参数
+	if len(*conf) > 0 {
+		warnings, err := goredis_server.LoadConfFile(*conf, opt)
+		if err != nil {
+			fatal("-conf", err)
+		}
+		for _, w := range warnings {
+			stdlog.Println("-conf", w)
+		}
+	}
+	if err := goredis_server.LoadConfEnv(os.Environ(), opt); err != nil {
+		fatal("env", err)
+	}
+	var flagErr error
+	flag.Visit(func(f *flag.Flag) {
+		args := []string{f.Name, f.Value.String()}
+		switch f.Name {
+		case "h":
+			args[0] = "bind"
+		case "p":
+			args[0] = "port"
+		case "slaveof":
+			// -slaveof host:port
+			args = append([]string{"slaveof"}, strings.Split(*slaveof, ":")...)
+		case "dbpath", "logpath", "warmup", "loadrdb", "restore", "restore-until":
+		default:
+			return
+		}
+		if _, err := opt.ApplyDirective(args, goredis_server.SourceFlag); err != nil && flagErr == nil {
+			flagErr = fmt.Errorf("-%s %s", f.Name, err)
 		}
-		p, e := strconv.Atoi(hostPort[1])
-		if e != nil {
-			panic(e)
+	})
+	if flagErr != nil {
+		fatal(flagErr)
+	}
+
+	if w := opt.WarmUp(); len(w) > 0 && w != "meta" && w != "full" {
+		fatal("-warmup", w, "must be meta or full")
+	}
+
+	runtime.GOMAXPROCS(*procs)
+
+	opt.SetDBPath(joinGoRedisPath(opt.DBPath(), opt.Port()))
+	opt.SetLogPath(joinGoRedisPath(opt.LogPath(), opt.Port()))
+	if len(*encryptkey) > 0 {
+		codec, err := levelredis.LoadAESCodec(*encryptkey)
+		if err != nil {
+			fatal("-encryptkey", err)
 		}
-		opt.SetSlaveOf(hostPort[0], p)
+		opt.AddValueCodec("", codec)
+	}
+	// 首次启动时创建数据目录，比如容器挂载的空volume
+	firstRun := !dirExist(filepath.Join(opt.DBPath(), "db0"))
+	if err := ensureDir("-dbpath", opt.DBPath()); err != nil {
+		fatal(err)
+	}
+	if err := ensureDir("-logpath", opt.LogPath()); err != nil {
+		fatal(err)
 	}
 
 	// 重定向日志输出位置
 	if err := redirectStdout(opt.LogPath()); err != nil {
-		panic(err)
+		fatal("redirect stdout:", err)
 	}
 
 	// repair
@@ -90,18 +138,110 @@ func main() {
 		return
 	}
 
-	stdlog.Println("========================================")
-	stdlog.Println("server init, version", goredis_server.VERSION, "...")
-	stdlog.Printf("dbpath:%s, logpath:%s\n", opt.DBPath(), opt.LogPath())
+	printBanner(opt, *procs, firstRun)
 
 	// GoRedis Server
 	server := goredis_server.NewGoRedisServer(opt)
 	if err := server.Init(); err != nil {
-		panic(err)
+		fatal("init failed:", explainStartError(err, opt))
 	}
 	if err := server.Listen(); err != nil {
-		panic(err)
+		fatal("listen failed:", explainStartError(err, opt))
+	}
+}
+
+// 启动失败时输出原因，以非0状态退出，容器编排可以据此重启或报警
+// stderr已经重定向到文件，panic的内容在容器日志中看不到
+func fatal(v ...interface{}) {
+	stdlog.Println(v...)
+	os.Exit(1)
+}
+
+// 启动时输出生效的配置和来源
+func printBanner(opt *goredis_server.Options, procs int, firstRun bool) {
+	stdlog.Println("========================================")
+	stdlog.Println("server init, version", goredis_server.VERSION, "...")
+	slaveof := "-"
+	if host, port := opt.SlaveOf(); len(host) > 0 {
+		slaveof = fmt.Sprintf("%s:%d", host, port)
+	}
+	warmup := opt.WarmUp()
+	if len(warmup) == 0 {
+		warmup = "-"
+	}
+	loadrdb := opt.LoadRDB()
+	if len(loadrdb) == 0 {
+		loadrdb = "-"
+	}
+	items := [][]string{
+		{"bind", opt.Host()},
+		{"port", strconv.Itoa(opt.Port())},
+		{"dbpath", opt.DBPath()},
+		{"logpath", opt.LogPath()},
+		{"slaveof", slaveof},
+		{"warmup", warmup},
+		{"loadrdb", loadrdb},
+	}
+	for _, item := range items {
+		stdlog.Printf("  %-10s %s (%s)\n", item[0], item[1], opt.Source(item[0]))
+	}
+	for _, p := range opt.Listeners() {
+		stdlog.Printf("  %-10s %s (%s)\n", "listen", p, opt.Source("listen"))
+	}
+	keys := make([]string, 0, len(opt.Configs()))
+	for key := range opt.Configs() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		stdlog.Printf("  %-10s %s (%s)\n", key, opt.Configs()[key], opt.Source(key))
+	}
+	stdlog.Printf("  %-10s %d\n", "procs", procs)
+	stdlog.Printf("  %-10s %v\n", "encrypt", len(opt.ValueCodecs()) > 0)
+	if firstRun {
+		stdlog.Println("first run, creating new database in", opt.DBPath())
+	}
+}
+
+// 为常见的启动失败补充处理建议
+func explainStartError(err error, opt *goredis_server.Options) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "lock"):
+		return fmt.Sprintf("%s; %s is locked, another goredis-server may be running with the same -dbpath and -p", msg, opt.DBPath())
+	case strings.Contains(msg, "ermission denied"):
+		return fmt.Sprintf("%s; check that uid %d can write %s and %s", msg, os.Getuid(), opt.DBPath(), opt.LogPath())
+	case strings.Contains(msg, "address already in use"):
+		return fmt.Sprintf("%s; port %d is in use, stop the other process or choose another -p", msg, opt.Port())
+	case strings.Contains(msg, "cannot assign requested address"):
+		return fmt.Sprintf("%s; %s is not an address of this host, use -h 0.0.0.0", msg, opt.Host())
+	}
+	return msg
+}
+
+// 目录不存在时创建，并检查是否可写
+func ensureDir(name, dir string) error {
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("%s %s cannot be created: %s", name, dir, err)
+		}
+	case err != nil:
+		return fmt.Errorf("%s %s: %s", name, dir, err)
+	case !info.IsDir():
+		return fmt.Errorf("%s %s is not a directory", name, dir)
+	}
+	f, err := ioutil.TempFile(dir, ".write_test")
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("%s %s is not writable by uid %d, fix the owner or mount another directory", name, dir, os.Getuid())
+		}
+		return fmt.Errorf("%s %s: %s", name, dir, err)
 	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
 }
 
 func init() {
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/latermoon/redigo/redis"
+	"strings"
+	"testing"
+)
+
+func TestBlob(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "mail:1", "mail:2", "mail:3"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("BLOB.ABORT", "mail:3")
+
+	// 跨越多个64KB块
+	body := strings.Repeat("0123456789abcdef", 10000)
+	digest := sha256.Sum256([]byte(body))
+	sum := hex.EncodeToString(digest[:])
+
+	if reply, err := redis.String(conn.Do("BLOB.PUT", "mail:1", body)); err != nil {
+		t.Fatal(err)
+	} else if reply != sum {
+		t.Error("bad sha256", reply)
+	}
+	if reply, err := redis.String(conn.Do("BLOB.GET", "mail:1")); err != nil {
+		t.Fatal(err)
+	} else if reply != body {
+		t.Error("bad value", len(reply))
+	}
+	if reply, err := redis.String(conn.Do("BLOB.GET", "mail:1", "65530", "10")); err != nil {
+		t.Fatal(err)
+	} else if reply != body[65530:65540] {
+		t.Error("bad range", reply)
+	}
+
+	// 相同内容只增加引用
+	if n, err := redis.Int(conn.Do("BLOB.LINK", "mail:2", sum)); err != nil || n != 1 {
+		t.Error("bad link", n, err)
+	}
+	if n, err := redis.Int(conn.Do("BLOB.LINK", "mail:2", strings.Repeat("0", 64))); err != nil || n != 0 {
+		t.Error("link missing content", n, err)
+	}
+
+	// 分段上传
+	for i := 0; i < len(body); i += 50000 {
+		end := i + 50000
+		if end > len(body) {
+			end = len(body)
+		}
+		if n, err := redis.Int(conn.Do("BLOB.APPEND", "mail:3", body[i:end])); err != nil || n != end {
+			t.Fatal("bad append", n, err)
+		}
+	}
+	if reply, err := redis.String(conn.Do("BLOB.COMMIT", "mail:3")); err != nil || reply != sum {
+		t.Error("bad commit", reply, err)
+	}
+	if _, err := conn.Do("BLOB.COMMIT", "mail:3"); err == nil {
+		t.Error("commit without upload should fail")
+	}
+
+	if stat, err := redis.Values(conn.Do("BLOB.STAT", "mail:3")); err != nil {
+		t.Fatal(err)
+	} else if len(stat) != 4 || string(stat[0].([]byte)) != sum || stat[1].(int64) != int64(len(body)) || stat[2].(int64) != 3 {
+		t.Error("bad stat", stat)
+	}
+	if reply, err := redis.String(conn.Do("TYPE", "mail:1")); err != nil || reply != "blob" {
+		t.Error("bad type", reply, err)
+	}
+
+	// 删除时减少引用，最后一个引用删除后内容不可再LINK
+	if n, err := redis.Int(conn.Do("DEL", "mail:1", "mail:2")); err != nil || n != 2 {
+		t.Error("bad del", n, err)
+	}
+	if reply, err := conn.Do("BLOB.GET", "mail:1"); err != nil || reply != nil {
+		t.Error("deleted blob", reply, err)
+	}
+	if stat, err := redis.Values(conn.Do("BLOB.STAT", "mail:3")); err != nil || stat[2].(int64) != 1 {
+		t.Error("bad refs", stat, err)
+	}
+	if _, err := conn.Do("DEL", "mail:3"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("BLOB.LINK", "mail:1", sum)); err != nil || n != 0 {
+		t.Error("content should be deleted", n, err)
+	}
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"GoRedis/goredis_client"
+	"github.com/latermoon/redigo/redis"
+	"testing"
+)
+
+func TestBulkWrite(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "bulk_str", "bulk_hash", "bulk_set", "bulk_zset")
+	conn.Do("SADD", "bulk_set", "a")
+
+	// 同一批里重复的member只计数一次
+	n, err := redis.Int(conn.Do("BULK.WRITE", "SET", "bulk_str", "v", "HSET", "bulk_hash", "f", "v",
+		"SADD", "bulk_set", "a", "SADD", "bulk_set", "b", "SADD", "bulk_set", "b",
+		"ZADD", "bulk_zset", "1", "m", "ZADD", "bulk_zset", "2", "m"))
+	if err != nil || n != 7 {
+		t.Fatal("bad ack", n, err)
+	}
+	if v, _ := redis.String(conn.Do("GET", "bulk_str")); v != "v" {
+		t.Error("bad string", v)
+	}
+	if v, _ := redis.String(conn.Do("HGET", "bulk_hash", "f")); v != "v" {
+		t.Error("bad hash", v)
+	}
+	if card, _ := redis.Int(conn.Do("SCARD", "bulk_set")); card != 2 {
+		t.Error("bad scard", card)
+	}
+	if card, _ := redis.Int(conn.Do("ZCARD", "bulk_zset")); card != 1 {
+		t.Error("bad zcard", card)
+	}
+	if score, _ := redis.String(conn.Do("ZSCORE", "bulk_zset", "m")); score != "2" {
+		t.Error("bad zscore", score)
+	}
+
+	// 类型不符时整批不写入
+	if _, err = conn.Do("BULK.WRITE", "SADD", "bulk_set", "c", "SADD", "bulk_hash", "c"); err == nil {
+		t.Error("wrongtype not rejected")
+	}
+	if ok, _ := redis.Int(conn.Do("SISMEMBER", "bulk_set", "c")); ok != 0 {
+		t.Error("partial batch written")
+	}
+	if _, err = conn.Do("BULK.WRITE", "SADD", "bulk_set"); err == nil {
+		t.Error("syntax error not returned")
+	}
+
+	// 客户端分批发送
+	client := goredis_client.NewClient(host, 1)
+	defer client.Close()
+	w := client.BulkWriter(3, 2)
+	for i := 0; i < 10; i++ {
+		w.ZAdd("bulk_zset", float64(i), i)
+	}
+	if err = w.Flush(); err != nil || w.Acked != 10 {
+		t.Error("bad bulk writer", w.Acked, err)
+	}
+	w.Close()
+	if card, _ := redis.Int(conn.Do("ZCARD", "bulk_zset")); card != 11 {
+		t.Error("bad zcard", card)
+	}
+
+	conn.Do("DEL", "bulk_str", "bulk_hash", "bulk_set", "bulk_zset")
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"GoRedis/goredis_client"
+	"bytes"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	client := goredis_client.NewClient(host, 10)
+	defer client.Close()
+
+	client.Do("DEL", "client_doc", "client_blob", "client_quota")
+
+	// doc
+	if err := client.DocSet("client_doc", map[string]interface{}{"name": "latermoon", "version": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DocSet("client_doc", map[string]interface{}{"$incr": map[string]interface{}{"version": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := client.DocGet("client_doc", "name", "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["name"] != "latermoon" || doc["version"] != float64(2) {
+		t.Error("bad doc", doc)
+	}
+	if doc, err = client.DocGet("client_doc_missing"); err != nil || doc != nil {
+		t.Error("missing doc", doc, err)
+	}
+
+	// pipeline，单条指令的错误不影响其它结果
+	p := client.Pipeline()
+	p.Send("SET", "client_quota", "1")
+	p.Send("HSET", "client_quota", "f", "v")
+	p.Send("INCR", "client_quota")
+	replies, err := p.Exec()
+	p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || replies[2] != int64(2) {
+		t.Error("bad pipeline", replies)
+	}
+	if _, ok := replies[1].(error); !ok {
+		t.Error("pipeline error not returned", replies[1])
+	}
+
+	if value, ok, err := client.IncrLimit("client_quota", 1, 2); err != nil || ok || value != 2 {
+		t.Error("bad incrlimit", value, ok, err)
+	}
+
+	// 跨越多个块的blob
+	data := bytes.Repeat([]byte("0123456789"), goredis_client.BlobChunkSize/4)
+	sum, err := client.BlobUpload("client_blob", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := client.BlobStat("client_blob"); err != nil || stat == nil || stat.Sum != sum || stat.Size != int64(len(data)) {
+		t.Error("bad blob stat", stat, err)
+	}
+	buf := &bytes.Buffer{}
+	if n, err := client.BlobDownload("client_blob", buf); err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Error("bad blob download", n, err)
+	}
+
+	client.Do("DEL", "client_doc", "client_blob", "client_quota")
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"github.com/latermoon/redigo/redis"
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("CRON.DEL", "test_trim")
+	conn.Do("CRON.DEL", "test_expire")
+	conn.Do("DEL", "cron_events", "cron_session:1", "cron_data:cron_session:1")
+
+	// 格式错误和不允许的指令
+	if _, err := conn.Do("CRON.ADD", "test_trim", "* * *", "DEL", "cron_events"); err == nil {
+		t.Error("bad spec accepted")
+	}
+	if _, err := conn.Do("CRON.ADD", "test_trim", "@daily", "BLPOP", "cron_events", "0"); err == nil {
+		t.Error("blocking command accepted")
+	}
+	if _, err := conn.Do("CRON.ADD", "test_trim", "@daily", "FLUSHALL"); err == nil {
+		t.Error("server command accepted")
+	}
+
+	if _, err := conn.Do("CRON.ADD", "test_trim", "*/5 3 * * 1-5", "ZREMRANGEBYSCORE", "cron_events", "-inf", "100"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("ZADD", "cron_events", "1", "a", "200", "b")
+	if n, _ := redis.Int(conn.Do("CRON.RUN", "test_trim")); n != 1 {
+		t.Error("bad cron run", n)
+	}
+	if n, _ := redis.Int(conn.Do("ZCARD", "cron_events")); n != 1 {
+		t.Error("trim not applied", n)
+	}
+	jobs, err := redis.Values(conn.Do("CRON.LIST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, job := range jobs {
+		fields, _ := redis.Values(job, nil)
+		if name, _ := redis.String(fields[0], nil); name == "test_trim" {
+			found = true
+			if runs, _ := redis.Int(fields[3], nil); runs != 1 {
+				t.Error("bad runs", runs)
+			}
+		}
+	}
+	if !found {
+		t.Error("job not listed")
+	}
+
+	// key过期后执行
+	if _, err := conn.Do("CRON.ADD", "test_expire", "@expire:cron_session:", "DEL", "cron_data:$KEY"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("SET", "cron_data:cron_session:1", "v")
+	conn.Do("SET", "cron_session:1", "v")
+	conn.Do("PEXPIRE", "cron_session:1", "50")
+	time.Sleep(500 * time.Millisecond)
+	if v, _ := conn.Do("GET", "cron_data:cron_session:1"); v != nil {
+		t.Error("expire job not run")
+	}
+
+	for _, name := range []string{"test_trim", "test_expire"} {
+		if n, _ := redis.Int(conn.Do("CRON.DEL", name)); n != 1 {
+			t.Error("bad cron del", name, n)
+		}
+	}
+}
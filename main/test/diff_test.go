@@ -0,0 +1,540 @@
+package test
+
+// 差异测试：随机生成指令序列，同时发送给GoRedis和内存中的参照模型，逐条比较回复，最后比较全部数据
+// 失败时输出随机种子，可以通过 -seed 复现
+// go test -run TestDiff -seed 1394000000000
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var diffSeed = flag.Int64("seed", 0, "random seed for TestDiff, 0 for time based")
+
+// 参照模型，语义与redis一致
+type refModel struct {
+	lists  map[string][]string
+	hashes map[string]map[string]string
+	zsets  map[string]map[string]float64
+}
+
+func newRefModel() *refModel {
+	return &refModel{
+		lists:  make(map[string][]string),
+		hashes: make(map[string]map[string]string),
+		zsets:  make(map[string]map[string]float64),
+	}
+}
+
+// 规范化的回复，与replyString的格式一致
+func refInt(n int) string            { return ":" + strconv.Itoa(n) }
+func refBulk(s string) string        { return "$" + s }
+func refArray(items []string) string { return "[" + strings.Join(items, ",") + "]" }
+
+const refNil = "nil"
+
+// redis风格的下标转换为[start, stop]，ok=false表示为空
+func refRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop, start <= stop
+}
+
+func (m *refModel) do(args []string) string {
+	key := args[1]
+	switch args[0] {
+	case "RPUSH", "LPUSH":
+		for _, v := range args[2:] {
+			if args[0] == "RPUSH" {
+				m.lists[key] = append(m.lists[key], v)
+			} else {
+				m.lists[key] = append([]string{v}, m.lists[key]...)
+			}
+		}
+		return refInt(len(m.lists[key]))
+	case "LPOP", "RPOP":
+		l := m.lists[key]
+		if len(l) == 0 {
+			return refNil
+		}
+		var v string
+		if args[0] == "LPOP" {
+			v, m.lists[key] = l[0], l[1:]
+		} else {
+			v, m.lists[key] = l[len(l)-1], l[:len(l)-1]
+		}
+		return refBulk(v)
+	case "LLEN":
+		return refInt(len(m.lists[key]))
+	case "LINDEX":
+		l := m.lists[key]
+		i, _ := strconv.Atoi(args[2])
+		if i < 0 {
+			i += len(l)
+		}
+		if i < 0 || i >= len(l) {
+			return refNil
+		}
+		return refBulk(l[i])
+	case "LRANGE":
+		l := m.lists[key]
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		items := []string{}
+		if start, stop, ok := refRange(start, stop, len(l)); ok {
+			for _, v := range l[start : stop+1] {
+				items = append(items, refBulk(v))
+			}
+		}
+		return refArray(items)
+	case "LTRIM":
+		l := m.lists[key]
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		if start, stop, ok := refRange(start, stop, len(l)); ok {
+			m.lists[key] = append([]string{}, l[start:stop+1]...)
+		} else {
+			m.lists[key] = nil
+		}
+		return "+OK"
+	case "LREM":
+		l := m.lists[key]
+		count, _ := strconv.Atoi(args[2])
+		value := args[3]
+		removed := make([]bool, len(l))
+		n := 0
+		for j := 0; j < len(l); j++ {
+			i := j
+			if count < 0 {
+				i = len(l) - 1 - j
+			}
+			if l[i] == value && (count == 0 || n < count || n < -count) {
+				removed[i] = true
+				n++
+			}
+		}
+		rest := []string{}
+		for i, v := range l {
+			if !removed[i] {
+				rest = append(rest, v)
+			}
+		}
+		m.lists[key] = rest
+		return refInt(n)
+	case "LSET":
+		l := m.lists[key]
+		i, _ := strconv.Atoi(args[2])
+		if i < 0 {
+			i += len(l)
+		}
+		if i < 0 || i >= len(l) {
+			return "-ERR"
+		}
+		l[i] = args[3]
+		return "+OK"
+	case "LINSERT":
+		l := m.lists[key]
+		if len(l) == 0 {
+			return refInt(0)
+		}
+		for i, v := range l {
+			if v == args[3] {
+				if args[2] == "AFTER" {
+					i++
+				}
+				l = append(l[:i], append([]string{args[4]}, l[i:]...)...)
+				m.lists[key] = l
+				return refInt(len(l))
+			}
+		}
+		return refInt(-1)
+	case "HMSET":
+		h, ok := m.hashes[key]
+		if !ok {
+			h = make(map[string]string)
+			m.hashes[key] = h
+		}
+		for i := 2; i < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return "+OK"
+	case "HSET":
+		h, ok := m.hashes[key]
+		if !ok {
+			h = make(map[string]string)
+			m.hashes[key] = h
+		}
+		n := 0
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return refInt(n)
+	case "HKEYS", "HVALS":
+		// GoRedis按field字节顺序返回
+		h := m.hashes[key]
+		fields := make([]string, 0, len(h))
+		for f := range h {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		items := make([]string, len(fields))
+		for i, f := range fields {
+			if args[0] == "HKEYS" {
+				items[i] = refBulk(f)
+			} else {
+				items[i] = refBulk(h[f])
+			}
+		}
+		return refArray(items)
+	case "HGET":
+		if v, ok := m.hashes[key][args[2]]; ok {
+			return refBulk(v)
+		}
+		return refNil
+	case "HEXISTS":
+		if _, ok := m.hashes[key][args[2]]; ok {
+			return refInt(1)
+		}
+		return refInt(0)
+	case "HDEL":
+		n := 0
+		for _, f := range args[2:] {
+			if _, ok := m.hashes[key][f]; ok {
+				delete(m.hashes[key], f)
+				n++
+			}
+		}
+		return refInt(n)
+	case "ZADD":
+		z, ok := m.zsets[key]
+		if !ok {
+			z = make(map[string]float64)
+			m.zsets[key] = z
+		}
+		n := 0
+		for i := 2; i < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			if _, ok := z[args[i+1]]; !ok {
+				n++
+			}
+			z[args[i+1]] = score
+		}
+		return refInt(n)
+	case "ZINCRBY":
+		z, ok := m.zsets[key]
+		if !ok {
+			z = make(map[string]float64)
+			m.zsets[key] = z
+		}
+		incr, _ := strconv.ParseFloat(args[2], 64)
+		z[args[3]] += incr
+		return refBulk(refScore(z[args[3]]))
+	case "ZREM":
+		n := 0
+		for _, member := range args[2:] {
+			if _, ok := m.zsets[key][member]; ok {
+				delete(m.zsets[key], member)
+				n++
+			}
+		}
+		return refInt(n)
+	case "ZSCORE":
+		if score, ok := m.zsets[key][args[2]]; ok {
+			return refBulk(refScore(score))
+		}
+		return refNil
+	case "ZCARD":
+		return refInt(len(m.zsets[key]))
+	case "ZRANK", "ZREVRANK":
+		items := m.sortedZSet(key)
+		for i, it := range items {
+			if it.member == args[2] {
+				if args[0] == "ZREVRANK" {
+					i = len(items) - 1 - i
+				}
+				return refInt(i)
+			}
+		}
+		return refNil
+	case "ZRANGE", "ZREVRANGE":
+		items := m.sortedZSet(key)
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		result := []string{}
+		if start, stop, ok := refRange(start, stop, len(items)); ok {
+			for i := start; i <= stop; i++ {
+				it := items[i]
+				if args[0] == "ZREVRANGE" {
+					it = items[len(items)-1-i]
+				}
+				result = append(result, refBulk(it.member))
+				if len(args) > 4 {
+					result = append(result, refBulk(refScore(it.score)))
+				}
+			}
+		}
+		return refArray(result)
+	case "ZRANGEBYSCORE":
+		min, _ := strconv.ParseFloat(args[2], 64)
+		max, _ := strconv.ParseFloat(args[3], 64)
+		result := []string{}
+		for _, it := range m.sortedZSet(key) {
+			if it.score >= min && it.score <= max {
+				result = append(result, refBulk(it.member))
+			}
+		}
+		return refArray(result)
+	case "ZREMRANGEBYRANK":
+		items := m.sortedZSet(key)
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		n := 0
+		if start, stop, ok := refRange(start, stop, len(items)); ok {
+			for _, it := range items[start : stop+1] {
+				delete(m.zsets[key], it.member)
+				n++
+			}
+		}
+		return refInt(n)
+	}
+	panic("unknown command " + args[0])
+}
+
+func (m *refModel) sortedZSet(key string) zrefItems {
+	items := make(zrefItems, 0, len(m.zsets[key]))
+	for member, score := range m.zsets[key] {
+		items = append(items, zrefItem{score, member})
+	}
+	sort.Sort(items)
+	return items
+}
+
+func refScore(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// 把redigo的回复转换为规范化的字符串
+func replyString(reply interface{}, err error) string {
+	if err != nil {
+		return "-ERR"
+	}
+	switch v := reply.(type) {
+	case nil:
+		return refNil
+	case int64:
+		return ":" + strconv.FormatInt(v, 10)
+	case string:
+		return "+" + v
+	case []byte:
+		return refBulk(string(v))
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = replyString(item, nil)
+		}
+		return refArray(items)
+	}
+	return fmt.Sprintf("?%v", reply)
+}
+
+// 随机指令生成，key和value取值范围很小，以便产生重复、覆盖、删空等边界情况
+type diffGen struct {
+	r *rand.Rand
+}
+
+func (g *diffGen) key(typ string) string {
+	return fmt.Sprintf("diff:%s:%d", typ, g.r.Intn(3))
+}
+
+func (g *diffGen) value() string {
+	return string('a' + byte(g.r.Intn(5)))
+}
+
+func (g *diffGen) index() string {
+	return strconv.Itoa(g.r.Intn(14) - 7)
+}
+
+// 0.5的倍数，ZINCRBY累加后没有精度误差
+func (g *diffGen) score() string {
+	return refScore(float64(g.r.Intn(21)-10) / 2)
+}
+
+func (g *diffGen) next() []string {
+	switch g.r.Intn(3) {
+	case 0:
+		key := g.key("list")
+		switch g.r.Intn(11) {
+		case 0, 1:
+			return []string{"RPUSH", key, g.value(), g.value()}
+		case 2:
+			return []string{"LPUSH", key, g.value()}
+		case 3:
+			return []string{"LPOP", key}
+		case 4:
+			return []string{"RPOP", key}
+		case 5:
+			return []string{"LINDEX", key, g.index()}
+		case 6:
+			return []string{"LRANGE", key, g.index(), g.index()}
+		case 7:
+			return []string{"LREM", key, strconv.Itoa(g.r.Intn(5) - 2), g.value()}
+		case 8:
+			return []string{"LSET", key, g.index(), g.value()}
+		case 9:
+			where := []string{"BEFORE", "AFTER"}[g.r.Intn(2)]
+			return []string{"LINSERT", key, where, g.value(), g.value()}
+		default:
+			if g.r.Intn(4) == 0 {
+				return []string{"LTRIM", key, g.index(), g.index()}
+			}
+			return []string{"LLEN", key}
+		}
+	case 1:
+		key := g.key("hash")
+		switch g.r.Intn(8) {
+		case 0, 1:
+			return []string{"HMSET", key, g.value(), g.value(), g.value(), g.value()}
+		case 2:
+			// 可能包含重复的field
+			return []string{"HSET", key, g.value(), g.value(), g.value(), g.value()}
+		case 3:
+			return []string{"HGET", key, g.value()}
+		case 4:
+			return []string{"HEXISTS", key, g.value()}
+		case 5:
+			return []string{"HKEYS", key}
+		case 6:
+			return []string{"HVALS", key}
+		default:
+			return []string{"HDEL", key, g.value(), g.value()}
+		}
+	default:
+		key := g.key("zset")
+		switch g.r.Intn(10) {
+		case 0, 1:
+			// 同一条ZADD中member不重复
+			m1, m2 := g.value(), g.value()
+			if m1 == m2 {
+				return []string{"ZADD", key, g.score(), m1}
+			}
+			return []string{"ZADD", key, g.score(), m1, g.score(), m2}
+		case 2:
+			return []string{"ZINCRBY", key, g.score(), g.value()}
+		case 3:
+			return []string{"ZREM", key, g.value()}
+		case 4:
+			return []string{"ZSCORE", key, g.value()}
+		case 5:
+			return []string{"ZRANGE", key, g.index(), g.index(), "WITHSCORES"}
+		case 6:
+			return []string{"ZREVRANGE", key, g.index(), g.index()}
+		case 7:
+			return []string{"ZRANGEBYSCORE", key, g.score(), g.score()}
+		case 8:
+			return []string{[]string{"ZRANK", "ZREVRANK"}[g.r.Intn(2)], key, g.value()}
+		default:
+			if g.r.Intn(4) == 0 {
+				return []string{"ZREMRANGEBYRANK", key, g.index(), g.index()}
+			}
+			return []string{"ZCARD", key}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	seed := *diffSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Log("seed", seed)
+
+	keys := []string{}
+	for _, typ := range []string{"list", "hash", "zset"} {
+		for i := 0; i < 3; i++ {
+			keys = append(keys, fmt.Sprintf("diff:%s:%d", typ, i))
+		}
+	}
+	for _, key := range keys {
+		if _, err := conn.Do("DEL", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	model := newRefModel()
+	gen := &diffGen{r: rand.New(rand.NewSource(seed))}
+	history := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		args := gen.next()
+		history = append(history, strings.Join(args, " "))
+		cmdargs := make([]interface{}, len(args)-1)
+		for j, arg := range args[1:] {
+			cmdargs[j] = arg
+		}
+		got := replyString(conn.Do(args[0], cmdargs...))
+		want := model.do(args)
+		if got != want {
+			t.Fatalf("seed %d, step %d, %s\ngot:  %s\nwant: %s\nrecent:\n%s", seed, i, history[i], got, want, recentHistory(history, 10))
+		}
+	}
+
+	// 比较最终数据
+	for _, key := range keys {
+		var got, want string
+		switch {
+		case strings.HasPrefix(key, "diff:list:"):
+			got = replyString(conn.Do("LRANGE", key, "0", "-1"))
+			want = model.do([]string{"LRANGE", key, "0", "-1"})
+		case strings.HasPrefix(key, "diff:hash:"):
+			reply, err := conn.Do("HGETALL", key)
+			got = replyString(reply, err)
+			// GoRedis按field排序返回，参照模型同样排序
+			fields := []string{}
+			for f := range model.hashes[key] {
+				fields = append(fields, f)
+			}
+			sort.Strings(fields)
+			items := []string{}
+			for _, f := range fields {
+				items = append(items, refBulk(f), refBulk(model.hashes[key][f]))
+			}
+			want = refArray(items)
+		default:
+			got = replyString(conn.Do("ZRANGE", key, "0", "-1", "WITHSCORES"))
+			want = model.do([]string{"ZRANGE", key, "0", "-1", "WITHSCORES"})
+		}
+		if got != want {
+			t.Errorf("seed %d, final state of %s\ngot:  %s\nwant: %s", seed, key, got, want)
+		}
+	}
+}
+
+func recentHistory(history []string, n int) string {
+	if len(history) > n {
+		history = history[len(history)-n:]
+	}
+	return strings.Join(history, "\n")
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"github.com/latermoon/redigo/redis"
+	"testing"
+)
+
+func TestDocHistory(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("CONFIG", "SET", "doc-history-len", "2"); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Do("CONFIG", "SET", "doc-history-len", "0")
+	if _, err := conn.Do("DEL", "dochistory"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{`{"v":1}`, `{"v":2}`, `{"v":3}`} {
+		if _, err := conn.Do("DOC_SET", "dochistory", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 只保留最近2个版本
+	reply, err := redis.Strings(conn.Do("DOC_HISTORY", "dochistory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 4 || reply[1] != `{"v":3}` || reply[3] != `{"v":2}` {
+		t.Error("bad history", reply)
+	}
+
+	// 撤销最后一次修改
+	if _, err := conn.Do("DOC_REVERT", "dochistory"); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := redis.String(conn.Do("DOC_GET", "dochistory")); err != nil || doc != `{"v":2}` {
+		t.Error("bad revert", doc, err)
+	}
+	if _, err := conn.Do("DOC_REVERT", "dochistory", "5"); err == nil {
+		t.Error("error expected")
+	}
+
+	if _, err := conn.Do("DEL", "dochistory"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := redis.Strings(conn.Do("DOC_HISTORY", "dochistory")); err != nil || len(reply) != 0 {
+		t.Error("history should be deleted", reply, err)
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+// key不存在或元素被全部弹出后，list/zset指令应返回nil bulk、nil array或空数组，不能报错或断开连接
+import (
+	"github.com/latermoon/redigo/redis"
+	"testing"
+)
+
+type emptyCase struct {
+	args  []interface{}
+	empty bool // true表示空数组，false表示nil
+}
+
+func checkEmptyReplies(t *testing.T, conn redis.Conn, cases []emptyCase) {
+	for _, c := range cases {
+		reply, err := conn.Do(c.args[0].(string), c.args[1:]...)
+		if err != nil {
+			t.Fatal(c.args, err)
+		}
+		if c.empty {
+			if bulks, ok := reply.([]interface{}); !ok || len(bulks) != 0 {
+				t.Error(c.args, "bad reply", reply)
+			}
+		} else if reply != nil {
+			t.Error(c.args, "bad reply", reply)
+		}
+	}
+}
+
+func TestEmptyList(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "emptylist", "emptylist2"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []emptyCase{
+		{[]interface{}{"LPOP", "emptylist"}, false},
+		{[]interface{}{"RPOP", "emptylist"}, false},
+		{[]interface{}{"LINDEX", "emptylist", "0"}, false},
+		{[]interface{}{"LINDEX", "emptylist", "-1"}, false},
+		{[]interface{}{"RPOPLPUSH", "emptylist", "emptylist2"}, false},
+		{[]interface{}{"LMOVE", "emptylist", "emptylist2", "LEFT", "RIGHT"}, false},
+		{[]interface{}{"LMPOP", "1", "emptylist", "LEFT"}, false},
+		{[]interface{}{"BLPOP", "emptylist", "0.1"}, false},
+		{[]interface{}{"LRANGE", "emptylist", "0", "-1"}, true},
+	}
+	checkEmptyReplies(t, conn, cases)
+
+	// 元素全部弹出后
+	if _, err := conn.Do("RPUSH", "emptylist", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := redis.Values(conn.Do("LMPOP", "1", "emptylist", "RIGHT", "COUNT", "10")); err != nil {
+		t.Fatal(err)
+	} else if len(reply) != 2 || len(reply[1].([]interface{})) != 2 {
+		t.Error("bad reply", reply)
+	}
+	checkEmptyReplies(t, conn, cases)
+
+	if n, err := redis.Int(conn.Do("LLEN", "emptylist")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Error("bad len", n)
+	}
+	if n, err := redis.Int(conn.Do("LLEN", "emptylist2")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Error("bad len", n)
+	}
+}
+
+func TestEmptySortedSet(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "emptyzset"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []emptyCase{
+		{[]interface{}{"ZSCORE", "emptyzset", "a"}, false},
+		{[]interface{}{"ZRANK", "emptyzset", "a"}, false},
+		{[]interface{}{"ZREVRANK", "emptyzset", "a"}, false},
+		{[]interface{}{"ZRANDMEMBER", "emptyzset"}, false},
+		{[]interface{}{"BZPOPMIN", "emptyzset", "0.1"}, false},
+		{[]interface{}{"ZPOPMIN", "emptyzset"}, true},
+		{[]interface{}{"ZPOPMAX", "emptyzset", "3"}, true},
+		{[]interface{}{"ZRANDMEMBER", "emptyzset", "3"}, true},
+		{[]interface{}{"ZRANGE", "emptyzset", "0", "-1"}, true},
+		{[]interface{}{"ZREVRANGE", "emptyzset", "0", "-1", "WITHSCORES"}, true},
+		{[]interface{}{"ZRANGEBYSCORE", "emptyzset", "-inf", "+inf"}, true},
+	}
+	checkEmptyReplies(t, conn, cases)
+
+	// 元素全部弹出后
+	if _, err := conn.Do("ZADD", "emptyzset", "1", "a", "2", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := redis.Strings(conn.Do("ZPOPMIN", "emptyzset", "10")); err != nil {
+		t.Fatal(err)
+	} else if len(reply) != 4 {
+		t.Error("bad reply", reply)
+	}
+	checkEmptyReplies(t, conn, cases)
+
+	if n, err := redis.Int(conn.Do("ZCARD", "emptyzset")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Error("bad card", n)
+	}
+}
@@ -1,7 +1,9 @@
 package test
 
 import (
-	// "fmt"
+	"fmt"
+	"github.com/latermoon/redigo/redis"
+	"strings"
 	"testing"
 )
 
@@ -45,11 +47,14 @@ func TestHash(t *testing.T) {
 		}
 	}
 
-	// if reply, err := conn.Do("HINCRBY", "user", "age", "2"); err != nil {
-	// 	t.Fatal(err)
-	// } else if reply.(int64) != 14 {
-	// 	t.Error("bad reply")
-	// }
+	if reply, err := conn.Do("HINCRBY", "user", "age", "2"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 14 {
+		t.Error("bad reply")
+	}
+	if _, err := conn.Do("HINCRBY", "user", "age", "-2"); err != nil {
+		t.Fatal(err)
+	}
 
 	if reply, err := conn.Do("HLEN", "user"); err != nil {
 		t.Fatal(err)
@@ -63,4 +68,149 @@ func TestHash(t *testing.T) {
 		t.Error("bad reply")
 	}
 
+	// 多个field，返回新增数量
+	if reply, err := conn.Do("HSET", "user", "name", "latermoon", "city", "gz", "lang", "go"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 2 {
+		t.Error("bad reply", reply)
+	}
+	if _, err := conn.Do("HSET", "user", "name"); err == nil {
+		t.Error("error expected")
+	}
+
+	if reply, err := redis.Strings(conn.Do("HKEYS", "user")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "age,city,lang,name" {
+		t.Error("bad reply", reply)
+	}
+	if reply, err := redis.Strings(conn.Do("HVALS", "user")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "12,gz,go,latermoon" {
+		t.Error("bad reply", reply)
+	}
+}
+
+func TestHScan(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "hscan"); err != nil {
+		t.Fatal(err)
+	}
+	total := 1000
+	for i := 0; i < total; i++ {
+		if _, err := conn.Do("HSET", "hscan", fmt.Sprintf("field:%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每个field只返回一次，value正确
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("HSCAN", "hscan", cursor, "COUNT", 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		elems, _ := redis.Strings(reply[1], nil)
+		for i := 0; i < len(elems); i += 2 {
+			if seen[elems[i]] {
+				t.Error("duplicate", elems[i])
+			}
+			seen[elems[i]] = true
+			if elems[i] != "field:"+elems[i+1] {
+				t.Error("bad value", elems[i], elems[i+1])
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != total {
+		t.Error("bad count", len(seen))
+	}
+
+	// MATCH
+	matched := 0
+	cursor = "0"
+	for {
+		reply, err := redis.Values(conn.Do("HSCAN", "hscan", cursor, "MATCH", "field:9?", "COUNT", 300))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		elems, _ := redis.Strings(reply[1], nil)
+		matched += len(elems) / 2
+		if cursor == "0" {
+			break
+		}
+	}
+	if matched != 10 {
+		t.Error("bad match", matched)
+	}
+}
+
+func TestHScanMutation(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testScanMutation(t, conn, "HSCAN", "hscan_mutation", 2, func(field string) error {
+		_, err := conn.Do("HSET", "hscan_mutation", field, "v")
+		return err
+	}, func(field string) error {
+		_, err := conn.Do("HDEL", "hscan_mutation", field)
+		return err
+	})
+}
+
+func TestHIncrBy(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "hincr"); err != nil {
+		t.Fatal(err)
+	}
+	// 不存在的field从0开始
+	if n, err := redis.Int64(conn.Do("HINCRBY", "hincr", "count", "5")); err != nil || n != 5 {
+		t.Error("bad reply", n, err)
+	}
+	if n, err := redis.Int64(conn.Do("HINCRBY", "hincr", "count", "-7")); err != nil || n != -2 {
+		t.Error("bad reply", n, err)
+	}
+	if _, err := conn.Do("HSET", "hincr", "name", "latermoon", "max", "9223372036854775807"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("HINCRBY", "hincr", "name", "1"); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Error("not an integer error expected", err)
+	}
+	if _, err := conn.Do("HINCRBY", "hincr", "max", "1"); err == nil || !strings.Contains(err.Error(), "overflow") {
+		t.Error("overflow error expected", err)
+	}
+
+	if f, err := redis.String(conn.Do("HINCRBYFLOAT", "hincr", "price", "10.5")); err != nil || f != "10.5" {
+		t.Error("bad reply", f, err)
+	}
+	if f, err := redis.String(conn.Do("HINCRBYFLOAT", "hincr", "price", "0.1")); err != nil || f != "10.6" {
+		t.Error("bad reply", f, err)
+	}
+	// 整数值也可以按浮点数增加
+	if f, err := redis.String(conn.Do("HINCRBYFLOAT", "hincr", "count", "2.5")); err != nil || f != "0.5" {
+		t.Error("bad reply", f, err)
+	}
+	if _, err := conn.Do("HINCRBYFLOAT", "hincr", "name", "1"); err == nil || !strings.Contains(err.Error(), "not a float") {
+		t.Error("not a float error expected", err)
+	}
+	if v, err := redis.String(conn.Do("HGET", "hincr", "price")); err != nil || v != "10.6" {
+		t.Error("bad value", v, err)
+	}
 }
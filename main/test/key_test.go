@@ -1,7 +1,15 @@
 package test
 
 import (
+	"GoRedis/libs/keydigest"
+	"fmt"
+	"github.com/latermoon/redigo/redis"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestKey(t *testing.T) {
@@ -12,3 +20,1177 @@ func TestKey(t *testing.T) {
 	defer conn.Close()
 
 }
+
+// 每种类型的key在创建后可以通过TYPE和KEYSEARCH找到，
+// 删除最后一个元素或DEL之后，类型登记和元素数据都不能残留
+func TestKeyLifecycle(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cases := []struct {
+		typ     string
+		create  []interface{}
+		destroy []interface{}
+	}{
+		{"string", []interface{}{"SET", "lifecycle", "v"}, []interface{}{"DEL", "lifecycle"}},
+		{"hash", []interface{}{"HSET", "lifecycle", "f", "v"}, []interface{}{"HDEL", "lifecycle", "f"}},
+		{"hash", []interface{}{"HSET", "lifecycle", "f", "v"}, []interface{}{"DEL", "lifecycle"}},
+		{"set", []interface{}{"SADD", "lifecycle", "m"}, []interface{}{"SREM", "lifecycle", "m"}},
+		{"set", []interface{}{"SADD", "lifecycle", "m"}, []interface{}{"DEL", "lifecycle"}},
+		{"list", []interface{}{"RPUSH", "lifecycle", "e"}, []interface{}{"LPOP", "lifecycle"}},
+		{"list", []interface{}{"RPUSH", "lifecycle", "e"}, []interface{}{"DEL", "lifecycle"}},
+		{"zset", []interface{}{"ZADD", "lifecycle", "1", "m"}, []interface{}{"ZREM", "lifecycle", "m"}},
+		{"zset", []interface{}{"ZADD", "lifecycle", "1", "m"}, []interface{}{"ZPOPMIN", "lifecycle"}},
+		{"zset", []interface{}{"ZADD", "lifecycle", "1", "m"}, []interface{}{"DEL", "lifecycle"}},
+		{"doc", []interface{}{"DOC_SET", "lifecycle", `{"a":1}`}, []interface{}{"DEL", "lifecycle"}},
+	}
+	if _, err := conn.Do("DEL", "lifecycle"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if _, err := conn.Do(c.create[0].(string), c.create[1:]...); err != nil {
+			t.Fatal(c.create, err)
+		}
+		if typ, _ := redis.String(conn.Do("TYPE", "lifecycle")); typ != c.typ {
+			t.Error(c.create, "bad type", typ)
+		}
+		if keys, _ := redis.Strings(conn.Do("KEYSEARCH", "lifecycle")); len(keys) != 1 {
+			t.Error(c.create, "bad keysearch", keys)
+		}
+
+		if _, err := conn.Do(c.destroy[0].(string), c.destroy[1:]...); err != nil {
+			t.Fatal(c.destroy, err)
+		}
+		if typ, _ := redis.String(conn.Do("TYPE", "lifecycle")); typ != "none" {
+			t.Error(c.destroy, "type left", typ)
+		}
+		if keys, _ := redis.Strings(conn.Do("KEYSEARCH", "lifecycle")); len(keys) != 0 {
+			t.Error(c.destroy, "key left", keys)
+		}
+	}
+}
+
+// 导出export:前缀，再以export_copy:前缀导入
+func TestExportImport(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "export:name", "export:queue", "export_copy:name", "export_copy:queue"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("SET", "export:name", "latermoon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "export:queue", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := conn.Do("EXPORT", "export:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Base(string(reply.([]byte)))
+
+	if reply, err := conn.Do("IMPORT", filename, "export:", "export_copy:"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 2 {
+		t.Error("bad reply", reply)
+	}
+
+	if reply, err := conn.Do("GET", "export_copy:name"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "latermoon" {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("LLEN", "export_copy:queue"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 2 {
+		t.Error("bad reply")
+	}
+}
+
+// PAUSE WRITE期间写指令等待，读指令不受影响
+func TestClientPause(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	other, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	if _, err := conn.Do("CLIENT", "PAUSE", "300", "WRITE"); err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	if _, err := other.Do("GET", "pause"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) > 100*time.Millisecond {
+		t.Error("read should not be paused")
+	}
+	if _, err := other.Do("SET", "pause", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) < 200*time.Millisecond {
+		t.Error("write should be paused")
+	}
+
+	// UNPAUSE立即解除
+	if _, err := conn.Do("CLIENT", "PAUSE", "10000", "ALL"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Do("CLIENT", "UNPAUSE")
+	}()
+	begin = time.Now()
+	if _, err := other.Do("GET", "pause"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed < 50*time.Millisecond || elapsed > 2*time.Second {
+		t.Error("bad unpause", elapsed)
+	}
+
+	// 到期的PAUSE ALL不影响之后的PAUSE WRITE
+	if _, err := conn.Do("CLIENT", "PAUSE", "50", "ALL"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := conn.Do("CLIENT", "PAUSE", "300", "WRITE"); err != nil {
+		t.Fatal(err)
+	}
+	begin = time.Now()
+	if _, err := other.Do("GET", "pause"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) > 100*time.Millisecond {
+		t.Error("read should not be paused after the old pause expired")
+	}
+	conn.Do("CLIENT", "UNPAUSE")
+}
+
+func TestExpire(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "expire", "expire_missing")
+	if n, _ := redis.Int(conn.Do("EXPIRE", "expire_missing", "10")); n != 0 {
+		t.Error("expire missing key", n)
+	}
+	if n, _ := redis.Int(conn.Do("TTL", "expire_missing")); n != -2 {
+		t.Error("ttl missing key", n)
+	}
+
+	conn.Do("SET", "expire", "v")
+	if n, _ := redis.Int(conn.Do("TTL", "expire")); n != -1 {
+		t.Error("ttl without expire", n)
+	}
+	if n, _ := redis.Int(conn.Do("EXPIRE", "expire", "100")); n != 1 {
+		t.Error("bad expire", n)
+	}
+	if n, _ := redis.Int(conn.Do("TTL", "expire")); n < 99 || n > 100 {
+		t.Error("bad ttl", n)
+	}
+	if n, _ := redis.Int(conn.Do("PERSIST", "expire")); n != 1 {
+		t.Error("bad persist", n)
+	}
+	if n, _ := redis.Int(conn.Do("TTL", "expire")); n != -1 {
+		t.Error("ttl after persist", n)
+	}
+
+	// SET清除过期时间
+	conn.Do("EXPIRE", "expire", "100")
+	conn.Do("SET", "expire", "v2")
+	if n, _ := redis.Int(conn.Do("TTL", "expire")); n != -1 {
+		t.Error("ttl after set", n)
+	}
+
+	// 到期后读取不到
+	conn.Do("PEXPIRE", "expire", "100")
+	time.Sleep(200 * time.Millisecond)
+	if v, _ := conn.Do("GET", "expire"); v != nil {
+		t.Error("expired key still readable", v)
+	}
+	if n, _ := redis.Int(conn.Do("PTTL", "expire")); n != -2 {
+		t.Error("pttl after expired", n)
+	}
+
+	// 过去的时间直接删除
+	conn.Do("RPUSH", "expire", "e")
+	if n, _ := redis.Int(conn.Do("EXPIREAT", "expire", "1")); n != 1 {
+		t.Error("bad expireat", n)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "expire")); typ != "none" {
+		t.Error("expireat in the past", typ)
+	}
+}
+
+func TestExpiryStats(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats := func() map[string]int {
+		values, err := redis.Strings(conn.Do("EXPIRYSTATS", "60", "2"))
+		if err != nil || len(values) != 8 {
+			t.Fatal("bad expirystats", values, err)
+		}
+		m := make(map[string]int)
+		for i := 0; i < len(values); i += 2 {
+			m[values[i]], _ = strconv.Atoi(values[i+1])
+		}
+		return m
+	}
+	keys := []interface{}{"expirystats_a", "expirystats_b", "expirystats_c"}
+	conn.Do("DEL", keys...)
+	defer conn.Do("DEL", keys...)
+	before := stats()
+	for _, key := range keys {
+		conn.Do("SET", key, "v")
+	}
+	conn.Do("EXPIRE", "expirystats_a", "30")
+	conn.Do("EXPIRE", "expirystats_b", "90")
+	conn.Do("EXPIRE", "expirystats_c", "1000")
+	after := stats()
+	for bucket, n := range map[string]int{"expired": 0, "60": 1, "120": 1, "later": 1} {
+		if after[bucket]-before[bucket] != n {
+			t.Error("bad bucket", bucket, before, after)
+		}
+	}
+	if _, err := conn.Do("EXPIRYSTATS", "0"); err == nil {
+		t.Error("zero window should fail")
+	}
+}
+
+// 写指令在其它类型的key上返回WRONGTYPE，SET覆盖任意类型
+func TestWrongType(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "wrongtype", "wrongtype_list")
+	conn.Do("RPUSH", "wrongtype", "e")
+	for _, args := range [][]interface{}{
+		{"HSET", "wrongtype", "f", "v"},
+		{"SADD", "wrongtype", "m"},
+		{"ZADD", "wrongtype", "1", "m"},
+		{"INCR", "wrongtype"},
+		{"APPEND", "wrongtype", "v"},
+		{"SETBIT", "wrongtype", "1", "1"},
+		{"SINTERSTORE", "wrongtype_set", "wrongtype"},
+	} {
+		if _, err := conn.Do(args[0].(string), args[1:]...); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Error(args, "should be wrongtype", err)
+		}
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "wrongtype")); typ != "list" {
+		t.Error("bad type", typ)
+	}
+
+	conn.Do("SET", "wrongtype_list", "v")
+	if _, err := conn.Do("RPOPLPUSH", "wrongtype", "wrongtype_list"); err == nil {
+		t.Error("rpoplpush to string")
+	}
+
+	// SET覆盖list
+	if _, err := conn.Do("SET", "wrongtype", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "wrongtype")); typ != "string" {
+		t.Error("set not overwritten", typ)
+	}
+	if keys, _ := redis.Strings(conn.Do("KEYSEARCH", "wrongtype")); len(keys) != 2 {
+		t.Error("type left", keys)
+	}
+	conn.Do("DEL", "wrongtype", "wrongtype_list")
+}
+
+func TestKeysPattern(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	keys := []string{"kp:user:1", "kp:user:2", "kp:user:10", "kp:item:1", "kp:*"}
+	for _, key := range keys {
+		conn.Do("SET", key, "v")
+	}
+	cases := map[string]int{
+		"kp:user:*":    3,
+		"kp:user:?":    2,
+		"kp:user:[12]": 2,
+		"kp:[^u]*":     2,
+		"kp:\\*":       1,
+		"kp:*:1":       2,
+		"kp:none*":     0,
+	}
+	for pattern, count := range cases {
+		found, err := redis.Strings(conn.Do("KEYS", pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != count {
+			t.Error(pattern, found)
+		}
+	}
+	for _, key := range keys {
+		conn.Do("DEL", key)
+	}
+}
+
+// DEBUG DIGEST-VALUE与按HGETALL在客户端计算的摘要一致，和写入顺序无关
+func TestDigestValue(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "digest_a", "digest_b", "digest_missing")
+	conn.Do("HSET", "digest_a", "f1", "v1")
+	conn.Do("HSET", "digest_a", "f2", "v2")
+	conn.Do("HSET", "digest_b", "f2", "v2")
+	conn.Do("HSET", "digest_b", "f1", "v1")
+	sums, err := redis.Strings(conn.Do("DEBUG", "DIGEST-VALUE", "digest_a", "digest_b", "digest_missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 3 || sums[0] != sums[1] || sums[2] != keydigest.Missing {
+		t.Fatal("bad digests", sums)
+	}
+	d := keydigest.New("hash")
+	values, _ := redis.Values(conn.Do("HGETALL", "digest_a"))
+	for _, v := range values {
+		d.Add(v.([]byte))
+	}
+	if d.Sum() != sums[0] {
+		t.Error("digest differs from client side", d.Sum(), sums[0])
+	}
+	conn.Do("HSET", "digest_b", "f1", "v3")
+	if sums, _ = redis.Strings(conn.Do("DEBUG", "DIGEST-VALUE", "digest_a", "digest_b")); sums[0] == sums[1] {
+		t.Error("digest not changed")
+	}
+	conn.Do("DEL", "digest_a", "digest_b")
+}
+
+// 扫描期间增删key，开始前存在且没有被删除的key都要返回
+func TestScan(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	want := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("scan:%02d", i)
+		conn.Do("SET", key, "v")
+		if i%5 != 0 {
+			want[key] = true
+		}
+	}
+	conn.Do("SET", "scanx", "v")
+
+	seen := make(map[string]int)
+	cursor := "0"
+	for round := 0; ; round++ {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "scan:*", "COUNT", "7"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if round == 1 {
+			for i := 0; i < 50; i += 5 {
+				conn.Do("DEL", fmt.Sprintf("scan:%02d", i))
+			}
+			conn.Do("SET", "scan:00a", "v")
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	for key := range want {
+		if seen[key] != 1 {
+			t.Error("missed or repeated", key, seen[key])
+		}
+	}
+	if seen["scanx"] != 0 {
+		t.Error("match not applied")
+	}
+	if _, err := conn.Do("SCAN", "abc"); err == nil {
+		t.Error("bad cursor accepted")
+	}
+	for i := 0; i < 50; i++ {
+		conn.Do("DEL", fmt.Sprintf("scan:%02d", i))
+	}
+	conn.Do("DEL", "scanx", "scan:00a")
+}
+
+func TestDBSize(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "dbsize_a", "dbsize_b")
+	time.Sleep(1100 * time.Millisecond) // 等待缓存过期
+	before, err := redis.Int(conn.Do("DBSIZE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("SET", "dbsize_a", "v")
+	conn.Do("HSET", "dbsize_b", "f", "v")
+	time.Sleep(1100 * time.Millisecond)
+	if after, _ := redis.Int(conn.Do("DBSIZE")); after != before+2 {
+		t.Error("bad dbsize", before, after)
+	}
+	conn.Do("DEL", "dbsize_a", "dbsize_b")
+}
+
+func TestRandomKey(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("SET", "randomkey_a", "v")
+	for i := 0; i < 10; i++ {
+		key, err := redis.String(conn.Do("RANDOMKEY"))
+		if err != nil || len(key) == 0 {
+			t.Fatal("bad randomkey", key, err)
+		}
+		if typ, _ := redis.String(conn.Do("TYPE", key)); typ == "none" {
+			t.Error("randomkey not exists", key)
+		}
+	}
+	conn.Do("DEL", "randomkey_a")
+}
+
+func TestRename(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "rename_a", "rename_b", "rename_c")
+	conn.Do("ZADD", "rename_a", 1, "m1", 2, "m2")
+	conn.Do("EXPIRE", "rename_a", 100)
+	conn.Do("SET", "rename_b", "v")
+	if _, err = conn.Do("RENAME", "rename_a", "rename_b"); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "rename_a")); typ != "none" {
+		t.Error("old key remains", typ)
+	}
+	if card, _ := redis.Int(conn.Do("ZCARD", "rename_b")); card != 2 {
+		t.Error("bad zcard", card)
+	}
+	if score, _ := redis.String(conn.Do("ZSCORE", "rename_b", "m2")); score != "2" {
+		t.Error("bad score", score)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "rename_b")); ttl <= 0 {
+		t.Error("ttl not moved", ttl)
+	}
+	if _, err = conn.Do("RENAME", "rename_a", "rename_c"); err == nil {
+		t.Error("rename missing key")
+	}
+
+	conn.Do("SET", "rename_c", "v")
+	if n, _ := redis.Int(conn.Do("RENAMENX", "rename_b", "rename_c")); n != 0 {
+		t.Error("renamenx overwrote", n)
+	}
+	conn.Do("DEL", "rename_c")
+	if n, _ := redis.Int(conn.Do("RENAMENX", "rename_b", "rename_c")); n != 1 {
+		t.Error("bad renamenx", n)
+	}
+	if card, _ := redis.Int(conn.Do("ZCARD", "rename_c")); card != 2 {
+		t.Error("bad zcard", card)
+	}
+	conn.Do("DEL", "rename_a", "rename_b", "rename_c")
+}
+
+func TestCopy(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "copy_a", "copy_b")
+	conn.Do("HMSET", "copy_a", "f1", "v1", "f2", "v2")
+	conn.Do("EXPIRE", "copy_a", 100)
+	if n, _ := redis.Int(conn.Do("COPY", "copy_a", "copy_b")); n != 1 {
+		t.Fatal("bad copy", n)
+	}
+	conn.Do("HSET", "copy_a", "f3", "v3")
+	if n, _ := redis.Int(conn.Do("HLEN", "copy_b")); n != 2 {
+		t.Error("bad copied hlen", n)
+	}
+	if v, _ := redis.String(conn.Do("HGET", "copy_b", "f2")); v != "v2" {
+		t.Error("bad copied value", v)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "copy_b")); ttl <= 0 {
+		t.Error("ttl not copied", ttl)
+	}
+	if n, _ := redis.Int(conn.Do("COPY", "copy_a", "copy_b")); n != 0 {
+		t.Error("copy overwrote", n)
+	}
+	if n, _ := redis.Int(conn.Do("COPY", "copy_a", "copy_b", "REPLACE")); n != 1 {
+		t.Error("bad copy replace", n)
+	}
+	if n, _ := redis.Int(conn.Do("HLEN", "copy_b")); n != 3 {
+		t.Error("bad hlen", n)
+	}
+	// REPLACE删除目标原有的元素，类型不同时同样替换
+	conn.Do("DEL", "copy_c", "copy_d")
+	conn.Do("HSET", "copy_c", "f9", "v9")
+	conn.Do("SET", "copy_d", "string")
+	for _, dst := range []string{"copy_c", "copy_d"} {
+		if n, _ := redis.Int(conn.Do("COPY", "copy_a", dst, "REPLACE")); n != 1 {
+			t.Error("bad copy replace", dst, n)
+		}
+		if n, _ := redis.Int(conn.Do("HLEN", dst)); n != 3 {
+			t.Error("bad replaced hlen", dst, n)
+		}
+	}
+	if v, err := conn.Do("HGET", "copy_c", "f9"); v != nil || err != nil {
+		t.Error("stale field after replace", v, err)
+	}
+	conn.Do("DEL", "copy_c", "copy_d")
+	if _, err = conn.Do("COPY", "copy_a", "copy_a"); err == nil {
+		t.Error("copy to itself")
+	}
+
+	conn.Do("SELECT", 1)
+	conn.Do("DEL", "copy_a")
+	conn.Do("SELECT", 0)
+	if n, _ := redis.Int(conn.Do("COPY", "copy_a", "copy_a", "DB", 1)); n != 1 {
+		t.Error("bad copy db", n)
+	}
+	conn.Do("SELECT", 1)
+	if n, _ := redis.Int(conn.Do("HLEN", "copy_a")); n != 3 {
+		t.Error("bad hlen in db1", n)
+	}
+	conn.Do("DEL", "copy_a")
+	conn.Do("SELECT", 0)
+	conn.Do("DEL", "copy_a", "copy_b")
+}
+
+func TestDBInfo(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("DBINFO"))
+	if err != nil || len(values)%2 != 0 {
+		t.Fatal("bad dbinfo", values, err)
+	}
+	info := make(map[string]interface{})
+	for i := 0; i < len(values); i += 2 {
+		name, _ := redis.String(values[i], nil)
+		info[name] = values[i+1]
+	}
+	version, _ := redis.Int(info["layout_version"], nil)
+	supported, _ := redis.Int(info["supported_version"], nil)
+	if version == 0 || version != supported {
+		t.Error("bad layout version", version, supported)
+	}
+	if pending, _ := redis.Values(info["pending_migrations"], nil); len(pending) != 0 {
+		t.Error("pending migrations after startup", pending)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "unlink_str", "unlink_hash", "unlink_zset")
+	conn.Do("SET", "unlink_str", "v")
+	conn.Do("HSET", "unlink_hash", "f", "v")
+	for i := 0; i < 100; i++ {
+		conn.Do("ZADD", "unlink_zset", i, fmt.Sprint("m", i))
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "unlink_str", "unlink_hash", "unlink_str", "unlink_none")); n != 3 {
+		t.Error("bad exists", n)
+	}
+	if n, _ := redis.Int(conn.Do("UNLINK", "unlink_str", "unlink_zset", "unlink_none")); n != 2 {
+		t.Error("bad unlink", n)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "unlink_str", "unlink_zset")); n != 0 {
+		t.Error("unlinked key exists", n)
+	}
+	// 后台删除完成之前重新创建，不能出现旧的元素
+	conn.Do("ZADD", "unlink_zset", 1, "new")
+	if card, _ := redis.Int(conn.Do("ZCARD", "unlink_zset")); card != 1 {
+		t.Error("old members remain", card)
+	}
+	if members, _ := redis.Strings(conn.Do("ZRANGE", "unlink_zset", 0, -1)); len(members) != 1 {
+		t.Error("old members remain", members)
+	}
+	if n, _ := redis.Int(conn.Do("DEL", "unlink_hash", "unlink_zset", "unlink_hash")); n != 2 {
+		t.Error("bad del", n)
+	}
+}
+
+// 会清空测试实例的全部数据
+func TestFlushAll(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, mode := range []string{"SYNC", "ASYNC"} {
+		conn.Do("SET", "flush_str", "v")
+		conn.Do("HSET", "flush_hash", "f", "v")
+		conn.Do("ZADD", "flush_zset", 1, "old")
+		conn.Do("EXPIRE", "flush_str", 100)
+		if ok, err := redis.String(conn.Do("FLUSHALL", mode)); ok != "OK" {
+			t.Fatal("bad flushall", mode, ok, err)
+		}
+		if n, _ := redis.Int(conn.Do("EXISTS", "flush_str", "flush_hash", "flush_zset")); n != 0 {
+			t.Error("keys remain", mode, n)
+		}
+		conn.Do("ZADD", "flush_zset", 2, "new")
+		if members, _ := redis.Strings(conn.Do("ZRANGE", "flush_zset", 0, -1)); len(members) != 1 {
+			t.Error("old members remain", mode, members)
+		}
+		if ttl, _ := redis.Int(conn.Do("TTL", "flush_zset")); ttl != -1 {
+			t.Error("bad ttl", mode, ttl)
+		}
+	}
+	if _, err = conn.Do("FLUSHDB", "LATER"); err == nil {
+		t.Error("syntax error not returned")
+	}
+	conn.Do("FLUSHDB")
+}
+
+func TestSelect(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("SELECT", 1)
+	conn.Do("DEL", "select_key", "select_hash")
+	conn.Do("SELECT", 0)
+	conn.Do("DEL", "select_key", "select_hash")
+
+	conn.Do("SET", "select_key", "db0")
+	conn.Do("HSET", "select_hash", "f", "v")
+	conn.Do("EXPIRE", "select_hash", 100)
+	if ok, _ := redis.String(conn.Do("SELECT", 1)); ok != "OK" {
+		t.Fatal("bad select", ok)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "select_key")); n != 0 {
+		t.Error("key visible in db1")
+	}
+	conn.Do("SET", "select_key", "db1")
+
+	// 目标db已有同名key
+	conn.Do("SELECT", 0)
+	if n, _ := redis.Int(conn.Do("MOVE", "select_key", 1)); n != 0 {
+		t.Error("move overwrite", n)
+	}
+	if n, _ := redis.Int(conn.Do("MOVE", "select_hash", 1)); n != 1 {
+		t.Error("bad move", n)
+	}
+	if _, err = conn.Do("MOVE", "select_key", 0); err == nil {
+		t.Error("same db not rejected")
+	}
+
+	conn.Do("SELECT", 1)
+	if v, _ := redis.String(conn.Do("HGET", "select_hash", "f")); v != "v" {
+		t.Error("bad moved hash", v)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "select_hash")); ttl <= 0 {
+		t.Error("ttl lost", ttl)
+	}
+
+	// SWAPDB之后db0看到原来db1的数据
+	if ok, _ := redis.String(conn.Do("SWAPDB", 0, 1)); ok != "OK" {
+		t.Fatal("bad swapdb", ok)
+	}
+	if v, _ := redis.String(conn.Do("GET", "select_key")); v != "db0" {
+		t.Error("bad swapped value", v)
+	}
+	conn.Do("SWAPDB", 0, 1)
+	if _, err = conn.Do("SELECT", 100000); err == nil {
+		t.Error("out of range not rejected")
+	}
+
+	conn.Do("SELECT", 1)
+	conn.Do("DEL", "select_key", "select_hash")
+	conn.Do("SELECT", 0)
+	conn.Do("DEL", "select_key")
+}
+
+func TestDumpRestore(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	keys := []interface{}{"dump_str", "dump_hash", "dump_list", "dump_set", "dump_zset", "dump_copy"}
+	conn.Do("DEL", keys...)
+	conn.Do("SET", "dump_str", "100")
+	conn.Do("HSET", "dump_hash", "f", "v")
+	conn.Do("RPUSH", "dump_list", "a", "b", "c")
+	conn.Do("SADD", "dump_set", "a", "b")
+	conn.Do("ZADD", "dump_zset", 1.5, "a", 2, "b")
+
+	for _, key := range keys[:5] {
+		payload, err := redis.Bytes(conn.Do("DUMP", key))
+		if err != nil {
+			t.Fatal("bad dump", key, err)
+		}
+		conn.Do("DEL", "dump_copy")
+		if ok, err := redis.String(conn.Do("RESTORE", "dump_copy", 0, payload)); ok != "OK" {
+			t.Fatal("bad restore", key, ok, err)
+		}
+		src, _ := redis.String(conn.Do("DEBUG", "DIGEST-VALUE", key))
+		dst, _ := redis.String(conn.Do("DEBUG", "DIGEST-VALUE", "dump_copy"))
+		if src != dst {
+			t.Error("restored value differ", key)
+		}
+	}
+
+	payload, _ := redis.Bytes(conn.Do("DUMP", "dump_list"))
+	if _, err = conn.Do("RESTORE", "dump_copy", 0, payload); err == nil {
+		t.Error("busykey not returned")
+	}
+	if ok, _ := redis.String(conn.Do("RESTORE", "dump_copy", 10000, payload, "REPLACE")); ok != "OK" {
+		t.Error("bad restore replace", ok)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", "dump_copy")); n != 3 {
+		t.Error("bad replaced list", n)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "dump_copy")); ttl <= 0 || ttl > 10 {
+		t.Error("bad ttl", ttl)
+	}
+	payload[0]++
+	if _, err = conn.Do("RESTORE", "dump_copy", 0, payload, "REPLACE"); err == nil {
+		t.Error("bad checksum not rejected")
+	}
+	if v, _ := conn.Do("DUMP", "dump_none"); v != nil {
+		t.Error("dump missing key", v)
+	}
+
+	// redis 5.0生成的DUMP，值为10
+	redisPayload := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n")
+	conn.Do("RESTORE", "dump_copy", 0, redisPayload, "REPLACE")
+	if v, _ := redis.String(conn.Do("GET", "dump_copy")); v != "10" {
+		t.Error("bad redis payload", v)
+	}
+
+	conn.Do("DEL", keys...)
+}
+
+func TestMigrate(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h, p, _ := net.SplitHostPort(host)
+
+	// 迁移到同一个实例的db1
+	conn.Do("SELECT", 1)
+	conn.Do("DEL", "migrate_a", "migrate_b")
+	conn.Do("SELECT", 0)
+	conn.Do("RPUSH", "migrate_a", "x", "y")
+	conn.Do("SET", "migrate_b", "v")
+
+	if ok, err := redis.String(conn.Do("MIGRATE", h, p, "migrate_a", 1, 1000, "COPY")); ok != "OK" {
+		t.Fatal("bad migrate copy", ok, err)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "migrate_a")); n != 1 {
+		t.Error("copy deleted source")
+	}
+	if _, err = conn.Do("MIGRATE", h, p, "migrate_a", 1, 1000); err == nil {
+		t.Error("busykey not returned")
+	}
+	if ok, _ := redis.String(conn.Do("MIGRATE", h, p, "", 1, 1000, "REPLACE", "KEYS", "migrate_a", "migrate_b")); ok != "OK" {
+		t.Error("bad migrate keys", ok)
+	}
+	if n, _ := redis.Int(conn.Do("EXISTS", "migrate_a", "migrate_b")); n != 0 {
+		t.Error("source not deleted", n)
+	}
+	if ok, _ := redis.String(conn.Do("MIGRATE", h, p, "migrate_a", 1, 1000)); ok != "NOKEY" {
+		t.Error("bad nokey", ok)
+	}
+
+	conn.Do("SELECT", 1)
+	if n, _ := redis.Int(conn.Do("LLEN", "migrate_a")); n != 2 {
+		t.Error("bad migrated list", n)
+	}
+	conn.Do("DEL", "migrate_a", "migrate_b")
+	conn.Do("SELECT", 0)
+}
+
+func TestSave(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("SET", "save_str", "v")
+	conn.Do("ZADD", "save_zset", 1, "a")
+	begin := time.Now().Unix()
+	if ok, err := redis.String(conn.Do("SAVE")); ok != "OK" {
+		t.Fatal("bad save", ok, err)
+	}
+	if at, _ := redis.Int64(conn.Do("LASTSAVE")); at < begin {
+		t.Error("bad lastsave", at, begin)
+	}
+	info, _ := redis.String(conn.Do("INFO"))
+	if !strings.Contains(info, "rdb_last_bgsave_status:ok") {
+		t.Error("bad save status")
+	}
+	if s, _ := redis.String(conn.Do("BGSAVE")); s != "Background saving started" {
+		t.Error("bad bgsave", s)
+	}
+	// 等待后台保存结束，否则后面的SAVE会返回正在保存
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		info, _ := redis.String(conn.Do("INFO"))
+		if strings.Contains(info, "rdb_bgsave_in_progress:0") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bgsave not finished")
+		}
+	}
+	conn.Do("DEL", "save_str", "save_zset")
+}
+
+func TestLoadRDB(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "loadrdb_str", "loadrdb_list", "loadrdb_hash")
+	conn.Do("SET", "loadrdb_str", "v")
+	conn.Do("PEXPIRE", "loadrdb_str", 100000)
+	conn.Do("RPUSH", "loadrdb_list", "a", "b", "c")
+	conn.Do("HSET", "loadrdb_hash", "f", "v")
+	if ok, err := redis.String(conn.Do("SAVE")); ok != "OK" {
+		t.Fatal("bad save", ok, err)
+	}
+	conn.Do("DEL", "loadrdb_str", "loadrdb_list")
+	conn.Do("HSET", "loadrdb_hash", "f2", "v2")
+	if n, err := redis.Int(conn.Do("DEBUG", "LOADRDB")); err != nil || n == 0 {
+		t.Fatal("bad loadrdb", n, err)
+	}
+	if s, _ := redis.String(conn.Do("GET", "loadrdb_str")); s != "v" {
+		t.Error("bad string", s)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "loadrdb_str")); ttl <= 0 {
+		t.Error("bad ttl", ttl)
+	}
+	if values, _ := redis.Strings(conn.Do("LRANGE", "loadrdb_list", 0, -1)); strings.Join(values, ",") != "a,b,c" {
+		t.Error("bad list", values)
+	}
+	// 覆盖已有的key
+	if n, _ := redis.Int(conn.Do("HLEN", "loadrdb_hash")); n != 1 {
+		t.Error("bad hash", n)
+	}
+	if _, err := conn.Do("DEBUG", "LOADRDB", "/not/exist/dump.rdb"); err == nil {
+		t.Error("missing file should fail")
+	}
+	conn.Do("DEL", "loadrdb_str", "loadrdb_list", "loadrdb_hash")
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("CONFIG", "SET", "appendfsync", "always")
+	defer conn.Do("CONFIG", "SET", "appendfsync", "everysec")
+	if s, err := redis.String(conn.Do("BGREWRITEAOF")); s != "Background append only file rewriting started" {
+		t.Fatal("bad bgrewriteaof", s, err)
+	}
+	defer conn.Do("AOF", "NO")
+	// 等待快照写完
+	for i := 0; i < 100; i++ {
+		info, _ := redis.String(conn.Do("INFO"))
+		if strings.Contains(info, "aof_enabled:1") && strings.Contains(info, "aof_rewrite_in_progress:0") {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := conn.Do("AOF", "YES"); err == nil {
+		t.Error("aof yes should fail when enabled")
+	}
+	if s, _ := redis.String(conn.Do("BGREWRITEAOF")); s != "Background append only file rewriting started" {
+		t.Error("bad rewrite", s)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dir, err := redis.String(conn.Do("BACKUP"))
+	if err != nil && strings.Contains(err.Error(), "requires rocksdb 5.14") {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal("bad backup", err)
+	}
+	if _, err := conn.Do("BACKUP", dir); err == nil {
+		t.Error("backup to an existing dir should fail")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "compact_z")
+	for i := 0; i < 1000; i++ {
+		conn.Do("ZADD", "compact_z", i, i)
+	}
+	conn.Do("ZREMRANGEBYRANK", "compact_z", 0, 989)
+	if _, err = redis.Int(conn.Do("COMPACT", "compact_")); err != nil {
+		t.Fatal("bad compact", err)
+	}
+	if n, _ := redis.Int(conn.Do("ZCARD", "compact_z")); n != 10 {
+		t.Error("bad zcard after compact", n)
+	}
+	conn.Do("DEL", "compact_z")
+}
+
+func TestReplconf(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if ok, err := redis.String(conn.Do("REPLCONF", "listening-port", 1603, "capa", "eof")); err != nil || ok != "OK" {
+		t.Error("bad replconf", ok, err)
+	}
+	if _, err = conn.Do("REPLCONF", "unknown", "1"); err == nil {
+		t.Error("unknown replconf option")
+	}
+	info, _ := redis.String(conn.Do("INFO", "replication"))
+	if !strings.Contains(info, "master_repl_offset:") {
+		t.Error("no master_repl_offset", info)
+	}
+}
+
+func TestWait(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("SET", "wait_a", "1")
+	if n, err := redis.Int(conn.Do("WAIT", 0, 0)); err != nil || n < 0 {
+		t.Error("bad wait", n, err)
+	}
+	begin := time.Now()
+	if _, err = redis.Int(conn.Do("WAIT", 100, 200)); err != nil {
+		t.Error("bad wait", err)
+	}
+	if elapsed := time.Since(begin); elapsed < 150*time.Millisecond {
+		t.Error("wait returned before timeout", elapsed)
+	}
+	if _, err = conn.Do("WAIT", 1, -1); err == nil {
+		t.Error("negative timeout")
+	}
+	conn.Do("DEL", "wait_a")
+}
+
+func TestClientKill(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	other, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// 只断开这个测试创建的连接，不影响并行测试的其它连接
+	addrOf := func(c redis.Conn) string {
+		info, err := redis.String(c.Do("CLIENT", "INFO"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimPrefix(strings.Fields(info)[0], "addr=")
+	}
+	self, addr := addrOf(conn), addrOf(other)
+	if _, err = conn.Do("CLIENT", "KILL", "ADDR", "127.0.0.1:1", "TYPE", "unknown"); err == nil {
+		t.Error("unknown client type")
+	}
+	if n, err := redis.Int(conn.Do("CLIENT", "KILL", "ADDR", "127.0.0.1:1")); err != nil || n != 0 {
+		t.Error("bad kill missing addr", n, err)
+	}
+	// 不带SKIPME no时不关闭当前连接
+	if n, err := redis.Int(conn.Do("CLIENT", "KILL", "ADDR", self, "TYPE", "normal")); err != nil || n != 0 {
+		t.Error("bad kill skipme", n, err)
+	}
+	if n, err := redis.Int(conn.Do("CLIENT", "KILL", "ADDR", addr, "TYPE", "normal")); err != nil || n != 1 {
+		t.Error("bad kill normal", n, err)
+	}
+	if _, err = other.Do("PING"); err == nil {
+		t.Error("killed connection still alive")
+	}
+	if _, err = conn.Do("PING"); err != nil {
+		t.Error("skipme", err)
+	}
+	// 主库上执行只是没有可断开的主库
+	if _, err = redis.String(conn.Do("REPLICAOF", "NO", "ONE")); err != nil {
+		t.Error("bad replicaof no one", err)
+	}
+}
+
+func TestExportImportJSON(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "json:str", "json:bin", "json:zset", "json:hash")
+	conn.Do("SET", "json:str", "v")
+	conn.Do("PEXPIRE", "json:str", 100000)
+	conn.Do("SET", "json:bin", "\xff\x00")
+	conn.Do("ZADD", "json:zset", 1.5, "a", "+inf", "b")
+	conn.Do("HSET", "json:hash", "f", "v")
+	path, err := redis.String(conn.Do("EXPORT.JSON", "json:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("DEL", "json:str", "json:bin", "json:zset")
+	conn.Do("HSET", "json:hash", "f2", "v2")
+	if n, err := redis.Int(conn.Do("IMPORT.JSON", filepath.Base(path))); err != nil || n != 4 {
+		t.Fatal("bad import", n, err)
+	}
+	if s, _ := redis.String(conn.Do("GET", "json:bin")); s != "\xff\x00" {
+		t.Error("bad binary value", []byte(s))
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "json:str")); ttl <= 0 {
+		t.Error("bad ttl", ttl)
+	}
+	if score, _ := redis.String(conn.Do("ZSCORE", "json:zset", "b")); score != "inf" {
+		t.Error("bad score", score)
+	}
+	if n, _ := redis.Int(conn.Do("HLEN", "json:hash")); n != 1 {
+		t.Error("bad hash", n)
+	}
+	conn.Do("DEL", "json:str", "json:bin", "json:zset", "json:hash")
+}
+
+// 只通知订阅连接所在db的写入，执行出错的指令不通知
+func TestWatchPrefix(t *testing.T) {
+	watch, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 订阅之前的DEL即使还在指令队列里也不会推送
+	conn.Do("DEL", "wp:s", "wp:end")
+	if reply, err := redis.String(watch.Do("WATCHPREFIX", "wp:")); err != nil || reply != "OK" {
+		t.Fatal("bad reply", reply, err)
+	}
+	conn.Do("SELECT", "1")
+	conn.Do("SET", "wp:other", "1")
+	conn.Do("DEL", "wp:other")
+	conn.Do("SELECT", "0")
+	conn.Do("SET", "wp:s", "abc")
+	if _, err := conn.Do("INCR", "wp:s"); err == nil {
+		t.Error("INCR error expected")
+	}
+	conn.Do("SET", "wp:end", "1")
+	for _, expect := range []string{"SET wp:s", "SET wp:end"} {
+		if line, err := redis.String(watch.Receive()); err != nil || line != expect {
+			t.Error("bad notify", expect, line, err)
+		}
+	}
+	conn.Do("DEL", "wp:s", "wp:end")
+}
+
+// numkeys之后的参数(LEFT、WEIGHTS)在写指令的key检查之后保持不变
+func TestArgsAfterNumKeys(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "numkeys_l1", "numkeys_z1", "numkeys_out")
+	defer conn.Do("DEL", "numkeys_l1", "numkeys_z1", "numkeys_out")
+	conn.Do("RPUSH", "numkeys_l1", "a", "b")
+	reply, err := redis.Values(conn.Do("LMPOP", "1", "numkeys_l1", "LEFT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := redis.String(reply[0], nil); key != "numkeys_l1" {
+		t.Error("bad lmpop key", key)
+	}
+	if elems, _ := redis.Strings(reply[1], nil); len(elems) != 1 || elems[0] != "a" {
+		t.Error("bad lmpop", elems)
+	}
+
+	conn.Do("ZADD", "numkeys_z1", "1", "a")
+	if n, err := redis.Int(conn.Do("ZUNIONSTORE", "numkeys_out", "1", "numkeys_z1", "WEIGHTS", "3")); err != nil || n != 1 {
+		t.Fatal("bad zunionstore", n, err)
+	}
+	if score, _ := redis.String(conn.Do("ZSCORE", "numkeys_out", "a")); score != "3" {
+		t.Error("bad weighted score", score)
+	}
+}
@@ -1,7 +1,10 @@
 package test
 
 import (
+	"github.com/latermoon/redigo/redis"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestList(t *testing.T) {
@@ -79,3 +82,601 @@ func TestList(t *testing.T) {
 		t.Error("bad reply")
 	}
 }
+
+// LPUSH多个值时依次插入表头，与redis一致，LPUSH key a b c 的结果为 c b a
+func TestLPushMulti(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("LPUSH", "queue", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 3 {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("LPUSH", "queue", "d"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 4 {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("LRANGE", "queue", "0", "-1"); err != nil {
+		t.Fatal(err)
+	} else {
+		bulks := reply.([]interface{})
+		expect := []string{"d", "c", "b", "a"}
+		if len(bulks) != len(expect) {
+			t.Fatal("bad reply")
+		}
+		for i, s := range expect {
+			if string(bulks[i].([]byte)) != s {
+				t.Error("bad reply", i)
+			}
+		}
+	}
+
+	// LPUSH/RPOP 队列
+	if reply, err := conn.Do("RPOP", "queue"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "a" {
+		t.Error("bad reply")
+	}
+}
+
+// 空list或不存在的key，LPOP/RPOP返回nil
+func TestPopEmpty(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("RPOP", "queue"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("bad reply")
+	}
+
+	if _, err := conn.Do("RPUSH", "queue", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("RPOP", "queue"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "a" {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("RPOP", "queue"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("LPOP", "queue"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("bad reply")
+	}
+}
+
+func TestLRem(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "queue", "a", "x", "b", "x", "c", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkRange := func(expect ...string) {
+		reply, err := conn.Do("LRANGE", "queue", "0", "-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bulks := reply.([]interface{})
+		if len(bulks) != len(expect) {
+			t.Fatal("bad length", len(bulks), expect)
+		}
+		for i, s := range expect {
+			if string(bulks[i].([]byte)) != s {
+				t.Error("bad reply", i, s)
+			}
+		}
+	}
+
+	// 从表头删除1个
+	if reply, err := conn.Do("LREM", "queue", "1", "x"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 1 {
+		t.Error("bad reply")
+	}
+	checkRange("a", "b", "x", "c", "x")
+
+	// 从表尾删除1个
+	if reply, err := conn.Do("LREM", "queue", "-1", "x"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 1 {
+		t.Error("bad reply")
+	}
+	checkRange("a", "b", "x", "c")
+
+	// 删除后LINDEX仍然连续
+	if reply, err := conn.Do("LINDEX", "queue", "3"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "c" {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("LREM", "queue", "0", "x"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 1 {
+		t.Error("bad reply")
+	}
+	checkRange("a", "b", "c")
+
+	if reply, err := conn.Do("LREM", "queue", "0", "none"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 0 {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("LLEN", "queue"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 3 {
+		t.Error("bad reply")
+	}
+}
+
+func TestLSet(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "queue", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("LSET", "queue", "1", "B"); err != nil {
+		t.Fatal(err)
+	} else if reply.(string) != "OK" {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("LINDEX", "queue", "1"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "B" {
+		t.Error("bad reply")
+	}
+
+	if _, err := conn.Do("LSET", "queue", "-1", "C"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("LINDEX", "queue", "2"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "C" {
+		t.Error("bad reply")
+	}
+
+	if _, err := conn.Do("LSET", "queue", "3", "x"); err == nil {
+		t.Error("index out of range expected")
+	}
+	if _, err := conn.Do("LSET", "nokey", "0", "x"); err == nil {
+		t.Error("index out of range expected")
+	}
+}
+
+func TestLInsert(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("LINSERT", "queue", "BEFORE", "a", "x"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 0 {
+		t.Error("bad reply")
+	}
+
+	if _, err := conn.Do("RPUSH", "queue", "a", "b", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 靠近表头，移动左段
+	if reply, err := conn.Do("LINSERT", "queue", "AFTER", "a", "a1"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 5 {
+		t.Error("bad reply")
+	}
+	// 靠近表尾，移动右段
+	if reply, err := conn.Do("LINSERT", "queue", "BEFORE", "d", "c1"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 6 {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("LINSERT", "queue", "BEFORE", "a", "a0"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 7 {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("LINSERT", "queue", "AFTER", "none", "x"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != -1 {
+		t.Error("bad reply")
+	}
+
+	reply, err := conn.Do("LRANGE", "queue", "0", "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulks := reply.([]interface{})
+	expect := []string{"a0", "a", "a1", "b", "c", "c1", "d"}
+	if len(bulks) != len(expect) {
+		t.Fatal("bad length", len(bulks))
+	}
+	for i, s := range expect {
+		if string(bulks[i].([]byte)) != s {
+			t.Error("bad reply", i, s)
+		}
+	}
+}
+
+func TestLTrim(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "queue", "a", "b", "c", "d", "e", "f"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只保留最新的4条，常用于有界日志
+	if reply, err := conn.Do("LTRIM", "queue", "-4", "-1"); err != nil {
+		t.Fatal(err)
+	} else if reply.(string) != "OK" {
+		t.Error("bad reply")
+	}
+	if _, err := conn.Do("LTRIM", "queue", "1", "100"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := conn.Do("LRANGE", "queue", "0", "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulks := reply.([]interface{})
+	expect := []string{"d", "e", "f"}
+	if len(bulks) != len(expect) {
+		t.Fatal("bad length", len(bulks))
+	}
+	for i, s := range expect {
+		if string(bulks[i].([]byte)) != s {
+			t.Error("bad reply", i, s)
+		}
+	}
+
+	// start > stop 清空list
+	if _, err := conn.Do("LTRIM", "queue", "2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("LLEN", "queue"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 0 {
+		t.Error("bad reply")
+	}
+}
+
+func TestRPopLPush(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue", "queue:processing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "queue", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := conn.Do("RPOPLPUSH", "queue", "queue:processing"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "c" {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("LMOVE", "queue", "queue:processing", "LEFT", "RIGHT"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "a" {
+		t.Error("bad reply")
+	}
+	// 旋转
+	if reply, err := conn.Do("LMOVE", "queue:processing", "queue:processing", "LEFT", "RIGHT"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "c" {
+		t.Error("bad reply")
+	}
+
+	reply, err := conn.Do("LRANGE", "queue:processing", "0", "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulks := reply.([]interface{})
+	if len(bulks) != 2 || string(bulks[0].([]byte)) != "a" || string(bulks[1].([]byte)) != "c" {
+		t.Error("bad reply", bulks)
+	}
+
+	if _, err := conn.Do("LPOP", "queue"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("RPOPLPUSH", "queue", "queue:processing"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+}
+
+func TestBLPop(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue", "queue2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超时
+	begin := time.Now()
+	if reply, err := conn.Do("BLPOP", "queue", "queue2", "0.2"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+	if time.Since(begin) < 200*time.Millisecond {
+		t.Error("timeout too short")
+	}
+
+	// 另一个连接写入后唤醒
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c, err := NewRedisConn(host)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Do("RPUSH", "queue2", "job1")
+	}()
+	if reply, err := conn.Do("BLPOP", "queue", "queue2", "5"); err != nil {
+		t.Fatal(err)
+	} else {
+		bulks := reply.([]interface{})
+		if len(bulks) != 2 || string(bulks[0].([]byte)) != "queue2" || string(bulks[1].([]byte)) != "job1" {
+			t.Error("bad reply", bulks)
+		}
+	}
+
+	// 非空时立即返回
+	if _, err := conn.Do("RPUSH", "queue", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("BRPOP", "queue", "0"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]interface{})[1].([]byte)) != "b" {
+		t.Error("bad reply")
+	}
+	if reply, err := conn.Do("BLMOVE", "queue", "queue2", "LEFT", "RIGHT", "1"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "a" {
+		t.Error("bad reply")
+	}
+}
+
+// 阻塞中的连接被关闭后，之后写入的元素不能被它弹出
+func TestBLPopKilled(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	blocked, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+
+	conn.Do("DEL", "queue_killed")
+	info, err := redis.String(blocked.Do("CLIENT", "INFO"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.TrimPrefix(strings.Fields(info)[0], "addr=")
+	done := make(chan error, 1)
+	go func() {
+		_, err := blocked.Do("BLPOP", "queue_killed", "0")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if n, err := redis.Int(conn.Do("CLIENT", "KILL", "ADDR", addr)); err != nil || n != 1 {
+		t.Fatal("bad kill", n, err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("killed client got a reply")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked client not released")
+	}
+	conn.Do("LPUSH", "queue_killed", "job")
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := redis.Int(conn.Do("LLEN", "queue_killed")); n != 1 {
+		t.Error("element lost", n)
+	}
+	conn.Do("DEL", "queue_killed")
+}
+
+func TestLRangeNegative(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("RPUSH", "queue", "a", "b", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		start, stop string
+		expect      string
+	}{
+		{"0", "-1", "abcd"},
+		{"-2", "-1", "cd"},
+		{"-100", "1", "ab"},
+		{"1", "100", "bcd"},
+		{"-1", "-2", ""},
+		{"5", "10", ""},
+	}
+	for _, c := range cases {
+		reply, err := conn.Do("LRANGE", "queue", c.start, c.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := ""
+		for _, b := range reply.([]interface{}) {
+			s += string(b.([]byte))
+		}
+		if s != c.expect {
+			t.Error("bad reply", c.start, c.stop, s)
+		}
+	}
+}
+
+func TestLMPop(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "queue", "queue2"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("LMPOP", "2", "queue", "queue2", "LEFT"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+
+	if _, err := conn.Do("RPUSH", "queue2", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("LMPOP", "2", "queue", "queue2", "RIGHT", "COUNT", "2"); err != nil {
+		t.Fatal(err)
+	} else {
+		bulks := reply.([]interface{})
+		values := bulks[1].([]interface{})
+		if string(bulks[0].([]byte)) != "queue2" || len(values) != 2 ||
+			string(values[0].([]byte)) != "c" || string(values[1].([]byte)) != "b" {
+			t.Error("bad reply", bulks)
+		}
+	}
+
+	if reply, err := conn.Do("BLMPOP", "0.1", "2", "queue", "queue2", "LEFT", "COUNT", "10"); err != nil {
+		t.Fatal(err)
+	} else {
+		bulks := reply.([]interface{})
+		values := bulks[1].([]interface{})
+		if len(values) != 1 || string(values[0].([]byte)) != "a" {
+			t.Error("bad reply", bulks)
+		}
+	}
+	if reply, err := conn.Do("BLMPOP", "0.1", "2", "queue", "queue2", "LEFT"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+
+	// 在SELECT的db里弹出
+	conn.Do("SELECT", 1)
+	defer conn.Do("SELECT", 0)
+	conn.Do("DEL", "queue_db1")
+	conn.Do("RPUSH", "queue_db1", "x")
+	if reply, err := redis.Values(conn.Do("LMPOP", "1", "queue_db1", "LEFT")); err != nil {
+		t.Fatal(err)
+	} else if values, _ := redis.Strings(reply[1], nil); len(values) != 1 || values[0] != "x" {
+		t.Error("bad lmpop in db1", values)
+	}
+}
+
+// 批量生产者场景，每次迭代写入pushBatch个元素
+const pushBatch = 100
+
+// 逐个RPUSH，使用pipeline排除网络往返，每个元素一次leveldb写入
+func BenchmarkRPushSingle(b *testing.B) {
+	benchmark(b, func(conn redis.Conn) {
+		conn.Do("DEL", "pushbench")
+	}, func(conn redis.Conn) (err error) {
+		for i := 0; i < pushBatch; i++ {
+			conn.Send("RPUSH", "pushbench", "value")
+		}
+		if err = conn.Flush(); err != nil {
+			return
+		}
+		for i := 0; i < pushBatch; i++ {
+			if _, err = conn.Receive(); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// 一次RPUSH多个元素，只有一次WriteBatch和一次游标更新
+func BenchmarkRPushBatch(b *testing.B) {
+	args := []interface{}{"pushbench"}
+	for i := 0; i < pushBatch; i++ {
+		args = append(args, "value")
+	}
+	benchmark(b, func(conn redis.Conn) {
+		conn.Do("DEL", "pushbench")
+	}, func(conn redis.Conn) (err error) {
+		_, err = conn.Do("RPUSH", args...)
+		return
+	})
+}
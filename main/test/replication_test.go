@@ -0,0 +1,109 @@
+package test
+
+// 主从测试需要另外启动两个实例，使用GOREDIS_SERVER指定的程序，没有指定时编译../goredis-server.go，编译失败时跳过
+import (
+	"bytes"
+	"github.com/latermoon/redigo/redis"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func buildServer(t *testing.T, dir string) string {
+	if bin := os.Getenv("GOREDIS_SERVER"); len(bin) > 0 {
+		return bin
+	}
+	bin := filepath.Join(dir, "goredis-server")
+	if out, err := exec.Command("go", "build", "-o", bin, "../goredis-server.go").CombinedOutput(); err != nil {
+		t.Skip("build goredis-server failed", err, string(out))
+	}
+	return bin
+}
+
+// 启动实例并等待端口可用，输出写入out
+func startServer(t *testing.T, bin, dir, port string, out *bytes.Buffer, args ...string) *exec.Cmd {
+	os.MkdirAll(dir, os.ModePerm)
+	args = append([]string{"-p", port, "-dbpath", dir, "-logpath", dir}, args...)
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if conn, err := NewRedisConn("localhost:" + port); err == nil {
+			conn.Close()
+			return cmd
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	t.Fatal("server not started", out.String())
+	return nil
+}
+
+func waitInfo(conn redis.Conn, field string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if info, _ := redis.String(conn.Do("INFO", "replication")); strings.Contains(info, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// 从库在线同步期间会发送REPLCONF ACK，主库关闭时仍然要收到SYNC_SHUTDOWN_ACK
+func TestMasterShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goredis_repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildServer(t, dir)
+
+	masterOut, slaveOut := &bytes.Buffer{}, &bytes.Buffer{}
+	master := startServer(t, bin, filepath.Join(dir, "master"), "1702", masterOut)
+	defer master.Process.Kill()
+	slave := startServer(t, bin, filepath.Join(dir, "slave"), "1703", slaveOut, "-slaveof", "localhost:1702")
+	defer slave.Process.Kill()
+
+	mconn, err := NewRedisConn("localhost:1702")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mconn.Close()
+	sconn, err := NewRedisConn("localhost:1703")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sconn.Close()
+
+	if !waitInfo(sconn, "master_link_status:up", 10*time.Second) {
+		t.Fatal("replica not online", slaveOut.String())
+	}
+	mconn.Do("SET", "repl_shutdown", "1")
+	// 等待至少一次ACK
+	if !waitInfo(mconn, "lag=0", 5*time.Second) {
+		t.Fatal("no ack from replica")
+	}
+
+	master.Process.Signal(syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		done <- master.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("master not closed")
+	}
+	if !strings.Contains(masterOut.String(), "shutdown ack seq") {
+		t.Error("no shutdown ack", masterOut.String())
+	}
+	if v, _ := redis.String(sconn.Do("GET", "repl_shutdown")); v != "1" {
+		t.Error("bad replica value", v)
+	}
+}
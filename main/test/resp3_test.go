@@ -0,0 +1,61 @@
+package test
+
+import (
+	"GoRedis/goredis"
+	"bufio"
+	"github.com/latermoon/redigo/redis"
+	"net"
+	"strings"
+	"testing"
+)
+
+// HELLO 3之后按RESP3的类型返回，RESP2的连接不受影响
+func TestRESP3(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Do("DEL", "resp3_zset")
+	conn.Do("ZADD", "resp3_zset", "1.5", "m")
+	if score, _ := redis.String(conn.Do("ZSCORE", "resp3_zset", "m")); score != "1.5" {
+		t.Error("bad resp2 score", score)
+	}
+
+	raw, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	r := bufio.NewReader(raw)
+	send := func(args ...string) string {
+		bs := make([][]byte, len(args))
+		for i, arg := range args {
+			bs[i] = []byte(arg)
+		}
+		raw.Write(goredis.NewCommand(bs...).Bytes())
+		line, _ := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+	if line := send("HELLO", "3"); !strings.HasPrefix(line, "%") {
+		t.Fatal("bad hello", line)
+	}
+	// 跳过HELLO返回的map
+	for {
+		line, _ := r.ReadString('\n')
+		if strings.HasPrefix(line, "*0") {
+			break
+		}
+	}
+	if line := send("ZSCORE", "resp3_zset", "m"); line != ",1.5" {
+		t.Error("bad resp3 score", line)
+	}
+	if line := send("ZINCRBY", "resp3_zset", "inf", "m"); line != ",inf" {
+		t.Error("bad resp3 incr", line)
+	}
+	if line := send("HELLO", "4"); !strings.HasPrefix(line, "-NOPROTO") {
+		t.Error("bad hello 4", line)
+	}
+
+	conn.Do("DEL", "resp3_zset")
+}
@@ -1,6 +1,9 @@
 package test
 
 import (
+	"fmt"
+	"github.com/latermoon/redigo/redis"
+	"strings"
 	"testing"
 )
 
@@ -55,3 +58,281 @@ func TestSet(t *testing.T) {
 		}
 	}
 }
+
+// 元素数量持久化保存，不受数量限制
+func TestSetCard(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "setcard"); err != nil {
+		t.Fatal(err)
+	}
+	args := []interface{}{"setcard"}
+	for i := 0; i < 500; i++ {
+		args = append(args, fmt.Sprintf("member:%d", i))
+	}
+	// 同一条指令中重复的member只计数一次
+	args = append(args, "member:0")
+	if reply, err := conn.Do("SADD", args...); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 500 {
+		t.Error("bad reply", reply)
+	}
+	if reply, err := conn.Do("SREM", "setcard", "member:0", "member:0", "none"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 1 {
+		t.Error("bad reply", reply)
+	}
+	if reply, err := conn.Do("SCARD", "setcard"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 499 {
+		t.Error("bad reply", reply)
+	}
+	if _, err := conn.Do("DEL", "setcard"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("SCARD", "setcard"); err != nil {
+		t.Fatal(err)
+	} else if reply.(int64) != 0 {
+		t.Error("bad reply", reply)
+	}
+}
+
+// 没有设置大集合阈值时SMEMBERS返回全部member
+func TestSMembersLarge(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Do("DEL", "smembers_large")
+	args := []interface{}{"smembers_large"}
+	for i := 0; i < 1500; i++ {
+		args = append(args, fmt.Sprintf("member:%d", i))
+	}
+	if _, err := conn.Do("SADD", args...); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Do("DEL", "smembers_large")
+	members, err := redis.Strings(conn.Do("SMEMBERS", "smembers_large"))
+	if err != nil && strings.Contains(err.Error(), "LARGECOLL") {
+		t.Skip("large collection threshold configured")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1500 {
+		t.Error("bad smembers", len(members))
+	}
+}
+
+func TestSetOps(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "setops:a", "setops:b", "setops:c", "setops:dest"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("SADD", "setops:a", "a", "b", "c", "d")
+	conn.Do("SADD", "setops:b", "c", "d", "e")
+	conn.Do("SADD", "setops:c", "d", "f")
+
+	// 结果按member字节顺序返回
+	cases := []struct {
+		args   []interface{}
+		expect string
+	}{
+		{[]interface{}{"SINTER", "setops:a", "setops:b"}, "c,d"},
+		{[]interface{}{"SINTER", "setops:a", "setops:b", "setops:c"}, "d"},
+		{[]interface{}{"SINTER", "setops:a", "setops:none"}, ""},
+		{[]interface{}{"SUNION", "setops:a", "setops:b", "setops:c"}, "a,b,c,d,e,f"},
+		{[]interface{}{"SUNION", "setops:none", "setops:c"}, "d,f"},
+		{[]interface{}{"SDIFF", "setops:a", "setops:b"}, "a,b"},
+		{[]interface{}{"SDIFF", "setops:a", "setops:b", "setops:c"}, "a,b"},
+		{[]interface{}{"SDIFF", "setops:c", "setops:a"}, "f"},
+	}
+	for _, c := range cases {
+		reply, err := redis.Strings(conn.Do(c.args[0].(string), c.args[1:]...))
+		if err != nil {
+			t.Fatal(c.args, err)
+		}
+		if strings.Join(reply, ",") != c.expect {
+			t.Error(c.args, "bad reply", reply)
+		}
+	}
+
+	// destination同时作为输入
+	if _, err := conn.Do("SADD", "setops:dest", "x", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("SUNIONSTORE", "setops:dest", "setops:dest", "setops:b")); err != nil || n != 4 {
+		t.Error("bad reply", n, err)
+	}
+	if n, err := redis.Int(conn.Do("SINTERSTORE", "setops:dest", "setops:dest", "setops:a")); err != nil || n != 2 {
+		t.Error("bad reply", n, err)
+	}
+	if reply, err := redis.Strings(conn.Do("SMEMBERS", "setops:dest")); err != nil || strings.Join(reply, ",") != "c,d" {
+		t.Error("bad members", reply, err)
+	}
+	if n, err := redis.Int(conn.Do("SDIFFSTORE", "setops:dest", "setops:a", "setops:a")); err != nil || n != 0 {
+		t.Error("bad reply", n, err)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "setops:dest")); typ != "none" {
+		t.Error("empty result should not create key", typ)
+	}
+}
+
+func TestSetRandom(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "setrand"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("SRANDMEMBER", "setrand"); err != nil || reply != nil {
+		t.Error("nil expected", reply, err)
+	}
+	if reply, err := conn.Do("SPOP", "setrand"); err != nil || reply != nil {
+		t.Error("nil expected", reply, err)
+	}
+	total := 100
+	for i := 0; i < total; i++ {
+		if _, err := conn.Do("SADD", "setrand", fmt.Sprintf("m%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不重复，seek和全量打乱两种方式
+	for _, count := range []int{5, 50, 200} {
+		reply, err := redis.Strings(conn.Do("SRANDMEMBER", "setrand", count))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := count
+		if expect > total {
+			expect = total
+		}
+		if len(reply) != expect {
+			t.Error("bad count", count, len(reply))
+		}
+		seen := make(map[string]bool)
+		for _, member := range reply {
+			if seen[member] || !strings.HasPrefix(member, "m") {
+				t.Error("bad member", member)
+			}
+			seen[member] = true
+		}
+	}
+	// 负数允许重复
+	if reply, err := redis.Strings(conn.Do("SRANDMEMBER", "setrand", -300)); err != nil || len(reply) != 300 {
+		t.Error("bad count", len(reply), err)
+	}
+
+	popped, err := redis.Strings(conn.Do("SPOP", "setrand", 10))
+	if err != nil || len(popped) != 10 {
+		t.Fatal("bad pop", popped, err)
+	}
+	for _, member := range popped {
+		if ok, _ := redis.Bool(conn.Do("SISMEMBER", "setrand", member)); ok {
+			t.Error("popped member still exists", member)
+		}
+	}
+	if n, _ := redis.Int(conn.Do("SCARD", "setrand")); n != total-10 {
+		t.Error("bad card", n)
+	}
+	if _, err := conn.Do("SPOP", "setrand", -1); err == nil {
+		t.Error("negative count should fail")
+	}
+	if reply, err := redis.Strings(conn.Do("SPOP", "setrand", total)); err != nil || len(reply) != total-10 {
+		t.Error("bad pop", len(reply), err)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "setrand")); typ != "none" {
+		t.Error("empty set should be removed", typ)
+	}
+}
+
+func TestSScan(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "sscan"); err != nil {
+		t.Fatal(err)
+	}
+	total := 1000
+	for i := 0; i < total; i++ {
+		if _, err := conn.Do("SADD", "sscan", fmt.Sprintf("member:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每个member只返回一次
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SSCAN", "sscan", cursor, "COUNT", 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		members, _ := redis.Strings(reply[1], nil)
+		for _, member := range members {
+			if seen[member] {
+				t.Error("duplicate", member)
+			}
+			seen[member] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != total {
+		t.Error("bad count", len(seen))
+	}
+
+	// MATCH
+	matched := 0
+	cursor = "0"
+	for {
+		reply, err := redis.Values(conn.Do("SSCAN", "sscan", cursor, "MATCH", "member:9?", "COUNT", 300))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		members, _ := redis.Strings(reply[1], nil)
+		matched += len(members)
+		if cursor == "0" {
+			break
+		}
+	}
+	if matched != 10 {
+		t.Error("bad match", matched)
+	}
+}
+
+func TestSScanMutation(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testScanMutation(t, conn, "SSCAN", "sscan_mutation", 1, func(member string) error {
+		_, err := conn.Do("SADD", "sscan_mutation", member)
+		return err
+	}, func(member string) error {
+		_, err := conn.Do("SREM", "sscan_mutation", member)
+		return err
+	})
+}
@@ -104,3 +104,310 @@ func BenchmarkGet(b *testing.B) {
 		return
 	})
 }
+
+func TestIncrLimit(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "quota"); err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]int{
+		// increment, limit, value, hit
+		{3, 5, 3, 0},
+		{2, 5, 5, 0},
+		{1, 5, 5, 1},
+		{-2, 5, 3, 0},
+		{3, 5, 3, 1},
+	}
+	for _, c := range cases {
+		reply, err := redis.Values(conn.Do("INCRLIMIT", "quota", c[0], c[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply) != 2 {
+			t.Fatal("bad reply", c, reply)
+		}
+		value, _ := redis.Int(reply[0], nil)
+		hit, _ := redis.Int(reply[1], nil)
+		if value != c[2] || hit != c[3] {
+			t.Error("bad reply", c, value, hit)
+		}
+	}
+	if value, err := redis.Int(conn.Do("GET", "quota")); err != nil || value != 3 {
+		t.Error("bad value", value, err)
+	}
+}
+
+func TestIncrErrors(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "counter", "name", "price"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("SET", "name", "latermoon"); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{
+		{"INCR", "name"},
+		{"DECRBY", "name", "2"},
+		{"INCRBYFLOAT", "name", "1.5"},
+		{"INCRBY", "counter", "abc"},
+		{"INCRBYFLOAT", "counter", "abc"},
+	} {
+		if _, err := conn.Do(args[0].(string), args[1:]...); err == nil {
+			t.Error(args, "should fail")
+		}
+	}
+
+	// 溢出时不修改
+	if _, err := conn.Do("SET", "counter", "9223372036854775806"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int64(conn.Do("INCR", "counter")); err != nil || n != 9223372036854775807 {
+		t.Error("bad reply", n, err)
+	}
+	if _, err := conn.Do("INCR", "counter"); err == nil {
+		t.Error("overflow should fail")
+	}
+	if value, err := redis.String(conn.Do("GET", "counter")); err != nil || value != "9223372036854775807" {
+		t.Error("bad value", value, err)
+	}
+
+	for _, c := range [][]string{
+		// increment, value
+		{"10.5", "10.5"},
+		{"0.1", "10.6"},
+		{"-5", "5.6"},
+		{"3.0e3", "3005.6"},
+	} {
+		if value, err := redis.String(conn.Do("INCRBYFLOAT", "price", c[0])); err != nil || value != c[1] {
+			t.Error("bad reply", c, value, err)
+		}
+	}
+	if _, err := conn.Do("INCRBY", "price", "1"); err == nil {
+		t.Error("INCRBY on float should fail")
+	}
+}
+
+func TestStringRange(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "strrange", "strpad"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("APPEND", "strrange", "Hello")); err != nil || n != 5 {
+		t.Error("bad append", n, err)
+	}
+	if n, err := redis.Int(conn.Do("APPEND", "strrange", " World")); err != nil || n != 11 {
+		t.Error("bad append", n, err)
+	}
+	if n, err := redis.Int(conn.Do("STRLEN", "strrange")); err != nil || n != 11 {
+		t.Error("bad strlen", n, err)
+	}
+	if n, err := redis.Int(conn.Do("STRLEN", "strpad")); err != nil || n != 0 {
+		t.Error("bad strlen", n, err)
+	}
+
+	cases := []struct {
+		start, end int
+		expect     string
+	}{
+		{0, 4, "Hello"},
+		{-5, -1, "World"},
+		{0, -1, "Hello World"},
+		{6, 100, "World"},
+		{-100, 1, "He"},
+		{5, 2, ""},
+		{20, 30, ""},
+	}
+	for _, c := range cases {
+		if s, err := redis.String(conn.Do("GETRANGE", "strrange", c.start, c.end)); err != nil || s != c.expect {
+			t.Error("bad getrange", c.start, c.end, s, err)
+		}
+	}
+
+	if n, err := redis.Int(conn.Do("SETRANGE", "strrange", 6, "Redis")); err != nil || n != 11 {
+		t.Error("bad setrange", n, err)
+	}
+	if s, _ := redis.String(conn.Do("GET", "strrange")); s != "Hello Redis" {
+		t.Error("bad value", s)
+	}
+
+	// 超过长度时用0x00填充
+	if n, err := redis.Int(conn.Do("SETRANGE", "strpad", 3, "ab")); err != nil || n != 5 {
+		t.Error("bad setrange", n, err)
+	}
+	if s, _ := redis.String(conn.Do("GET", "strpad")); s != "\x00\x00\x00ab" {
+		t.Errorf("bad padding %q", s)
+	}
+	// 空value不创建key
+	if n, err := redis.Int(conn.Do("SETRANGE", "strnone", 10, "")); err != nil || n != 0 {
+		t.Error("bad setrange", n, err)
+	}
+	if reply, _ := conn.Do("GET", "strnone"); reply != nil {
+		t.Error("key should not exist", reply)
+	}
+	if _, err := conn.Do("SETRANGE", "strpad", -1, "x"); err == nil {
+		t.Error("negative offset should fail")
+	}
+}
+
+func TestBitmap(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "bits", "bits2", "bitstr", "bitdest", "bitbig"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("SETBIT", "bits", 7, 1)); err != nil || n != 0 {
+		t.Error("bad setbit", n, err)
+	}
+	if n, err := redis.Int(conn.Do("SETBIT", "bits", 7, 0)); err != nil || n != 1 {
+		t.Error("bad setbit", n, err)
+	}
+	// 跨越多个块
+	for _, offset := range []int{1, 6, 40000, 70000} {
+		if _, err := conn.Do("SETBIT", "bits", offset, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := redis.Int(conn.Do("GETBIT", "bits", 40000)); n != 1 {
+		t.Error("bad getbit", n)
+	}
+	if n, _ := redis.Int(conn.Do("GETBIT", "bits", 40001)); n != 0 {
+		t.Error("bad getbit", n)
+	}
+	if n, _ := redis.Int(conn.Do("STRLEN", "bits")); n != 70000/8+1 {
+		t.Error("bad strlen", n)
+	}
+	if typ, _ := redis.String(conn.Do("TYPE", "bits")); typ != "string" {
+		t.Error("bad type", typ)
+	}
+	value, _ := redis.Bytes(conn.Do("GET", "bits"))
+	if len(value) != 70000/8+1 || value[0] != 0x42 || value[5000] != 0x80 {
+		t.Error("bad value", len(value))
+	}
+
+	countCases := []struct {
+		args   []interface{}
+		expect int
+	}{
+		{[]interface{}{"bits"}, 4},
+		{[]interface{}{"bits", 0, 0}, 2},
+		{[]interface{}{"bits", 1, -1}, 2},
+		{[]interface{}{"bits", 2, 6, "BIT"}, 1},
+		{[]interface{}{"bits", 0, 40000, "BIT"}, 3},
+		{[]interface{}{"nobits"}, 0},
+	}
+	for _, c := range countCases {
+		if n, err := redis.Int(conn.Do("BITCOUNT", c.args...)); err != nil || n != c.expect {
+			t.Error("bad bitcount", c.args, n, err)
+		}
+	}
+
+	// 已有的string先转换为bitmap
+	if _, err := conn.Do("SET", "bitstr", "\xff\xf0\x00"); err != nil {
+		t.Fatal(err)
+	}
+	posCases := []struct {
+		args   []interface{}
+		expect int
+	}{
+		{[]interface{}{"bitstr", 0}, 12},
+		{[]interface{}{"bitstr", 1, 1}, 8},
+		{[]interface{}{"bitstr", 1, 2}, -1},
+		{[]interface{}{"bitstr", 0, 0, 0}, -1},
+		{[]interface{}{"bitstr", 1, 3, 10, "BIT"}, 3},
+		{[]interface{}{"nobits", 0}, 0},
+		{[]interface{}{"nobits", 1}, -1},
+	}
+	for _, c := range posCases {
+		if n, err := redis.Int(conn.Do("BITPOS", c.args...)); err != nil || n != c.expect {
+			t.Error("bad bitpos", c.args, n, err)
+		}
+	}
+	if _, err := conn.Do("SET", "bits2", "\xff\xff\xff\xff"); err != nil {
+		t.Fatal(err)
+	}
+	// 全为1且没有指定end时，返回值之后的第一个bit
+	if n, _ := redis.Int(conn.Do("BITPOS", "bits2", 0)); n != 32 {
+		t.Error("bad bitpos", n)
+	}
+	if n, err := redis.Int(conn.Do("SETBIT", "bitstr", 23, 1)); err != nil || n != 0 {
+		t.Error("bad setbit", n, err)
+	}
+	if s, _ := redis.String(conn.Do("GET", "bitstr")); s != "\xff\xf0\x01" {
+		t.Errorf("bad value %q", s)
+	}
+	if n, _ := redis.Int(conn.Do("APPEND", "bitstr", "a")); n != 4 {
+		t.Error("bad append", n)
+	}
+	if s, _ := redis.String(conn.Do("GETRANGE", "bitstr", -2, -1)); s != "\x01a" {
+		t.Errorf("bad getrange %q", s)
+	}
+
+	opCases := []struct {
+		op     string
+		keys   []interface{}
+		expect string
+	}{
+		{"AND", []interface{}{"bitstr", "bits2"}, "\xff\xf0\x01a"},
+		{"OR", []interface{}{"bitstr", "nobits"}, "\xff\xf0\x01a"},
+		{"XOR", []interface{}{"bitstr", "bits2"}, "\x00\x0f\xfe\x9e"},
+		{"NOT", []interface{}{"bitstr"}, "\x00\x0f\xfe\x9e"},
+	}
+	for _, c := range opCases {
+		args := append([]interface{}{c.op, "bitdest"}, c.keys...)
+		if n, err := redis.Int(conn.Do("BITOP", args...)); err != nil || n != 4 {
+			t.Error("bad bitop", c.op, n, err)
+		}
+		if s, _ := redis.String(conn.Do("GET", "bitdest")); s != c.expect {
+			t.Errorf("bad bitop %s %q", c.op, s)
+		}
+	}
+	// 输入不存在时删除destkey
+	if n, err := redis.Int(conn.Do("BITOP", "OR", "bitdest", "nobits")); err != nil || n != 0 {
+		t.Error("bad bitop", n, err)
+	}
+	if reply, _ := conn.Do("GET", "bitdest"); reply != nil {
+		t.Error("bitdest should be deleted", reply)
+	}
+	if _, err := conn.Do("BITOP", "NOT", "bitdest", "bits", "bits2"); err == nil {
+		t.Error("NOT with multiple keys should fail")
+	}
+
+	// 大offset只写入一个块
+	if _, err := conn.Do("SETBIT", "bitbig", 4294967295, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := redis.Int(conn.Do("GETBIT", "bitbig", 4294967295)); n != 1 {
+		t.Error("bad getbit", n)
+	}
+	if n, _ := redis.Int(conn.Do("STRLEN", "bitbig")); n != 1<<29 {
+		t.Error("bad strlen", n)
+	}
+	if n, _ := redis.Int(conn.Do("BITCOUNT", "bitbig", -1, -1)); n != 1 {
+		t.Error("bad bitcount", n)
+	}
+	if _, err := conn.Do("SETBIT", "bitbig", 4294967296, 1); err == nil {
+		t.Error("offset out of range should fail")
+	}
+	if n, _ := redis.Int(conn.Do("DEL", "bitbig")); n != 1 {
+		t.Error("bad del", n)
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"fmt"
 	"github.com/latermoon/redigo/redis"
 	"testing"
 	"time"
@@ -52,3 +53,71 @@ func benchmark(b *testing.B, before func(conn redis.Conn), fn func(conn redis.Co
 		}
 	}
 }
+
+// HSCAN/SSCAN/ZSCAN在扫描期间修改集合，step为每个元素在回复中占用的项数
+// 第一批之后删除一个已经返回的和十个还没有返回的元素，在游标之前和之后各加入一个元素，检查:
+// 没有重复；一直存在的元素全部返回；还没有返回就删除的元素不返回；游标之后加入的返回，之前加入的不返回
+func testScanMutation(t *testing.T, conn redis.Conn, scan, key string, step int, add, del func(member string) error) {
+	if _, err := conn.Do("DEL", key); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Do("DEL", key)
+	for i := 0; i < 300; i++ {
+		if err := add(fmt.Sprintf("m:%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]int)
+	removed := make(map[string]bool)
+	before, after := "m:005x", "m:250x"
+	cursor, mutated := "0", false
+	for {
+		reply, err := redis.Values(conn.Do(scan, key, cursor, "COUNT", 50))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		elems, _ := redis.Strings(reply[1], nil)
+		for i := 0; i < len(elems); i += step {
+			seen[elems[i]]++
+		}
+		if !mutated {
+			mutated = true
+			if seen["m:010"] == 0 || seen["m:006"] == 0 || seen["m:200"] > 0 {
+				t.Fatal("unexpected first batch", len(elems)/step)
+			}
+			del("m:010")
+			for i := 200; i < 210; i++ {
+				member := fmt.Sprintf("m:%03d", i)
+				del(member)
+				removed[member] = true
+			}
+			add(before)
+			add(after)
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	for member, n := range seen {
+		if n > 1 {
+			t.Error("duplicate", scan, member, n)
+		}
+		if removed[member] {
+			t.Error("removed member returned", scan, member)
+		}
+	}
+	for i := 0; i < 300; i++ {
+		if member := fmt.Sprintf("m:%03d", i); !removed[member] && seen[member] == 0 {
+			t.Error("missing", scan, member)
+		}
+	}
+	if seen[before] > 0 {
+		t.Error("member added before the cursor returned", scan)
+	}
+	if seen[after] == 0 {
+		t.Error("member added after the cursor missing", scan)
+	}
+}
@@ -2,7 +2,12 @@ package test
 
 import (
 	"fmt"
+	"github.com/latermoon/redigo/redis"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSortedSet(t *testing.T) {
@@ -131,3 +136,664 @@ func TestSortedSet(t *testing.T) {
 		t.Error("bad reply")
 	}
 }
+
+// 排行榜场景，读多写少
+func initLeaderboard(conn redis.Conn) {
+	conn.Do("DEL", "leaderboard")
+	args := []interface{}{"leaderboard"}
+	for i := 0; i < 1000; i++ {
+		args = append(args, i, fmt.Sprintf("user:%d", i))
+	}
+	conn.Do("ZADD", args...)
+}
+
+func BenchmarkZScore(b *testing.B) {
+	benchmark(b, initLeaderboard, func(conn redis.Conn) (err error) {
+		_, err = conn.Do("ZSCORE", "leaderboard", "user:500")
+		return
+	})
+}
+
+func BenchmarkZRevRange(b *testing.B) {
+	benchmark(b, initLeaderboard, func(conn redis.Conn) (err error) {
+		_, err = conn.Do("ZREVRANGE", "leaderboard", "0", "9", "WITHSCORES")
+		return
+	})
+}
+
+// 并发读取的同时有少量ZINCRBY，比较读路径是否被写入阻塞
+func BenchmarkZScoreParallel(b *testing.B) {
+	pool := RedisPool(host)
+	defer pool.Close()
+	conn := pool.Get()
+	initLeaderboard(conn)
+	conn.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		conn := pool.Get()
+		defer conn.Close()
+		for i := 0; pb.Next(); i++ {
+			var err error
+			if i%100 == 0 {
+				_, err = conn.Do("ZINCRBY", "leaderboard", "1", "user:500")
+			} else {
+				_, err = conn.Do("ZSCORE", "leaderboard", "user:500")
+			}
+			if err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+type zrefItem struct {
+	score  float64
+	member string
+}
+
+// score相同时按member排序
+type zrefItems []zrefItem
+
+func (z zrefItems) Len() int      { return len(z) }
+func (z zrefItems) Swap(i, j int) { z[i], z[j] = z[j], z[i] }
+func (z zrefItems) Less(i, j int) bool {
+	if z[i].score != z[j].score {
+		return z[i].score < z[j].score
+	}
+	return z[i].member < z[j].member
+}
+
+// 用内存中排好序的数组作为参照，随机比较ZRANGE/ZREVRANGE的结果
+func TestZRangeReference(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zref"); err != nil {
+		t.Fatal(err)
+	}
+	items := make(zrefItems, 0, 50)
+	args := []interface{}{"zref"}
+	for i := 0; i < 50; i++ {
+		it := zrefItem{float64(rand.Intn(40) - 20), fmt.Sprintf("m%02d", i)}
+		items = append(items, it)
+		args = append(args, it.score, it.member)
+	}
+	if _, err := conn.Do("ZADD", args...); err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(items)
+
+	expect := func(high2low bool, start, stop int) (members []string) {
+		n := len(items)
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		for i := start; i <= stop; i++ {
+			if high2low {
+				members = append(members, items[n-1-i].member)
+			} else {
+				members = append(members, items[i].member)
+			}
+		}
+		return
+	}
+
+	for round := 0; round < 200; round++ {
+		start, stop := rand.Intn(120)-60, rand.Intn(120)-60
+		for _, high2low := range []bool{false, true} {
+			name := "ZRANGE"
+			if high2low {
+				name = "ZREVRANGE"
+			}
+			reply, err := conn.Do(name, "zref", start, stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bulks := reply.([]interface{})
+			want := expect(high2low, start, stop)
+			if len(bulks) != len(want) {
+				t.Fatal(name, start, stop, "bad length", len(bulks), len(want))
+			}
+			for i, m := range want {
+				if string(bulks[i].([]byte)) != m {
+					t.Fatal(name, start, stop, "bad member at", i)
+				}
+			}
+		}
+	}
+}
+
+// 负数和浮点数score按数值排序
+func TestZSetFloatScore(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zfloat"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zfloat", "3", "d", "-1.5", "b", "0.25", "c", "-10", "a", "1e3", "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := conn.Do("ZRANGE", "zfloat", "0", "-1", "WITHSCORES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulks := reply.([]interface{})
+	expect := []string{"a", "-10", "b", "-1.5", "c", "0.25", "d", "3", "e", "1000"}
+	if len(bulks) != len(expect) {
+		t.Fatal("bad length", len(bulks))
+	}
+	for i, s := range expect {
+		if string(bulks[i].([]byte)) != s {
+			t.Error("bad reply", i, s, string(bulks[i].([]byte)))
+		}
+	}
+
+	if reply, err := conn.Do("ZRANGEBYSCORE", "zfloat", "-2", "0.25"); err != nil {
+		t.Fatal(err)
+	} else {
+		bulks := reply.([]interface{})
+		if len(bulks) != 2 || string(bulks[0].([]byte)) != "b" || string(bulks[1].([]byte)) != "c" {
+			t.Error("bad reply", bulks)
+		}
+	}
+
+	if reply, err := conn.Do("ZINCRBY", "zfloat", "0.5", "b"); err != nil {
+		t.Fatal(err)
+	} else if string(reply.([]byte)) != "-1" {
+		t.Error("bad reply")
+	}
+
+	if reply, err := conn.Do("ZRANGEBYSCORE", "zfloat", "-inf", "+inf"); err != nil {
+		t.Fatal(err)
+	} else if len(reply.([]interface{})) != 5 {
+		t.Error("bad reply")
+	}
+
+	// nan没有顺序，不能作为score
+	for _, args := range [][]interface{}{{"ZADD", "zfloat", "nan", "f"}, {"ZINCRBY", "zfloat", "NaN", "a"}} {
+		if _, err := conn.Do(args[0].(string), args[1:]...); err == nil || !strings.Contains(err.Error(), "not a valid float") {
+			t.Error("nan should be rejected", args, err)
+		}
+	}
+	if n, err := redis.Int(conn.Do("ZCARD", "zfloat")); err != nil || n != 5 {
+		t.Error("bad zcard", n, err)
+	}
+}
+
+// 浮点数增量、inf以及NaN
+// +inf编码后首字节是0xFF，按前缀扫描索引时不能漏掉
+func TestZSetInfScore(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zinf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zinf", "1.5", "a", "+inf", "b", "-inf", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := redis.Strings(conn.Do("ZRANGE", "zinf", "0", "-1")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "c,a,b" {
+		t.Error("bad zrange", reply)
+	}
+	if reply, err := redis.Strings(conn.Do("ZREVRANGE", "zinf", "0", "-1")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "b,a,c" {
+		t.Error("bad zrevrange", reply)
+	}
+	if n, err := redis.Int(conn.Do("ZRANK", "zinf", "b")); err != nil || n != 2 {
+		t.Error("bad zrank", n, err)
+	}
+	if n, err := redis.Int(conn.Do("ZREVRANK", "zinf", "c")); err != nil || n != 2 {
+		t.Error("bad zrevrank", n, err)
+	}
+	if reply, err := redis.Strings(conn.Do("ZPOPMAX", "zinf")); err != nil {
+		t.Fatal(err)
+	} else if len(reply) != 2 || reply[0] != "b" {
+		t.Error("bad zpopmax", reply)
+	}
+	if reply, err := redis.Strings(conn.Do("ZPOPMIN", "zinf")); err != nil {
+		t.Fatal(err)
+	} else if len(reply) != 2 || reply[0] != "c" {
+		t.Error("bad zpopmin", reply)
+	}
+	if n, err := redis.Int(conn.Do("ZCARD", "zinf")); err != nil || n != 1 {
+		t.Error("bad zcard", n, err)
+	}
+}
+
+func TestZIncrByFloat(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zincrfloat"); err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]string{
+		// incr, member, score
+		{"0.1", "a", "0.1"},
+		{"0.2", "a", "0.30000000000000004"},
+		{"-1.3", "a", "-1"},
+		{"1e300", "b", "1e+300"},
+		{"1e-7", "c", "1e-07"},
+		{"inf", "d", "inf"},
+		{"-inf", "e", "-inf"},
+	}
+	for _, c := range cases {
+		if reply, err := redis.String(conn.Do("ZINCRBY", "zincrfloat", c[0], c[1])); err != nil {
+			t.Fatal(err)
+		} else if reply != c[2] {
+			t.Error("bad reply", c, reply)
+		}
+	}
+	if _, err := conn.Do("ZINCRBY", "zincrfloat", "-inf", "d"); err == nil {
+		t.Error("NaN error expected")
+	}
+	if reply, err := redis.String(conn.Do("ZSCORE", "zincrfloat", "d")); err != nil || reply != "inf" {
+		t.Error("score should not change", reply, err)
+	}
+	if reply, err := redis.Strings(conn.Do("ZRANGE", "zincrfloat", "0", "0")); err != nil || len(reply) != 1 || reply[0] != "e" {
+		t.Error("bad order", reply, err)
+	}
+}
+
+// member的字典顺序与score顺序相反时，排名仍然正确
+func TestZRank(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zrank"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zrank", "1", "c", "2", "b", "3", "a", "3", "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	ranks := map[string]int64{"c": 0, "b": 1, "a": 2, "d": 3}
+	for member, rank := range ranks {
+		if reply, err := conn.Do("ZRANK", "zrank", member); err != nil {
+			t.Fatal(err)
+		} else if reply.(int64) != rank {
+			t.Error("bad rank", member, reply)
+		}
+		if reply, err := conn.Do("ZREVRANK", "zrank", member); err != nil {
+			t.Fatal(err)
+		} else if reply.(int64) != 3-rank {
+			t.Error("bad revrank", member, reply)
+		}
+	}
+	if reply, err := conn.Do("ZRANK", "zrank", "none"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+}
+
+func TestZCount(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zcount"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zcount", "-1.5", "a", "0", "b", "1", "c", "1", "d", "2.5", "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][]interface{}{
+		{"-inf", "+inf", int64(5)},
+		{"0", "1", int64(3)},
+		{"(0", "1", int64(2)},
+		{"0", "(1", int64(1)},
+		{"(1", "(1", int64(0)},
+		{"(-1.5", "2.5", int64(4)},
+		{"3", "-inf", int64(0)},
+	}
+	for _, c := range cases {
+		if reply, err := conn.Do("ZCOUNT", "zcount", c[0], c[1]); err != nil {
+			t.Fatal(err)
+		} else if reply.(int64) != c[2].(int64) {
+			t.Error("bad count", c, reply)
+		}
+	}
+	if _, err := conn.Do("ZCOUNT", "zcount", "a", "1"); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestZAddFlags(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zaddflags"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zaddflags", "1", "a", "2", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][]interface{}{
+		{[]interface{}{"NX", "10", "a", "3", "c"}, int64(1)},       // a不变，新增c
+		{[]interface{}{"XX", "10", "b", "4", "d"}, int64(0)},       // b=10，不新增d
+		{[]interface{}{"XX", "CH", "11", "b", "4", "d"}, int64(1)}, // b=11
+		{[]interface{}{"GT", "CH", "0", "a", "5", "c"}, int64(1)},  // a不变，c=5
+		{[]interface{}{"LT", "CH", "0", "a", "6", "e"}, int64(2)},  // a=0，新增e
+		{[]interface{}{"CH", "0", "a"}, int64(0)},                  // score相同不算修改
+	}
+	for _, c := range cases {
+		args := append([]interface{}{"zaddflags"}, c[0].([]interface{})...)
+		if reply, err := conn.Do("ZADD", args...); err != nil {
+			t.Fatal(err)
+		} else if reply.(int64) != c[1].(int64) {
+			t.Error("bad zadd", c, reply)
+		}
+	}
+	scores := map[string]string{"a": "0", "b": "11", "c": "5", "e": "6"}
+	for member, score := range scores {
+		if reply, err := redis.String(conn.Do("ZSCORE", "zaddflags", member)); err != nil {
+			t.Fatal(err)
+		} else if reply != score {
+			t.Error("bad score", member, reply)
+		}
+	}
+	if reply, err := conn.Do("ZSCORE", "zaddflags", "d"); err != nil || reply != nil {
+		t.Error("d should not exist", reply, err)
+	}
+
+	// INCR
+	if reply, err := redis.String(conn.Do("ZADD", "zaddflags", "INCR", "2.5", "a")); err != nil || reply != "2.5" {
+		t.Error("bad incr", reply, err)
+	}
+	if reply, err := conn.Do("ZADD", "zaddflags", "GT", "INCR", "-1", "a"); err != nil || reply != nil {
+		t.Error("nil expected", reply, err)
+	}
+
+	for _, args := range [][]interface{}{
+		{"zaddflags", "NX", "XX", "1", "a"},
+		{"zaddflags", "NX", "GT", "1", "a"},
+		{"zaddflags", "INCR", "1", "a", "2", "b"},
+	} {
+		if _, err := conn.Do("ZADD", args...); err == nil {
+			t.Error("error expected", args)
+		}
+	}
+}
+
+func TestZUnionInterStore(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zstore1", "zstore2", "zstore_out"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Do("ZADD", "zstore1", "1", "a", "2", "b", "3", "c")
+	conn.Do("ZADD", "zstore2", "10", "b", "20", "c", "30", "d")
+
+	cases := []struct {
+		args   []interface{}
+		n      int64
+		scores map[string]string
+	}{
+		{[]interface{}{"ZUNIONSTORE", "zstore_out", "2", "zstore1", "zstore2"}, 4,
+			map[string]string{"a": "1", "b": "12", "c": "23", "d": "30"}},
+		{[]interface{}{"ZUNIONSTORE", "zstore_out", "2", "zstore1", "zstore2", "WEIGHTS", "2", "0.5", "AGGREGATE", "MAX"}, 4,
+			map[string]string{"a": "2", "b": "5", "c": "10", "d": "15"}},
+		{[]interface{}{"ZINTERSTORE", "zstore_out", "2", "zstore1", "zstore2"}, 2,
+			map[string]string{"b": "12", "c": "23"}},
+		{[]interface{}{"ZINTERSTORE", "zstore_out", "2", "zstore1", "zstore2", "AGGREGATE", "MIN"}, 2,
+			map[string]string{"b": "2", "c": "3"}},
+		// destination同时作为输入
+		{[]interface{}{"ZUNIONSTORE", "zstore_out", "2", "zstore_out", "zstore1"}, 3,
+			map[string]string{"a": "1", "b": "4", "c": "6"}},
+	}
+	for _, c := range cases {
+		if reply, err := conn.Do(c.args[0].(string), c.args[1:]...); err != nil {
+			t.Fatal(err)
+		} else if reply.(int64) != c.n {
+			t.Error("bad count", c.args, reply)
+		}
+		if reply, err := redis.Int(conn.Do("ZCARD", "zstore_out")); err != nil || int64(reply) != c.n {
+			t.Error("bad zcard", c.args, reply, err)
+		}
+		for member, score := range c.scores {
+			if reply, err := redis.String(conn.Do("ZSCORE", "zstore_out", member)); err != nil || reply != score {
+				t.Error("bad score", c.args, member, reply, err)
+			}
+		}
+	}
+
+	if _, err := conn.Do("ZUNIONSTORE", "zstore_out", "2", "zstore1", "zstore2", "WEIGHTS", "1"); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestZPop(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zpop", "zpop2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("ZADD", "zpop", "1", "a", "2", "b", "3", "c", "4", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := redis.Strings(conn.Do("ZPOPMIN", "zpop", "2")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "a,1,b,2" {
+		t.Error("bad zpopmin", reply)
+	}
+	if reply, err := redis.Strings(conn.Do("ZPOPMAX", "zpop")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "d,4" {
+		t.Error("bad zpopmax", reply)
+	}
+	if reply, err := redis.Int(conn.Do("ZCARD", "zpop")); err != nil || reply != 1 {
+		t.Error("bad zcard", reply, err)
+	}
+
+	// 超时
+	begin := time.Now()
+	if reply, err := conn.Do("BZPOPMIN", "zpop2", "0.2"); err != nil {
+		t.Fatal(err)
+	} else if reply != nil {
+		t.Error("nil expected")
+	}
+	if time.Since(begin) < 200*time.Millisecond {
+		t.Error("timeout too short")
+	}
+
+	// ZADD唤醒
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c, err := NewRedisConn(host)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Do("ZADD", "zpop2", "5", "e", "6", "f")
+	}()
+	if reply, err := redis.Strings(conn.Do("BZPOPMAX", "zpop2", "5")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "zpop2,f,6" {
+		t.Error("bad bzpopmax", reply)
+	}
+	// 按顺序检查key
+	if reply, err := redis.Strings(conn.Do("BZPOPMIN", "zpop", "zpop2", "0")); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(reply, ",") != "zpop,c,3" {
+		t.Error("bad bzpopmin", reply)
+	}
+}
+
+func TestZScan(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zscan"); err != nil {
+		t.Fatal(err)
+	}
+	total := 1000
+	for i := 0; i < total; i++ {
+		if _, err := conn.Do("ZADD", "zscan", i, fmt.Sprintf("member:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每个member只返回一次，score正确
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("ZSCAN", "zscan", cursor, "COUNT", 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		elems, _ := redis.Strings(reply[1], nil)
+		for i := 0; i < len(elems); i += 2 {
+			if seen[elems[i]] {
+				t.Error("duplicate", elems[i])
+			}
+			seen[elems[i]] = true
+			if elems[i] != "member:"+elems[i+1] {
+				t.Error("bad score", elems[i], elems[i+1])
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != total {
+		t.Error("bad count", len(seen))
+	}
+
+	// MATCH
+	matched := 0
+	cursor = "0"
+	for {
+		reply, err := redis.Values(conn.Do("ZSCAN", "zscan", cursor, "MATCH", "member:9?", "COUNT", 300))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		elems, _ := redis.Strings(reply[1], nil)
+		matched += len(elems) / 2
+		if cursor == "0" {
+			break
+		}
+	}
+	if matched != 10 {
+		t.Error("bad match", matched)
+	}
+}
+
+func TestZScanMutation(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testScanMutation(t, conn, "ZSCAN", "zscan_mutation", 2, func(member string) error {
+		_, err := conn.Do("ZADD", "zscan_mutation", 1, member)
+		return err
+	}, func(member string) error {
+		_, err := conn.Do("ZREM", "zscan_mutation", member)
+		return err
+	})
+}
+
+func TestZRandMember(t *testing.T) {
+	conn, err := NewRedisConn(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "zrand"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.Do("ZRANDMEMBER", "zrand"); err != nil || reply != nil {
+		t.Error("nil expected", reply, err)
+	}
+	total := 100
+	for i := 0; i < total; i++ {
+		if _, err := conn.Do("ZADD", "zrand", i, fmt.Sprintf("m%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不重复，seek和全量打乱两种方式
+	for _, count := range []int{5, 50, 200} {
+		reply, err := redis.Strings(conn.Do("ZRANDMEMBER", "zrand", count, "WITHSCORES"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := count
+		if expect > total {
+			expect = total
+		}
+		if len(reply) != expect*2 {
+			t.Error("bad count", count, len(reply))
+		}
+		seen := make(map[string]bool)
+		for i := 0; i < len(reply); i += 2 {
+			if seen[reply[i]] {
+				t.Error("duplicate", reply[i])
+			}
+			seen[reply[i]] = true
+			if reply[i] != "m"+reply[i+1] {
+				t.Error("bad score", reply[i], reply[i+1])
+			}
+		}
+	}
+
+	// 负数允许重复
+	if reply, err := redis.Strings(conn.Do("ZRANDMEMBER", "zrand", -300)); err != nil {
+		t.Fatal(err)
+	} else if len(reply) != 300 {
+		t.Error("bad count", len(reply))
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+// 比较两个实例的数据，用于迁移完成、切换之前的校验
+// 扫描源实例的key，分批比较两边的摘要(见libs/keydigest)，输出目标缺失和内容不同的key
+// GoRedis使用DEBUG DIGEST-VALUE在服务端计算摘要，redis读取数据后在本地计算
+// go run migratediff.go -src localhost:6379 -dest localhost:1602
+// go run migratediff.go -src localhost:6379 -dest localhost:1602 -prefix user: -count 500
+import (
+	"GoRedis/libs/keydigest"
+	"GoRedis/libs/redigo/redis"
+	"GoRedis/libs/stdlog"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	stdlog.SetPrefix(func() string {
+		t := time.Now()
+		return fmt.Sprintf("[%d-%02d-%02d %02d:%02d:%02d] ", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	})
+}
+
+// 一个被比较的实例
+type instance struct {
+	conn    redis.Conn
+	goredis bool
+}
+
+func connect(host string) (ins *instance, err error) {
+	conn, err := redis.Dial("tcp", host)
+	if err != nil {
+		return
+	}
+	info, err := redis.String(conn.Do("INFO"))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	ins = &instance{conn: conn, goredis: strings.Contains(info, "goredis_version:")}
+	return
+}
+
+// 按源实例的方式扫描key，GoRedis使用KEYNEXT，redis使用SCAN
+// fn返回false时停止
+func (ins *instance) scan(prefix string, count int, fn func(keys []string) bool) (err error) {
+	if ins.goredis {
+		seek := prefix
+		first := true
+		for {
+			keys, err := redis.Strings(ins.conn.Do("KEYNEXT", seek, count+1))
+			if err != nil {
+				return err
+			}
+			// KEYNEXT的结果包含seek本身
+			if !first && len(keys) > 0 && keys[0] == seek {
+				keys = keys[1:]
+			}
+			first = false
+			matched := make([]string, 0, len(keys))
+			for _, key := range keys {
+				if !strings.HasPrefix(key, prefix) {
+					break
+				}
+				matched = append(matched, key)
+			}
+			if len(matched) > 0 && !fn(matched) {
+				return nil
+			}
+			if len(matched) < len(keys) || len(keys) < count {
+				return nil
+			}
+			seek = keys[len(keys)-1]
+		}
+	}
+	cursor := "0"
+	for {
+		values, err := redis.Values(ins.conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", count))
+		if err != nil {
+			return err
+		}
+		if len(values) != 2 {
+			return fmt.Errorf("bad scan reply")
+		}
+		if cursor, err = redis.String(values[0], nil); err != nil {
+			return err
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 && !fn(keys) {
+			return nil
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// 返回每个key的摘要，不存在的key为keydigest.Missing
+func (ins *instance) digests(keys []string) (sums []string, err error) {
+	if ins.goredis {
+		args := make([]interface{}, 0, len(keys)+1)
+		args = append(args, "DIGEST-VALUE")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		return redis.Strings(ins.conn.Do("DEBUG", args...))
+	}
+	for _, key := range keys {
+		ins.conn.Send("TYPE", key)
+	}
+	ins.conn.Flush()
+	types := make([]string, len(keys))
+	for i := range keys {
+		if types[i], err = redis.String(ins.conn.Receive()); err != nil {
+			return
+		}
+	}
+	sums = make([]string, len(keys))
+	for i, key := range keys {
+		if sums[i], err = ins.digest(key, types[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (ins *instance) digest(key string, typ string) (sum string, err error) {
+	var reply interface{}
+	switch typ {
+	case "none":
+		return keydigest.Missing, nil
+	case "string":
+		reply, err = ins.conn.Do("GET", key)
+	case "list":
+		reply, err = ins.conn.Do("LRANGE", key, 0, -1)
+	case "hash":
+		reply, err = ins.conn.Do("HGETALL", key)
+	case "set":
+		reply, err = ins.conn.Do("SMEMBERS", key)
+	case "zset":
+		reply, err = ins.conn.Do("ZRANGE", key, 0, -1, "WITHSCORES")
+	default:
+		return "", fmt.Errorf("unsupported type %s: %s", typ, key)
+	}
+	if err != nil {
+		return
+	}
+	if reply == nil {
+		return keydigest.Missing, nil
+	}
+	d := keydigest.New(typ)
+	if b, ok := reply.([]byte); ok {
+		d.Add(b)
+	} else {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range values {
+			b, _ := v.([]byte)
+			d.Add(b)
+		}
+	}
+	return d.Sum(), nil
+}
+
+func main() {
+	src := flag.String("src", "", "source host, redis or goredis")
+	dest := flag.String("dest", "", "dest host, redis or goredis")
+	prefix := flag.String("prefix", "", "only compare keys with prefix")
+	count := flag.Int("count", 100, "keys per batch")
+	limit := flag.Int("limit", 1000, "stop after limit differences, 0 for no limit")
+	flag.Parse()
+
+	if len(*src) == 0 || len(*dest) == 0 {
+		stdlog.Println("must set -src and -dest")
+		os.Exit(2)
+	}
+	from, err := connect(*src)
+	if err != nil {
+		panic(err)
+	}
+	defer from.conn.Close()
+	to, err := connect(*dest)
+	if err != nil {
+		panic(err)
+	}
+	defer to.conn.Close()
+
+	begin := time.Now()
+	total, missing, differ := 0, 0, 0
+	err = from.scan(*prefix, *count, func(keys []string) bool {
+		srcsums, err := from.digests(keys)
+		if err != nil {
+			panic(err)
+		}
+		destsums, err := to.digests(keys)
+		if err != nil {
+			panic(err)
+		}
+		for i, key := range keys {
+			total++
+			switch {
+			case srcsums[i] == destsums[i]:
+				continue
+			case srcsums[i] == keydigest.Missing:
+				// 扫描之后在源实例上被删除或过期
+				continue
+			case destsums[i] == keydigest.Missing:
+				missing++
+				fmt.Printf("missing %q\n", key)
+			default:
+				differ++
+				fmt.Printf("differ %q\n", key)
+			}
+		}
+		if total%100000 < len(keys) {
+			stdlog.Printf("compared %d keys, %d missing, %d differ\n", total, missing, differ)
+		}
+		return *limit == 0 || missing+differ < *limit
+	})
+	if err != nil {
+		panic(err)
+	}
+	stdlog.Printf("done %d keys, %d missing, %d differ, %s\n", total, missing, differ, time.Since(begin))
+	if missing+differ > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// 回放DEBUG RECORD录制的指令，用真实流量对另一个实例做压力测试
+// go run replay.go -file /data/goredis_1602/record_20140301_120000.log -dest localhost:1603
+// go run replay.go -file record.log -dest localhost:1603 -speed 4    4倍速回放
+// go run replay.go -file record.log -dest localhost:1603 -speed 0    不等待，尽快回放
+// 每个录制的客户端使用一个连接，按录制顺序发送，SELECT只影响自己的连接，BLPOP等阻塞时不影响其它客户端
+import (
+	. "GoRedis/goredis"
+	"GoRedis/libs/stdlog"
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	stdlog.SetPrefix(func() string {
+		t := time.Now()
+		return fmt.Sprintf("[%d-%02d-%02d %02d:%02d:%02d] ", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	})
+}
+
+// 一个录制的客户端，指令在自己的goroutine里依次执行
+type client struct {
+	cmds    chan *Command
+	session *Session
+}
+
+var count, errors int64
+
+func newClient(dest string, wg *sync.WaitGroup) (c *client, err error) {
+	conn, err := net.Dial("tcp", dest)
+	if err != nil {
+		return
+	}
+	c = &client{cmds: make(chan *Command, 10000), session: NewSession(conn)}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer c.session.Close()
+		for cmd := range c.cmds {
+			if err := c.session.WriteCommand(cmd); err != nil {
+				panic(err)
+			}
+			reply, err := c.session.ReadReply()
+			if err != nil {
+				panic(err)
+			}
+			if reply.Type == ReplyTypeError {
+				atomic.AddInt64(&errors, 1)
+			}
+			if n := atomic.AddInt64(&count, 1); n%10000 == 0 {
+				stdlog.Printf("replay %d commands, %d errors\n", n, atomic.LoadInt64(&errors))
+			}
+		}
+	}()
+	return
+}
+
+// "<offset> <elapsed> <client> <size>"，旧格式没有client，全部指令在一个连接上回放
+func readHeader(r *bufio.Reader) (offset int64, name string, size int, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 3:
+	case 4:
+		name = fields[2]
+	default:
+		return 0, "", 0, fmt.Errorf("bad record header %q", line)
+	}
+	if offset, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return
+	}
+	size, err = strconv.Atoi(fields[len(fields)-1])
+	return
+}
+
+func main() {
+	file := flag.String("file", "", "record file")
+	dest := flag.String("dest", "", "dest host")
+	speed := flag.Float64("speed", 1, "replay speed, 0 for no wait")
+	flag.Parse()
+
+	if len(*file) == 0 || len(*dest) == 0 {
+		stdlog.Println("must set -file and -dest")
+		return
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	clients := make(map[string]*client)
+	wg := &sync.WaitGroup{}
+	begin := time.Now()
+	r := bufio.NewReaderSize(f, 1024*1024)
+	for {
+		var offset int64
+		var name string
+		var size int
+		if offset, name, size, err = readHeader(r); err != nil {
+			break
+		}
+		b := make([]byte, size)
+		if _, err = io.ReadFull(r, b); err != nil {
+			break
+		}
+		cmd, err := ParseCommand(bytes.NewBuffer(b))
+		if err != nil {
+			stdlog.Println("bad command", err)
+			continue
+		}
+
+		// 按原始时间间隔回放
+		if *speed > 0 {
+			wait := time.Duration(float64(offset)/(*speed))*time.Microsecond - time.Since(begin)
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		c, ok := clients[name]
+		if !ok {
+			if c, err = newClient(*dest, wg); err != nil {
+				panic(err)
+			}
+			clients[name] = c
+		}
+		c.cmds <- cmd
+	}
+	if err != nil && err != io.EOF {
+		stdlog.Println("read record", err)
+	}
+	for _, c := range clients {
+		close(c.cmds)
+	}
+	wg.Wait()
+	stdlog.Printf("replay finish, %d clients, %d commands, %d errors, %s\n", len(clients), count, errors, time.Since(begin))
+}